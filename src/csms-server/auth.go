@@ -1,66 +1,215 @@
-// Provides OCPP auth mechanism using auth records stored in redis
+// Provides OCPP security profile auth: Basic auth checked by the configured auth backend, or client certificates
 package main
 
 import (
+	"crypto/x509"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
-	"regexp"
+	"net/netip"
+	"strconv"
 	"strings"
-	"sw/ocpp/csms/internal/telemetry"
+	"time"
 
-	"github.com/go-redis/redis"
+	"sw/ocpp/csms/internal/auth"
+	helpers "sw/ocpp/csms/internal/helpers"
+	"sw/ocpp/csms/internal/logging"
+	mqmodels "sw/ocpp/csms/internal/models/mq"
+	mq "sw/ocpp/csms/internal/mq"
+	"sw/ocpp/csms/internal/telemetry"
 )
 
 const (
 	PrefixConnState string = "CS_"
-	PrefixCpAuth    string = "CP_"
+	PrefixCpAuth    string = auth.KeyPrefix_ChargePoint
 )
 
-func AuthConnection(rw http.ResponseWriter, req *http.Request, serviceState *ServiceState) (bool, string) {
+// Authenticates a charger's websocket upgrade request, writing the rejection status if it fails
+func AuthConnection(rw http.ResponseWriter, req *http.Request, serviceState *ServiceState) (bool, string, int) {
 	networkId, err := toNetworkId(req.URL.Path)
 	if err != nil {
 		log.Warn("Invalid networkId passed, return 404...: ", err.Error())
 		rw.WriteHeader(http.StatusNotFound)
-		return false, ""
+		return false, "", http.StatusNotFound
 	}
-	log.Debug("networkId: ", networkId)
+	clog := logging.ForCharger(networkId).WithField(logging.Field_RemoteAddr, req.RemoteAddr)
+	clog.Debug("networkId received")
 
-	if !serviceState.Config.Services.CsmsServer.EnableAuth {
-		log.Debug("networkId OK, auth is disabled...")
-		return true, networkId
+	cert := clientCertificate(req)
+	if cert == nil && !serviceState.Config.Services.CsmsServer.EnableAuth {
+		clog.Debug("networkId OK, auth is disabled...")
+		return true, networkId, http.StatusOK
 	}
 
-	isValid := authNetworkId(networkId, serviceState)
-	if !isValid {
-		log.Warn("networkId invalid, return 404...")
-		rw.WriteHeader(http.StatusNotFound)
-		telemetry.TrackAuthenticationEvent(networkId, req.RemoteAddr, "401")
-		return false, ""
+	clientIp := clientIp(req, serviceState.TrustedProxies)
+	now := time.Now()
+	status := http.StatusOK
+	if !serviceState.AuthLimiter.Allowed(clientIp, now) {
+		status = http.StatusTooManyRequests
+	} else {
+		if cert != nil {
+			status = authCertificate(req, cert, networkId, serviceState.DeviceChecker)
+		} else {
+			status = authBasic(req, networkId, serviceState.Authenticator)
+		}
+		switch status {
+		case http.StatusOK:
+			serviceState.AuthLimiter.Reset(clientIp)
+		case http.StatusUnauthorized:
+			serviceState.AuthLimiter.Fail(clientIp, now)
+		}
+	}
+	serviceState.Telemetry.TrackAuthenticationEvent(telemetry.AuthenticationEvent{NetworkId: networkId, ClientAddress: req.RemoteAddr, ResponseCode: strconv.Itoa(status)})
+
+	if status != http.StatusOK {
+		clog.Warnf("Authentication failed, return %d...", status)
+		if status == http.StatusUnauthorized {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="csms", charset="UTF-8"`)
+		}
+		rw.WriteHeader(status)
+		return false, networkId, status
+	}
+	clog.Debug("networkId OK")
+	return true, networkId, status
+}
+
+// Security profile 3: a verified client certificate authenticates the charger if its CN is the networkId,
+// and the charger is known and enabled if there's a checker
+func authCertificate(req *http.Request, cert *x509.Certificate, networkId string, checker auth.DeviceChecker) int {
+	clog := logging.ForCharger(networkId)
+	if cert.Subject.CommonName != networkId {
+		clog.Warnf("Client certificate CN doesn't match networkId: %s", cert.Subject.CommonName)
+		return http.StatusUnauthorized
+	}
+	if checker == nil {
+		return http.StatusOK
 	}
-	log.Debug("networkId OK")
-	telemetry.TrackAuthenticationEvent(networkId, req.RemoteAddr, "200")
 
-	return true, networkId
+	allowed, err := checker.DeviceAllowed(req.Context(), networkId)
+	if err != nil {
+		clog.Error("Auth backend error: ", err)
+		return http.StatusServiceUnavailable
+	}
+	if !allowed {
+		clog.Warn("Client certificate valid, but charger unknown or disabled")
+		return http.StatusUnauthorized
+	}
+	return http.StatusOK
 }
 
-func authNetworkId(networkId string, serviceState *ServiceState) bool {
-	key := PrefixCpAuth + networkId
+// The client certificate verified in the TLS handshake, or nil
+func clientCertificate(req *http.Request) *x509.Certificate {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return req.TLS.VerifiedChains[0][0]
+}
 
-	cache := serviceState.Cache
+// Checks the request's Basic auth with the authenticator, returning the response status.
+// The username must be the networkId
+func authBasic(req *http.Request, networkId string, authenticator auth.Authenticator) int {
+	clog := logging.ForCharger(networkId)
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		clog.Warn("No Basic auth credentials")
+		return http.StatusUnauthorized
+	}
+	if username != networkId {
+		clog.Warnf("Basic auth username doesn't match networkId: %s", username)
+		return http.StatusUnauthorized
+	}
 
-	response := cache.Get(key)
-	if response.Err() == redis.Nil {
-		log.Error("Not found: ", key)
-		return false
+	allowed, err := authenticator.Authenticate(req.Context(), networkId, password)
+	if err != nil {
+		clog.Error("Auth backend error: ", err)
+		return http.StatusServiceUnavailable
+	}
+	if !allowed {
+		clog.Warn("Password invalid, or charger unknown or disabled")
+		return http.StatusUnauthorized
+	}
+	return http.StatusOK
+}
+
+// Drops a charger's cached auth decision when device-manager notifies its auth record changed
+func ProcessRecvNotify(messageBy []byte, state any) {
+	serviceState := state.(*ServiceState)
+	cache, ok := serviceState.Authenticator.(*auth.CachingAuthenticator)
+	if !ok {
+		return
+	}
+
+	notify := mqmodels.MqNotifyAuthChange{}
+	if err := json.Unmarshal(messageBy, &notify); err != nil {
+		log.Errorf("MQ Received Notify, unmarshall error: %s", err.Error())
+		return
+	}
+	if notify.NotifyType != mq.NotifyMsg_AuthChanged || notify.NetworkId == "" {
+		return
+	}
+	cache.Invalidate(notify.NetworkId)
+	logging.ForCharger(notify.NetworkId).Debug("Auth cache invalidated")
+}
+
+// The client's IP, without the port, for limiting failed attempts. Behind trusted proxies it's the last
+// X-Forwarded-For address which isn't a trusted proxy, as earlier ones can be set by the client
+func clientIp(req *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if !trustedProxy(host, trustedProxies) {
+		return host
+	}
+
+	forwarded := []string{}
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if _, err := netip.ParseAddr(ip); err != nil {
+			break
+		}
+		host = ip
+		if !trustedProxy(ip, trustedProxies) {
+			break
+		}
 	}
-	if response.Err() != nil {
-		log.Error("Cache error: ", response.Err())
+	return host
+}
+
+func trustedProxy(ip string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
 		return false
 	}
-	val := response.Val()
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
 
-	log.Debug("Found auth = ", val)
-	return true
+// Parses auth.trusted_proxies, IPs or CIDRs
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth trusted_proxies entry: %s", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
 }
 
 func toNetworkId(urlPath string) (string, error) {
@@ -72,17 +221,12 @@ func toNetworkId(urlPath string) (string, error) {
 	networkId := urlPath[idx+1:]
 
 	networkId = truncateText(networkId, NetworkIdMaxLen)
-	if !validNetworkIdString(networkId) {
+	if !helpers.ValidNetworkIdString(networkId) {
 		return "", errors.New("invalid characters in networkId")
 	}
 	return networkId, nil
 }
 
-func validNetworkIdString(networkId string) bool {
-	isValid := regexp.MustCompile(`^[A-Za-z0-9\-]+$`).MatchString
-	return isValid(networkId)
-}
-
 func truncateText(s string, max int) string {
 	if len(s) > max {
 		return s[:max]
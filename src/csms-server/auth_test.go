@@ -1,58 +1,210 @@
 package main
 
 import (
-	"github.com/stretchr/testify/mock"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sw/ocpp/csms/internal/auth"
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	"sw/ocpp/csms/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-// Mocking the ServiceState and its dependencies
-type MockServiceState struct {
-	Config MockConfig
+type authSink struct {
+	telemetry.NopSink
+	events []telemetry.AuthenticationEvent
+}
+
+func (s *authSink) TrackAuthenticationEvent(event telemetry.AuthenticationEvent) {
+	s.events = append(s.events, event)
 }
 
-type MockConfig struct {
-	Services MockServices
+// Fails for the down networkId, as if the backend were unreachable
+type downAuthenticator struct {
+	auth.Authenticator
+	down string
 }
 
-type MockServices struct {
-	CsmsServer MockCsmsServer
+func (a *downAuthenticator) Authenticate(ctx context.Context, networkId string, password string) (bool, error) {
+	if networkId == a.down {
+		return false, errors.New("connection refused")
+	}
+	return a.Authenticator.Authenticate(ctx, networkId, password)
 }
 
-type MockCsmsServer struct {
-	EnableAuth bool
+func TestAuthBasic(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+	hash, err := auth.HashPassword("0123456789abcdef")
+	require.NoError(t, err)
+	static, err := auth.NewStaticAuthenticator([]conf.StaticCharger{{NetworkId: "charger-1", PasswordHash: hash}, {NetworkId: "charger-2", PasswordHash: "legacy"}})
+	require.NoError(t, err)
+	authenticator := &downAuthenticator{Authenticator: static, down: "charger-down"}
+
+	tests := []struct {
+		name      string
+		networkId string
+		username  string
+		password  string
+		expected  int
+	}{
+		{"valid", "charger-1", "charger-1", "0123456789abcdef", http.StatusOK},
+		{"wrong password", "charger-1", "charger-1", "0123456789abcdeX", http.StatusUnauthorized},
+		{"username not networkId", "charger-1", "charger-9", "0123456789abcdef", http.StatusUnauthorized},
+		{"no credentials", "charger-1", "", "", http.StatusUnauthorized},
+		{"unknown charger", "charger-9", "charger-9", "0123456789abcdef", http.StatusUnauthorized},
+		{"record not a hash", "charger-2", "charger-2", "legacy", http.StatusUnauthorized},
+		{"backend error", "charger-down", "charger-down", "0123456789abcdef", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ocpp/"+tt.networkId, nil)
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			assert.Equal(t, tt.expected, authBasic(req, tt.networkId, authenticator))
+		})
+	}
 }
 
-// Mocking the telemetry
-type MockTelemetry struct {
-	mock.Mock
+func TestAuthConnectionRateLimited(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+	sink := &authSink{}
+	state := &ServiceState{Config: &conf.Configuration{}, Telemetry: sink, AuthLimiter: auth.NewFailureLimiter(1, time.Minute)}
+	state.Config.Services.CsmsServer.EnableAuth = true
+	state.AuthLimiter.Fail("10.0.0.1", time.Now())
+
+	req := httptest.NewRequest(http.MethodGet, "/ocpp/charger-1", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.SetBasicAuth("charger-1", "0123456789abcdef")
+	rw := httptest.NewRecorder()
+
+	authenticated, networkId, status := AuthConnection(rw, req, state)
+	assert.False(t, authenticated)
+	assert.Equal(t, "charger-1", networkId)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, "429", sink.events[0].ResponseCode)
 }
 
-func (m *MockTelemetry) TrackAuthenticationEvent(networkId, remoteAddr, status string) {
-	m.Called(networkId, remoteAddr, status)
+func TestClientIp(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+	_, err = parseTrustedProxies([]string{"ingress"})
+	assert.Error(t, err)
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"203.0.113.9:5000", nil, "203.0.113.9"},
+		{"203.0.113.9:5000", []string{"198.51.100.1"}, "203.0.113.9"}, // not from a proxy
+		{"10.1.2.3:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"10.1.2.3:5000", []string{"1.2.3.4, 198.51.100.1, 192.168.1.1"}, "198.51.100.1"}, // spoofed first entry ignored
+		{"10.1.2.3:5000", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"10.1.2.3:5000", nil, "10.1.2.3"},
+		{"10.1.2.3:5000", []string{"not-an-ip"}, "10.1.2.3"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/ocpp/charger-1", nil)
+		req.RemoteAddr = test.remoteAddr
+		for _, header := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+		assert.Equal(t, test.expected, clientIp(req, trusted), test.forwarded)
+	}
 }
 
-var telemetryT = &MockTelemetry{}
+func TestAuthConnectionInvalidNetworkId(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+	state := &ServiceState{Config: &conf.Configuration{}, Telemetry: telemetry.NopSink{}}
+
+	rw := httptest.NewRecorder()
+	authenticated, _, status := AuthConnection(rw, httptest.NewRequest(http.MethodGet, "/ocpp/bad_id", nil), state)
+	assert.False(t, authenticated)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
 
-/* TODO
-func TestAuthConnection(t *testing.T) {
-	// Arrange
-	// Mock telemetry
-	telemetryT.On("TrackAuthenticationEvent", mock.Anything, mock.Anything, mock.Anything).Return()
+func TestAuthConnectionClientCertificate(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+	sink := &authSink{}
+	devices := memdb.NewDeviceRepository()
+	devices.InsertDevice(context.Background(), &dbmodels.Device{NetworkId: "charger-1"})
+	devices.InsertDevice(context.Background(), &dbmodels.Device{NetworkId: "charger-3", Disabled: true})
+	state := &ServiceState{Config: &conf.Configuration{}, Telemetry: sink, AuthLimiter: auth.NewFailureLimiter(3, time.Minute),
+		DeviceChecker: auth.NewSqlAuthenticator(devices, "", nil)}
 
-	serviceState := &MockServiceState{
-		Config: MockConfig{
-			Services: MockServices{
-				CsmsServer: MockCsmsServer{
-					EnableAuth: true,
-				},
-			},
-		},
+	request := func(networkId string, commonName string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/ocpp/"+networkId, nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}}}
+		return req
 	}
 
-	// Act
-	result, networkId := AuthConnection(rw, req, serviceState)
+	authenticated, _, status := AuthConnection(httptest.NewRecorder(), request("charger-1", "charger-1"), state)
+	assert.True(t, authenticated, "no Basic auth needed")
+	assert.Equal(t, http.StatusOK, status)
+
+	rw := httptest.NewRecorder()
+	authenticated, _, status = AuthConnection(rw, request("charger-1", "charger-2"), state)
+	assert.False(t, authenticated, "CN checked, even with enable_auth false")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+
+	_, _, status = AuthConnection(httptest.NewRecorder(), request("charger-3", "charger-3"), state)
+	assert.Equal(t, http.StatusUnauthorized, status, "disabled")
+	_, _, status = AuthConnection(httptest.NewRecorder(), request("charger-9", "charger-9"), state)
+	assert.Equal(t, http.StatusUnauthorized, status, "unknown")
+	_, _, status = AuthConnection(httptest.NewRecorder(), request("charger-1", "charger-1"), state)
+	assert.Equal(t, http.StatusTooManyRequests, status, "certificate rejections count as failures")
+
+	require.Len(t, sink.events, 5)
+	assert.Equal(t, "200", sink.events[0].ResponseCode)
+	assert.Equal(t, "401", sink.events[1].ResponseCode)
+}
+
+func TestProcessRecvNotify(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+	hash, err := auth.HashPassword("0123456789abcdef")
+	require.NoError(t, err)
+	static, err := auth.NewStaticAuthenticator([]conf.StaticCharger{{NetworkId: "charger-1", PasswordHash: hash}})
+	require.NoError(t, err)
+	backend := &downAuthenticator{Authenticator: static}
+	cache := auth.NewCachingAuthenticator(backend, time.Minute, time.Minute)
+	state := &ServiceState{Authenticator: cache}
+
+	allowed, err := cache.Authenticate(context.Background(), "charger-1", "0123456789abcdef")
+	require.NoError(t, err)
+	require.True(t, allowed)
 
-	// Assert
-	assert.Equal(t, tt.expectedResult, result)
-	assert.Equal(t, tt.expectedId, networkId)
-	assert.Equal(t, tt.expectedStatus, rw.Result().StatusCode)
-}*/
+	backend.down = "charger-1"
+	_, err = cache.Authenticate(context.Background(), "charger-1", "0123456789abcdef")
+	assert.NoError(t, err, "cached")
+
+	ProcessRecvNotify([]byte(`{"notifyType":"ClientConnected","networkId":"charger-1"}`), state)
+	_, err = cache.Authenticate(context.Background(), "charger-1", "0123456789abcdef")
+	assert.NoError(t, err, "still cached")
+
+	ProcessRecvNotify([]byte(`{"notifyType":"AuthChanged","networkId":"charger-1"}`), state)
+	_, err = cache.Authenticate(context.Background(), "charger-1", "0123456789abcdef")
+	assert.Error(t, err, "invalidated, so the backend is called")
+}
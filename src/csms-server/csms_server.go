@@ -1,24 +1,33 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"sw/ocpp/csms/internal/admin"
+	"sw/ocpp/csms/internal/auth"
 	redisManage "sw/ocpp/csms/internal/cache"
+	"sw/ocpp/csms/internal/capture"
 	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db"
 	helpers "sw/ocpp/csms/internal/helpers"
 	httplistener "sw/ocpp/csms/internal/http"
 	"sw/ocpp/csms/internal/logging"
+	"sw/ocpp/csms/internal/metrics"
 	mqmodels "sw/ocpp/csms/internal/models/mq"
 	svc "sw/ocpp/csms/internal/models/service"
 	svcmodels "sw/ocpp/csms/internal/models/service"
 	mq "sw/ocpp/csms/internal/mq"
 	service "sw/ocpp/csms/internal/service"
 	"sw/ocpp/csms/internal/telemetry"
+	"sw/ocpp/csms/internal/tracing"
 
 	"github.com/go-redis/redis"
 	"github.com/gorilla/websocket"
@@ -42,6 +51,10 @@ func initialise() *ServiceState {
 	if err != nil {
 		return &ServiceState{LastError: err}
 	}
+	telemetrySink, err := telemetry.NewSink(config.Telemetry, telemetryHook, "csms-server")
+	if err != nil {
+		return &ServiceState{LastError: err}
+	}
 
 	// Setup auth cache
 	var cacheClient *redis.Client
@@ -67,16 +80,87 @@ func initialise() *ServiceState {
 		return &ServiceState{LastError: err}
 	}
 
+	err = mqConnection.MqQueueDeclare(mq.MqChannelName_DeadLetter)
+	if err != nil {
+		return &ServiceState{LastError: err}
+	}
+
 	mq.SetupMqReceiver(mqConnection, config.Mq.Type, serviceContext.HostName, mq.MqChannelName_MessagesOut)
 
+	var recorder *capture.Recorder
+	if config.Services.CsmsServer.Capture.Enabled {
+		recorder, err = capture.NewRecorder(config.Services.CsmsServer.Capture)
+		if err != nil {
+			return &ServiceState{LastError: err}
+		}
+		log.Warnf("Capturing frames to: %s", config.Services.CsmsServer.Capture.Directory)
+	}
+
+	authConfig := config.Services.CsmsServer.Auth
+	authLimiter := auth.NewFailureLimiter(authConfig.MaxFailures, time.Duration(authConfig.FailureWindowSecs)*time.Second)
+	trustedProxies, err := parseTrustedProxies(authConfig.TrustedProxies)
+	if err != nil {
+		return &ServiceState{LastError: err}
+	}
+
+	// Chargers with client certificates are checked against the devices table if there's a DB, unless the
+	// static backend lists them
+	tlsConfig := config.Services.CsmsServer.Tls
+	clientCerts := tlsConfig.Enabled && tlsConfig.ClientCaFile != ""
+	var store *db.Store
+	var devices db.DeviceRepository
+	if (config.Services.CsmsServer.EnableAuth && authConfig.Backend == auth.Backend_Sql) ||
+		(clientCerts && authConfig.Backend != auth.Backend_Static && config.DbConfig.DbType != "") {
+		store, err = db.Open(config.DbConfig)
+		if err != nil {
+			return &ServiceState{LastError: err}
+		}
+		devices = store.Devices
+	}
+
+	var deviceChecker auth.DeviceChecker
+	if clientCerts {
+		deviceChecker, err = auth.NewDeviceChecker(authConfig, devices)
+		if err != nil {
+			return &ServiceState{LastError: err}
+		}
+		if deviceChecker == nil {
+			log.Warn("No db_config, client certificates aren't checked against devices")
+		}
+	}
+
+	var authenticator auth.Authenticator
+	if config.Services.CsmsServer.EnableAuth {
+		authenticator, err = auth.NewAuthenticator(authConfig, cacheClient, devices, func(networkId string) {
+			// device-manager replaces the charger's current password with the one it's confirmed
+			if err := mq.MqNotifyPasswordConfirmed(mqConnection, serviceContext.HostName, networkId); err != nil {
+				logging.ForCharger(networkId).Errorf("Pending password confirmation not notified: %s", err.Error())
+			}
+		})
+		if err != nil {
+			return &ServiceState{LastError: err}
+		}
+		if _, ok := authenticator.(*auth.CachingAuthenticator); ok {
+			// device-manager notifies auth record changes, to drop cached decisions
+			mq.SetupMqReceiver(mqConnection, config.Mq.Type, serviceContext.HostName, mq.MqChannelName_Notify)
+		}
+	}
+
 	return &ServiceState{
 		Cache:           cacheClient,
+		Store:           store,
+		Authenticator:   authenticator,
+		DeviceChecker:   deviceChecker,
+		AuthLimiter:     authLimiter,
+		TrustedProxies:  trustedProxies,
 		Config:          config,
 		MqBus:           mqConnection,
 		Connections:     xsync.NewMap(),
 		Context:         serviceContext,
 		AppInsightsHook: telemetryHook,
+		Telemetry:       telemetrySink,
 		MessagesWaiting: xsync.NewMap(),
+		Capture:         recorder,
 	}
 }
 
@@ -98,7 +182,12 @@ func main() {
 		<-exitNotification // send notification to unblock and exit
 	}()
 
-	log = logging.LoggingSetup(true, "csms-server") // start with debug enabled until overridden in config later
+	log = logging.LoggingSetup(true, "csms-server", logging.Format_Text) // start with debug enabled until overridden in config later
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		log = logging.LoggingSetup(false, "csms-server", logging.Format_Text)
+		runReplayCommand(os.Args[2:])
+	}
 
 	log.Infof("--- CSMS OCPP Server - v%s ---", service.Version)
 
@@ -109,11 +198,18 @@ func main() {
 	}
 	config := serviceState.Config.Services.CsmsServer
 
-	log = logging.LoggingSetup(config.Debug, "csms-server")
+	log = logging.LoggingSetup(config.Debug, "csms-server", serviceState.Config.Logging.Format)
 	if len(serviceState.Config.Logging.AppInsightsInstrumentationKey) > 0 {
 		log.AddHook(serviceState.AppInsightsHook)
 	}
 
+	shutdownTracing, err := tracing.Setup(serviceState.Config.Tracing, "csms-server")
+	if err != nil {
+		log.Errorf("Error in tracing setup: %s", err.Error())
+		os.Exit(1)
+	}
+	serviceState.ShutdownTracing = shutdownTracing
+
 	log.Debugf("standalone_mode: %t", serviceState.Config.Services.CsmsServer.StandaloneMode)
 	log.Debugf("enable_auth: %t", serviceState.Config.Services.CsmsServer.EnableAuth)
 
@@ -121,21 +217,62 @@ func main() {
 	//go expungeOldWaitingMessages(serviceState.MessagesWaiting, 1*time.Minute) // Expunge every 1 minute
 
 	go serviceState.MqBus.RunMqTopicReceiver(ProcessRecvMqMessage, mq.MqChannelName_MessagesOut, serviceState)
+	if _, ok := serviceState.Authenticator.(*auth.CachingAuthenticator); ok {
+		go serviceState.MqBus.RunMqTopicReceiver(ProcessRecvNotify, mq.MqChannelName_Notify, serviceState)
+	}
 
-	var err error
 	listenNetPort := fmt.Sprintf("%s:%d", config.ListenAddress, config.ListenPort)
 	log.Info("OCPP listening on: ", listenNetPort)
 
 	hostName := serviceState.Context.HostName
 	mq.MqNotifyNodeConnected(serviceState.MqBus, hostName)
 
-	ioCloser, err := httplistener.ListenAndServeWithClose(listenNetPort, HttpHandler(serviceState))
-	serviceState.IoCloser = &ioCloser
+	metrics.WatchConnectedChargers(hostName, serviceState.Connections.Size)
+	metrics.WatchMessagesWaiting(serviceState.MessagesWaiting.Size)
+	if config.Admin.ListenPort > 0 {
+		server := admin.NewServer(config.Admin)
+		server.AddLivenessCheck("listener", func(ctx context.Context) error {
+			if serviceState.Listener == nil {
+				return errors.New("not listening")
+			}
+			return serviceState.Listener.Check(ctx)
+		})
+		server.AddReadinessCheck("mq", serviceState.MqBus.MqHealthCheck)
+		if serviceState.Cache != nil {
+			server.AddReadinessCheck("redis", func(ctx context.Context) error {
+				return serviceState.Cache.WithContext(ctx).Ping().Err()
+			})
+		}
+		if serviceState.Store != nil {
+			server.AddReadinessCheck("db", serviceState.Store.Ping)
+		}
+		adminCloser, err := server.Start()
+		if err != nil {
+			log.Errorf("Error starting admin listener: %s", err.Error())
+			os.Exit(1)
+		}
+		serviceState.AdminCloser = &adminCloser
+	}
 
+	var listener *httplistener.Listener
+	if config.Tls.Enabled {
+		tlsConfig, tlsErr := httplistener.NewTlsConfig(config.Tls)
+		if tlsErr != nil {
+			log.Errorf("Error in TLS config: %s", tlsErr.Error())
+			os.Exit(1)
+		}
+		log.Infof("Serving TLS, client certificates required: %t", config.Tls.RequireClientCert)
+		listener, err = httplistener.ListenAndServeTLSWithClose(listenNetPort, HttpHandler(serviceState), tlsConfig)
+	} else {
+		listener, err = httplistener.ListenAndServeWithClose(listenNetPort, HttpHandler(serviceState))
+	}
 	if err != nil {
 		log.Fatalln(err)
 		os.Exit(1)
 	}
+	var ioCloser io.Closer = listener
+	serviceState.IoCloser = &ioCloser
+	serviceState.Listener = listener
 	log.Debug("block...")
 	exitNotification <- struct{}{} // block until exit notification received
 	log.Debug("Service closing...")
@@ -172,6 +309,10 @@ func dispose() {
 		log.Debug("Close websocket listener")
 		(*serviceState.IoCloser).Close()
 	}
+	if serviceState.AdminCloser != nil {
+		log.Debug("Close admin listener")
+		(*serviceState.AdminCloser).Close()
+	}
 	if serviceState.MqBus != nil {
 		log.Debug("Close MQ")
 		serviceState.MqBus.Close()
@@ -180,6 +321,18 @@ func dispose() {
 		log.Debug("Close cache")
 		serviceState.Cache.Close()
 	}
+	if serviceState.Store != nil {
+		log.Debug("Close DB")
+		serviceState.Store.Close()
+	}
+	if serviceState.Telemetry != nil {
+		log.Debug("Flush telemetry")
+		serviceState.Telemetry.Close()
+	}
+	if serviceState.ShutdownTracing != nil {
+		log.Debug("Flush traces")
+		serviceState.ShutdownTracing(context.Background())
+	}
 }
 
 func multiSignalHandler(signal os.Signal) {
@@ -211,7 +364,12 @@ func ProcessRecvMqMessage(messageBy []byte, state any) { //message amqp.Delivery
 	serviceState := state.(*ServiceState)
 	val, ok := serviceState.Connections.Load(msgEnvelope.Client)
 
-	if ok {
+	if ok && msgEnvelope.Command == mq.Command_Disconnect {
+		logging.ForCharger(msgEnvelope.Client).Warn("Disconnecting, as requested over MQ")
+		if err := disconnectClient(val.(*svcmodels.ConnectionState), "disconnected by CSMS"); err != nil {
+			logging.ForCharger(msgEnvelope.Client).Errorf("Error disconnecting: %s", err.Error())
+		}
+	} else if ok {
 		connection := val.(*svcmodels.ConnectionState)
 		//ocppEnvelopeFields := new(ocppmodels.OcppMessage)
 		ocppEnvelopeFields := msgEnvelope.Body.(map[string]interface{})
@@ -224,16 +382,22 @@ func ProcessRecvMqMessage(messageBy []byte, state any) { //message amqp.Delivery
 
 		body, err := json.Marshal(ocppEnvelopeFields["messageBody"])
 		if err != nil {
-			log.Errorf("[ %s ] Unable to marshall messageBody for envelope: %s - %s", msgEnvelope.Client, string(messageBy), err.Error())
+			logging.ForCharger(msgEnvelope.Client).Errorf("Unable to marshall messageBody for envelope: %s - %s", string(messageBy), err.Error())
 			// TODO reply with OCPP error ?
 			return
 		}
 		msgId := ocppEnvelopeFields["msgId"].(string)
 
 		var msgReply string
+		action, _ := ocppEnvelopeFields["messageType"].(string)
 		direction := int(ocppEnvelopeFields["direction"].(float64))
+		ctx, span := tracing.StartConsumerSpan(msgEnvelope.TraceContext, "ocpp send "+action,
+			tracing.Attr_NetworkId.String(msgEnvelope.Client), tracing.Attr_MsgId.String(msgId),
+			tracing.Attr_Action.String(action), tracing.Attr_Direction.Int(direction))
+		defer span.End()
+		mlog := logging.ForMessage(ctx, msgEnvelope.Client, msgId, action)
 		if direction == 2 {
-			waitMessage := &svc.WaitingMessage{}
+			waitMessage := &svc.WaitingMessage{Action: action, TraceContext: tracing.Inject(ctx)}
 			waitMessage.Notify = make(chan int)
 			waitMessage.CreatedTimestamp = time.Now()
 			serviceState.MessagesWaiting.Store(msgId, waitMessage)
@@ -251,14 +415,13 @@ func ProcessRecvMqMessage(messageBy []byte, state any) { //message amqp.Delivery
 				body)
 		}
 
-		log.Debugf("[ %s ] Reply: %s", msgEnvelope.Client, msgReply)
-		connection.WebSocketMutex.Lock()
-		err = connection.WebSocket.WriteMessage(websocket.TextMessage, []byte(msgReply))
-		connection.WebSocketMutex.Unlock()
+		mlog.Debugf("Reply: %s", msgReply)
+		err = writeClientMessage(connection, websocket.TextMessage, action, []byte(msgReply))
 		if err != nil {
-			log.Errorf("[ %s ] Error writing msg to client, msg: %s - %s", msgEnvelope.Client, string(msgReply), err.Error())
+			mlog.Errorf("Error writing msg to client, msg: %s - %s", string(msgReply), err.Error())
+			tracing.SetError(ctx, err)
 		}
-	} else {
-		log.Warnf("[ %s ] Client no longer exists, message lost: %s", msgEnvelope.Client, string(messageBy))
+	} else if msgEnvelope.Command == "" {
+		logging.ForCharger(msgEnvelope.Client).Warnf("Client no longer exists, message lost: %s", string(messageBy))
 	}
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/capture"
+)
+
+// Replays a capture file against a csms-server as a fake charger, e.g:
+// replay -url ws://localhost:5200/ocpp -speed 10 -ignore currentTime,transactionId capture.jsonl
+// Exits 2 if any server responses differ from the capture.
+func runReplayCommand(args []string) {
+	diffs, err := replayCommand(args, os.Stdout)
+	if err != nil {
+		log.Errorf("Error in replay: %s", err.Error())
+		os.Exit(1)
+	}
+	if diffs > 0 {
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+func replayCommand(args []string, out io.Writer) (int, error) {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(out)
+	url := flags.String("url", "ws://localhost:5200/ocpp", "csms-server websocket url, the networkId is appended")
+	networkId := flags.String("networkid", "", "networkId to connect as, defaults to the captured networkId")
+	speed := flags.Float64("speed", 1, "1 keeps the recorded timing, 10 runs ten times faster, 0 sends without delay")
+	timeout := flags.Duration("timeout", 10*time.Second, "time to wait for each response")
+	ignore := flags.String("ignore", "currentTime", "comma separated response fields not compared")
+	if err := flags.Parse(args); err != nil {
+		return 0, err
+	}
+	if flags.NArg() != 1 {
+		return 0, errors.New("expected one capture file")
+	}
+
+	frames, err := capture.ReadCaptureFile(flags.Arg(0))
+	if err != nil {
+		return 0, err
+	}
+
+	options := capture.ReplayOptions{Url: *url, NetworkId: *networkId, Speed: *speed, ResponseTimeout: *timeout}
+	if *ignore != "" {
+		options.IgnoreFields = strings.Split(*ignore, ",")
+	}
+	result, err := capture.Replay(context.Background(), frames, options)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, diff := range result.Diffs {
+		diffBy, _ := json.Marshal(diff)
+		fmt.Fprintln(out, string(diffBy))
+	}
+	fmt.Fprintf(out, "sent=%d compared=%d diffs=%d\n", result.Sent, result.Compared, len(result.Diffs))
+	return len(result.Diffs), nil
+}
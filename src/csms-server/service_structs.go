@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
 	"io"
+	"net/netip"
 
+	"sw/ocpp/csms/internal/auth"
+	"sw/ocpp/csms/internal/capture"
 	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db"
+	httplistener "sw/ocpp/csms/internal/http"
 	mq "sw/ocpp/csms/internal/mq"
+	"sw/ocpp/csms/internal/telemetry"
 
 	"github.com/go-redis/redis"
 	"github.com/puzpuzpuz/xsync/v3"
@@ -14,13 +21,23 @@ import (
 type ServiceState struct {
 	Config          *conf.Configuration
 	IoCloser        *io.Closer
+	AdminCloser     *io.Closer
+	Listener        *httplistener.Listener
 	Cache           *redis.Client
+	Store           *db.Store
+	Authenticator   auth.Authenticator
+	DeviceChecker   auth.DeviceChecker // nil if client certificates aren't checked against device records
+	AuthLimiter     *auth.FailureLimiter
+	TrustedProxies  []netip.Prefix // whose X-Forwarded-For gives the client IP
 	MqBus           mq.MqBus
 	Connections     *xsync.Map
 	LastError       error
 	Context         ServiceContext
 	AppInsightsHook logrus.Hook
+	Telemetry       telemetry.TelemetrySink
 	MessagesWaiting *xsync.Map
+	Capture         *capture.Recorder
+	ShutdownTracing func(context.Context) error
 }
 
 type ServiceContext struct {
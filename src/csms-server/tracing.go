@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+
+	svc "sw/ocpp/csms/internal/models/service"
+	"sw/ocpp/csms/internal/ocpp"
+	"sw/ocpp/csms/internal/tracing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Starts the span for a frame received from a charger. A response continues the trace of the call it answers,
+// so an action sent by device-manager is traced through the charger's round trip.
+func startFrameSpan(serviceState *ServiceState, connectionState *svc.ConnectionState, msgEnvelope *OcppMessage, parseErr error) (context.Context, trace.Span) {
+	parent := context.Background()
+	action := msgEnvelope.MessageType
+	if parseErr == nil && (msgEnvelope.Direction == ocpp.MsgType_ServerToClientResult || msgEnvelope.Direction == ocpp.MsgType_Error) {
+		if waiting, ok := serviceState.MessagesWaiting.Load(msgEnvelope.MsgId); ok {
+			waitingMessage := waiting.(*svc.WaitingMessage)
+			parent = tracing.Extract(parent, waitingMessage.TraceContext)
+			action = waitingMessage.Action
+		}
+	}
+
+	name := "ocpp frame"
+	if action != "" {
+		name = "ocpp " + action
+	}
+	return tracing.Tracer().Start(parent, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		tracing.Attr_NetworkId.String(connectionState.Info.NetworkId),
+		tracing.Attr_MsgId.String(msgEnvelope.MsgId),
+		tracing.Attr_Action.String(action),
+		tracing.Attr_Direction.Int(msgEnvelope.Direction),
+	))
+}
@@ -2,17 +2,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"sw/ocpp/csms/internal/capture"
 	"sw/ocpp/csms/internal/helpers"
+	"sw/ocpp/csms/internal/logging"
+	"sw/ocpp/csms/internal/metrics"
 	svc "sw/ocpp/csms/internal/models/service"
 	mq "sw/ocpp/csms/internal/mq"
 	"sw/ocpp/csms/internal/ocpp"
 	telemetry "sw/ocpp/csms/internal/telemetry"
+	"sw/ocpp/csms/internal/tracing"
 	"time"
 
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/websocket"
 	logrus "github.com/sirupsen/logrus"
@@ -26,7 +32,7 @@ type OcppHeartBeatAck ocpp.OcppHeartBeatAck
 type OcppDataTransfer ocpp.OcppDataTransfer
 
 const (
-	NetworkIdMaxLen = 32
+	NetworkIdMaxLen = helpers.NetworkIdMaxLen
 	MaxMsgSize      = 8192
 )
 
@@ -71,14 +77,17 @@ func removeConnection(serviceState *ServiceState, networkId string) {
 // TODO this needs some serious refactoring
 // ServeHTTP implements the http.Handler that proxies WebSocket connections.
 func (w *Websocket) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	start := time.Now()
 
 	log.Debug("Client connected to : ", req.Host, " path:", req.URL.Path, ", client: ", req.RemoteAddr)
-	authenticated, networkId := AuthConnection(rw, req, w.serviceState)
+	authenticated, networkId, status := AuthConnection(rw, req, w.serviceState)
 	if !authenticated {
+		trackConnectionRequest(w.serviceState, req, networkId, start, strconv.Itoa(status))
 		return
 	}
 
 	remoteAddrStr := req.RemoteAddr
+	clog := logging.ForCharger(networkId).WithField(logging.Field_RemoteAddr, remoteAddrStr)
 	connInfo := svc.ConnectionInfo{NetworkId: networkId, RemoteAddr: remoteAddrStr}
 	connectionState := svc.ConnectionState{
 		Info:        &connInfo,
@@ -89,7 +98,8 @@ func (w *Websocket) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if !w.serviceState.Config.Services.CsmsServer.StandaloneMode {
 		err := mq.MqNotifyClientConnected(w.serviceState.MqBus, w.serviceState.Context.HostName, connectionState.Info)
 		if err != nil {
-			log.Errorf("%s : websocket: problem sending MQ notify connected %s", remoteAddrStr, err)
+			clog.Errorf("websocket: problem sending MQ notify connected %s", err)
+			trackConnectionRequest(w.serviceState, req, networkId, start, "503")
 			return
 		}
 	}
@@ -100,12 +110,20 @@ func (w *Websocket) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	// Upgrade the existing incoming request to a WebSocket connection.
 	connPub, err := upgrader.Upgrade(rw, req, upgradeHeader)
 	if err != nil {
-		log.Errorf("%s : websocket: couldn't upgrade %s", remoteAddrStr, err)
+		clog.Errorf("websocket: couldn't upgrade %s", err)
+		trackConnectionRequest(w.serviceState, req, networkId, start, "400")
 		return
 	}
+	trackConnectionRequest(w.serviceState, req, networkId, start, "101")
 	connectionState.WebSocket = connPub
 	defer connPub.Close()
 
+	connectionState.Capture, err = w.serviceState.Capture.Open(networkId)
+	if err != nil {
+		clog.Errorf("unable to open capture: %s", err)
+	}
+	defer connectionState.Capture.Close()
+
 	errClient := make(chan error, 1)
 
 	handleClientWebsocket := func(src *websocket.Conn, errc chan error) {
@@ -123,14 +141,17 @@ func (w *Websocket) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 				}*/
 				errc <- err
 				//disposeClient(w.serviceState, &connectionState)
-				log.Warnf("%s : Client disconnected(read): %s", remoteAddrStr, err)
+				logging.ForCharger(networkId).WithField(logging.Field_RemoteAddr, remoteAddrStr).Warnf("Client disconnected(read): %s", err)
 				break
 			}
+			if err := connectionState.Capture.Record(capture.Direction_In, msg); err != nil {
+				logging.ForCharger(networkId).WithField(logging.Field_RemoteAddr, remoteAddrStr).Errorf("capture error: %s", err)
+			}
 
 			err = HandleMessage(msgType, msg, w.serviceState, &connectionState)
 			if err != nil {
 				errc <- err
-				log.Warnf("%s : Error: %s", remoteAddrStr, err)
+				logging.ForCharger(networkId).WithField(logging.Field_RemoteAddr, remoteAddrStr).Warnf("Error: %s", err)
 				break
 			}
 		}
@@ -149,12 +170,26 @@ func (w *Websocket) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if err == <-errClient {
 		message = "websocket: Error when copying from client: %v"
 	}
-	log.Warnf("%s : Wait return, close", remoteAddrStr)
+	clog = logging.ForCharger(networkId).WithField(logging.Field_RemoteAddr, remoteAddrStr)
+	clog.Warnf("Wait return, close")
 	if e, ok := err.(*websocket.CloseError); !ok || e.Code == websocket.CloseAbnormalClosure {
-		log.Errorf(message, err)
+		clog.Errorf(message, err)
 	}
 	disposeClient(w.serviceState, &connectionState)
-	log.Warnf("%s : Return", remoteAddrStr)
+	clog.Warnf("Return")
+}
+
+// Tracks a charger's connection request, from its receipt until the websocket upgrade or rejection
+func trackConnectionRequest(serviceState *ServiceState, req *http.Request, networkId string, start time.Time, responseCode string) {
+	serviceState.Telemetry.TrackConnectionRequest(telemetry.ConnectionRequest{
+		Url: fmt.Sprintf("%s:%d:%s", serviceState.Context.HostName,
+			serviceState.Config.Services.CsmsServer.ListenPort, req.URL),
+		NetworkId:     networkId,
+		ClientAddress: req.RemoteAddr,
+		ResponseCode:  responseCode,
+		Start:         start,
+		Duration:      time.Since(start),
+	})
 }
 
 func disposeClient(serviceState *ServiceState, connState *svc.ConnectionState) {
@@ -166,6 +201,18 @@ func disposeClient(serviceState *ServiceState, connState *svc.ConnectionState) {
 	removeConnection(serviceState, connState.Info.NetworkId)
 }
 
+// Closes a charger's connection with a close frame, e.g once device-manager disables it. ServeHTTP then disposes of it
+func disconnectClient(connectionState *svc.ConnectionState, reason string) error {
+	connectionState.WebSocketMutex.Lock()
+	defer connectionState.WebSocketMutex.Unlock()
+	if connectionState.WebSocket == nil {
+		return errors.New("websocket not upgraded")
+	}
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	connectionState.WebSocket.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	return connectionState.WebSocket.Close()
+}
+
 /*
 func runPingWebsocket(src *websocket.Conn, errc chan error, cancel chan bool) {
 
@@ -193,19 +240,35 @@ type HandleMessageDelegate interface {
 }
 
 func HandleMessage(msgType int, msgBytes []byte, serviceState *ServiceState, connectionState *svc.ConnectionState) error {
+	received := time.Now()
 	msgStr := string(msgBytes)
 
-	log.Debug("RecvClient->: ", msgStr)
-
 	var msgEnvelope OcppMessage
 	err := msgEnvelope.UnmarshalOcppJson(msgBytes)
 
-	if !serviceState.Config.Services.CsmsServer.StandaloneMode {
-		connectionUrl := fmt.Sprintf("%s:%d:%s", serviceState.Context.HostName,
-			serviceState.Config.Services.CsmsServer.ListenPort,
-			connectionState.HttpRequest.URL)
-		telemetry.TrackConnectionRequest(connectionUrl, 1)
-	}
+	ctx, span := startFrameSpan(serviceState, connectionState, &msgEnvelope, err)
+	defer span.End()
+
+	mlog := logging.ForMessage(ctx, connectionState.Info.NetworkId, msgEnvelope.MsgId, msgEnvelope.MessageType)
+	mlog.Debug("RecvClient->: ", msgStr)
+
+	// Charger requests are tracked until their response or MQ publish, responses to our calls aren't requests
+	trackRequest := true
+	outcome := telemetry.Outcome_Ok
+	defer func() {
+		if !trackRequest {
+			return
+		}
+		serviceState.Telemetry.TrackOcppRequest(telemetry.OcppRequest{
+			NetworkId:     connectionState.Info.NetworkId,
+			ClientAddress: connectionState.Info.RemoteAddr,
+			MsgId:         msgEnvelope.MsgId,
+			Action:        msgEnvelope.MessageType,
+			Outcome:       outcome,
+			Start:         received,
+			Duration:      time.Since(received),
+		})
+	}()
 
 	var msgSendBy []byte = nil
 
@@ -213,28 +276,47 @@ func HandleMessage(msgType int, msgBytes []byte, serviceState *ServiceState, con
 	sendToMq := true
 	standaloneMode := serviceState.Config.Services.CsmsServer.StandaloneMode
 	if err != nil {
-		log.Warnf("Unable to parse ocpp envelope: %s, for message: %s", err, msgStr)
-		// TODO envelope bad messages and send to other storage?
-
+		mlog.Warnf("Unable to parse ocpp envelope: %s, for message: %s", err, msgStr)
+		outcome = telemetry.Outcome_DeadLetter
+		publishDeadLetter(ctx, serviceState, connectionState, msgBytes, mq.DeadLetterReason_Malformed, err)
 		return nil // Ignore malformed message
 	} else {
-		if msgEnvelope.Direction == ocpp.MsgType_ServerToClientResult {
-			_, ok := serviceState.MessagesWaiting.Load(msgEnvelope.MsgId)
+		if msgEnvelope.Direction == ocpp.MsgType_ServerToClientResult || msgEnvelope.Direction == ocpp.MsgType_Error {
+			trackRequest = false
+			waiting, ok := serviceState.MessagesWaiting.Load(msgEnvelope.MsgId)
 			if ok {
-				log.Debugf("Valid message response: %s", msgEnvelope.MsgId)
+				metrics.CountOcppMessage(waiting.(*svc.WaitingMessage).Action, metrics.Direction_In)
+				mlog.Debugf("Valid message response: %s", msgEnvelope.MsgId)
 
-				mqErr := serviceState.MqBus.MqSendClientMessageRetry(serviceState.Context.HostName, connectionState.Info, msgEnvelope)
+				mqErr := serviceState.MqBus.MqSendClientMessageRetry(ctx, serviceState.Context.HostName, connectionState.Info, msgEnvelope)
 				if mqErr != nil {
-					log.Errorf("Error sending to MQ: %s", mqErr.Error())
+					mlog.Errorf("Error sending to MQ: %s", mqErr.Error())
+					tracing.SetError(ctx, mqErr)
 					return mqErr // transient MQ error unrecoverable, close connection to CP
 				}
 
 				serviceState.MessagesWaiting.Delete(msgEnvelope.MsgId)
 				return nil
 			} else {
-				log.Warnf("No waiting messages for message: %s", msgStr)
+				metrics.CountOcppMessage("", metrics.Direction_In)
+				mlog.Warnf("No waiting messages for message: %s", msgStr)
+				publishDeadLetter(ctx, serviceState, connectionState, msgBytes, mq.DeadLetterReason_Unroutable,
+					fmt.Errorf("no waiting call for msgId: %s", msgEnvelope.MsgId))
+				return nil
 			}
 		} else if msgEnvelope.Direction == ocpp.MsgType_ClientToServer {
+			metrics.CountOcppMessage(msgEnvelope.MessageType, metrics.Direction_In)
+			if err := ocpp.ValidateCall(msgEnvelope.MessageType, msgEnvelope.MessageBody); err != nil {
+				mlog.Warnf("Invalid call: %s, for message: %s", err, msgStr)
+				outcome = telemetry.Outcome_CallError
+				publishDeadLetter(ctx, serviceState, connectionState, msgBytes, mq.DeadLetterReason_SchemaInvalid, err)
+				errorCode := ocpp.CallError_FormationViolation
+				if errors.Is(err, ocpp.ErrUnknownAction) {
+					errorCode = ocpp.CallError_NotImplemented
+				}
+				return writeClientMessage(connectionState, msgType, msgEnvelope.MessageType, []byte(ocpp.GetCallError(msgEnvelope.MsgId, errorCode, err.Error())))
+			}
+
 			switch msgEnvelope.MessageType {
 			case "StatusNotification", "MeterValues", "SecurityEventNotification":
 				if standaloneMode {
@@ -245,7 +327,7 @@ func HandleMessage(msgType int, msgBytes []byte, serviceState *ServiceState, con
 					sendToMq = false
 				}
 
-				log.Debugf("Received BootNotification: %s", msgStr)
+				mlog.Debugf("Received BootNotification: %s", msgStr)
 				bootResponse := OcppBootNotificationResponse{Status: ocpp.BootStatus_Accepted, CurrentTime: helpers.GenerateDateNow(), Interval: 60}
 				msgSendBy, _ = MarshalOcppJsonResponse(ocpp.MsgType_ServerToClientResult, msgEnvelope.MsgId, &bootResponse)
 
@@ -253,7 +335,7 @@ func HandleMessage(msgType int, msgBytes []byte, serviceState *ServiceState, con
 				if standaloneMode {
 					sendToMq = false
 				}
-				log.Debugf("Received Heartbeat: %s", msgStr)
+				mlog.Debugf("Received Heartbeat: %s", msgStr)
 				msg := ocpp.GetHeatBeatAck(msgEnvelope.MsgId)
 				msgSendBy = []byte(msg)
 			case "StartTransaction":
@@ -262,19 +344,28 @@ func HandleMessage(msgType int, msgBytes []byte, serviceState *ServiceState, con
 			case "StopTransaction":
 				sendToMq = true
 				skipAck = false
+			case "SignCertificate":
+				sendToMq = true
+				skipAck = true // answered by device-manager, which then sends CertificateSigned
 			case "DataTransfer":
 				skipAck = false
 				msgSendBy = []byte(fmt.Sprintf("[%d,\"%s\",{\"status\":\"UnknownVendorId\"}]", ocpp.MsgType_ServerToClientResult, msgEnvelope.MsgId))
 			}
 		} else {
-			log.Errorf("Unhandled OCPP direction: %d", msgEnvelope.Direction)
+			mlog.Errorf("Unhandled OCPP direction: %d", msgEnvelope.Direction)
+			outcome = telemetry.Outcome_DeadLetter
+			publishDeadLetter(ctx, serviceState, connectionState, msgBytes, mq.DeadLetterReason_Unroutable,
+				fmt.Errorf("unhandled message type: %d", msgEnvelope.Direction))
+			return nil
 		}
 	}
 
 	if sendToMq {
-		mqErr := serviceState.MqBus.MqSendClientMessageRetry(serviceState.Context.HostName, connectionState.Info, msgEnvelope)
+		mqErr := serviceState.MqBus.MqSendClientMessageRetry(ctx, serviceState.Context.HostName, connectionState.Info, msgEnvelope)
 		if mqErr != nil {
-			log.Errorf("Error sending to MQ: %s", mqErr.Error())
+			mlog.Errorf("Error sending to MQ: %s", mqErr.Error())
+			outcome = telemetry.Outcome_MqError
+			tracing.SetError(ctx, mqErr)
 			return mqErr // transient MQ error unrecoverable, close connection to CP
 		}
 	}
@@ -282,20 +373,15 @@ func HandleMessage(msgType int, msgBytes []byte, serviceState *ServiceState, con
 	if !skipAck && msgSendBy == nil { // ACK message once it's send to MQ
 		msgSendBy = []byte(getSimpleAckMsg(msgEnvelope.MsgId))
 	}
-	if !standaloneMode {
-		telemetry.TrackOcppRequest(connectionState.Info.NetworkId, connectionState.Info.RemoteAddr,
-			msgEnvelope.MsgId, msgEnvelope.MessageType, "200", 1*time.Millisecond)
-	}
-
 	if msgSendBy != nil {
-		if log.IsLevelEnabled(logrus.DebugLevel) {
-			log.Debug("<-SendClient: ", string(msgSendBy))
+		if mlog.Logger.IsLevelEnabled(logrus.DebugLevel) {
+			mlog.Debug("<-SendClient: ", string(msgSendBy))
 		}
-		connectionState.WebSocketMutex.Lock()
-		err = connectionState.WebSocket.WriteMessage(msgType, msgSendBy)
-		connectionState.WebSocketMutex.Unlock()
+		err = writeClientMessage(connectionState, msgType, msgEnvelope.MessageType, msgSendBy)
 		if err != nil {
-			log.Warnf("%s : Client disconnected(write): %s", connectionState.Info.RemoteAddr, err)
+			mlog.Warnf("Client disconnected(write): %s", err)
+			outcome = telemetry.Outcome_WriteError
+			tracing.SetError(ctx, err)
 			return err
 		}
 	}
@@ -303,6 +389,35 @@ func HandleMessage(msgType int, msgBytes []byte, serviceState *ServiceState, con
 	return nil
 }
 
+// Publishes a frame which couldn't be handled to the DeadLetter channel, for message-manager to archive
+func publishDeadLetter(ctx context.Context, serviceState *ServiceState, connectionState *svc.ConnectionState, frame []byte, reason string, cause error) {
+	tracing.SetError(ctx, fmt.Errorf("%s: %w", reason, cause))
+	json, err := mq.MqCreateDeadLetter(ctx, serviceState.Context.HostName, connectionState.Info, frame, reason, cause)
+	if err != nil {
+		logging.ForCharger(connectionState.Info.NetworkId).WithContext(ctx).Errorf("Error creating dead letter: %s", err.Error())
+		return
+	}
+	if err = serviceState.MqBus.MqMessagePublishRetry(mq.MqChannelName_DeadLetter, json); err != nil {
+		logging.ForCharger(connectionState.Info.NetworkId).WithContext(ctx).Errorf("Error sending dead letter to MQ, lost: %s", err.Error())
+	}
+}
+
+// Writes a frame to the client, recording it if the connection is being captured. The action is
+// the call's, or for a response the action of the call it answers
+func writeClientMessage(connectionState *svc.ConnectionState, msgType int, action string, data []byte) error {
+	connectionState.WebSocketMutex.Lock()
+	err := connectionState.WebSocket.WriteMessage(msgType, data)
+	connectionState.WebSocketMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	metrics.CountOcppMessage(action, metrics.Direction_Out)
+	if err = connectionState.Capture.Record(capture.Direction_Out, data); err != nil {
+		logging.ForCharger(connectionState.Info.NetworkId).WithField(logging.Field_RemoteAddr, connectionState.Info.RemoteAddr).Errorf("capture error: %s", err)
+	}
+	return nil
+}
+
 func getSimpleAckMsg(msgId string) string {
 	return fmt.Sprintf("[%d,\"%s\",{}]", ocpp.MsgType_ServerToClientResult, msgId)
 }
@@ -347,6 +462,20 @@ func (n *OcppMessage) UnmarshalOcppJson(buf []byte) error {
 		if err := json.Unmarshal(buf, &tmp); err != nil {
 			return err
 		}
+	} else if direction == ocpp.MsgType_Error {
+		// [4, msgId, errorCode, errorDescription, errorDetails], the error is passed on as the body
+		callError := ocpp.OcppCallError{}
+		tmp := []interface{}{&n.Direction, &n.MsgId, &callError.ErrorCode, &callError.ErrorDescription, &callError.ErrorDetails}
+		if err := json.Unmarshal(buf, &tmp); err != nil {
+			return err
+		}
+		body, err := json.Marshal(callError)
+		if err != nil {
+			return err
+		}
+		n.MessageBody = body
+	} else {
+		n.Direction = direction
 	}
 	return nil
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	svc "sw/ocpp/csms/internal/models/service"
+	mq "sw/ocpp/csms/internal/mq"
+	"sw/ocpp/csms/internal/telemetry"
+	"sw/ocpp/csms/internal/tracing"
+
+	"github.com/gorilla/websocket"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Records the trace context of messages sent to the MQ
+type fakeMqBus struct {
+	mq.MqBus
+	sent []map[string]string
+}
+
+func (f *fakeMqBus) MqSendClientMessageRetry(ctx context.Context, hostName string, connState *svc.ConnectionInfo, body any) error {
+	f.sent = append(f.sent, tracing.Inject(ctx))
+	return nil
+}
+
+// Records the OCPP requests tracked
+type requestSink struct {
+	telemetry.NopSink
+	requests []telemetry.OcppRequest
+}
+
+func (s *requestSink) TrackOcppRequest(request telemetry.OcppRequest) {
+	s.requests = append(s.requests, request)
+}
+
+func TestHandleMessageTracksRequest(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+	sink := &requestSink{}
+	state := &ServiceState{Config: &conf.Configuration{}, MqBus: &fakeMqBus{}, MessagesWaiting: xsync.NewMap(), Telemetry: sink}
+	state.Config.Services.CsmsServer.StandaloneMode = true
+	connectionState := &svc.ConnectionState{Info: &svc.ConnectionInfo{NetworkId: "charger-1", RemoteAddr: "10.0.0.1:5000"}}
+
+	before := time.Now()
+	frame := `[2,"m1","StartTransaction",{"connectorId":1,"idTag":"tag-1","meterStart":0,"timestamp":"2024-10-01T12:00:00Z"}]`
+	require.NoError(t, HandleMessage(websocket.TextMessage, []byte(frame), state, connectionState))
+
+	require.Len(t, sink.requests, 1)
+	request := sink.requests[0]
+	assert.Equal(t, "charger-1", request.NetworkId)
+	assert.Equal(t, "10.0.0.1:5000", request.ClientAddress)
+	assert.Equal(t, "m1", request.MsgId)
+	assert.Equal(t, "StartTransaction", request.Action)
+	assert.Equal(t, telemetry.Outcome_Ok, request.Outcome)
+	assert.False(t, request.Start.Before(before))
+	assert.Positive(t, request.Duration)
+}
+
+func TestHandleMessageResponseContinuesTrace(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.SetupWithExporter(exporter, "csms-server")
+	defer provider.Shutdown(context.Background())
+
+	bus := &fakeMqBus{}
+	state := &ServiceState{Config: &conf.Configuration{}, MqBus: bus, MessagesWaiting: xsync.NewMap(), Telemetry: telemetry.NopSink{}}
+	state.Config.Services.CsmsServer.StandaloneMode = true
+	connectionState := &svc.ConnectionState{Info: &svc.ConnectionInfo{NetworkId: "charger-1"}}
+
+	ctx, send := tracing.Tracer().Start(context.Background(), "ocpp send Reset")
+	state.MessagesWaiting.Store("m1", &svc.WaitingMessage{Action: "Reset", TraceContext: tracing.Inject(ctx)})
+	send.End()
+
+	require.NoError(t, HandleMessage(websocket.TextMessage, []byte(`[3,"m1",{"status":"Accepted"}]`), state, connectionState))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	response := spans[1]
+	assert.Equal(t, "ocpp Reset", response.Name)
+	assert.Equal(t, send.SpanContext().TraceID(), response.SpanContext.TraceID())
+	assert.Equal(t, send.SpanContext().SpanID(), response.Parent.SpanID())
+
+	require.Len(t, bus.sent, 1)
+	assert.Contains(t, bus.sent[0]["traceparent"], response.SpanContext.SpanID().String())
+	_, waiting := state.MessagesWaiting.Load("m1")
+	assert.False(t, waiting)
+}
+
+func TestProcessRecvMqMessageDisconnect(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+	state := &ServiceState{Connections: xsync.NewMap()}
+
+	upgraded := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		conn, err := DefaultUpgrader.Upgrade(rw, req, nil)
+		require.NoError(t, err)
+		state.Connections.Store("charger-1", &svc.ConnectionState{Info: &svc.ConnectionInfo{NetworkId: "charger-1"}, WebSocket: conn})
+		close(upgraded)
+	}))
+	defer server.Close()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	defer client.Close()
+	<-upgraded
+
+	ProcessRecvMqMessage([]byte(`{"client":"charger-1","command":"disconnect"}`), state)
+
+	_, _, err = client.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	ocppmodels "sw/ocpp/csms/internal/ocpp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupActionsRouter(t *testing.T) (http.Handler, *fakeMqBus) {
+	bus, _ := setupDeviceState(t)
+	_, err := serviceState.Devices.InsertDevice(context.Background(), &dbmodels.Device{NetworkId: "charger-1"})
+	require.NoError(t, err)
+
+	return apiRouter(), bus
+}
+
+// Serves the action in the background, as it waits for the charger's response
+func serveAction(router http.Handler, target string, body string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() { done <- serveDevices(router, http.MethodPost, target, body) }()
+	return done
+}
+
+func TestActionResponse(t *testing.T) {
+	router, bus := setupActionsRouter(t)
+
+	done := serveAction(router, "/actions/reset/charger-1", `{"type":"Soft"}`)
+	call := bus.next(t)
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Rejected"}), nil)
+
+	rec := <-done
+	require.Equal(t, http.StatusOK, rec.Code)
+	response := &ActionResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), response))
+	assert.Equal(t, call.Body.MsgId, response.MsgId)
+	assert.Equal(t, "Reset", response.Action)
+	assert.Equal(t, "Rejected", response.Status)
+	assert.JSONEq(t, `{"status":"Rejected"}`, string(response.Response))
+}
+
+func TestActionServerNode(t *testing.T) {
+	router, bus := setupActionsRouter(t)
+	recordConnected("charger-1", "node1")
+
+	done := serveAction(router, "/actions/reset/charger-1", `{"type":"Soft"}`)
+	call := bus.next(t)
+	assert.Equal(t, "node1", call.ServerNode, "sent to the node the charger is connected to")
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Accepted"}), nil)
+	require.Equal(t, http.StatusOK, (<-done).Code)
+}
+
+func TestActionCallError(t *testing.T) {
+	router, bus := setupActionsRouter(t)
+
+	done := serveAction(router, "/actions/reset/charger-1", `{"type":"Soft"}`)
+	call := bus.next(t)
+	ProcessRecvMessage(chargerMessage(t, 4, call.Body.MsgId, "",
+		ocppmodels.OcppCallError{ErrorCode: "NotSupported", ErrorDescription: "no reset"}), nil)
+
+	rec := <-done
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.JSONEq(t, `{"errorCode":"NotSupported","errorDescription":"no reset"}`, string(mustField(t, rec.Body.Bytes(), "callError")))
+	assert.JSONEq(t, `"`+call.Body.MsgId+`"`, string(mustField(t, rec.Body.Bytes(), "msgId")))
+}
+
+func TestActionTimeout(t *testing.T) {
+	router, bus := setupActionsRouter(t)
+
+	done := serveAction(router, "/actions/reset/charger-1?timeoutSecs=1", `{"type":"Soft"}`)
+	bus.next(t)
+	rec := <-done
+	require.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.JSONEq(t, `"timed out waiting for response"`, string(mustField(t, rec.Body.Bytes(), "error")))
+
+	for _, timeout := range []string{"0", "301", "soon"} {
+		rec = serveDevices(router, http.MethodPost, "/actions/reset/charger-1?timeoutSecs="+timeout, `{"type":"Soft"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, timeout)
+	}
+	assert.Equal(t, http.StatusNotFound, serveDevices(router, http.MethodPost, "/actions/reset/charger-9", `{"type":"Soft"}`).Code)
+}
+
+func TestActionAwaitCancelled(t *testing.T) {
+	_, bus := setupActionsRouter(t)
+	device := &Device{NetworkId: "charger-1", ServerNode: "node1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	action, err := sendAction(ctx, device, ocppmodels.MsgType_Reset, json.RawMessage(`{"type":"Soft"}`))
+	require.NoError(t, err)
+	call := bus.next(t)
+	cancel()
+	_, err = action.await(ctx, time.Minute)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// A response after the waiter has gone, or a duplicate, mustn't block the receiver
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Accepted"}), nil)
+	action, err = sendAction(context.Background(), device, ocppmodels.MsgType_Reset, json.RawMessage(`{"type":"Soft"}`))
+	require.NoError(t, err)
+	call = bus.next(t)
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Accepted"}), nil)
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Rejected"}), nil)
+	response, err := action.await(context.Background(), time.Minute)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":"Accepted"}`, string(response.MessageBody))
+}
+
+func TestActionTimeoutConfig(t *testing.T) {
+	setupDeviceState(t)
+	assert.Equal(t, 5*time.Second, actionTimeout(ocppmodels.MsgType_Reset))
+
+	serviceState.Config.Services.DeviceManager.Actions.TimeoutSecs = 10
+	serviceState.Config.Services.DeviceManager.Actions.Timeouts = map[string]int{"getdiagnostics": 120}
+	assert.Equal(t, 10*time.Second, actionTimeout(ocppmodels.MsgType_Reset))
+	assert.Equal(t, 120*time.Second, actionTimeout(ocppmodels.MsgType_GetDiagnostics))
+}
+
+func TestActionOffline(t *testing.T) {
+	router, bus := setupActionsRouter(t)
+
+	ProcessRecvNotify([]byte(`{"notifyType":"ClientDisconnected","serverNode":"node1","networkId":"charger-1"}`), nil)
+	rec := serveDevices(router, http.MethodPost, "/actions/reset/charger-1", `{"type":"Soft"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, http.StatusConflict, serveDevices(router, http.MethodPost, "/actions/reset/charger-1?async=true", `{"type":"Soft"}`).Code)
+	assert.Empty(t, bus.published)
+
+	ProcessRecvNotify([]byte(`{"notifyType":"ClientConnected","serverNode":"node2","networkId":"charger-1"}`), nil)
+	ProcessRecvNotify([]byte(`{"notifyType":"ClientDisconnected","serverNode":"node1","networkId":"charger-1"}`), nil)
+	assert.False(t, deviceOffline("charger-1"), "reconnected to node2")
+	ProcessRecvNotify([]byte(`{"notifyType":"NodeDisconnected","serverNode":"node2"}`), nil)
+	assert.True(t, deviceOffline("charger-1"))
+
+	ProcessRecvMessage(chargerMessage(t, 2, "m1", "Heartbeat", map[string]string{}), nil)
+	assert.False(t, deviceOffline("charger-1"), "seen sending")
+}
@@ -0,0 +1,519 @@
+// Bulk jobs send an action to each of the devices selected by networkIds, template, firmware version or tag. Jobs run
+// in the background with limited concurrency and rate, recording each charger's result as it completes. Jobs only run
+// in the device-manager which created them, so those still running at startup are marked interrupted
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
+	helpers "sw/ocpp/csms/internal/helpers"
+	"sw/ocpp/csms/internal/logging"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	ocppmodels "sw/ocpp/csms/internal/ocpp"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+const (
+	defaultBulkConcurrency    = 10
+	defaultBulkMaxConcurrency = 50
+	defaultBulkMaxTargets     = 10000
+	bulkSelectPageSize        = 500
+)
+
+// Actions which can be sent in bulk. Transaction and connector actions are per charger decisions, and
+// ChangePassword has its own endpoint
+var bulkActions = []string{
+	ocppmodels.MsgType_ChangeAvailability,
+	ocppmodels.MsgType_ChangeConfiguration,
+	ocppmodels.MsgType_ClearChargingProfile,
+	ocppmodels.MsgType_DataTransfer,
+	ocppmodels.MsgType_DeleteCertificate,
+	ocppmodels.MsgType_ExtendedTriggerMessage,
+	ocppmodels.MsgType_GetConfiguration,
+	ocppmodels.MsgType_GetDiagnostics,
+	ocppmodels.MsgType_GetInstalledCertificateIds,
+	ocppmodels.MsgType_InstallCertificate,
+	ocppmodels.MsgType_Reset,
+	ocppmodels.MsgType_SetChargingProfile,
+	ocppmodels.MsgType_TriggerMessage,
+}
+
+// Cancels the running jobs, by job id
+var runningBulkJobs = xsync.NewMap()
+
+// How often job progress is sent to event stream clients
+var bulkJobEventInterval = time.Second
+
+// Job fields set by create requests
+type BulkJobRequest struct {
+	Action      string                `json:"action"`
+	Payload     json.RawMessage       `json:"payload"`
+	Selector    dbmodels.BulkSelector `json:"selector"`
+	Concurrency int                   `json:"concurrency"` // chargers sent the action at a time, default 10
+	RatePerSec  int                   `json:"ratePerSec"`  // max chargers sent the action per second, 0 is unlimited
+}
+
+// Lists jobs, newest first, filtered by query parameters: status, limit, offset
+func jobs_List(w http.ResponseWriter, r *http.Request) {
+	filter := dbmodels.BulkJobFilter{Tenant: serviceState.Config.Services.DeviceManager.Tenant}
+
+	switch status := r.URL.Query().Get("status"); status {
+	case "", dbmodels.BulkJobStatus_Running, dbmodels.BulkJobStatus_Completed, dbmodels.BulkJobStatus_Cancelled,
+		dbmodels.BulkJobStatus_Interrupted:
+		filter.Status = status
+	default:
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid status: %s", status)))
+		return
+	}
+	var err error
+	filter.Limit, filter.Offset, err = pageFromQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	jobs, err := serviceState.BulkJobs.ListJobs(r.Context(), filter)
+	if err != nil {
+		log.Errorf("Error listing jobs: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, jobs)
+}
+
+// Creates a job and starts it in the background, returning it with a pending result for each selected device
+func jobs_Create(w http.ResponseWriter, r *http.Request) {
+	config := serviceState.Config.Services.DeviceManager
+	job, err := bulkJobFromRequest(r, config.BulkJobs)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	networkIds, err := selectBulkTargets(r.Context(), job.Selector, bulkMaxTargets(config.BulkJobs))
+	if err != nil {
+		var invalid *invalidSelectorError
+		if errors.As(err, &invalid) {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		log.Errorf("Error selecting job devices: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+
+	if _, err = serviceState.BulkJobs.InsertJob(r.Context(), job, networkIds); err != nil {
+		log.Errorf("Error creating job: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	startBulkJob(job, networkIds)
+
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, job)
+}
+
+// Gets a job with its number of results by status
+func jobs_Get(w http.ResponseWriter, r *http.Request) {
+	job, ok := getBulkJob(w, r)
+	if !ok {
+		return
+	}
+	render.JSON(w, r, job)
+}
+
+// Lists a job's results by networkId, filtered by query parameters: status, limit, offset
+func jobs_ListResults(w http.ResponseWriter, r *http.Request) {
+	job, ok := getBulkJob(w, r)
+	if !ok {
+		return
+	}
+	filter := dbmodels.BulkJobResultFilter{JobId: job.Id}
+
+	switch status := r.URL.Query().Get("status"); status {
+	case "", dbmodels.BulkResultStatus_Pending, dbmodels.BulkResultStatus_Accepted, dbmodels.BulkResultStatus_Rejected,
+		dbmodels.BulkResultStatus_Error, dbmodels.BulkResultStatus_TimedOut:
+		filter.Status = status
+	default:
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid status: %s", status)))
+		return
+	}
+	var err error
+	filter.Limit, filter.Offset, err = pageFromQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	results, err := serviceState.BulkJobs.ListResults(r.Context(), filter)
+	if err != nil {
+		log.Errorf("Error listing job results: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, results)
+}
+
+// Streams the job as server-sent events: a progress event whenever its results change, then a done event once it
+// has finished
+func jobs_Events(w http.ResponseWriter, r *http.Request) {
+	job, ok := getBulkJob(w, r)
+	if !ok {
+		return
+	}
+	// the stream lasts as long as the job, longer than the server's write timeout
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(bulkJobEventInterval)
+	defer ticker.Stop()
+	var last []byte
+	for {
+		event := "progress"
+		if job.Status != dbmodels.BulkJobStatus_Running {
+			event = "done"
+		}
+		data, _ := json.Marshal(job)
+		if event == "done" || string(data) != string(last) {
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return
+			}
+			controller.Flush()
+			last = data
+		}
+		if event == "done" {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		next, err := serviceState.BulkJobs.GetJob(r.Context(), job.Tenant, job.Id)
+		if err != nil {
+			log.Errorf("Error getting job: %s", err.Error())
+			return
+		}
+		job = next
+	}
+}
+
+// Cancels a running job. Chargers already sent the action keep their result, the others stay pending
+func jobs_Cancel(w http.ResponseWriter, r *http.Request) {
+	job, ok := getBulkJob(w, r)
+	if !ok {
+		return
+	}
+
+	err := serviceState.BulkJobs.UpdateJobStatus(r.Context(), job.Tenant, job.Id, dbmodels.BulkJobStatus_Running,
+		dbmodels.BulkJobStatus_Cancelled, time.Now().UTC())
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrConflict(fmt.Errorf("job is %s", job.Status)))
+		return
+	}
+	if err != nil {
+		log.Errorf("Error cancelling job: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if cancel, ok := runningBulkJobs.Load(bulkJobKey(job.Id)); ok {
+		cancel.(context.CancelFunc)()
+	}
+	log.Infof("Job %d cancelled", job.Id)
+
+	job, ok = getBulkJob(w, r)
+	if !ok {
+		return
+	}
+	render.JSON(w, r, job)
+}
+
+func getBulkJob(w http.ResponseWriter, r *http.Request) (*dbmodels.BulkJob, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "jobid"), 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("invalid jobid")))
+		return nil, false
+	}
+
+	job, err := serviceState.BulkJobs.GetJob(r.Context(), serviceState.Config.Services.DeviceManager.Tenant, id)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Errorf("Error getting job: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return nil, false
+	}
+	return job, true
+}
+
+func bulkJobFromRequest(r *http.Request, config conf.BulkJobsConfig) (*dbmodels.BulkJob, error) {
+	request := &BulkJobRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(bulkActions, request.Action) {
+		return nil, fmt.Errorf("invalid action: %q, must be one of: %s", request.Action, strings.Join(bulkActions, ", "))
+	}
+	if len(request.Payload) == 0 || request.Payload[0] != '{' {
+		return nil, errors.New("payload must be an object")
+	}
+	if request.Action == ocppmodels.MsgType_ChangeConfiguration {
+		change := &ocppmodels.OcppChangeConfiguration{}
+		json.Unmarshal(request.Payload, change)
+		// per charger, set by ChangePassword
+		if strings.EqualFold(change.Key, "AuthorizationKey") {
+			return nil, errors.New("AuthorizationKey can't be changed in bulk")
+		}
+	}
+
+	maxConcurrency := bulkMaxConcurrency(config)
+	concurrency := request.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultBulkConcurrency
+	}
+	if concurrency < 0 || concurrency > maxConcurrency {
+		return nil, fmt.Errorf("invalid concurrency, must be 1-%d", maxConcurrency)
+	}
+	if request.RatePerSec < 0 {
+		return nil, errors.New("invalid ratePerSec, must be 0 or more")
+	}
+
+	return &dbmodels.BulkJob{Tenant: serviceState.Config.Services.DeviceManager.Tenant, Owner: serviceState.Context.HostName, Action: request.Action, Payload: request.Payload,
+		Selector: request.Selector, Concurrency: concurrency, RatePerSec: request.RatePerSec, Status: dbmodels.BulkJobStatus_Running,
+		CreatedAt: time.Now().UTC()}, nil
+}
+
+type invalidSelectorError struct {
+	message string
+}
+
+func (e *invalidSelectorError) Error() string {
+	return e.message
+}
+
+// Gets the networkIds selected: either those listed, or the enabled devices matching all of the other fields
+func selectBulkTargets(ctx context.Context, selector dbmodels.BulkSelector, maxTargets int) ([]string, error) {
+	byDevice := selector.DeviceTemplateId != 0 || selector.FirmwareVersion != "" || selector.Tag != ""
+	if len(selector.NetworkIds) > 0 {
+		if byDevice {
+			return nil, &invalidSelectorError{"selector must have either networkIds or deviceTemplateId, firmwareVersion and tag"}
+		}
+		if len(selector.NetworkIds) > maxTargets {
+			return nil, &invalidSelectorError{fmt.Sprintf("too many networkIds, max %d", maxTargets)}
+		}
+		networkIds := []string{}
+		for _, networkId := range selector.NetworkIds {
+			if !helpers.ValidNetworkIdString(networkId) {
+				return nil, &invalidSelectorError{fmt.Sprintf("invalid networkId: %q", networkId)}
+			}
+			if !slices.Contains(networkIds, networkId) {
+				networkIds = append(networkIds, networkId)
+			}
+		}
+		return networkIds, nil
+	}
+	if !byDevice {
+		return nil, &invalidSelectorError{"selector is empty, set networkIds, deviceTemplateId, firmwareVersion or tag"}
+	}
+
+	tenant := serviceState.Config.Services.DeviceManager.Tenant
+	enabled := false
+	filter := dbmodels.DeviceFilter{Tenant: tenant, DeviceTemplateId: selector.DeviceTemplateId, FirmwareVersion: selector.FirmwareVersion,
+		Tag: strings.ToLower(selector.Tag), Disabled: &enabled, Limit: bulkSelectPageSize}
+	networkIds := []string{}
+	for ; ; filter.Offset += bulkSelectPageSize {
+		devices, err := serviceState.Devices.ListDevices(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, device := range devices {
+			if device.Tenant == tenant { // an empty filter tenant matches all
+				networkIds = append(networkIds, device.NetworkId)
+			}
+		}
+		if len(networkIds) > maxTargets {
+			return nil, &invalidSelectorError{fmt.Sprintf("selector matches more than %d devices", maxTargets)}
+		}
+		if len(devices) < bulkSelectPageSize {
+			break
+		}
+	}
+	if len(networkIds) == 0 {
+		return nil, &invalidSelectorError{"selector matches no devices"}
+	}
+	return networkIds, nil
+}
+
+// Runs the job in the background until all of the chargers have a result or it's cancelled
+func startBulkJob(job *dbmodels.BulkJob, networkIds []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	key := bulkJobKey(job.Id)
+	runningBulkJobs.Store(key, cancel)
+	log.Infof("Job %d started, %s to %d chargers", job.Id, job.Action, len(networkIds))
+
+	go func() {
+		defer runningBulkJobs.Delete(key)
+		defer cancel()
+		runBulkJob(ctx, job, networkIds)
+
+		err := serviceState.BulkJobs.UpdateJobStatus(context.Background(), job.Tenant, job.Id, dbmodels.BulkJobStatus_Running,
+			dbmodels.BulkJobStatus_Completed, time.Now().UTC())
+		if err != nil && !errors.Is(err, db.ErrNotFound) { // not found if cancelled
+			log.Errorf("Error completing job %d: %s", job.Id, err.Error())
+			return
+		}
+		if err == nil {
+			log.Infof("Job %d completed", job.Id)
+		}
+	}()
+}
+
+// Sends the action to the chargers, job.Concurrency at a time and at most job.RatePerSec a second
+func runBulkJob(ctx context.Context, job *dbmodels.BulkJob, networkIds []string) {
+	targets := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < job.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for networkId := range targets {
+				result := runBulkTarget(ctx, job, networkId)
+				completedAt := time.Now().UTC()
+				result.CompletedAt = &completedAt
+				if err := serviceState.BulkJobs.SetResult(context.Background(), result); err != nil {
+					logging.ForCharger(networkId).Errorf("Error recording job %d result: %s", job.Id, err.Error())
+				}
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if job.RatePerSec > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(job.RatePerSec))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+dispatch:
+	for i, networkId := range networkIds {
+		if tick != nil && i > 0 {
+			select {
+			case <-ctx.Done():
+				break dispatch
+			case <-tick:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case targets <- networkId:
+		}
+	}
+	close(targets)
+	wg.Wait()
+}
+
+// Sends the action to a charger, returning its result
+func runBulkTarget(ctx context.Context, job *dbmodels.BulkJob, networkId string) *dbmodels.BulkJobResult {
+	result := &dbmodels.BulkJobResult{JobId: job.Id, NetworkId: networkId}
+
+	device, err := dbGetDevice(ctx, networkId)
+	if errors.Is(err, db.ErrNotFound) {
+		result.Status, result.Error = dbmodels.BulkResultStatus_Error, "unknown device"
+		return result
+	}
+	if err != nil {
+		result.Status, result.Error = dbmodels.BulkResultStatus_Error, err.Error()
+		return result
+	}
+	if device.Record.Disabled {
+		result.Status, result.Error = dbmodels.BulkResultStatus_Error, "device is disabled"
+		return result
+	}
+
+	// Cancelling the job stops sending, but a call already sent still gets its response
+	response, err := sendActionAndWait(context.WithoutCancel(ctx), device, job.Action, job.Payload)
+	if errors.Is(err, errActionTimeout) {
+		result.Status, result.Error = dbmodels.BulkResultStatus_TimedOut, err.Error()
+		return result
+	}
+	if err != nil {
+		result.Status, result.Error = dbmodels.BulkResultStatus_Error, err.Error()
+		return result
+	}
+
+	result.Response = response.MessageBody
+	if responseAccepted(response.MessageBody) {
+		result.Status = dbmodels.BulkResultStatus_Accepted
+	} else {
+		result.Status = dbmodels.BulkResultStatus_Rejected
+	}
+	return result
+}
+
+// Marks the jobs left running by this instance before it restarted as interrupted. Other instances' jobs are
+// still running
+func interruptBulkJobs(ctx context.Context) {
+	interrupted, err := serviceState.BulkJobs.InterruptJobs(ctx, serviceState.Config.Services.DeviceManager.Tenant,
+		serviceState.Context.HostName, time.Now().UTC())
+	if err != nil {
+		log.Errorf("Error interrupting jobs: %s", err.Error())
+		return
+	}
+	if interrupted > 0 {
+		log.Warnf("Jobs interrupted by restart: %d", interrupted)
+	}
+}
+
+// Marks running jobs without a result for longer than any action is awaited as interrupted, whichever instance runs
+// them, so those of an instance which stopped and didn't restart don't stay running. A running job completes a result
+// at least every action timeout, as its rate is at least one a second
+func expireBulkJobs(ctx context.Context) {
+	inactiveSince := time.Now().UTC().Add(-longestCommandTimeout() - expiryGrace)
+	expired, err := serviceState.BulkJobs.ExpireJobs(ctx, serviceState.Config.Services.DeviceManager.Tenant, inactiveSince, time.Now().UTC())
+	if err != nil {
+		log.Errorf("Error expiring jobs: %s", err.Error())
+		return
+	}
+	if expired > 0 {
+		log.Warnf("Jobs expired, no longer running: %d", expired)
+	}
+}
+
+func bulkMaxConcurrency(config conf.BulkJobsConfig) int {
+	if config.MaxConcurrency <= 0 {
+		return defaultBulkMaxConcurrency
+	}
+	return config.MaxConcurrency
+}
+
+func bulkMaxTargets(config conf.BulkJobsConfig) int {
+	if config.MaxTargets <= 0 {
+		return defaultBulkMaxTargets
+	}
+	return config.MaxTargets
+}
+
+func bulkJobKey(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
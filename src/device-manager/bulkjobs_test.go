@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupJobsRouter(t *testing.T) (http.Handler, *fakeMqBus) {
+	bus, _ := setupDeviceState(t)
+	serviceState.BulkJobs = memdb.NewBulkJobRepository()
+	t.Cleanup(func() {
+		require.Eventually(t, func() bool { return runningBulkJobs.Size() == 0 }, 5*time.Second, 10*time.Millisecond, "jobs still running")
+	})
+	for _, device := range []dbmodels.Device{
+		{NetworkId: "charger-1", Tags: []string{"site-1"}},
+		{NetworkId: "charger-2", Tags: []string{"site-1"}, FirmwareVersion: "1.0"},
+		{NetworkId: "charger-3", Tags: []string{"site-1"}, Disabled: true},
+		{NetworkId: "charger-4", FirmwareVersion: "1.0"},
+	} {
+		_, err := serviceState.Devices.InsertDevice(context.Background(), &device)
+		require.NoError(t, err)
+	}
+
+	return apiRouter(), bus
+}
+
+func getJob(t *testing.T, router http.Handler, target string) *dbmodels.BulkJob {
+	rec := serveDevices(router, http.MethodGet, target, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	job := &dbmodels.BulkJob{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), job))
+	return job
+}
+
+func TestBulkJob(t *testing.T) {
+	router, bus := setupJobsRouter(t)
+
+	rec := serveDevices(router, http.MethodPost, "/jobs", `{"action":"Reset","payload":{"type":"Soft"},"selector":{"tag":"Site-1"},"concurrency":1}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	job := &dbmodels.BulkJob{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), job))
+	assert.Equal(t, 2, job.Total, "enabled devices only")
+	assert.Equal(t, dbmodels.BulkJobStatus_Running, job.Status)
+
+	for _, reply := range []struct{ networkId, status string }{{"charger-1", "Accepted"}, {"charger-2", "Rejected"}} {
+		call := bus.next(t)
+		assert.Equal(t, reply.networkId, call.Client)
+		assert.Equal(t, "Reset", call.Body.MessageType)
+		assert.JSONEq(t, `{"type":"Soft"}`, string(call.Body.MessageBody))
+		ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": reply.status}), nil)
+	}
+
+	require.Eventually(t, func() bool {
+		job = getJob(t, router, "/jobs/1")
+		return job.Status == dbmodels.BulkJobStatus_Completed
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]int{dbmodels.BulkResultStatus_Accepted: 1, dbmodels.BulkResultStatus_Rejected: 1}, job.Results)
+
+	rec = serveDevices(router, http.MethodGet, "/jobs/1/results?status=rejected", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var results []dbmodels.BulkJobResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+	require.Len(t, results, 1)
+	assert.Equal(t, "charger-2", results[0].NetworkId)
+	assert.JSONEq(t, `{"status":"Rejected"}`, string(results[0].Response))
+	assert.NotNil(t, results[0].CompletedAt)
+
+	rec = serveDevices(router, http.MethodGet, "/jobs/1/events", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "event: done\ndata: {")
+	assert.Equal(t, http.StatusConflict, serveDevices(router, http.MethodPost, "/jobs/1/cancel", "").Code, "already completed")
+	assert.Equal(t, http.StatusNotFound, serveDevices(router, http.MethodGet, "/jobs/2", "").Code)
+}
+
+func TestBulkJobCancel(t *testing.T) {
+	router, bus := setupJobsRouter(t)
+
+	rec := serveDevices(router, http.MethodPost, "/jobs", `{"action":"ChangeConfiguration","payload":{"key":"HeartbeatInterval","value":"300"},
+		"selector":{"networkIds":["charger-9","charger-1","charger-2","charger-1"]},"concurrency":1}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	assert.JSONEq(t, `3`, string(mustField(t, rec.Body.Bytes(), "total")), "deduped")
+
+	call := bus.next(t)
+	assert.Equal(t, "charger-1", call.Client, "unknown device skipped")
+	rec = serveDevices(router, http.MethodPost, "/jobs/1/cancel", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `"cancelled"`, string(mustField(t, rec.Body.Bytes(), "status")))
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "RebootRequired"}), nil)
+
+	require.Eventually(t, func() bool {
+		return getJob(t, router, "/jobs/1").Results[dbmodels.BulkResultStatus_Accepted] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	job := getJob(t, router, "/jobs/1")
+	assert.Equal(t, dbmodels.BulkJobStatus_Cancelled, job.Status)
+	assert.Equal(t, map[string]int{dbmodels.BulkResultStatus_Accepted: 1, dbmodels.BulkResultStatus_Error: 1, dbmodels.BulkResultStatus_Pending: 1},
+		job.Results)
+	assert.Empty(t, bus.published, "charger-2 not sent")
+
+	rec = serveDevices(router, http.MethodGet, "/jobs?status=cancelled", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var jobs []dbmodels.BulkJob
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &jobs))
+	assert.Len(t, jobs, 1)
+}
+
+func TestBulkJobInvalid(t *testing.T) {
+	router, _ := setupJobsRouter(t)
+	for _, body := range []string{
+		`{"action":"RemoteStartTransaction","payload":{},"selector":{"tag":"site-1"}}`,
+		`{"action":"Reset","payload":"Soft","selector":{"tag":"site-1"}}`,
+		`{"action":"Reset","payload":{}}`,
+		`{"action":"Reset","payload":{},"selector":{"networkIds":["charger-1"],"tag":"site-1"}}`,
+		`{"action":"Reset","payload":{},"selector":{"networkIds":["charger 1"]}}`,
+		`{"action":"Reset","payload":{},"selector":{"tag":"site-2"}}`,
+		`{"action":"Reset","payload":{},"selector":{"firmwareVersion":"1.0"},"concurrency":51}`,
+		`{"action":"Reset","payload":{},"selector":{"firmwareVersion":"1.0"},"ratePerSec":-1}`,
+		`{"action":"ChangeConfiguration","payload":{"key":"authorizationkey","value":"x"},"selector":{"tag":"site-1"}}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodPost, "/jobs", body).Code, body)
+	}
+
+	serviceState.Config.Services.DeviceManager.BulkJobs.MaxTargets = 1
+	assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodPost, "/jobs",
+		`{"action":"Reset","payload":{},"selector":{"firmwareVersion":"1.0"}}`).Code, "too many targets")
+	assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodGet, "/jobs?status=done", "").Code)
+}
+
+func TestBulkJobExpiry(t *testing.T) {
+	setupJobsRouter(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	// Run by an instance which stopped, and a job another instance is still running, with a recent result
+	abandoned := &dbmodels.BulkJob{Owner: "node-2", Action: "Reset", Payload: json.RawMessage(`{}`), Status: dbmodels.BulkJobStatus_Running,
+		CreatedAt: now.Add(-time.Hour)}
+	running := &dbmodels.BulkJob{Owner: "node-2", Action: "Reset", Payload: json.RawMessage(`{}`), Status: dbmodels.BulkJobStatus_Running,
+		CreatedAt: now.Add(-time.Hour)}
+	for _, job := range []*dbmodels.BulkJob{abandoned, running} {
+		_, err := serviceState.BulkJobs.InsertJob(ctx, job, []string{"charger-1", "charger-2"})
+		require.NoError(t, err)
+	}
+	completedAt := now.Add(-time.Minute)
+	require.NoError(t, serviceState.BulkJobs.SetResult(ctx, &dbmodels.BulkJobResult{JobId: running.Id, NetworkId: "charger-1",
+		Status: dbmodels.BulkResultStatus_Accepted, CompletedAt: &completedAt}))
+
+	expireBulkJobs(ctx)
+	got, err := serviceState.BulkJobs.GetJob(ctx, "", abandoned.Id)
+	require.NoError(t, err)
+	assert.Equal(t, dbmodels.BulkJobStatus_Interrupted, got.Status)
+	got, err = serviceState.BulkJobs.GetJob(ctx, "", running.Id)
+	require.NoError(t, err)
+	assert.Equal(t, dbmodels.BulkJobStatus_Running, got.Status)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
+	"sw/ocpp/csms/internal/logging"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mqmodels "sw/ocpp/csms/internal/models/mq"
+	mq "sw/ocpp/csms/internal/mq"
+	ocppmodels "sw/ocpp/csms/internal/ocpp"
+	"sw/ocpp/csms/internal/pki"
+	"sw/ocpp/csms/internal/tracing"
+
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultRenewBeforeDays   = 30
+	defaultRenewIntervalMins = 60
+	certificateTimeout       = 30 * time.Second
+)
+
+// Answers a charger's SignCertificate, then signs its CSR and sends the chain with CertificateSigned
+func processSignCertificate(ctx context.Context, msgEnvelope *mqmodels.MqMessageEnvelope, ocppMessage *ocppmodels.OcppMessage) {
+	mlog := logging.ForMessage(ctx, msgEnvelope.Client, ocppMessage.MsgId, "SignCertificate")
+
+	status := ocppmodels.GenericStatus_Rejected
+	var csr *x509.CertificateRequest
+	if serviceState.CertificateAuthority == nil {
+		mlog.Warn("No pki configured, rejecting SignCertificate")
+	} else {
+		signCertificate := &ocppmodels.OcppSignCertificate{}
+		err := json.Unmarshal(ocppMessage.MessageBody, signCertificate)
+		if err == nil {
+			csr, err = pki.ParseCsr(signCertificate.Csr, msgEnvelope.Client)
+		}
+		if err != nil {
+			mlog.Warnf("Rejecting SignCertificate: %s", err.Error())
+		} else {
+			status = ocppmodels.GenericStatus_Accepted
+		}
+	}
+
+	if err := replyToCharger(ctx, msgEnvelope, ocppMessage.MsgId, &ocppmodels.OcppStatusResponse{Status: status}); err != nil {
+		mlog.Errorf("Error sending reply to MQ, msg lost: %s", err.Error())
+		tracing.SetError(ctx, err)
+		return
+	}
+	if csr != nil {
+		// the charger's CertificateSigned response is received by this goroutine's caller, so it can't wait here
+		device := &Device{NetworkId: msgEnvelope.Client, ServerNode: msgEnvelope.ServerNode}
+		go issueCertificate(context.WithoutCancel(ctx), device, csr)
+	}
+}
+
+// Signs a CSR, records the certificate and sends it to the charger, recording whether the charger accepts it
+func issueCertificate(ctx context.Context, device *Device, csr *x509.CertificateRequest) {
+	clog := logging.ForCharger(device.NetworkId).WithContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, certificateTimeout)
+	defer cancel()
+
+	chain, err := serviceState.CertificateAuthority.SignCsr(ctx, csr)
+	if err != nil {
+		clog.Errorf("Error signing CSR: %s", err.Error())
+		return
+	}
+	tenant := serviceState.Config.Services.DeviceManager.Tenant
+	certificate := &dbmodels.Certificate{
+		Tenant:           tenant,
+		NetworkId:        device.NetworkId,
+		CertificateType:  ocppmodels.CertificateType_ChargePoint,
+		SerialNumber:     chain[0].SerialNumber.Text(16),
+		CommonName:       chain[0].Subject.CommonName,
+		Status:           dbmodels.CertificateStatus_Issued,
+		CertificateChain: pki.EncodeChain(chain),
+		NotBefore:        chain[0].NotBefore,
+		NotAfter:         chain[0].NotAfter,
+		IssuedAt:         time.Now(),
+	}
+	id, err := serviceState.Certificates.InsertCertificate(ctx, certificate)
+	if err != nil {
+		clog.Errorf("Error recording certificate %s: %s", certificate.SerialNumber, err.Error())
+		return
+	}
+
+	status := dbmodels.CertificateStatus_Rejected
+	payload, _ := json.Marshal(ocppmodels.OcppCertificateSigned{CertificateChain: certificate.CertificateChain})
+	response, err := sendActionAndWait(ctx, device, ocppmodels.MsgType_CertificateSigned, payload)
+	if err != nil {
+		clog.Errorf("Error sending CertificateSigned: %s", err.Error())
+	} else {
+		signedResponse := &ocppmodels.OcppStatusResponse{}
+		if err := json.Unmarshal(response.MessageBody, signedResponse); err != nil {
+			clog.Errorf("Invalid CertificateSigned response: %s", err.Error())
+		} else if signedResponse.Status == ocppmodels.GenericStatus_Accepted {
+			status = dbmodels.CertificateStatus_Accepted
+		}
+	}
+
+	if err := serviceState.Certificates.UpdateCertificateStatus(ctx, id, status); err != nil {
+		clog.Errorf("Error updating certificate %s: %s", certificate.SerialNumber, err.Error())
+		return
+	}
+	if status == dbmodels.CertificateStatus_Accepted {
+		if err := serviceState.Certificates.SupersedeCertificates(ctx, tenant, device.NetworkId, certificate.CertificateType, id); err != nil {
+			clog.Errorf("Error superseding certificates: %s", err.Error())
+		}
+	}
+	clog.Infof("Certificate %s %s, expires: %s", certificate.SerialNumber, status, certificate.NotAfter.Format(time.RFC3339))
+}
+
+// Publishes a response to a charger's call to MessagesOut
+func replyToCharger(ctx context.Context, msgEnvelope *mqmodels.MqMessageEnvelope, msgId string, payload any) error {
+	payloadBy, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ocppResponse := &ocppmodels.OcppMessageResponse{MsgId: msgId, Direction: ocppmodels.OcppDirection_Reply, MessageBody: payloadBy}
+	json, err := mq.MqCreateMessageEnvelope(ctx, msgEnvelope.ServerNode, msgEnvelope.Client, ocppResponse)
+	if err != nil {
+		return err
+	}
+	return serviceState.MqBus.MqMessagePublishRetry(mq.MqChannelName_MessagesOut, json)
+}
+
+func renewBefore(config conf.PkiConfig) time.Duration {
+	days := config.RenewBeforeDays
+	if days <= 0 {
+		days = defaultRenewBeforeDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func renewInterval(config conf.PkiConfig) time.Duration {
+	mins := config.RenewIntervalMins
+	if mins <= 0 {
+		mins = defaultRenewIntervalMins
+	}
+	return time.Duration(mins) * time.Minute
+}
+
+// Asks chargers with accepted certificates expiring within renewBefore to send a new CSR, until ctx is cancelled
+func runCertificateRenewal(ctx context.Context, interval time.Duration, renewBefore time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		renewCertificates(ctx, time.Now(), renewBefore)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Triggers SignCertificate on each charger with an expiring certificate, once per certificate
+func renewCertificates(ctx context.Context, now time.Time, renewBefore time.Duration) {
+	expiresBefore := now.Add(renewBefore)
+	expiring, err := serviceState.Certificates.ListCertificates(ctx, dbmodels.CertificateFilter{
+		Tenant: serviceState.Config.Services.DeviceManager.Tenant,
+		Status: dbmodels.CertificateStatus_Accepted, ExpiresBefore: &expiresBefore, RenewalNotRequested: true})
+	if err != nil {
+		log.Errorf("Error listing expiring certificates: %s", err.Error())
+		return
+	}
+
+	for _, certificate := range expiring {
+		clog := logging.ForCharger(certificate.NetworkId)
+		device, err := dbGetDevice(ctx, certificate.NetworkId)
+		if errors.Is(err, db.ErrNotFound) {
+			clog.Warnf("Certificate %s not renewed, not a device", certificate.SerialNumber)
+			continue
+		}
+		if err != nil {
+			clog.Errorf("Error getting device: %s", err.Error())
+			continue
+		}
+		payload, _ := json.Marshal(ocppmodels.OcppExtendedTriggerMessage{RequestedMessage: ocppmodels.TriggerMessage_SignChargePointCertificate})
+		response, err := sendActionAndWait(ctx, device, ocppmodels.MsgType_ExtendedTriggerMessage, payload)
+		if err != nil {
+			clog.Warnf("Error triggering certificate %s renewal, retrying later: %s", certificate.SerialNumber, err.Error())
+			continue
+		}
+		triggerResponse := &ocppmodels.OcppStatusResponse{}
+		if err := json.Unmarshal(response.MessageBody, triggerResponse); err != nil || triggerResponse.Status != ocppmodels.GenericStatus_Accepted {
+			clog.Warnf("Charger didn't accept certificate %s renewal, retrying later: %s", certificate.SerialNumber, string(response.MessageBody))
+			continue
+		}
+		if err := serviceState.Certificates.SetRenewalRequested(ctx, certificate.Id, now); err != nil {
+			clog.Errorf("Error recording certificate %s renewal: %s", certificate.SerialNumber, err.Error())
+			continue
+		}
+		clog.Infof("Certificate %s renewal requested, expires: %s", certificate.SerialNumber, certificate.NotAfter.Format(time.RFC3339))
+	}
+}
+
+// Lists the certificates issued to the device, newest first, filtered by query parameter: status
+func certificates_List(w http.ResponseWriter, r *http.Request) {
+	device := r.Context().Value("device").(*Device)
+
+	filter := dbmodels.CertificateFilter{Tenant: device.Record.Tenant, NetworkId: device.NetworkId, Status: r.URL.Query().Get("status")}
+	certificates, err := serviceState.Certificates.ListCertificates(r.Context(), filter)
+	if err != nil {
+		log.Errorf("Error listing certificates: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, certificates)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mq "sw/ocpp/csms/internal/mq"
+	"sw/ocpp/csms/internal/pki"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Passes published messages to the test
+type fakeMqBus struct {
+	mq.MqBus
+	published chan string
+}
+
+func (f *fakeMqBus) MqMessagePublishRetry(channel string, json string) error {
+	f.published <- json
+	return nil
+}
+
+type publishedMessage struct {
+	ServerNode string `json:"serverNode"`
+	Client     string `json:"client"`
+	Body       struct {
+		Direction   int             `json:"direction"`
+		MsgId       string          `json:"msgId"`
+		MessageType string          `json:"messageType"`
+		MessageBody json.RawMessage `json:"messageBody"`
+	} `json:"body"`
+}
+
+func (f *fakeMqBus) next(t *testing.T) publishedMessage {
+	select {
+	case json := <-f.published:
+		var message publishedMessage
+		require.NoError(t, jsonUnmarshal(json, &message))
+		return message
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "nothing published")
+		return publishedMessage{}
+	}
+}
+
+func jsonUnmarshal(s string, v any) error {
+	return json.Unmarshal([]byte(s), v)
+}
+
+func setupCertificateState(t *testing.T) (*fakeMqBus, *memdb.CertificateRepository) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "chargers-ca"}, NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().AddDate(1, 0, 0), KeyUsage: x509.KeyUsageCertSign, IsCA: true, BasicConstraintsValid: true}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	ca, err := pki.NewLocalCa(certFile, keyFile, 24*time.Hour)
+	require.NoError(t, err)
+
+	bus := &fakeMqBus{published: make(chan string, 10)}
+	certificates := memdb.NewCertificateRepository()
+	serviceState = &ServiceState{Config: &conf.Configuration{}, MqBus: bus, MessagesWaiting: xsync.NewMap(), Devices: memdb.NewDeviceRepository(),
+		Certificates: certificates, CertificateAuthority: ca}
+	t.Cleanup(func() {
+		serviceState = nil
+		deviceConnections.Clear()
+	})
+	return bus, certificates
+}
+
+func generateCsrPem(t *testing.T, commonName string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}, key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func chargerMessage(t *testing.T, direction int, msgId string, messageType string, payload any) []byte {
+	payloadBy, err := json.Marshal(payload)
+	require.NoError(t, err)
+	return []byte(fmt.Sprintf(`{"serverNode":"node1","client":"charger-1","messageTime":"2024-09-27T09:00:00.000Z",
+		"body":{"direction":%d,"msgId":"%s","messageType":"%s","messageBody":%s}}`, direction, msgId, messageType, payloadBy))
+}
+
+func TestSignCertificate(t *testing.T) {
+	bus, certificates := setupCertificateState(t)
+	serviceState.Config.Services.DeviceManager.Tenant = "t1"
+
+	ProcessRecvMessage(chargerMessage(t, 2, "m1", "SignCertificate", map[string]string{"csr": generateCsrPem(t, "charger-1")}), nil)
+
+	reply := bus.next(t)
+	assert.Equal(t, 3, reply.Body.Direction)
+	assert.Equal(t, "m1", reply.Body.MsgId)
+	assert.JSONEq(t, `{"status":"Accepted"}`, string(reply.Body.MessageBody))
+
+	call := bus.next(t)
+	assert.Equal(t, "charger-1", call.Client)
+	assert.Equal(t, "CertificateSigned", call.Body.MessageType)
+	var signed struct {
+		CertificateChain string `json:"certificateChain"`
+	}
+	require.NoError(t, json.Unmarshal(call.Body.MessageBody, &signed))
+	chain, err := pki.ParseChain(signed.CertificateChain)
+	require.NoError(t, err)
+	assert.Equal(t, "charger-1", chain[0].Subject.CommonName)
+
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Accepted"}), nil)
+
+	require.Eventually(t, func() bool {
+		issued, err := certificates.ListCertificates(context.Background(), dbmodels.CertificateFilter{NetworkId: "charger-1"})
+		return err == nil && len(issued) == 1 && issued[0].Status == dbmodels.CertificateStatus_Accepted
+	}, 5*time.Second, 10*time.Millisecond)
+	issued, _ := certificates.ListCertificates(context.Background(), dbmodels.CertificateFilter{NetworkId: "charger-1"})
+	assert.Equal(t, chain[0].SerialNumber.Text(16), issued[0].SerialNumber)
+	assert.Equal(t, chain[0].NotAfter, issued[0].NotAfter)
+	assert.Equal(t, "t1", issued[0].Tenant)
+}
+
+func TestSignCertificateRejected(t *testing.T) {
+	bus, certificates := setupCertificateState(t)
+
+	ProcessRecvMessage(chargerMessage(t, 2, "m1", "SignCertificate", map[string]string{"csr": generateCsrPem(t, "charger-2")}), nil)
+
+	reply := bus.next(t)
+	assert.JSONEq(t, `{"status":"Rejected"}`, string(reply.Body.MessageBody))
+	assert.Empty(t, bus.published, "no CertificateSigned")
+	issued, err := certificates.ListCertificates(context.Background(), dbmodels.CertificateFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, issued)
+}
+
+func TestRenewCertificates(t *testing.T) {
+	bus, certificates := setupCertificateState(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := serviceState.Devices.InsertDevice(ctx, &dbmodels.Device{NetworkId: "charger-1"})
+	require.NoError(t, err)
+
+	expiring := &dbmodels.Certificate{NetworkId: "charger-1", CertificateType: "ChargePointCertificate", SerialNumber: "01",
+		Status: dbmodels.CertificateStatus_Accepted, NotAfter: now.AddDate(0, 0, 10), IssuedAt: now.AddDate(-1, 0, 0)}
+	_, err = certificates.InsertCertificate(ctx, expiring)
+	require.NoError(t, err)
+	_, err = certificates.InsertCertificate(ctx, &dbmodels.Certificate{NetworkId: "charger-3", CertificateType: "ChargePointCertificate",
+		SerialNumber: "03", Status: dbmodels.CertificateStatus_Accepted, NotAfter: now.AddDate(0, 0, 10), IssuedAt: now.AddDate(-1, 0, 0)})
+	require.NoError(t, err)
+	_, err = certificates.InsertCertificate(ctx, &dbmodels.Certificate{NetworkId: "charger-2", CertificateType: "ChargePointCertificate",
+		SerialNumber: "02", Status: dbmodels.CertificateStatus_Accepted, NotAfter: now.AddDate(0, 6, 0), IssuedAt: now})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		renewCertificates(ctx, now, 30*24*time.Hour)
+		close(done)
+	}()
+
+	call := bus.next(t)
+	assert.Equal(t, "charger-1", call.Client)
+	assert.Equal(t, "ExtendedTriggerMessage", call.Body.MessageType)
+	assert.JSONEq(t, `{"requestedMessage":"SignChargePointCertificate"}`, string(call.Body.MessageBody))
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Accepted"}), nil)
+	<-done
+
+	renewed, err := certificates.ListCertificates(ctx, dbmodels.CertificateFilter{NetworkId: "charger-1"})
+	require.NoError(t, err)
+	require.NotNil(t, renewed[0].RenewalRequestedAt)
+	assert.Empty(t, bus.published, "only the expiring certificate of a device is renewed")
+}
+
+func TestCertificatesListByTenant(t *testing.T) {
+	_, certificates := setupCertificateState(t)
+	serviceState.Config.Services.DeviceManager.Tenant = "t1"
+	ctx := context.Background()
+	_, err := serviceState.Devices.InsertDevice(ctx, &dbmodels.Device{Tenant: "t1", NetworkId: "charger-1"})
+	require.NoError(t, err)
+	for _, tenant := range []string{"t1", "t2"} {
+		_, err = certificates.InsertCertificate(ctx, &dbmodels.Certificate{Tenant: tenant, NetworkId: "charger-1", SerialNumber: tenant,
+			Status: dbmodels.CertificateStatus_Accepted, IssuedAt: time.Now()})
+		require.NoError(t, err)
+	}
+
+	router := apiRouter()
+	rec := serveDevices(router, http.MethodGet, "/certificates/charger-1", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var listed []dbmodels.Certificate
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, "t1", listed[0].SerialNumber)
+}
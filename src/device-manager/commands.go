@@ -0,0 +1,398 @@
+// Commands are actions sent to a charger in the background, for clients which can't hold a request open until the
+// charger responds, e.g a slow GetDiagnostics. An action requested with ?async=true returns 202 with the command, whose
+// status and the charger's response are recorded, and POSTed to its callbackUrl once it completes
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
+	"sw/ocpp/csms/internal/logging"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	ocppmodels "sw/ocpp/csms/internal/ocpp"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultCommandTimeoutSecs  = 60
+	defaultCallbackTimeoutSecs = 10
+	callbackAttempts           = 3
+	callbackSignatureHeader    = "X-Csms-Signature"
+	callbackTimestampHeader    = "X-Csms-Timestamp"
+	// Added to the longest a command or job's action can be awaited before it's expired, for differences in
+	// instances' config and clocks
+	expiryGrace = time.Minute
+)
+
+// Response statuses counted as accepted. Responses without a status, e.g GetConfiguration, are accepted too
+var acceptedStatuses = []string{"Accepted", "RebootRequired", "Scheduled", "Unlocked"}
+
+// Delay before retrying a failed callback, doubled for each retry
+var callbackRetryDelay = time.Second
+
+// Commands being sent or awaited
+var commandsInFlight sync.WaitGroup
+
+// How often commands and jobs no longer run by any instance are expired
+var expiryInterval = time.Minute
+
+// Lists commands, newest first, filtered by query parameters: networkId, status, limit, offset
+func commands_List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := dbmodels.CommandFilter{Tenant: serviceState.Config.Services.DeviceManager.Tenant, NetworkId: query.Get("networkId")}
+
+	switch status := query.Get("status"); status {
+	case "", dbmodels.CommandStatus_Queued, dbmodels.CommandStatus_Sent, dbmodels.CommandStatus_Accepted, dbmodels.CommandStatus_Rejected,
+		dbmodels.CommandStatus_Error, dbmodels.CommandStatus_TimedOut:
+		filter.Status = status
+	default:
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid status: %s", status)))
+		return
+	}
+	var err error
+	filter.Limit, filter.Offset, err = pageFromQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	commands, err := serviceState.Commands.ListCommands(r.Context(), filter)
+	if err != nil {
+		log.Errorf("Error listing commands: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, commands)
+}
+
+// Gets a single command, with the charger's response once it has one
+func commands_Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "commandid"), 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("invalid commandid")))
+		return
+	}
+
+	command, err := serviceState.Commands.GetCommand(r.Context(), serviceState.Config.Services.DeviceManager.Tenant, id)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error getting command: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, command)
+}
+
+// Whether the action was requested with ?async=true
+func asyncFromQuery(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("async")
+	if value == "" {
+		return false, nil
+	}
+	async, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid async: %s", value)
+	}
+	return async, nil
+}
+
+// Records the action as a queued command and sends it in the background, responding 202 with the command. The
+// optional callbackUrl query parameter is POSTed the command once it completes. A zero timeout is the command's
+// configured timeout, see commandTimeout
+func queueCommand(w http.ResponseWriter, r *http.Request, device *Device, msgType string, payload json.RawMessage, timeout time.Duration) {
+	if !json.Valid(payload) {
+		render.Render(w, r, ErrInvalidRequest(errors.New("payload isn't valid JSON")))
+		return
+	}
+	callbackUrl := r.URL.Query().Get("callbackUrl")
+	if callbackUrl != "" {
+		if err := validateCallbackUrl(serviceState.Config.Services.DeviceManager.Commands, callbackUrl); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+	}
+
+	command := &dbmodels.Command{Tenant: device.Record.Tenant, Owner: serviceState.Context.HostName, NetworkId: device.NetworkId, Action: msgType, Payload: payload,
+		Status: dbmodels.CommandStatus_Queued, CallbackUrl: callbackUrl, CreatedAt: time.Now().UTC()}
+	if _, err := serviceState.Commands.InsertCommand(r.Context(), command); err != nil {
+		log.Errorf("Error creating command: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+
+	// Taken before responding, as render.Status replaces the request's context
+	ctx := context.WithoutCancel(r.Context())
+	queued := *command
+	commandsInFlight.Add(1)
+	go func() {
+		defer commandsInFlight.Done()
+		runCommand(ctx, command, device, timeout)
+	}()
+
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, queued)
+}
+
+// Checks the callbackUrl is http(s) to an allowed host. Hostnames are only resolved when called back, where
+// callbackDialControl checks the address
+func validateCallbackUrl(config conf.CommandsConfig, callbackUrl string) error {
+	u, err := url.Parse(callbackUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("invalid callbackUrl: %s", callbackUrl)
+	}
+	if len(config.CallbackAllowedHosts) > 0 {
+		if !slices.Contains(config.CallbackAllowedHosts, u.Hostname()) {
+			return fmt.Errorf("callbackUrl host not allowed: %s", u.Hostname())
+		}
+		return nil
+	}
+	if u.Hostname() == "localhost" {
+		return fmt.Errorf("callbackUrl host not allowed: %s", u.Hostname())
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !publicAddress(ip) {
+		return fmt.Errorf("callbackUrl host not allowed: %s", u.Hostname())
+	}
+	return nil
+}
+
+// Whether the address is routable on the internet, not loopback, private, link-local e.g cloud metadata at
+// 169.254.169.254, unspecified or multicast
+func publicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// Refuses connections to non-public addresses, checked once the callback's host is resolved so DNS can't point an
+// allowed name at an internal service. Allowed hosts are trusted with any address
+func callbackDialControl(config conf.CommandsConfig) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if len(config.CallbackAllowedHosts) > 0 {
+			return nil
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+			return fmt.Errorf("callback address not allowed: %s", host)
+		}
+		return nil
+	}
+}
+
+// Sends the command and waits for the charger's response, recording each step, then calls back if requested
+func runCommand(ctx context.Context, command *dbmodels.Command, device *Device, timeout time.Duration) {
+	clog := logging.ForCharger(command.NetworkId).WithContext(ctx)
+	if timeout == 0 {
+		timeout = commandTimeout(command.Action)
+	}
+
+	action, err := sendAction(ctx, device, command.Action, command.Payload)
+	if err != nil {
+		completeCommand(ctx, command, dbmodels.CommandStatus_Error, nil, err)
+		return
+	}
+	sentAt := time.Now().UTC()
+	command.MsgId, command.Status, command.SentAt = action.MsgId, dbmodels.CommandStatus_Sent, &sentAt
+	if err := serviceState.Commands.UpdateCommand(ctx, command); err != nil {
+		clog.Errorf("Error recording command %d sent: %s", command.Id, err.Error())
+	}
+
+	response, err := action.await(ctx, timeout)
+	switch {
+	case errors.Is(err, errActionTimeout):
+		completeCommand(ctx, command, dbmodels.CommandStatus_TimedOut, nil, err)
+	case err != nil:
+		completeCommand(ctx, command, dbmodels.CommandStatus_Error, nil, err)
+	case responseAccepted(response.MessageBody):
+		completeCommand(ctx, command, dbmodels.CommandStatus_Accepted, response.MessageBody, nil)
+	default:
+		completeCommand(ctx, command, dbmodels.CommandStatus_Rejected, response.MessageBody, nil)
+	}
+}
+
+func completeCommand(ctx context.Context, command *dbmodels.Command, status string, response json.RawMessage, err error) {
+	clog := logging.ForCharger(command.NetworkId).WithContext(ctx)
+	completedAt := time.Now().UTC()
+	command.Status, command.Response, command.CompletedAt = status, response, &completedAt
+	if err != nil {
+		command.Error = err.Error()
+	}
+	if err := serviceState.Commands.UpdateCommand(ctx, command); err != nil {
+		clog.Errorf("Error recording command %d result: %s", command.Id, err.Error())
+	}
+	clog.Infof("Command %d %s: %s", command.Id, command.Action, command.Status)
+
+	if command.CallbackUrl != "" {
+		if err := deliverCallback(ctx, serviceState.Config.Services.DeviceManager.Commands, command); err != nil {
+			clog.Warnf("Command %d callback failed: %s", command.Id, err.Error())
+		}
+	}
+}
+
+// POSTs the command to its callbackUrl, retrying until it responds 2xx or the attempts run out
+func deliverCallback(ctx context.Context, config conf.CommandsConfig, command *dbmodels.Command) error {
+	body, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+	timeoutSecs := config.CallbackTimeoutSecs
+	if timeoutSecs <= 0 {
+		timeoutSecs = defaultCallbackTimeoutSecs
+	}
+	dialer := &net.Dialer{Timeout: time.Duration(timeoutSecs) * time.Second, Control: callbackDialControl(config)}
+	client := &http.Client{
+		Timeout:   time.Duration(timeoutSecs) * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+		// Redirects aren't followed, as they could lead to a host which isn't allowed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	defer client.CloseIdleConnections()
+
+	delay := callbackRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = postCallback(ctx, client, config.CallbackSecret, command.CallbackUrl, body)
+		if err == nil || attempt == callbackAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func postCallback(ctx context.Context, client *http.Client, secret string, callbackUrl string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(callbackTimestampHeader, timestamp)
+		req.Header.Set(callbackSignatureHeader, callbackSignature(secret, timestamp, body))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("callback responded %d", res.StatusCode)
+	}
+	return nil
+}
+
+// sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">, so receivers can check the callback came from device-manager,
+// and reject replays with an old timestamp
+func callbackSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Whether the charger's response has an accepted status, or none
+func responseAccepted(body json.RawMessage) bool {
+	statusResponse := &ocppmodels.OcppStatusResponse{}
+	json.Unmarshal(body, statusResponse)
+	return statusResponse.Status == "" || slices.Contains(acceptedStatuses, statusResponse.Status)
+}
+
+// The time to wait for a command's response: the action's entry in actions.timeouts, else commands.timeout_secs
+func commandTimeout(msgType string) time.Duration {
+	if timeout := configuredActionTimeout(msgType); timeout > 0 {
+		return timeout
+	}
+	secs := serviceState.Config.Services.DeviceManager.Commands.TimeoutSecs
+	if secs <= 0 {
+		secs = defaultCommandTimeoutSecs
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// Marks the commands left queued or sent by this instance before it restarted as errors, as their responses can't
+// be received. Other instances' commands are still awaited
+func interruptCommands(ctx context.Context) {
+	interrupted, err := serviceState.Commands.InterruptCommands(ctx, serviceState.Config.Services.DeviceManager.Tenant,
+		serviceState.Context.HostName, time.Now().UTC())
+	if err != nil {
+		log.Errorf("Error interrupting commands: %s", err.Error())
+		return
+	}
+	if interrupted > 0 {
+		log.Warnf("Commands interrupted by restart: %d", interrupted)
+	}
+}
+
+// The longest any instance can await a command's or job's response: commands.timeout_secs, actions.timeout_secs,
+// actions.max_timeout_secs for a ?timeoutSecs, or an action's configured timeout
+func longestCommandTimeout() time.Duration {
+	config := serviceState.Config.Services.DeviceManager
+	// Without an action, the defaults of commands and actions without a configured timeout
+	longest := max(commandTimeout(""), actionTimeout(""))
+	maxSecs := config.Actions.MaxTimeoutSecs
+	if maxSecs <= 0 {
+		maxSecs = defaultMaxActionTimeoutSecs
+	}
+	longest = max(longest, time.Duration(maxSecs)*time.Second)
+	for msgType := range config.Actions.Timeouts {
+		longest = max(longest, actionTimeout(msgType))
+	}
+	return longest
+}
+
+// Marks queued and sent commands older than any instance awaits as errors, whichever instance sent them, so those
+// of an instance which stopped and didn't restart don't stay sent
+func expireCommands(ctx context.Context) {
+	createdBefore := time.Now().UTC().Add(-longestCommandTimeout() - expiryGrace)
+	expired, err := serviceState.Commands.ExpireCommands(ctx, serviceState.Config.Services.DeviceManager.Tenant, createdBefore,
+		time.Now().UTC())
+	if err != nil {
+		log.Errorf("Error expiring commands: %s", err.Error())
+		return
+	}
+	if expired > 0 {
+		log.Warnf("Commands expired, no longer awaited: %d", expired)
+	}
+}
+
+// Expires commands and jobs every interval until the context is cancelled
+func runExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		expireCommands(ctx)
+		expireBulkJobs(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
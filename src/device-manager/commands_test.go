@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	ocppmodels "sw/ocpp/csms/internal/ocpp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupCommandsRouter(t *testing.T) (http.Handler, *fakeMqBus) {
+	bus, _ := setupDeviceState(t)
+	serviceState.Commands = memdb.NewCommandRepository()
+	t.Cleanup(commandsInFlight.Wait)
+	_, err := serviceState.Devices.InsertDevice(context.Background(), &dbmodels.Device{NetworkId: "charger-1"})
+	require.NoError(t, err)
+
+	return apiRouter(), bus
+}
+
+func getCommand(t *testing.T, router http.Handler, target string) *dbmodels.Command {
+	rec := serveDevices(router, http.MethodGet, target, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	command := &dbmodels.Command{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), command))
+	return command
+}
+
+func TestCommandAsync(t *testing.T) {
+	router, bus := setupCommandsRouter(t)
+
+	rec := serveDevices(router, http.MethodPost, "/actions/reset/charger-1?async=true", `{"type":"Hard"}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	queued := &dbmodels.Command{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), queued))
+	assert.Equal(t, int64(1), queued.Id)
+	assert.Equal(t, dbmodels.CommandStatus_Queued, queued.Status)
+	assert.Equal(t, "Reset", queued.Action)
+
+	call := bus.next(t)
+	assert.Equal(t, "charger-1", call.Client)
+	assert.JSONEq(t, `{"type":"Hard"}`, string(call.Body.MessageBody))
+	require.Eventually(t, func() bool {
+		return getCommand(t, router, "/commands/1").Status == dbmodels.CommandStatus_Sent
+	}, 5*time.Second, 10*time.Millisecond)
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Accepted"}), nil)
+
+	commandsInFlight.Wait()
+	command := getCommand(t, router, "/commands/1")
+	assert.Equal(t, dbmodels.CommandStatus_Accepted, command.Status)
+	assert.Equal(t, call.Body.MsgId, command.MsgId)
+	assert.JSONEq(t, `{"status":"Accepted"}`, string(command.Response))
+	assert.NotNil(t, command.SentAt)
+	assert.NotNil(t, command.CompletedAt)
+
+	rec = serveDevices(router, http.MethodGet, "/commands?networkId=charger-1&status=accepted", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var commands []dbmodels.Command
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &commands))
+	assert.Len(t, commands, 1)
+	assert.Equal(t, http.StatusNotFound, serveDevices(router, http.MethodGet, "/commands/2", "").Code)
+}
+
+func TestCommandCallback(t *testing.T) {
+	router, bus := setupCommandsRouter(t)
+	serviceState.Config.Services.DeviceManager.Commands.CallbackSecret = "secret"
+	serviceState.Config.Services.DeviceManager.Commands.CallbackAllowedHosts = []string{"127.0.0.1"}
+	callbackRetryDelay = time.Millisecond
+	t.Cleanup(func() { callbackRetryDelay = time.Second })
+
+	callbacks := make(chan *http.Request, 3)
+	bodies := make(chan []byte, 3)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		callbacks <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	rec := serveDevices(router, http.MethodPost, "/actions/datatransfer/charger-1?async=1&callbackUrl="+server.URL,
+		`{"vendorId":"acme","messageId":"ping"}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	call := bus.next(t)
+	assert.Equal(t, ocppmodels.MsgType_DataTransfer, call.Body.MessageType)
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Rejected"}), nil)
+
+	commandsInFlight.Wait()
+	require.Len(t, callbacks, 1, "retried after 503")
+	callback, body := <-callbacks, <-bodies
+	timestamp := callback.Header.Get(callbackTimestampHeader)
+	assert.NotEmpty(t, timestamp)
+	assert.Equal(t, callbackSignature("secret", timestamp, body), callback.Header.Get(callbackSignatureHeader))
+	assert.NotEqual(t, callbackSignature("secret", "0", body), callback.Header.Get(callbackSignatureHeader), "timestamp signed")
+	assert.JSONEq(t, `"rejected"`, string(mustField(t, body, "status")))
+	assert.JSONEq(t, `{"status":"Rejected"}`, string(mustField(t, body, "response")))
+}
+
+func TestCommandCallbackAddress(t *testing.T) {
+	router, _ := setupCommandsRouter(t)
+	for _, host := range []string{"localhost", "127.0.0.1", "[::1]", "169.254.169.254", "10.0.0.1", "192.168.1.1", "0.0.0.0"} {
+		rec := serveDevices(router, http.MethodPost, "/actions/reset/charger-1?async=true&callbackUrl=http://"+host+"/hook", `{"type":"Soft"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, host)
+	}
+
+	// Hostnames are checked once resolved
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	callbackRetryDelay = time.Millisecond
+	t.Cleanup(func() { callbackRetryDelay = time.Second })
+	command := &dbmodels.Command{CallbackUrl: strings.Replace(server.URL, "127.0.0.1", "localhost", 1)}
+	err := deliverCallback(context.Background(), conf.CommandsConfig{}, command)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "callback address not allowed")
+
+	config := conf.CommandsConfig{CallbackAllowedHosts: []string{"hooks.internal"}}
+	assert.NoError(t, validateCallbackUrl(config, "http://hooks.internal:8080/commands"))
+	assert.Error(t, validateCallbackUrl(config, "https://example.com/commands"), "not listed")
+	assert.NoError(t, validateCallbackUrl(conf.CommandsConfig{}, "https://example.com/commands"))
+}
+
+func TestCommandTimeout(t *testing.T) {
+	router, bus := setupCommandsRouter(t)
+	serviceState.Config.Services.DeviceManager.Commands.TimeoutSecs = 1
+
+	require.Equal(t, http.StatusAccepted, serveDevices(router, http.MethodPost, "/actions/reset/charger-1?async=true", `{"type":"Soft"}`).Code)
+	bus.next(t)
+
+	commandsInFlight.Wait()
+	command := getCommand(t, router, "/commands/1")
+	assert.Equal(t, dbmodels.CommandStatus_TimedOut, command.Status)
+	assert.NotEmpty(t, command.Error)
+	assert.Nil(t, command.Response)
+}
+
+func TestCommandActionTimeout(t *testing.T) {
+	router, bus := setupCommandsRouter(t)
+	// The action's configured timeout is used rather than commands.timeout_secs
+	serviceState.Config.Services.DeviceManager.Commands.TimeoutSecs = 60
+	serviceState.Config.Services.DeviceManager.Actions.Timeouts = map[string]int{"reset": 1}
+	assert.Equal(t, 60*time.Second, commandTimeout(ocppmodels.MsgType_DataTransfer))
+
+	start := time.Now()
+	require.Equal(t, http.StatusAccepted, serveDevices(router, http.MethodPost, "/actions/reset/charger-1?async=true", `{"type":"Soft"}`).Code)
+	bus.next(t)
+
+	commandsInFlight.Wait()
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.Equal(t, dbmodels.CommandStatus_TimedOut, getCommand(t, router, "/commands/1").Status)
+}
+
+func TestCommandInvalid(t *testing.T) {
+	router, _ := setupCommandsRouter(t)
+
+	for _, target := range []string{
+		"/actions/reset/charger-1?async=maybe",
+		"/actions/reset/charger-1?async=true&callbackUrl=ftp://example.com",
+		"/actions/reset/charger-1?async=true&callbackUrl=/relative",
+	} {
+		assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodPost, target, `{"type":"Soft"}`).Code, target)
+	}
+	assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodGet, "/commands?status=done", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodGet, "/commands/x", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveDevices(router, http.MethodPost, "/actions/reset/charger-9?async=true", `{}`).Code)
+}
+
+func TestCommandExpiry(t *testing.T) {
+	setupCommandsRouter(t)
+	serviceState.Config.Services.DeviceManager.Actions.MaxTimeoutSecs = 120
+	serviceState.Config.Services.DeviceManager.Actions.Timeouts = map[string]int{"updatefirmware": 600}
+	assert.Equal(t, 600*time.Second, longestCommandTimeout())
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	// Sent by an instance which stopped, and a command another instance is still awaiting
+	abandoned := &dbmodels.Command{Owner: "node-2", NetworkId: "charger-1", Action: "Reset", Payload: json.RawMessage(`{}`),
+		Status: dbmodels.CommandStatus_Sent, CreatedAt: now.Add(-time.Hour)}
+	awaited := &dbmodels.Command{Owner: "node-2", NetworkId: "charger-1", Action: "Reset", Payload: json.RawMessage(`{}`),
+		Status: dbmodels.CommandStatus_Sent, CreatedAt: now.Add(-5 * time.Minute)}
+	for _, command := range []*dbmodels.Command{abandoned, awaited} {
+		_, err := serviceState.Commands.InsertCommand(ctx, command)
+		require.NoError(t, err)
+	}
+
+	expireCommands(ctx)
+	got, err := serviceState.Commands.GetCommand(ctx, "", abandoned.Id)
+	require.NoError(t, err)
+	assert.Equal(t, dbmodels.CommandStatus_Error, got.Status)
+	assert.NotNil(t, got.CompletedAt)
+	got, err = serviceState.Commands.GetCommand(ctx, "", awaited.Id)
+	require.NoError(t, err)
+	assert.Equal(t, dbmodels.CommandStatus_Sent, got.Status)
+}
@@ -0,0 +1,75 @@
+// Tracks which chargers are connected, from csms-server's connection notifications and the messages chargers send, so
+// actions to an offline charger fail fast rather than waiting to time out. Chargers not seen since device-manager
+// started are assumed connected
+package main
+
+import (
+	"encoding/json"
+
+	"sw/ocpp/csms/internal/logging"
+	mqmodels "sw/ocpp/csms/internal/models/mq"
+	"sw/ocpp/csms/internal/mq"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// Last known connection of each charger, by networkId
+var deviceConnections = xsync.NewMap()
+
+type deviceConnection struct {
+	ServerNode string
+	Connected  bool
+}
+
+// Records connection changes notified by csms-server, and chargers confirming their pending password
+func ProcessRecvNotify(messageBy []byte, state any) {
+	notify := mqmodels.MqNotifyConnectionChange{}
+	if err := json.Unmarshal(messageBy, &notify); err != nil {
+		log.Errorf("MQ Received Notify, unmarshall error: %s", err.Error())
+		return
+	}
+
+	switch notify.NotifyType {
+	case mq.NotifyMsg_ClientConnected:
+		recordConnected(notify.NetworkId, notify.ServerNode)
+	case mq.NotifyMsg_ClientDisconnected:
+		// Ignored if the charger has since reconnected to another node
+		value, ok := deviceConnections.Load(notify.NetworkId)
+		if ok && value.(deviceConnection).ServerNode != notify.ServerNode {
+			return
+		}
+		deviceConnections.Store(notify.NetworkId, deviceConnection{ServerNode: notify.ServerNode})
+		logging.ForCharger(notify.NetworkId).Debug("Disconnected")
+	case mq.NotifyMsg_PasswordConfirmed:
+		confirmPendingPassword(notify.NetworkId)
+	case mq.NotifyMsg_NodeDisconnected:
+		deviceConnections.Range(func(networkId string, value interface{}) bool {
+			if value.(deviceConnection).ServerNode == notify.ServerNode {
+				deviceConnections.Store(networkId, deviceConnection{ServerNode: notify.ServerNode})
+			}
+			return true
+		})
+	}
+}
+
+func recordConnected(networkId string, serverNode string) {
+	if networkId == "" {
+		return
+	}
+	deviceConnections.Store(networkId, deviceConnection{ServerNode: serverNode, Connected: true})
+}
+
+// The csms-server node the charger is connected to, or "" if it isn't known to be connected
+func connectedServerNode(networkId string) string {
+	value, ok := deviceConnections.Load(networkId)
+	if !ok || !value.(deviceConnection).Connected {
+		return ""
+	}
+	return value.(deviceConnection).ServerNode
+}
+
+// Whether the charger is known to be disconnected
+func deviceOffline(networkId string) bool {
+	value, ok := deviceConnections.Load(networkId)
+	return ok && !value.(deviceConnection).Connected
+}
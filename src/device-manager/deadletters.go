@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	db "sw/ocpp/csms/internal/db"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mq "sw/ocpp/csms/internal/mq"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// Lists dead letters, newest first, filtered by query parameters: networkId, reason, from, to, limit, offset
+func deadLetters_List(w http.ResponseWriter, r *http.Request) {
+	filter, err := deadLetterFilterFromQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	deadLetters, err := serviceState.DeadLetters.ListDeadLetters(r.Context(), *filter)
+	if err != nil {
+		log.Errorf("Error listing dead letters: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, deadLetters)
+}
+
+// Gets a single dead letter
+func deadLetters_Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deadletterid"), 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("invalid deadletterid")))
+		return
+	}
+
+	deadLetter, err := serviceState.DeadLetters.GetDeadLetter(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error getting dead letter: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, deadLetter)
+}
+
+// Deletes a dead letter once it has been triaged
+func deadLetters_Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deadletterid"), 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("invalid deadletterid")))
+		return
+	}
+
+	err = serviceState.DeadLetters.DeleteDeadLetter(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error deleting dead letter: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deadLetterFilterFromQuery(r *http.Request) (*dbmodels.DeadLetterFilter, error) {
+	query := r.URL.Query()
+	filter := &dbmodels.DeadLetterFilter{NetworkId: query.Get("networkId")}
+
+	if reason := query.Get("reason"); reason != "" {
+		switch reason {
+		case mq.DeadLetterReason_Malformed, mq.DeadLetterReason_Unroutable, mq.DeadLetterReason_SchemaInvalid:
+			filter.Reason = reason
+		default:
+			return nil, fmt.Errorf("invalid reason: %s", reason)
+		}
+	}
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %s", from)
+		}
+		filter.From = &t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to: %s", to)
+		}
+		filter.To = &t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxListLimit {
+			return nil, fmt.Errorf("invalid limit, must be 1-%d", maxListLimit)
+		}
+		filter.Limit = l
+	}
+	if offset := query.Get("offset"); offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil || o < 0 {
+			return nil, fmt.Errorf("invalid offset: %s", offset)
+		}
+		filter.Offset = o
+	}
+	return filter, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mq "sw/ocpp/csms/internal/mq"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupDeadLettersRouter(t *testing.T) (http.Handler, *memdb.DeadLetterRepository) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+
+	deadLetters := memdb.NewDeadLetterRepository()
+	serviceState = &ServiceState{DeadLetters: deadLetters}
+	t.Cleanup(func() { serviceState = nil })
+
+	return apiRouter(), deadLetters
+}
+
+func TestDeadLettersList(t *testing.T) {
+	router, deadLetters := setupDeadLettersRouter(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	_, err := deadLetters.InsertDeadLetter(ctx, &dbmodels.DeadLetter{NetworkId: "charger-1", Reason: mq.DeadLetterReason_Malformed, Frame: "[2,", MessageTime: now})
+	require.NoError(t, err)
+	id, err := deadLetters.InsertDeadLetter(ctx, &dbmodels.DeadLetter{NetworkId: "charger-1", Reason: mq.DeadLetterReason_SchemaInvalid, Frame: `[2,"1","Foo",{}]`, MessageTime: now})
+	require.NoError(t, err)
+	_, err = deadLetters.InsertDeadLetter(ctx, &dbmodels.DeadLetter{NetworkId: "charger-2", Reason: mq.DeadLetterReason_SchemaInvalid, Frame: `[2,"1","Foo",{}]`, MessageTime: now})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/deadletters?networkId=charger-1&reason=schema_invalid", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var result []dbmodels.DeadLetter
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Len(t, result, 1)
+	assert.Equal(t, id, result[0].Id)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/deadletters?reason=unknown", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestDeadLettersGetAndDelete(t *testing.T) {
+	router, deadLetters := setupDeadLettersRouter(t)
+
+	id, err := deadLetters.InsertDeadLetter(context.Background(), &dbmodels.DeadLetter{NetworkId: "charger-1", Reason: mq.DeadLetterReason_Unroutable, MessageTime: time.Now().UTC()})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/deadletters/1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var result dbmodels.DeadLetter
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, id, result.Id)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/deadletters/1", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/deadletters/1", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/deadletters/1", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
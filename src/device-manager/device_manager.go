@@ -4,27 +4,37 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"sw/ocpp/csms/internal/admin"
+	"sw/ocpp/csms/internal/auth"
+	redisManage "sw/ocpp/csms/internal/cache"
 	conf "sw/ocpp/csms/internal/config"
 	db "sw/ocpp/csms/internal/db"
 	helpers "sw/ocpp/csms/internal/helpers"
 	httplistener "sw/ocpp/csms/internal/http"
 	"sw/ocpp/csms/internal/logging"
+	"sw/ocpp/csms/internal/metrics"
 	svc "sw/ocpp/csms/internal/models/service"
 	mq "sw/ocpp/csms/internal/mq"
 	ocppmodels "sw/ocpp/csms/internal/ocpp"
+	"sw/ocpp/csms/internal/pki"
 	service "sw/ocpp/csms/internal/service"
 	telemetry "sw/ocpp/csms/internal/telemetry"
+	"sw/ocpp/csms/internal/tracing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-redis/redis"
 	"github.com/puzpuzpuz/xsync/v3"
 
 	"github.com/go-chi/render"
@@ -37,10 +47,17 @@ var (
 	BuildTimestamp = "n/a"
 
 	// Globals
-	exitNotification  chan T
-	log               = logging.Logger
-	serviceState      *ServiceState
-	actionTimeoutSecs time.Duration = 5 // TODO make this configurable - move to config
+	exitNotification chan T
+	log              = logging.Logger
+	serviceState     *ServiceState
+
+	errActionTimeout = errors.New("timed out waiting for response")
+	errDeviceOffline = errors.New("device is offline")
+)
+
+const (
+	defaultActionTimeoutSecs    = 5
+	defaultMaxActionTimeoutSecs = 300
 )
 
 type T = struct{}
@@ -67,8 +84,24 @@ func initialise() *ServiceState {
 	}
 
 	mq.SetupMqReceiver(mqConnection, config.Mq.Type, serviceContext.HostName, mq.MqChannelName_MessagesIn)
+	// csms-server's connection changes, to know which chargers are offline
+	mq.SetupMqReceiver(mqConnection, config.Mq.Type, serviceContext.HostName, mq.MqChannelName_Notify)
+
+	// Auth cache, written through to on device changes
+	var cacheClient *redis.Client
+	var authRecords auth.AuthRecordStore
+	cacheConfig := config.Services.DeviceManager.Cache
+	if len(cacheConfig.HostPort) > 0 {
+		cacheClient, err = redisManage.ConnectRedis(cacheConfig.HostPort, cacheConfig.Password, cacheConfig.DbId)
+		if err != nil {
+			return &ServiceState{LastError: err}
+		}
+		authRecords = auth.NewRedisAuthRecords(cacheClient)
+	}
 
 	return &ServiceState{
+		Cache:           cacheClient,
+		AuthRecords:     authRecords,
 		Config:          config,
 		MqBus:           mqConnection,
 		Context:         serviceContext,
@@ -91,6 +124,7 @@ func setupRestApi(serviceState *ServiceState, config conf.HttpConfig) error {
 
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
+	router.Use(tracing.HttpMiddleware)
 	router.Use(middleware.Logger)
 	//router.Use(mwLogger.New(log))
 	router.Use(middleware.Recoverer)
@@ -104,74 +138,159 @@ func setupRestApi(serviceState *ServiceState, config conf.HttpConfig) error {
 			config.HttpUser: config.HttpPassword,
 		}))
 
-		r.Route("/actions", func(r chi.Router) {
-			r.Route("/datatransfer/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", action_dataTransfer)
-				//r.Post("/restart", action_dataTransfer)
-			})
-			r.Route("/setchargingprofile/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_SetChargingProfile))
-			})
-			r.Route("/clearchargingprofile/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_ClearChargingProfile))
-			})
-			r.Route("/remotestarttransaction/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_RemoteStartTransaction))
-			})
-			r.Route("/remotestoptransaction/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_RemoteStopTransaction))
-			})
-			r.Route("/unlockconnector/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_UnlockConnector))
-			})
-			r.Route("/reset/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_Reset))
-			})
-			r.Route("/getdiagnostics/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_GetDiagnostics))
-			})
-			r.Route("/getconfiguration/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_GetConfiguration))
-			})
-			r.Route("/changeavailability/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_ChangeAvailability))
-			})
-			r.Route("/changeconfiguration/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_ChangeConfiguration))
-			})
-			r.Route("/triggermessage/{networkid}", func(r chi.Router) {
-				r.Use(NetworkIdCtx)
-				r.Post("/", createSendActionHandler(ocppmodels.MsgType_TriggerMessage))
-			})
-		})
+		registerApiRoutes(r)
 	})
 
 	log.Info("Starting REST API Server")
 	listenNetPort := fmt.Sprintf("%s:%d", config.ListenAddress, config.ListenPort)
 	log.Info("REST API listening on: ", listenNetPort)
 
-	_ioCloser, err := httplistener.ListenAndServeWithClose(listenNetPort, router)
+	listener, err := httplistener.ListenAndServeWithClose(listenNetPort, router)
 	if err != nil {
 		log.Error("Failed to start REST API server")
 		return err
 	}
-	serviceState.IoCloser = &_ioCloser
+	var ioCloser io.Closer = listener
+	serviceState.IoCloser = &ioCloser
+	serviceState.Listener = listener
 
 	log.Info("REST server started")
 	return nil
 }
 
+// Registers the API's routes, in setupRestApi behind Basic auth, and in tests
+func registerApiRoutes(r chi.Router) {
+	r.Route("/actions", func(r chi.Router) {
+		r.Route("/datatransfer/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", action_dataTransfer)
+			//r.Post("/restart", action_dataTransfer)
+		})
+		r.Route("/setchargingprofile/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_SetChargingProfile))
+		})
+		r.Route("/clearchargingprofile/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_ClearChargingProfile))
+		})
+		r.Route("/remotestarttransaction/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_RemoteStartTransaction))
+		})
+		r.Route("/remotestoptransaction/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_RemoteStopTransaction))
+		})
+		r.Route("/unlockconnector/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_UnlockConnector))
+		})
+		r.Route("/reset/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_Reset))
+		})
+		r.Route("/getdiagnostics/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_GetDiagnostics))
+		})
+		r.Route("/getconfiguration/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_GetConfiguration))
+		})
+		r.Route("/changeavailability/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_ChangeAvailability))
+		})
+		r.Route("/changeconfiguration/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_ChangeConfiguration))
+		})
+		r.Route("/triggermessage/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_TriggerMessage))
+		})
+		r.Route("/changepassword/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", action_changePassword)
+		})
+		r.Route("/installcertificate/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_InstallCertificate))
+		})
+		r.Route("/getinstalledcertificateids/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_GetInstalledCertificateIds))
+		})
+		r.Route("/deletecertificate/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_DeleteCertificate))
+		})
+		r.Route("/extendedtriggermessage/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Post("/", createSendActionHandler(ocppmodels.MsgType_ExtendedTriggerMessage))
+		})
+	})
+
+	r.Route("/devices", func(r chi.Router) {
+		r.Get("/", devices_List)
+		r.Post("/", devices_Create)
+		r.Route("/{networkid}", func(r chi.Router) {
+			r.Use(NetworkIdCtx)
+			r.Get("/", devices_Get)
+			r.Patch("/", devices_Update)
+			r.Delete("/", devices_Delete)
+			r.Post("/disable", devices_SetDisabled(true))
+			r.Post("/enable", devices_SetDisabled(false))
+			r.Get("/compliance", devices_GetCompliance)
+			r.Post("/compliance", devices_CheckCompliance)
+		})
+	})
+
+	r.Route("/templates", func(r chi.Router) {
+		r.Get("/", templates_List)
+		r.Post("/", templates_Create)
+		r.Get("/{templateid}", templates_Get)
+		r.Put("/{templateid}", templates_Replace)
+		r.Delete("/{templateid}", templates_Delete)
+	})
+
+	r.Get("/compliance", compliance_List)
+
+	r.Route("/commands", func(r chi.Router) {
+		r.Get("/", commands_List)
+		r.Get("/{commandid}", commands_Get)
+	})
+
+	r.Route("/jobs", func(r chi.Router) {
+		r.Get("/", jobs_List)
+		r.Post("/", jobs_Create)
+		r.Route("/{jobid}", func(r chi.Router) {
+			r.Get("/", jobs_Get)
+			r.Get("/results", jobs_ListResults)
+			r.Get("/events", jobs_Events)
+			r.Post("/cancel", jobs_Cancel)
+		})
+	})
+
+	r.Route("/transactions/{networkid}", func(r chi.Router) {
+		r.Use(NetworkIdCtx)
+		r.Get("/", transactions_List)
+		r.Get("/{transactionid}", transactions_Get)
+	})
+
+	r.Route("/certificates/{networkid}", func(r chi.Router) {
+		r.Use(NetworkIdCtx)
+		r.Get("/", certificates_List)
+	})
+
+	r.Route("/deadletters", func(r chi.Router) {
+		r.Get("/", deadLetters_List)
+		r.Get("/{deadletterid}", deadLetters_Get)
+		r.Delete("/{deadletterid}", deadLetters_Delete)
+	})
+}
+
 func StreamToByte(stream io.Reader) []byte {
 	buf := new(bytes.Buffer)
 	buf.ReadFrom(stream)
@@ -182,59 +301,17 @@ func action_SendPayloadToCharger(w http.ResponseWriter, r *http.Request, msgType
 	log.Info("Path: " + r.URL.Path)
 
 	device := r.Context().Value("device").(*Device)
-	var response *ocppmodels.ActionResponse
 
 	var buf bytes.Buffer
 	_, err := io.Copy(&buf, r.Body)
 	if err != nil {
-		log.Errorf("Error streaming response: %s", err.Error())
-		render.JSON(w, r, createActionResponse("Error streaming response"))
+		log.Errorf("Error streaming request: %s", err.Error())
+		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 	log.Debugf("Read: %s", buf.String())
 
-	msgId := ocppmodels.GenerateUniqueId()
-	profileRaw := json.RawMessage(buf.Bytes())
-	ocppMessageJson, err := CreateCsmsToDeviceRequest(msgId, device.ServerNode, device.NetworkId, msgType, profileRaw)
-	if err != nil {
-		log.Errorf("Error in CreateCsmsToDeviceRequest: %s", err.Error())
-		render.JSON(w, r, createActionResponse("Error in serialisation"))
-		return
-		// TODO log to appinsights
-	}
-
-	waitMessage := &svc.DeviceWaitingMessage{}
-	waitMessage.CreatedTimestamp = time.Now()
-	waitMessage.Notify = make(chan int)
-
-	serviceState.MessagesWaiting.Store(msgId, waitMessage)
-	mqErr := serviceState.MqBus.MqMessagePublishRetry(mq.MqChannelName_MessagesOut, ocppMessageJson)
-	if mqErr != nil {
-		log.Errorf("Error sending reply to MQ, msg lost: %s", mqErr.Error())
-		render.JSON(w, r, createActionResponse("Error sending reply to MQ, msg lost"))
-		return
-		// TODO log to appinsights
-	}
-
-	responseRaw, ok := WithTimeout(func() interface{} { return <-waitMessage.Notify }, time.Second*actionTimeoutSecs)
-	if !ok {
-		log.Errorf("Timed out waiting for response")
-		response = createActionResponse("Timed out waiting for response")
-	} else {
-		if responseRaw != nil {
-			responseStr := string(waitMessage.Response.MessageBody)
-			response = createActionResponse(responseStr)
-
-			log.Info("Response: " + responseStr)
-			w.WriteHeader(http.StatusOK)
-		} else {
-			log.Warn("Nil response for action")
-			response = createActionResponse("Nil response")
-			w.WriteHeader(http.StatusNotFound)
-		}
-	}
-	render.JSON(w, r, response)
-	serviceState.MessagesWaiting.Delete(msgId)
+	sendActionRequest(w, r, device, msgType, json.RawMessage(buf.Bytes()))
 }
 
 func action_dataTransfer(w http.ResponseWriter, r *http.Request) {
@@ -244,76 +321,129 @@ func action_dataTransfer(w http.ResponseWriter, r *http.Request) {
 	err := json.Unmarshal(StreamToByte(r.Body), dataTransferMessage)
 	if err != nil {
 		log.Errorf("Error unmarshalling DataTransfer: %s", err.Error())
+		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 
 	dataTransferBy, err := json.Marshal(&dataTransferMessage)
 	if err != nil {
 		log.Errorf("Error marshalling response: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
 		return
 	}
 
-	msgId := ocppmodels.GenerateUniqueId()
-	dataTransferRaw := json.RawMessage(dataTransferBy)
-	ocppMessageJson, _ := CreateCsmsToDeviceRequest(msgId, device.ServerNode, device.NetworkId, ocppmodels.MsgType_DataTransfer, dataTransferRaw)
-
-	waitMessage := &svc.DeviceWaitingMessage{}
-	waitMessage.CreatedTimestamp = time.Now()
-	waitMessage.Notify = make(chan int)
-
-	//err := serviceState.MqBus.MqRegisterCallback(mq.MqChannelName_MessagesIn)
-	serviceState.MessagesWaiting.Store(msgId, waitMessage)
-	mqErr := serviceState.MqBus.MqMessagePublishRetry(mq.MqChannelName_MessagesOut, ocppMessageJson)
-	if mqErr != nil {
-		log.Errorf("Error sending reply to MQ, msg lost: %s", mqErr.Error())
-		//return mqErr // transient MQ error unrecoverable, close connection to CP
-		// TODO log to appinsights
-	}
-
-	responseRaw, ok := WithTimeout(func() interface{} { return <-waitMessage.Notify }, time.Second*actionTimeoutSecs)
-	var response *ocppmodels.ActionResponse
-	if !ok {
-		log.Errorf("Timed out waiting for response")
-		response = createActionResponse("Timed out waiting for response")
-	} else {
-		if responseRaw != nil {
-			responseStr := string(waitMessage.Response.MessageBody)
-			response = createActionResponse(responseStr)
-			log.Info("Response: " + responseStr)
-			w.WriteHeader(http.StatusOK)
-		} else {
-			log.Warn("Nil response for data transfer action")
-			response = createActionResponse("Nil response")
-			w.WriteHeader(http.StatusNotFound)
-		}
-	}
-	render.JSON(w, r, response)
-	serviceState.MessagesWaiting.Delete(msgId)
+	sendActionRequest(w, r, device, ocppmodels.MsgType_DataTransfer, json.RawMessage(dataTransferBy))
 }
 
-func createActionResponse(message string) *ocppmodels.ActionResponse {
-	msgData, err := json.Marshal(message)
+// Sends the action and responds with the charger's response, or queues it as a command with ?async=true
+func sendActionRequest(w http.ResponseWriter, r *http.Request, device *Device, msgType string, payload json.RawMessage) {
+	async, err := asyncFromQuery(r)
 	if err != nil {
-		fmt.Println("Error marshaling to JSON:", err)
-		return nil
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	timeout, err := timeoutFromQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if deviceOffline(device.NetworkId) {
+		render.Render(w, r, ErrConflict(errDeviceOffline))
+		return
+	}
+	if async {
+		queueCommand(w, r, device, msgType, payload, timeout)
+		return
+	}
+	if timeout == 0 {
+		timeout = actionTimeout(msgType)
 	}
 
-	response := ocppmodels.ActionResponse{MessageBody: json.RawMessage(msgData)}
+	action, err := sendAction(r.Context(), device, msgType, payload)
+	if err != nil {
+		log.Errorf("Error sending %s: %s", msgType, err.Error())
+		render.Render(w, r, ErrAction(err))
+		return
+	}
+	response := &ActionResponse{MsgId: action.MsgId, Action: msgType}
+	reply, err := action.await(r.Context(), timeout)
+	if err != nil {
+		logging.ForCharger(device.NetworkId).Warnf("%s failed: %s", msgType, err.Error())
+		response.Error = err.Error()
+		var callErr *callError
+		if errors.As(err, &callErr) {
+			response.CallError = &callErr.OcppCallError
+		}
+		render.Status(r, actionErrorStatus(err))
+		render.JSON(w, r, response)
+		return
+	}
 
-	return &response
+	statusResponse := &ocppmodels.OcppStatusResponse{}
+	json.Unmarshal(reply.MessageBody, statusResponse)
+	response.Status = statusResponse.Status
+	response.Response = reply.MessageBody
+	render.JSON(w, r, response)
 }
 
+// The charger's response to an action, or why there isn't one
 type ActionResponse struct {
+	MsgId     string                    `json:"msgId"`
+	Action    string                    `json:"action"`
+	Status    string                    `json:"status,omitempty"`   // the response's status, if it has one
+	Response  json.RawMessage           `json:"response,omitempty"` // the charger's response payload
+	CallError *ocppmodels.OcppCallError `json:"callError,omitempty"`
+	Error     string                    `json:"error,omitempty"`
 }
 
-func ActionResponseRender(rd *ocppmodels.ActionResponse) *ActionResponse {
-	actionResponse := new(ActionResponse)
+// The ?timeoutSecs to wait for the charger's response, up to actions.max_timeout_secs, or 0 if not set
+func timeoutFromQuery(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("timeoutSecs")
+	if value == "" {
+		return 0, nil
+	}
+	maxSecs := serviceState.Config.Services.DeviceManager.Actions.MaxTimeoutSecs
+	if maxSecs <= 0 {
+		maxSecs = defaultMaxActionTimeoutSecs
+	}
+	secs, err := strconv.Atoi(value)
+	if err != nil || secs <= 0 || secs > maxSecs {
+		return 0, fmt.Errorf("invalid timeoutSecs, must be 1 to %d: %s", maxSecs, value)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// The configured time to wait for the charger's response to the action
+func actionTimeout(msgType string) time.Duration {
+	if timeout := configuredActionTimeout(msgType); timeout > 0 {
+		return timeout
+	}
+	secs := serviceState.Config.Services.DeviceManager.Actions.TimeoutSecs
+	if secs <= 0 {
+		secs = defaultActionTimeoutSecs
+	}
+	return time.Duration(secs) * time.Second
+}
 
-	return actionResponse
+// The action's entry in actions.timeouts, or 0 if it has none
+func configuredActionTimeout(msgType string) time.Duration {
+	secs := serviceState.Config.Services.DeviceManager.Actions.Timeouts[strings.ToLower(msgType)]
+	return time.Duration(max(secs, 0)) * time.Second
 }
 
-func (rd *ActionResponse) Render(w http.ResponseWriter, r *http.Request) error {
-	return nil
+// The HTTP status of an action which failed: 504 if the charger didn't respond, 502 if it responded with a CALLERROR
+func actionErrorStatus(err error) int {
+	var callErr *callError
+	switch {
+	case errors.Is(err, errActionTimeout):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &callErr):
+		return http.StatusBadGateway
+	case errors.Is(err, errDeviceOffline):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 type ErrResponse struct {
@@ -330,6 +460,46 @@ func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+var ErrNotFound = &ErrResponse{HTTPStatusCode: 404, StatusText: "Resource not found."}
+
+func ErrInvalidRequest(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 400,
+		StatusText:     "Invalid request.",
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrConflict(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 409,
+		StatusText:     "Conflict.",
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrInternal(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 500,
+		StatusText:     "Internal server error.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// An action which failed, with the status from actionErrorStatus
+func ErrAction(err error) render.Renderer {
+	status := actionErrorStatus(err)
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: status,
+		StatusText:     http.StatusText(status) + ".",
+		ErrorText:      err.Error(),
+	}
+}
+
 func ErrRender(err error) render.Renderer {
 	return &ErrResponse{
 		Err:            err,
@@ -339,18 +509,87 @@ func ErrRender(err error) render.Renderer {
 	}
 }
 
-func WithTimeout(delegate func() interface{}, timeout time.Duration) (ret interface{}, ok bool) {
-	ch := make(chan interface{}, 1) // buffered
-	go func() { ch <- delegate() }()
+// Sends a call to a charger and waits for its response, for actions which handle the response themselves
+func sendActionAndWait(ctx context.Context, device *Device, msgType string, payload json.RawMessage) (*ocppmodels.OcppMessage, error) {
+	action, err := sendAction(ctx, device, msgType, payload)
+	if err != nil {
+		return nil, err
+	}
+	return action.await(ctx, actionTimeout(msgType))
+}
+
+// A call sent to a charger, waiting for its response
+type pendingAction struct {
+	MsgId   string
+	msgType string
+	waiting *svc.DeviceWaitingMessage
+}
+
+// Sends a call to a charger, returning once it's published. The response must then be awaited
+func sendAction(ctx context.Context, device *Device, msgType string, payload json.RawMessage) (*pendingAction, error) {
+	if deviceOffline(device.NetworkId) {
+		return nil, errDeviceOffline
+	}
+	msgId := ocppmodels.GenerateUniqueId()
+	ocppMessageJson, err := CreateCsmsToDeviceRequest(ctx, msgId, device.ServerNode, device.NetworkId, msgType, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	waitMessage := &svc.DeviceWaitingMessage{}
+	waitMessage.CreatedTimestamp = time.Now()
+	waitMessage.Notify = make(chan int, 1)
+	serviceState.MessagesWaiting.Store(msgId, waitMessage)
+
+	if err := serviceState.MqBus.MqMessagePublishRetry(mq.MqChannelName_MessagesOut, ocppMessageJson); err != nil {
+		serviceState.MessagesWaiting.Delete(msgId)
+		metrics.ObserveDeviceAction(msgType, metrics.Result_Error, waitMessage.CreatedTimestamp)
+		return nil, fmt.Errorf("error sending to MQ: %w", err)
+	}
+	return &pendingAction{MsgId: msgId, msgType: msgType, waiting: waitMessage}, nil
+}
+
+// Waits up to timeout for the charger's response, or until ctx is done
+func (a *pendingAction) await(ctx context.Context, timeout time.Duration) (*ocppmodels.OcppMessage, error) {
+	defer serviceState.MessagesWaiting.Delete(a.MsgId)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 	select {
-	case ret = <-ch:
-		return ret, true
-	case <-time.After(timeout):
+	case <-a.waiting.Notify:
+	case <-timer.C:
+		metrics.ObserveDeviceAction(a.msgType, metrics.Result_Timeout, a.waiting.CreatedTimestamp)
+		return nil, errActionTimeout
+	case <-ctx.Done():
+		metrics.ObserveDeviceAction(a.msgType, metrics.Result_Error, a.waiting.CreatedTimestamp)
+		return nil, ctx.Err()
+	}
+	if a.waiting.Response == nil {
+		metrics.ObserveDeviceAction(a.msgType, metrics.Result_Error, a.waiting.CreatedTimestamp)
+		return nil, errors.New("nil response")
 	}
-	return nil, false
+	if a.waiting.Response.Direction == ocppmodels.OcppDirection_CallError {
+		metrics.ObserveDeviceAction(a.msgType, metrics.Result_Error, a.waiting.CreatedTimestamp)
+		callErr := &callError{}
+		if err := json.Unmarshal(a.waiting.Response.MessageBody, &callErr.OcppCallError); err != nil {
+			return nil, fmt.Errorf("invalid CALLERROR: %w", err)
+		}
+		return nil, callErr
+	}
+	metrics.ObserveDeviceAction(a.msgType, metrics.Result_Ok, a.waiting.CreatedTimestamp)
+	return a.waiting.Response, nil
+}
+
+// A CALLERROR response from the charger
+type callError struct {
+	ocppmodels.OcppCallError
 }
 
-func CreateCsmsToDeviceRequest(msgId string, serverNode string, client string, messageType string, rawMessage json.RawMessage) (string, error) {
+func (e *callError) Error() string {
+	return fmt.Sprintf("charger responded %s: %s", e.ErrorCode, e.ErrorDescription)
+}
+
+func CreateCsmsToDeviceRequest(ctx context.Context, msgId string, serverNode string, client string, messageType string, rawMessage json.RawMessage) (string, error) {
 	ocppResponse := new(ocppmodels.OcppMessage)
 	ocppResponse.MsgId = msgId
 	ocppResponse.MessageType = messageType
@@ -363,7 +602,7 @@ func CreateCsmsToDeviceRequest(msgId string, serverNode string, client string, m
 		return "", err
 	}*/
 
-	return mq.MqCreateMessageEnvelope(serverNode, client, ocppResponse)
+	return mq.MqCreateMessageEnvelope(ctx, serverNode, client, ocppResponse)
 }
 
 // NetworkIdCtx middleware is used to load a device object from
@@ -371,17 +610,19 @@ func CreateCsmsToDeviceRequest(msgId string, serverNode string, client string, m
 // the it could not be found, we stop here and return a 404.
 func NetworkIdCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var device *Device
-		var err error
-
-		if networkid := chi.URLParam(r, "networkid"); networkid != "" {
-			device, err = dbGetDevice(networkid)
-		} else {
-			//render.Render(w, r, ErrNotFound)
+		networkid := chi.URLParam(r, "networkid")
+		if !helpers.ValidNetworkIdString(networkid) {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+		device, err := dbGetDevice(r.Context(), networkid)
+		if errors.Is(err, db.ErrNotFound) {
+			render.Render(w, r, ErrNotFound)
 			return
 		}
 		if err != nil {
-			//render.Render(w, r, ErrNotFound)
+			log.Errorf("Error getting device: %s", err.Error())
+			render.Render(w, r, ErrInternal(err))
 			return
 		}
 
@@ -390,8 +631,13 @@ func NetworkIdCtx(next http.Handler) http.Handler {
 	})
 }
 
-func dbGetDevice(networkid string) (*Device, error) {
-	return &Device{NetworkId: networkid, ServerNode: "Node1"}, nil
+// Gets a device of the configured tenant, or returns db.ErrNotFound
+func dbGetDevice(ctx context.Context, networkid string) (*Device, error) {
+	record, err := serviceState.Devices.GetDevice(ctx, serviceState.Config.Services.DeviceManager.Tenant, networkid)
+	if err != nil {
+		return nil, err
+	}
+	return &Device{NetworkId: networkid, ServerNode: connectedServerNode(networkid), Record: record}, nil
 }
 
 func main() {
@@ -408,7 +654,17 @@ func main() {
 		<-exitNotification // send notification to unblock and exit
 	}()
 
-	log = logging.LoggingSetup(true, "device-manager") // start with debug enabled until overridden in config later
+	log = logging.LoggingSetup(true, "device-manager", logging.Format_Text) // start with debug enabled until overridden in config later
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "hashpassword" {
+		runHashPasswordCommand()
+	}
+	if len(os.Args) > 1 && os.Args[1] == "hashauthrecords" {
+		runHashAuthRecordsCommand()
+	}
 
 	log.Infof("--- OCPP Device Manager - v%s ---", service.Version)
 
@@ -419,26 +675,89 @@ func main() {
 	}
 	config := serviceState.Config.Services.DeviceManager
 
-	log = logging.LoggingSetup(config.Debug, "device-manager")
+	log = logging.LoggingSetup(config.Debug, "device-manager", serviceState.Config.Logging.Format)
 	if len(serviceState.Config.Logging.AppInsightsInstrumentationKey) > 0 {
 		log.AddHook(serviceState.AppInsightsHook)
 	}
-	dbConfig := serviceState.Config.DbConfig
-	err := db.ConnectDb(dbConfig.DbType, dbConfig.DbConnectionString)
+	shutdownTracing, err := tracing.Setup(serviceState.Config.Tracing, "device-manager")
+	if err != nil {
+		log.Errorf("Error in tracing setup: %s", err.Error())
+		os.Exit(1)
+	}
+	serviceState.ShutdownTracing = shutdownTracing
+
+	store, err := db.Open(serviceState.Config.DbConfig)
 	if err != nil {
 		log.Errorf("Error in DB connection: %s", err.Error())
 		os.Exit(1)
 	}
-	err = db.CreateDeviceTables()
+	serviceState.Db = store
+	serviceState.Devices = store.Devices
+	serviceState.Transactions = store.Transactions
+	serviceState.DeadLetters = store.DeadLetters
+	serviceState.Certificates = store.Certificates
+	serviceState.Templates = store.Templates
+	serviceState.Compliance = store.Compliance
+	serviceState.BulkJobs = store.BulkJobs
+	serviceState.Commands = store.Commands
+	err = store.MigrateUp(context.Background())
 	if err != nil {
-		log.Errorf("Error in DB table create: %s", err.Error())
+		log.Errorf("Error in DB migration: %s", err.Error())
 		os.Exit(1)
 	}
+	interruptBulkJobs(context.Background())
+	interruptCommands(context.Background())
+	expiryCtx, stopExpiry := context.WithCancel(context.Background())
+	serviceState.StopExpiry = stopExpiry
+	go runExpiry(expiryCtx, expiryInterval)
 
 	go serviceState.MqBus.RunMqTopicReceiver(ProcessRecvMessage, mq.MqChannelName_MessagesIn, serviceState)
+	go serviceState.MqBus.RunMqTopicReceiver(ProcessRecvNotify, mq.MqChannelName_Notify, serviceState)
+
+	if serviceState.AuthRecords != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		serviceState.StopAuthSync = cancel
+		go runAuthSync(ctx, authSyncInterval(config.AuthSync), config.AuthSync.Prune)
+	}
+
+	if config.Pki.Enabled {
+		serviceState.CertificateAuthority, err = pki.NewCertificateAuthority(config.Pki)
+		if err != nil {
+			log.Errorf("Error in pki: %s", err.Error())
+			os.Exit(1)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		serviceState.StopRenewal = cancel
+		go runCertificateRenewal(ctx, renewInterval(config.Pki), renewBefore(config.Pki))
+		log.Infof("Signing charger certificates with %s CA", config.Pki.Ca)
+	}
 
 	setupRestApi(serviceState, config.HttpConfig)
 
+	metrics.WatchMessagesWaiting(serviceState.MessagesWaiting.Size)
+	if config.Admin.ListenPort > 0 {
+		server := admin.NewServer(config.Admin)
+		server.AddLivenessCheck("listener", func(ctx context.Context) error {
+			if serviceState.Listener == nil {
+				return errors.New("not listening")
+			}
+			return serviceState.Listener.Check(ctx)
+		})
+		server.AddReadinessCheck("mq", serviceState.MqBus.MqHealthCheck)
+		server.AddReadinessCheck("db", serviceState.Db.Ping)
+		if serviceState.Cache != nil {
+			server.AddReadinessCheck("redis", func(ctx context.Context) error {
+				return serviceState.Cache.WithContext(ctx).Ping().Err()
+			})
+		}
+		adminCloser, err := server.Start()
+		if err != nil {
+			log.Errorf("Error starting admin listener: %s", err.Error())
+			os.Exit(1)
+		}
+		serviceState.AdminCloser = &adminCloser
+	}
+
 	log.Debug("block...")
 	exitNotification <- struct{}{} // block until exit notification received
 	log.Debug("Service closing...")
@@ -448,6 +767,17 @@ func main() {
 	os.Exit(0)
 }
 
+// Runs DB schema migrations without starting the service, e.g: migrate up | down [steps] | status
+func runMigrateCommand(args []string) {
+	config := conf.ReadConfig()
+	err := db.RunMigrateCommand(config.DbConfig, args, os.Stdout)
+	if err != nil {
+		log.Errorf("Error in migrate: %s", err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func dispose() {
 	// TODO: move timeout to config
 	/*ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -460,6 +790,26 @@ func dispose() {
 		(*serviceState.IoCloser).Close()
 	}
 
+	if serviceState.AdminCloser != nil {
+		log.Debug("Close admin listener")
+		(*serviceState.AdminCloser).Close()
+	}
+
+	if serviceState.StopRenewal != nil {
+		log.Debug("Stop certificate renewal")
+		serviceState.StopRenewal()
+	}
+
+	if serviceState.StopAuthSync != nil {
+		log.Debug("Stop auth record reconciliation")
+		serviceState.StopAuthSync()
+	}
+
+	if serviceState.StopExpiry != nil {
+		log.Debug("Stop expiring commands and jobs")
+		serviceState.StopExpiry()
+	}
+
 	if serviceState.Cache != nil {
 		log.Debug("Close cache")
 		serviceState.Cache.Close()
@@ -469,6 +819,16 @@ func dispose() {
 		log.Debug("Close MqChannel")
 		serviceState.MqBus.Close()
 	}
+
+	if serviceState.Db != nil {
+		log.Debug("Close DB")
+		serviceState.Db.Close()
+	}
+
+	if serviceState.ShutdownTracing != nil {
+		log.Debug("Flush traces")
+		serviceState.ShutdownTracing(context.Background())
+	}
 }
 
 func multiSignalHandler(signal os.Signal) {
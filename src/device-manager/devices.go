@@ -0,0 +1,439 @@
+// Manages devices, and keeps the csms-server auth records in sync with the devices table. Device changes are written
+// through to the records, and reconcileAuthRecords repairs any drift, e.g records edited by hand or a failed write through
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/auth"
+	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
+	helpers "sw/ocpp/csms/internal/helpers"
+	"sw/ocpp/csms/internal/logging"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mq "sw/ocpp/csms/internal/mq"
+
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultAuthSyncIntervalMins = 60
+	authSyncPageSize            = 500
+	maxDeviceTags               = 20
+)
+
+// Lowercase, and without LIKE wildcards or commas, as tags are stored comma separated
+var validTag = regexp.MustCompile(`^[a-z0-9][a-z0-9.:-]{0,31}$`)
+
+// The changes made by a reconciliation
+type authSyncResult struct {
+	Set     int
+	Deleted int
+}
+
+// Device fields set by create and update requests. Fields left out of an update are unchanged
+type DeviceRequest struct {
+	NetworkId        string    `json:"networkId"`
+	DeviceTemplateId *int64    `json:"deviceTemplateId"`
+	Password         *string   `json:"password"`
+	Disabled         *bool     `json:"disabled"`
+	Tags             *[]string `json:"tags"`
+}
+
+// Lists devices by networkId, filtered by query parameters: networkIdPrefix, deviceTemplateId, disabled, firmwareVersion,
+// tag, limit, offset
+func devices_List(w http.ResponseWriter, r *http.Request) {
+	filter, err := deviceFilterFromQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	filter.Tenant = serviceState.Config.Services.DeviceManager.Tenant
+
+	devices, err := serviceState.Devices.ListDevices(r.Context(), *filter)
+	if err != nil {
+		log.Errorf("Error listing devices: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, devices)
+}
+
+// Creates a device, optionally with a password, which is stored hashed
+func devices_Create(w http.ResponseWriter, r *http.Request) {
+	request := &DeviceRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	// csms-server truncates longer networkIds, so they couldn't connect
+	if len(request.NetworkId) > helpers.NetworkIdMaxLen || !helpers.ValidNetworkIdString(request.NetworkId) {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid networkId, must be 1-%d letters, digits or -", helpers.NetworkIdMaxLen)))
+		return
+	}
+
+	device := &dbmodels.Device{Tenant: serviceState.Config.Services.DeviceManager.Tenant, NetworkId: request.NetworkId}
+	apply, err := deviceChanges(r.Context(), device.Tenant, request)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	apply(device)
+
+	err = createDevice(r.Context(), device)
+	if errors.Is(err, db.ErrRowExists) {
+		render.Render(w, r, ErrConflict(errors.New("device already exists")))
+		return
+	}
+	if err != nil {
+		log.Errorf("Error creating device: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	logging.ForCharger(device.NetworkId).Info("Device created")
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, device)
+}
+
+// Gets the device
+func devices_Get(w http.ResponseWriter, r *http.Request) {
+	device := r.Context().Value("device").(*Device)
+	render.JSON(w, r, device.Record)
+}
+
+// Updates the device's template, password, disabled flag and tags. The networkId can't be changed
+func devices_Update(w http.ResponseWriter, r *http.Request) {
+	device := r.Context().Value("device").(*Device)
+
+	request := &DeviceRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if request.NetworkId != "" && request.NetworkId != device.NetworkId {
+		render.Render(w, r, ErrInvalidRequest(errors.New("networkId can't be changed")))
+		return
+	}
+
+	apply, err := deviceChanges(r.Context(), device.Record.Tenant, request)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	saveDevice(w, r, apply)
+}
+
+// Returns a handler which disables or enables the device. A disabled device can't connect, and is disconnected
+func devices_SetDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		saveDevice(w, r, func(device *dbmodels.Device) {
+			device.Disabled = disabled
+		})
+	}
+}
+
+// Deletes the device, disconnecting it
+func devices_Delete(w http.ResponseWriter, r *http.Request) {
+	device := r.Context().Value("device").(*Device)
+
+	err := deleteDevice(r.Context(), device.Record.Tenant, device.NetworkId)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error deleting device: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	logging.ForCharger(device.NetworkId).Info("Device deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Applies modify to the device's current record, responding with the updated device
+func saveDevice(w http.ResponseWriter, r *http.Request, modify func(device *dbmodels.Device)) {
+	device := r.Context().Value("device").(*Device)
+	updated, err := updateDevice(r.Context(), device.Record.Tenant, device.NetworkId, modify)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error updating device: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, updated)
+}
+
+// Validates a request, hashing its password and checking its template exists, so its changes can be applied to a
+// device's current record without further lookups
+func deviceChanges(ctx context.Context, tenant string, request *DeviceRequest) (func(device *dbmodels.Device), error) {
+	// 0 is no template
+	if request.DeviceTemplateId != nil && *request.DeviceTemplateId != 0 {
+		_, err := serviceState.Templates.GetTemplate(ctx, tenant, *request.DeviceTemplateId)
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, fmt.Errorf("unknown deviceTemplateId: %d", *request.DeviceTemplateId)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	var hash string
+	if request.Password != nil {
+		if err := auth.ValidatePassword(*request.Password); err != nil {
+			return nil, err
+		}
+		var err error
+		if hash, err = auth.HashPassword(*request.Password); err != nil {
+			return nil, err
+		}
+	}
+	var tags []string
+	if request.Tags != nil {
+		var err error
+		if tags, err = normaliseTags(*request.Tags); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(device *dbmodels.Device) {
+		if request.DeviceTemplateId != nil {
+			device.DeviceTemplateId = *request.DeviceTemplateId
+		}
+		if request.Password != nil {
+			device.PasswordHash = hash
+		}
+		if request.Disabled != nil {
+			device.Disabled = *request.Disabled
+		}
+		if request.Tags != nil {
+			device.Tags = tags
+		}
+	}, nil
+}
+
+// Lowercases and dedupes tags, keeping their order
+func normaliseTags(tags []string) ([]string, error) {
+	if len(tags) > maxDeviceTags {
+		return nil, fmt.Errorf("too many tags, max %d", maxDeviceTags)
+	}
+	normalised := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(tag)
+		if !validTag.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag: %q, must be 1-32 letters, digits or .:-", tag)
+		}
+		if !slices.Contains(normalised, tag) {
+			normalised = append(normalised, tag)
+		}
+	}
+	return normalised, nil
+}
+
+func deviceFilterFromQuery(r *http.Request) (*dbmodels.DeviceFilter, error) {
+	query := r.URL.Query()
+	filter := &dbmodels.DeviceFilter{NetworkIdPrefix: query.Get("networkIdPrefix"), FirmwareVersion: query.Get("firmwareVersion"),
+		Tag: strings.ToLower(query.Get("tag"))}
+
+	if deviceTemplateId := query.Get("deviceTemplateId"); deviceTemplateId != "" {
+		id, err := strconv.ParseInt(deviceTemplateId, 10, 64)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid deviceTemplateId: %s", deviceTemplateId)
+		}
+		filter.DeviceTemplateId = id
+	}
+	if disabled := query.Get("disabled"); disabled != "" {
+		d, err := strconv.ParseBool(disabled)
+		if err != nil {
+			return nil, fmt.Errorf("invalid disabled: %s", disabled)
+		}
+		filter.Disabled = &d
+	}
+	var err error
+	filter.Limit, filter.Offset, err = pageFromQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// Creates a device, writing its auth record through
+func createDevice(ctx context.Context, device *dbmodels.Device) error {
+	if _, err := serviceState.Devices.InsertDevice(ctx, device); err != nil {
+		return err
+	}
+	return writeAuthRecord(device)
+}
+
+// Applies modify to a device's current record, writing its auth record through. Disabling a device also closes
+// its connection
+func updateDevice(ctx context.Context, tenant string, networkId string, modify func(device *dbmodels.Device)) (*dbmodels.Device, error) {
+	wasDisabled := false
+	device, err := serviceState.Devices.ModifyDevice(ctx, tenant, networkId, func(device *dbmodels.Device) {
+		wasDisabled = device.Disabled
+		modify(device)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if device.Disabled && !wasDisabled {
+		disconnectDevice(device.NetworkId)
+	}
+	return device, writeAuthRecord(device)
+}
+
+// Deletes a device and its auth record, closing its connection
+func deleteDevice(ctx context.Context, tenant string, networkId string) error {
+	if err := serviceState.Devices.DeleteDevice(ctx, tenant, networkId); err != nil {
+		return err
+	}
+	disconnectDevice(networkId)
+	if err := serviceState.Compliance.DeleteCompliance(ctx, tenant, networkId); err != nil {
+		logging.ForCharger(networkId).Errorf("Error deleting compliance: %s", err.Error())
+	}
+	return writeAuthRecord(&dbmodels.Device{Tenant: tenant, NetworkId: networkId, Disabled: true})
+}
+
+// Sets or removes the device's auth record, as the device can authenticate or not, and notifies csms-server to drop
+// its cached decision. Nothing is written if no cache is configured, e.g csms-server uses the sql auth backend
+func writeAuthRecord(device *dbmodels.Device) error {
+	if serviceState.AuthRecords != nil {
+		var err error
+		if record := authRecord(device); record == "" {
+			err = serviceState.AuthRecords.DeletePasswordHash(device.NetworkId)
+		} else {
+			err = serviceState.AuthRecords.SetPasswordHash(device.NetworkId, record)
+		}
+		if err != nil {
+			logging.ForCharger(device.NetworkId).Errorf("Auth record not written, until the next reconciliation: %s", err.Error())
+			return err
+		}
+	}
+	notifyAuthChanged(device.NetworkId)
+	return nil
+}
+
+// The device's auth record, with its current and pending password hashes, or empty if it can't authenticate
+func authRecord(device *dbmodels.Device) string {
+	if device.Disabled {
+		return ""
+	}
+	return auth.AuthRecord(device.PasswordHash, device.PendingPasswordHash)
+}
+
+func notifyAuthChanged(networkId string) {
+	if err := mq.MqNotifyAuthChanged(serviceState.MqBus, serviceState.Context.HostName, networkId); err != nil {
+		logging.ForCharger(networkId).Warnf("Auth change not notified, csms-server may use its cached decision until it expires: %s", err.Error())
+	}
+}
+
+// Asks csms-server to close the device's connection, if it has one
+func disconnectDevice(networkId string) {
+	if err := mq.MqDisconnectClient(serviceState.MqBus, networkId); err != nil {
+		logging.ForCharger(networkId).Errorf("Error disconnecting: %s", err.Error())
+	}
+}
+
+func authSyncInterval(config conf.AuthSyncConfig) time.Duration {
+	mins := config.IntervalMins
+	if mins <= 0 {
+		mins = defaultAuthSyncIntervalMins
+	}
+	return time.Duration(mins) * time.Minute
+}
+
+// Reconciles the auth records with the devices table now and every interval, until ctx is cancelled
+func runAuthSync(ctx context.Context, interval time.Duration, prune bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := reconcileAuthRecords(ctx, prune)
+		if err != nil {
+			log.Errorf("Error reconciling auth records: %s", err.Error())
+		}
+		if result.Set > 0 || result.Deleted > 0 {
+			log.Warnf("Auth records repaired, set: %d, deleted: %d", result.Set, result.Deleted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sets the auth record of each enabled device with a password, and removes those of disabled devices and devices
+// without a password. If prune is set, records of chargers which aren't devices are removed too
+func reconcileAuthRecords(ctx context.Context, prune bool) (authSyncResult, error) {
+	result := authSyncResult{}
+	tenant := serviceState.Config.Services.DeviceManager.Tenant
+	devices := map[string]dbmodels.Device{}
+	for offset := 0; ; offset += authSyncPageSize {
+		page, err := serviceState.Devices.ListDevices(ctx, dbmodels.DeviceFilter{Tenant: tenant, Limit: authSyncPageSize, Offset: offset})
+		if err != nil {
+			return result, err
+		}
+		for _, device := range page {
+			if device.Tenant == tenant { // an empty filter tenant matches all
+				devices[device.NetworkId] = device
+			}
+		}
+		if len(page) < authSyncPageSize {
+			break
+		}
+	}
+	records, err := serviceState.AuthRecords.ListPasswordHashes()
+	if err != nil {
+		return result, err
+	}
+
+	var errs []error
+	for networkId, device := range devices {
+		existing, exists := records[networkId]
+		record := authRecord(&device)
+		switch {
+		case record != "" && existing != record:
+			err = serviceState.AuthRecords.SetPasswordHash(networkId, record)
+			if err == nil {
+				result.Set++
+			}
+		case record == "" && exists:
+			err = serviceState.AuthRecords.DeletePasswordHash(networkId)
+			if err == nil {
+				result.Deleted++
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		notifyAuthChanged(networkId)
+	}
+
+	if prune {
+		for networkId := range records {
+			if _, ok := devices[networkId]; ok {
+				continue
+			}
+			if err := serviceState.AuthRecords.DeletePasswordHash(networkId); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			result.Deleted++
+			notifyAuthChanged(networkId)
+		}
+	}
+	return result, errors.Join(errs...)
+}
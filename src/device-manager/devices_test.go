@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sw/ocpp/csms/internal/auth"
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mq "sw/ocpp/csms/internal/mq"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Auth records in a map, failing for the networkId "charger-down"
+type fakeAuthRecords map[string]string
+
+func (f fakeAuthRecords) SetPasswordHash(networkId string, hash string) error {
+	if networkId == "charger-down" {
+		return errors.New("connection refused")
+	}
+	f[networkId] = hash
+	return nil
+}
+
+func (f fakeAuthRecords) DeletePasswordHash(networkId string) error {
+	delete(f, networkId)
+	return nil
+}
+
+func (f fakeAuthRecords) ListPasswordHashes() (map[string]string, error) {
+	hashes := map[string]string{}
+	for networkId, hash := range f {
+		hashes[networkId] = hash
+	}
+	return hashes, nil
+}
+
+func setupDeviceState(t *testing.T) (*fakeMqBus, fakeAuthRecords) {
+	bus, _ := setupCertificateState(t)
+	bus.published = make(chan string, 100)
+	records := fakeAuthRecords{}
+	serviceState.Config = &conf.Configuration{}
+	serviceState.Devices = memdb.NewDeviceRepository()
+	serviceState.Templates = memdb.NewDeviceTemplateRepository()
+	serviceState.Compliance = memdb.NewDeviceComplianceRepository()
+	serviceState.AuthRecords = records
+	return bus, records
+}
+
+// The published messages, by channel, as notifications and disconnect commands only differ by their fields
+func drainPublished(bus *fakeMqBus) (notified []string, disconnected []string) {
+	for {
+		select {
+		case published := <-bus.published:
+			var message struct {
+				NotifyType string `json:"notifyType"`
+				NetworkId  string `json:"networkId"`
+				Client     string `json:"client"`
+				Command    string `json:"command"`
+			}
+			json.Unmarshal([]byte(published), &message)
+			if message.NotifyType == mq.NotifyMsg_AuthChanged {
+				notified = append(notified, message.NetworkId)
+			}
+			if message.Command == mq.Command_Disconnect {
+				disconnected = append(disconnected, message.Client)
+			}
+		default:
+			return notified, disconnected
+		}
+	}
+}
+
+func TestDeviceWriteThrough(t *testing.T) {
+	bus, records := setupDeviceState(t)
+	ctx := context.Background()
+
+	device := &dbmodels.Device{NetworkId: "charger-1", PasswordHash: "hash-1"}
+	require.NoError(t, createDevice(ctx, device))
+	assert.Equal(t, "hash-1", records["charger-1"])
+	require.NoError(t, createDevice(ctx, &dbmodels.Device{NetworkId: "charger-2"}))
+	assert.NotContains(t, records, "charger-2", "no password")
+
+	setPassword := func(device *dbmodels.Device) { device.PasswordHash = "hash-2" }
+	_, err := updateDevice(ctx, "", "charger-1", setPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "hash-2", records["charger-1"])
+	notified, disconnected := drainPublished(bus)
+	assert.Equal(t, []string{"charger-1", "charger-2", "charger-1"}, notified)
+	assert.Empty(t, disconnected)
+
+	disable := func(device *dbmodels.Device) { device.Disabled = true }
+	_, err = updateDevice(ctx, "", "charger-1", disable)
+	require.NoError(t, err)
+	assert.NotContains(t, records, "charger-1")
+	_, disconnected = drainPublished(bus)
+	assert.Equal(t, []string{"charger-1"}, disconnected, "disabling kicks the connection")
+
+	_, err = updateDevice(ctx, "", "charger-1", disable)
+	require.NoError(t, err)
+	_, disconnected = drainPublished(bus)
+	assert.Empty(t, disconnected, "already disabled")
+
+	require.NoError(t, deleteDevice(ctx, "", "charger-2"))
+	_, disconnected = drainPublished(bus)
+	assert.Equal(t, []string{"charger-2"}, disconnected)
+
+	assert.Error(t, createDevice(ctx, &dbmodels.Device{NetworkId: "charger-down", PasswordHash: "hash"}), "write through failed")
+	_, err = serviceState.Devices.GetDevice(ctx, "", "charger-down")
+	assert.NoError(t, err, "left to reconciliation")
+}
+
+func TestReconcileAuthRecords(t *testing.T) {
+	bus, records := setupDeviceState(t)
+	ctx := context.Background()
+	for _, device := range []dbmodels.Device{
+		{NetworkId: "charger-1", PasswordHash: "hash-1"},
+		{NetworkId: "charger-2", PasswordHash: "hash-2"},
+		{NetworkId: "charger-3", PasswordHash: "hash-3", Disabled: true},
+		{NetworkId: "charger-4"},
+		{NetworkId: "charger-5", PasswordHash: "hash-5"},
+		{Tenant: "other", NetworkId: "charger-6", PasswordHash: "hash-6"},
+	} {
+		serviceState.Devices.InsertDevice(ctx, &device)
+	}
+	records["charger-1"] = "hash-1"
+	records["charger-2"] = "stale"
+	records["charger-3"] = "hash-3"
+	records["charger-4"] = "hand-made"
+	records["charger-9"] = "hand-made"
+
+	result, err := reconcileAuthRecords(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, authSyncResult{Set: 2, Deleted: 2}, result)
+	assert.Equal(t, fakeAuthRecords{"charger-1": "hash-1", "charger-2": "hash-2", "charger-5": "hash-5", "charger-9": "hand-made"}, records)
+	notified, _ := drainPublished(bus)
+	assert.ElementsMatch(t, []string{"charger-2", "charger-3", "charger-4", "charger-5"}, notified)
+
+	result, err = reconcileAuthRecords(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, authSyncResult{Deleted: 1}, result)
+	assert.NotContains(t, records, "charger-9", "pruned")
+}
+
+func setupDevicesRouter(t *testing.T) (http.Handler, *fakeMqBus, fakeAuthRecords) {
+	bus, records := setupDeviceState(t)
+	for _, name := range []string{"template-1", "template-2", "template-3"} {
+		_, err := serviceState.Templates.InsertTemplate(context.Background(), &dbmodels.DeviceTemplate{Name: name})
+		require.NoError(t, err)
+	}
+
+	return apiRouter(), bus, records
+}
+
+// The API's routes, without setupRestApi's Basic auth and middleware
+func apiRouter() http.Handler {
+	router := chi.NewRouter()
+	registerApiRoutes(router)
+	return router
+}
+
+func serveDevices(router http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func TestDevicesCreate(t *testing.T) {
+	router, _, records := setupDevicesRouter(t)
+
+	rec := serveDevices(router, http.MethodPost, "/devices", `{"networkId":"charger-1","deviceTemplateId":2,"password":"0123456789abcdef"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "password")
+	var created dbmodels.Device
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, "charger-1", created.NetworkId)
+	assert.Equal(t, int64(2), created.DeviceTemplateId)
+	valid, err := auth.VerifyPassword("0123456789abcdef", records["charger-1"])
+	require.NoError(t, err)
+	assert.True(t, valid, "written through")
+
+	assert.Equal(t, http.StatusConflict, serveDevices(router, http.MethodPost, "/devices", `{"networkId":"charger-1"}`).Code)
+	for _, body := range []string{`{"networkId":"charger_1"}`, `{"networkId":"0123456789012345678901234567890123"}`, `{}`,
+		`{"networkId":"charger-2","password":"short"}`, `{"networkId":"charger-2","deviceTemplateId":4}`, `not json`} {
+		assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodPost, "/devices", body).Code, body)
+	}
+}
+
+func TestDevicesList(t *testing.T) {
+	router, _, _ := setupDevicesRouter(t)
+	for _, body := range []string{`{"networkId":"site1-a","deviceTemplateId":1,"tags":["AC","ac","fleet-1"]}`, `{"networkId":"site1-b","disabled":true}`,
+		`{"networkId":"site2-a","tags":["fleet-1"]}`} {
+		require.Equal(t, http.StatusCreated, serveDevices(router, http.MethodPost, "/devices", body).Code)
+	}
+	networkIds := func(target string) []string {
+		rec := serveDevices(router, http.MethodGet, target, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var devices []dbmodels.Device
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &devices))
+		ids := []string{}
+		for _, device := range devices {
+			ids = append(ids, device.NetworkId)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"site1-a", "site1-b", "site2-a"}, networkIds("/devices"))
+	assert.Equal(t, []string{"site1-a", "site1-b"}, networkIds("/devices?networkIdPrefix=site1"))
+	assert.Equal(t, []string{"site1-a"}, networkIds("/devices?deviceTemplateId=1"))
+	assert.Equal(t, []string{"site1-a", "site2-a"}, networkIds("/devices?disabled=false"))
+	assert.Equal(t, []string{"site1-b"}, networkIds("/devices?limit=1&offset=1"))
+	assert.Equal(t, []string{"site1-a", "site2-a"}, networkIds("/devices?tag=Fleet-1"))
+	assert.Equal(t, []string{"site1-a"}, networkIds("/devices?tag=ac"))
+	for _, query := range []string{"disabled=maybe", "limit=0", "offset=-1", "deviceTemplateId=x"} {
+		assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodGet, "/devices?"+query, "").Code, query)
+	}
+}
+
+func TestDevicesUpdate(t *testing.T) {
+	router, bus, records := setupDevicesRouter(t)
+	require.Equal(t, http.StatusCreated, serveDevices(router, http.MethodPost, "/devices", `{"networkId":"charger-1","password":"0123456789abcdef"}`).Code)
+	drainPublished(bus)
+
+	rec := serveDevices(router, http.MethodPatch, "/devices/charger-1", `{"deviceTemplateId":3}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	device, err := serviceState.Devices.GetDevice(context.Background(), "", "charger-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), device.DeviceTemplateId)
+	assert.Equal(t, records["charger-1"], device.PasswordHash, "password unchanged")
+	assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodPatch, "/devices/charger-1", `{"networkId":"charger-2"}`).Code)
+
+	rec = serveDevices(router, http.MethodPatch, "/devices/charger-1", `{"tags":["Site-1","ac"]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `["site-1","ac"]`, string(mustField(t, rec.Body.Bytes(), "tags")))
+	for _, body := range []string{`{"tags":["site_1"]}`, `{"tags":["a,b"]}`, `{"tags":[""]}`} {
+		assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodPatch, "/devices/charger-1", body).Code, body)
+	}
+
+	require.Equal(t, http.StatusOK, serveDevices(router, http.MethodPost, "/devices/charger-1/disable", "").Code)
+	assert.NotContains(t, records, "charger-1")
+	_, disconnected := drainPublished(bus)
+	assert.Equal(t, []string{"charger-1"}, disconnected)
+
+	rec = serveDevices(router, http.MethodPost, "/devices/charger-1/enable", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, records, "charger-1")
+	assert.JSONEq(t, `false`, string(mustField(t, rec.Body.Bytes(), "disabled")))
+
+	assert.Equal(t, http.StatusNoContent, serveDevices(router, http.MethodDelete, "/devices/charger-1", "").Code)
+	assert.NotContains(t, records, "charger-1")
+	assert.Equal(t, http.StatusNotFound, serveDevices(router, http.MethodGet, "/devices/charger-1", "").Code)
+}
+
+func TestDevicesNotFound(t *testing.T) {
+	router, _, _ := setupDevicesRouter(t)
+
+	for _, target := range []string{"/devices/charger-1", "/devices/charger_1"} {
+		rec := serveDevices(router, http.MethodGet, target, "")
+		assert.Equal(t, http.StatusNotFound, rec.Code, target)
+		assert.JSONEq(t, `{"status":"Resource not found."}`, rec.Body.String())
+	}
+	assert.Equal(t, http.StatusNotFound, serveDevices(router, http.MethodPatch, "/devices/charger-1", `{}`).Code)
+	assert.Equal(t, http.StatusNotFound, serveDevices(router, http.MethodDelete, "/devices/charger-1", "").Code)
+}
+
+func mustField(t *testing.T, body []byte, field string) json.RawMessage {
+	var fields map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(body, &fields))
+	return fields[field]
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/auth"
+	redisManage "sw/ocpp/csms/internal/cache"
+	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
+	"sw/ocpp/csms/internal/logging"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	ocppmodels "sw/ocpp/csms/internal/ocpp"
+
+	"github.com/go-chi/render"
+)
+
+const passwordConfirmTimeout = 10 * time.Second
+
+type ChangePasswordRequest struct {
+	Password string `json:"password"`
+}
+
+type ChangePasswordResponse struct {
+	Status string `json:"status"`
+}
+
+func (rd *ChangePasswordResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Sends the charger a new security profile 1 password, as ChangeConfiguration AuthorizationKey. Its hash is stored as
+// the device's pending password first, so the charger can authenticate with either password if the response is lost,
+// and replaces the current one once the charger authenticates with it
+func action_changePassword(w http.ResponseWriter, r *http.Request) {
+	device := r.Context().Value("device").(*Device)
+	clog := logging.ForCharger(device.NetworkId)
+
+	request := &ChangePasswordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err := auth.ValidatePassword(request.Password); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	hash, err := auth.HashPassword(request.Password)
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+
+	payload, err := json.Marshal(ocppmodels.OcppChangeConfiguration{Key: ocppmodels.ConfigKey_AuthorizationKey, Value: request.Password})
+	if err != nil {
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if deviceOffline(device.NetworkId) {
+		render.Render(w, r, ErrConflict(errDeviceOffline))
+		return
+	}
+	_, err = updateDevice(r.Context(), device.Record.Tenant, device.NetworkId, func(device *dbmodels.Device) {
+		device.PendingPasswordHash = hash
+	})
+	if err != nil {
+		clog.Errorf("Error storing pending password: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+
+	action, err := sendAction(r.Context(), device, ocppmodels.MsgType_ChangeConfiguration, payload)
+	if err != nil {
+		clog.Errorf("Error changing password: %s", err.Error())
+		clearPendingPassword(r.Context(), device, hash)
+		render.Render(w, r, ErrAction(err))
+		return
+	}
+	response, err := action.await(r.Context(), actionTimeout(ocppmodels.MsgType_ChangeConfiguration))
+	if err != nil {
+		var callErr *callError
+		if errors.As(err, &callErr) {
+			clearPendingPassword(r.Context(), device, hash)
+		} else {
+			clog.Warn("Password change unconfirmed, the pending password is accepted until the charger authenticates with it")
+		}
+		clog.Errorf("Error changing password: %s", err.Error())
+		render.Render(w, r, ErrAction(err))
+		return
+	}
+
+	changeResponse := &ocppmodels.OcppChangeConfigurationResponse{}
+	if err := json.Unmarshal(response.MessageBody, changeResponse); err != nil {
+		render.Render(w, r, ErrInternal(fmt.Errorf("invalid ChangeConfiguration response: %w", err)))
+		return
+	}
+	switch changeResponse.Status {
+	case ocppmodels.ConfigurationStatus_Accepted, ocppmodels.ConfigurationStatus_RebootRequired:
+		clog.Info("Password changed, pending until the charger authenticates with it")
+	default:
+		clog.Warnf("Charger didn't change password: %s", changeResponse.Status)
+		clearPendingPassword(r.Context(), device, hash)
+		render.Status(r, http.StatusConflict)
+	}
+	render.Render(w, r, &ChangePasswordResponse{Status: changeResponse.Status})
+}
+
+// Removes the device's pending password after the charger refused it, unless it's since been replaced
+func clearPendingPassword(ctx context.Context, device *Device, hash string) {
+	_, err := updateDevice(ctx, device.Record.Tenant, device.NetworkId, func(device *dbmodels.Device) {
+		if device.PendingPasswordHash == hash {
+			device.PendingPasswordHash = ""
+		}
+	})
+	if err != nil {
+		logging.ForCharger(device.NetworkId).Errorf("Error clearing pending password: %s", err.Error())
+	}
+}
+
+// Makes the device's pending password its current one, once csms-server notifies the charger authenticated with it
+func confirmPendingPassword(networkId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordConfirmTimeout)
+	defer cancel()
+	confirmed := false
+	_, err := updateDevice(ctx, serviceState.Config.Services.DeviceManager.Tenant, networkId, func(device *dbmodels.Device) {
+		if device.PendingPasswordHash != "" {
+			device.PasswordHash, device.PendingPasswordHash = device.PendingPasswordHash, ""
+			confirmed = true
+		}
+	})
+	if errors.Is(err, db.ErrNotFound) { // another tenant's
+		return
+	}
+	if err != nil {
+		logging.ForCharger(networkId).Errorf("Error confirming pending password: %s", err.Error())
+		return
+	}
+	if confirmed {
+		logging.ForCharger(networkId).Info("Pending password confirmed, the previous one is no longer accepted")
+	}
+}
+
+// Prints the hash of a password, read from stdin, to store in a charger's CP_<networkId> redis key
+func runHashPasswordCommand() {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Errorf("Error reading password: %s", err.Error())
+		os.Exit(1)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if err := auth.ValidatePassword(password); err != nil {
+		log.Errorf("Invalid password: %s", err.Error())
+		os.Exit(1)
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		log.Errorf("Error hashing password: %s", err.Error())
+		os.Exit(1)
+	}
+	fmt.Println(hash)
+	os.Exit(0)
+}
+
+// Hashes the plaintext passwords left in CP_<networkId> redis keys from before passwords were hashed, as csms-server
+// rejects them
+func runHashAuthRecordsCommand() {
+	config := conf.ReadConfig()
+	cacheConfig := config.Services.DeviceManager.Cache
+	if cacheConfig.HostPort == "" {
+		log.Error("No device_manager.cache configured")
+		os.Exit(1)
+	}
+	cacheClient, err := redisManage.ConnectRedis(cacheConfig.HostPort, cacheConfig.Password, cacheConfig.DbId)
+	if err != nil {
+		log.Errorf("Error connecting to redis: %s", err.Error())
+		os.Exit(1)
+	}
+	hashed, err := auth.HashPlaintextRecords(auth.NewRedisAuthRecords(cacheClient))
+	if err != nil {
+		log.Errorf("Error hashing auth records, %d hashed: %s", hashed, err.Error())
+		os.Exit(1)
+	}
+	log.Infof("Auth records hashed: %d", hashed)
+	os.Exit(0)
+}
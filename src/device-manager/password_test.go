@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sw/ocpp/csms/internal/auth"
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mq "sw/ocpp/csms/internal/mq"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupPasswordRouter(t *testing.T) http.Handler {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+
+	serviceState = &ServiceState{Config: &conf.Configuration{}, Devices: memdb.NewDeviceRepository()}
+	t.Cleanup(func() { serviceState = nil })
+	_, err := serviceState.Devices.InsertDevice(context.Background(), &dbmodels.Device{NetworkId: "charger-1", PasswordHash: "old"})
+	require.NoError(t, err)
+
+	return apiRouter()
+}
+
+func TestChangePasswordValidation(t *testing.T) {
+	// No MQ, the requests are rejected before anything is sent
+	router := setupPasswordRouter(t)
+
+	for _, body := range []string{`{"password":"short"}`, `{"password":"0123456789 abcdef"}`, `not json`} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/actions/changepassword/charger-1", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+}
+
+func TestChangePasswordUnknownDevice(t *testing.T) {
+	router := setupPasswordRouter(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/actions/changepassword/charger-2", strings.NewReader(`{"password":"0123456789abcdef"}`)))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// Sends a password change in the background, returning its response and the published call, for the caller to
+// respond to
+func changePassword(t *testing.T, router http.Handler, bus *fakeMqBus, password string) (<-chan *httptest.ResponseRecorder, publishedMessage) {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/actions/changepassword/charger-1", strings.NewReader(`{"password":"`+password+`"}`)))
+		done <- rec
+	}()
+	notifyAuthChange(t, bus) // pending password stored before it's sent
+	call := bus.next(t)
+	require.Equal(t, "ChangeConfiguration", call.Body.MessageType)
+	return done, call
+}
+
+func notifyAuthChange(t *testing.T, bus *fakeMqBus) {
+	var notify struct {
+		NotifyType string `json:"notifyType"`
+		NetworkId  string `json:"networkId"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(<-bus.published), &notify))
+	assert.Equal(t, mq.NotifyMsg_AuthChanged, notify.NotifyType)
+	assert.Equal(t, "charger-1", notify.NetworkId)
+}
+
+func TestChangePasswordDevice(t *testing.T) {
+	router := setupPasswordRouter(t)
+	bus := &fakeMqBus{published: make(chan string, 10)}
+	records := fakeAuthRecords{}
+	serviceState.MqBus, serviceState.MessagesWaiting, serviceState.AuthRecords = bus, xsync.NewMap(), records
+
+	done, call := changePassword(t, router, bus, "0123456789abcdef")
+	device, err := serviceState.Devices.GetDevice(context.Background(), "", "charger-1")
+	require.NoError(t, err)
+	assert.Equal(t, "old", device.PasswordHash, "kept until confirmed")
+	valid, err := auth.VerifyPassword("0123456789abcdef", device.PendingPasswordHash)
+	require.NoError(t, err)
+	assert.True(t, valid)
+	assert.Equal(t, "old "+device.PendingPasswordHash, records["charger-1"], "both written through")
+
+	// changed while waiting for the charger, after the handler read the device
+	_, err = serviceState.Devices.ModifyDevice(context.Background(), "", "charger-1", func(device *dbmodels.Device) {
+		device.Tags = []string{"site-1"}
+	})
+	require.NoError(t, err)
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Accepted"}), nil)
+	rec := <-done
+	require.Equal(t, http.StatusOK, rec.Code)
+	device, err = serviceState.Devices.GetDevice(context.Background(), "", "charger-1")
+	require.NoError(t, err)
+	assert.Equal(t, "old", device.PasswordHash, "kept until the charger authenticates with the new one")
+	assert.Equal(t, []string{"site-1"}, device.Tags, "concurrent change kept")
+
+	ProcessRecvNotify([]byte(`{"notifyType":"PasswordConfirmed","serverNode":"node1","networkId":"charger-1"}`), nil)
+	notifyAuthChange(t, bus)
+	confirmed, err := serviceState.Devices.GetDevice(context.Background(), "", "charger-1")
+	require.NoError(t, err)
+	assert.Equal(t, device.PendingPasswordHash, confirmed.PasswordHash)
+	assert.Empty(t, confirmed.PendingPasswordHash)
+	assert.Equal(t, confirmed.PasswordHash, records["charger-1"], "old one dropped")
+}
+
+func TestChangePasswordUnconfirmed(t *testing.T) {
+	router := setupPasswordRouter(t)
+	bus := &fakeMqBus{published: make(chan string, 10)}
+	serviceState.MqBus, serviceState.MessagesWaiting, serviceState.AuthRecords = bus, xsync.NewMap(), fakeAuthRecords{}
+	serviceState.Config.Services.DeviceManager.Actions.TimeoutSecs = 1
+
+	// The response is lost, so the charger may have the new password
+	done, _ := changePassword(t, router, bus, "0123456789abcdef")
+	require.Equal(t, http.StatusGatewayTimeout, (<-done).Code)
+	device, err := serviceState.Devices.GetDevice(context.Background(), "", "charger-1")
+	require.NoError(t, err)
+	assert.Equal(t, "old", device.PasswordHash)
+	assert.NotEmpty(t, device.PendingPasswordHash, "still accepted")
+
+	// Refused, so the charger still has the old one
+	done, call := changePassword(t, router, bus, "abcdef0123456789")
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Rejected"}), nil)
+	require.Equal(t, http.StatusConflict, (<-done).Code)
+	notifyAuthChange(t, bus)
+	device, err = serviceState.Devices.GetDevice(context.Background(), "", "charger-1")
+	require.NoError(t, err)
+	assert.Equal(t, "old", device.PasswordHash)
+	assert.Empty(t, device.PendingPasswordHash)
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"sw/ocpp/csms/internal/logging"
 	mqmodels "sw/ocpp/csms/internal/models/mq"
 	svc "sw/ocpp/csms/internal/models/service"
 	ocppmodels "sw/ocpp/csms/internal/ocpp"
+	"sw/ocpp/csms/internal/tracing"
 )
 
 func ProcessRecvMessage(messageBy []byte, state any) {
@@ -29,19 +31,41 @@ func ProcessRecvMessage(messageBy []byte, state any) {
 		log.Errorf("MQ Received Message, unmarshall body error: %s\n", err.Error())
 		return
 	}
-	log.Debugf("OcppMessage Response, Direction: %d, Id: %s\n", ocppMessage.Direction, ocppMessage.MsgId)
-	if ocppMessage.Direction != 3 {
+	recordConnected(msgEnvelope.Client, msgEnvelope.ServerNode)
+
+	if ocppMessage.Direction == ocppmodels.OcppDirection_ClientServer && ocppMessage.MessageType == "SignCertificate" {
+		ctx, span := tracing.StartConsumerSpan(msgEnvelope.TraceContext, "device-manager SignCertificate",
+			tracing.Attr_NetworkId.String(msgEnvelope.Client), tracing.Attr_MsgId.String(ocppMessage.MsgId))
+		defer span.End()
+		processSignCertificate(ctx, msgEnvelope, ocppMessage)
+		return
+	}
+	if ocppMessage.Direction == ocppmodels.OcppDirection_ClientServer && ocppMessage.MessageType == "BootNotification" {
+		ctx, span := tracing.StartConsumerSpan(msgEnvelope.TraceContext, "device-manager BootNotification",
+			tracing.Attr_NetworkId.String(msgEnvelope.Client), tracing.Attr_MsgId.String(ocppMessage.MsgId))
+		defer span.End()
+		processBootNotification(ctx, msgEnvelope, ocppMessage)
 		return
 	}
+	if ocppMessage.Direction != ocppmodels.OcppDirection_Reply && ocppMessage.Direction != ocppmodels.OcppDirection_CallError {
+		return
+	}
+
+	ctx, span := tracing.StartConsumerSpan(msgEnvelope.TraceContext, "device-manager response",
+		tracing.Attr_NetworkId.String(msgEnvelope.Client), tracing.Attr_MsgId.String(ocppMessage.MsgId))
+	defer span.End()
+	mlog := logging.ForMessage(ctx, msgEnvelope.Client, ocppMessage.MsgId, "")
+	mlog.Debugf("OcppMessage Response, Direction: %d", ocppMessage.Direction)
 
-	val, ok := serviceState.MessagesWaiting.Load(ocppMessage.MsgId)
+	// Only the first response is delivered, so a duplicate can't race the waiter
+	val, ok := serviceState.MessagesWaiting.LoadAndDelete(ocppMessage.MsgId)
 	if ok {
 		msg := val.(*svc.DeviceWaitingMessage)
 		msg.Envelope = msgEnvelope
 		msg.Response = ocppMessage
 		msg.Notify <- 1
 	} else {
-		log.Errorf("Cannot find MsgId: %s\n", ocppMessage.MsgId)
+		mlog.Errorf("Cannot find MsgId: %s", ocppMessage.MsgId)
 	}
 }
 
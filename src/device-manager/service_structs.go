@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"io"
 	"net/http"
 
+	"sw/ocpp/csms/internal/auth"
 	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
+	httplistener "sw/ocpp/csms/internal/http"
+	dbmodels "sw/ocpp/csms/internal/models/db"
 	svc "sw/ocpp/csms/internal/models/service"
 	mq "sw/ocpp/csms/internal/mq"
+	"sw/ocpp/csms/internal/pki"
 
 	"github.com/go-redis/redis"
 	"github.com/sirupsen/logrus"
@@ -17,16 +23,35 @@ import (
 type ServiceState struct {
 	Config          *conf.Configuration
 	IoCloser        *io.Closer
+	AdminCloser     *io.Closer
+	Listener        *httplistener.Listener
 	Cache           *redis.Client
+	AuthRecords     auth.AuthRecordStore // the csms-server auth records in Cache, nil if it isn't configured
 	MqBus           mq.MqBus
 	LastError       error
 	Context         svc.ServiceContext
 	AppInsightsHook logrus.Hook
 	HttpServer      *http.Server
 	MessagesWaiting *xsync.Map
+	Db              *db.Store
+	Devices         db.DeviceRepository
+	Transactions    db.TransactionRepository
+	DeadLetters     db.DeadLetterRepository
+	Certificates    db.CertificateRepository
+	Templates       db.DeviceTemplateRepository
+	Compliance      db.DeviceComplianceRepository
+	BulkJobs        db.BulkJobRepository
+	Commands        db.CommandRepository
+	// Signs SignCertificate CSRs, nil if pki isn't enabled
+	CertificateAuthority pki.CertificateAuthority
+	StopRenewal          context.CancelFunc
+	StopAuthSync         context.CancelFunc
+	StopExpiry           context.CancelFunc
+	ShutdownTracing      func(context.Context) error
 }
 
 type Device struct {
 	NetworkId  string
-	ServerNode string
+	ServerNode string // csms-server node the charger is connected to, "" if it isn't known
+	Record     *dbmodels.Device
 }
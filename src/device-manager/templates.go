@@ -0,0 +1,478 @@
+// Device templates hold the desired OCPP configuration of their devices. After a device boots its configuration is
+// read with GetConfiguration, drifted keys are changed with ChangeConfiguration, the template's charging profiles are
+// set, and the device's compliance is recorded
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	db "sw/ocpp/csms/internal/db"
+	"sw/ocpp/csms/internal/logging"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mqmodels "sw/ocpp/csms/internal/models/mq"
+	ocppmodels "sw/ocpp/csms/internal/ocpp"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+const (
+	templateNameMaxLen     = 64
+	configKeyMaxLen        = 50  // OCPP 1.6 CiString50Type
+	configValueMaxLen      = 500 // OCPP 1.6 CiString500Type
+	templateEnforceTimeout = 5 * time.Minute
+)
+
+// networkIds of the devices being checked, so a charger booting repeatedly is only checked once at a time
+var enforcingTemplates = xsync.NewMap()
+
+// Template fields set by create and replace requests
+type TemplateRequest struct {
+	Name             string            `json:"name"`
+	Configuration    map[string]string `json:"configuration"`
+	ChargingProfiles []json.RawMessage `json:"chargingProfiles"`
+}
+
+// Lists templates by name, with query parameters: limit, offset
+func templates_List(w http.ResponseWriter, r *http.Request) {
+	filter := dbmodels.DeviceTemplateFilter{Tenant: serviceState.Config.Services.DeviceManager.Tenant}
+	var err error
+	filter.Limit, filter.Offset, err = pageFromQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	templates, err := serviceState.Templates.ListTemplates(r.Context(), filter)
+	if err != nil {
+		log.Errorf("Error listing templates: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, templates)
+}
+
+// Creates a template
+func templates_Create(w http.ResponseWriter, r *http.Request) {
+	template, err := templateFromRequest(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	_, err = serviceState.Templates.InsertTemplate(r.Context(), template)
+	if errors.Is(err, db.ErrRowExists) {
+		render.Render(w, r, ErrConflict(errors.New("template name already exists")))
+		return
+	}
+	if err != nil {
+		log.Errorf("Error creating template: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, template)
+}
+
+// Gets a single template
+func templates_Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "templateid"), 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("invalid templateid")))
+		return
+	}
+
+	template, err := serviceState.Templates.GetTemplate(r.Context(), serviceState.Config.Services.DeviceManager.Tenant, id)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error getting template: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, template)
+}
+
+// Replaces a template. Its devices are checked against it after they next boot
+func templates_Replace(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "templateid"), 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("invalid templateid")))
+		return
+	}
+	template, err := templateFromRequest(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	template.Id = id
+
+	err = serviceState.Templates.UpdateTemplate(r.Context(), template)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if errors.Is(err, db.ErrRowExists) {
+		render.Render(w, r, ErrConflict(errors.New("template name already exists")))
+		return
+	}
+	if err != nil {
+		log.Errorf("Error updating template: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, template)
+}
+
+// Deletes a template, unless devices use it
+func templates_Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "templateid"), 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("invalid templateid")))
+		return
+	}
+	tenant := serviceState.Config.Services.DeviceManager.Tenant
+
+	devices, err := serviceState.Devices.ListDevices(r.Context(), dbmodels.DeviceFilter{Tenant: tenant, DeviceTemplateId: id, Limit: 1})
+	if err != nil {
+		log.Errorf("Error listing template devices: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	if len(devices) > 0 {
+		render.Render(w, r, ErrConflict(errors.New("template is used by devices")))
+		return
+	}
+
+	err = serviceState.Templates.DeleteTemplate(r.Context(), tenant, id)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error deleting template: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Lists device compliance by networkId, filtered by query parameters: status, deviceTemplateId, limit, offset
+func compliance_List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := dbmodels.DeviceComplianceFilter{Tenant: serviceState.Config.Services.DeviceManager.Tenant}
+
+	switch status := query.Get("status"); status {
+	case "", dbmodels.ComplianceStatus_Compliant, dbmodels.ComplianceStatus_Remediated, dbmodels.ComplianceStatus_NonCompliant,
+		dbmodels.ComplianceStatus_Error:
+		filter.Status = status
+	default:
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid status: %s", status)))
+		return
+	}
+	if deviceTemplateId := query.Get("deviceTemplateId"); deviceTemplateId != "" {
+		id, err := strconv.ParseInt(deviceTemplateId, 10, 64)
+		if err != nil || id < 1 {
+			render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid deviceTemplateId: %s", deviceTemplateId)))
+			return
+		}
+		filter.DeviceTemplateId = id
+	}
+	var err error
+	filter.Limit, filter.Offset, err = pageFromQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	compliance, err := serviceState.Compliance.ListCompliance(r.Context(), filter)
+	if err != nil {
+		log.Errorf("Error listing compliance: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, compliance)
+}
+
+// Gets the result of the device's last check against its template
+func devices_GetCompliance(w http.ResponseWriter, r *http.Request) {
+	device := r.Context().Value("device").(*Device)
+
+	compliance, err := serviceState.Compliance.GetCompliance(r.Context(), device.Record.Tenant, device.NetworkId)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error getting compliance: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, compliance)
+}
+
+// Checks the connected device against its template now, rather than after it next boots
+func devices_CheckCompliance(w http.ResponseWriter, r *http.Request) {
+	device := r.Context().Value("device").(*Device)
+	if device.Record.DeviceTemplateId == 0 {
+		render.Render(w, r, ErrConflict(errors.New("device has no template")))
+		return
+	}
+
+	compliance, err := enforceTemplate(r.Context(), device)
+	if err != nil {
+		log.Errorf("Error checking compliance: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, compliance)
+}
+
+// Records the device's firmware version and checks it against its template once it has booted. Runs in the
+// background, as the charger's responses are received by the caller
+func processBootNotification(ctx context.Context, msgEnvelope *mqmodels.MqMessageEnvelope, ocppMessage *ocppmodels.OcppMessage) {
+	if _, running := enforcingTemplates.LoadOrStore(msgEnvelope.Client, true); running {
+		return
+	}
+	go func() {
+		defer enforcingTemplates.Delete(msgEnvelope.Client)
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), templateEnforceTimeout)
+		defer cancel()
+
+		device, err := dbGetDevice(ctx, msgEnvelope.Client)
+		if errors.Is(err, db.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			logging.ForCharger(msgEnvelope.Client).Errorf("Error getting device: %s", err.Error())
+			return
+		}
+		recordFirmwareVersion(ctx, device, ocppMessage)
+		if device.Record.DeviceTemplateId == 0 {
+			return
+		}
+		device.ServerNode = msgEnvelope.ServerNode
+		if _, err := enforceTemplate(ctx, device); err != nil {
+			logging.ForCharger(device.NetworkId).Errorf("Error checking compliance: %s", err.Error())
+		}
+	}()
+}
+
+func recordFirmwareVersion(ctx context.Context, device *Device, ocppMessage *ocppmodels.OcppMessage) {
+	boot := &ocppmodels.OcppBootNotification{}
+	if err := json.Unmarshal(ocppMessage.MessageBody, boot); err != nil || boot.FirmwareVersion == device.Record.FirmwareVersion {
+		return
+	}
+	err := serviceState.Devices.SetFirmwareVersion(ctx, device.Record.Tenant, device.NetworkId, boot.FirmwareVersion)
+	if err != nil {
+		logging.ForCharger(device.NetworkId).WithContext(ctx).Errorf("Error recording firmware version: %s", err.Error())
+		return
+	}
+	device.Record.FirmwareVersion = boot.FirmwareVersion
+}
+
+// Reads the device's configuration, changes the keys which differ from its template and sets the template's
+// charging profiles, recording the result. Only errors getting the template or recording the result are returned,
+// the charger's errors are part of the result
+func enforceTemplate(ctx context.Context, device *Device) (*dbmodels.DeviceCompliance, error) {
+	clog := logging.ForCharger(device.NetworkId).WithContext(ctx)
+	template, err := serviceState.Templates.GetTemplate(ctx, device.Record.Tenant, device.Record.DeviceTemplateId)
+	if err != nil {
+		return nil, fmt.Errorf("template %d: %w", device.Record.DeviceTemplateId, err)
+	}
+
+	compliance := &dbmodels.DeviceCompliance{Tenant: device.Record.Tenant, NetworkId: device.NetworkId, DeviceTemplateId: template.Id,
+		Status: dbmodels.ComplianceStatus_Compliant, Keys: []dbmodels.KeyCompliance{}, ChargingProfiles: []string{}}
+	actual, err := getConfiguration(ctx, device, template)
+	if err != nil {
+		clog.Warnf("Error getting configuration: %s", err.Error())
+		compliance.Status, compliance.Error = dbmodels.ComplianceStatus_Error, err.Error()
+	} else {
+		compliance.Keys = changeDriftedKeys(ctx, device, template, actual)
+		compliance.ChargingProfiles = setChargingProfiles(ctx, device, template)
+		compliance.Status = complianceStatus(compliance)
+	}
+	compliance.CheckedAt = time.Now().UTC()
+
+	if err := serviceState.Compliance.SetCompliance(ctx, compliance); err != nil {
+		return nil, err
+	}
+	clog.Infof("Template %d compliance: %s", template.Id, compliance.Status)
+	return compliance, nil
+}
+
+// Gets the values of the template's keys from the charger, by key. Keys the charger doesn't have are missing
+func getConfiguration(ctx context.Context, device *Device, template *dbmodels.DeviceTemplate) (map[string]ocppmodels.OcppKeyValue, error) {
+	keys := templateKeys(template)
+	if len(keys) == 0 {
+		return map[string]ocppmodels.OcppKeyValue{}, nil
+	}
+	payload, _ := json.Marshal(ocppmodels.OcppGetConfiguration{Key: keys})
+	response, err := sendActionAndWait(ctx, device, ocppmodels.MsgType_GetConfiguration, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	configuration := &ocppmodels.OcppGetConfigurationResponse{}
+	if err := json.Unmarshal(response.MessageBody, configuration); err != nil {
+		return nil, fmt.Errorf("invalid GetConfiguration response: %w", err)
+	}
+	actual := map[string]ocppmodels.OcppKeyValue{}
+	for _, keyValue := range configuration.ConfigurationKey {
+		actual[keyValue.Key] = keyValue
+	}
+	return actual, nil
+}
+
+func changeDriftedKeys(ctx context.Context, device *Device, template *dbmodels.DeviceTemplate, actual map[string]ocppmodels.OcppKeyValue) []dbmodels.KeyCompliance {
+	results := []dbmodels.KeyCompliance{}
+	for _, key := range templateKeys(template) {
+		result := dbmodels.KeyCompliance{Key: key, Desired: template.Configuration[key]}
+		keyValue, ok := actual[key]
+		result.Actual = keyValue.Value
+		switch {
+		case !ok:
+			result.Status = dbmodels.KeyStatus_Unknown
+		case configValuesEqual(keyValue.Value, result.Desired):
+			result.Status = dbmodels.KeyStatus_Compliant
+		case keyValue.Readonly:
+			result.Status = dbmodels.KeyStatus_Readonly
+		default:
+			result.Status = changeConfiguration(ctx, device, key, result.Desired)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func changeConfiguration(ctx context.Context, device *Device, key string, value string) string {
+	payload, _ := json.Marshal(ocppmodels.OcppChangeConfiguration{Key: key, Value: value})
+	response, err := sendActionAndWait(ctx, device, ocppmodels.MsgType_ChangeConfiguration, payload)
+	if err != nil {
+		logging.ForCharger(device.NetworkId).WithContext(ctx).Warnf("Error changing %s: %s", key, err.Error())
+		return dbmodels.KeyStatus_Error
+	}
+
+	changeResponse := &ocppmodels.OcppChangeConfigurationResponse{}
+	json.Unmarshal(response.MessageBody, changeResponse)
+	switch changeResponse.Status {
+	case ocppmodels.ConfigurationStatus_Accepted:
+		return dbmodels.KeyStatus_Changed
+	case ocppmodels.ConfigurationStatus_RebootRequired:
+		return dbmodels.KeyStatus_RebootRequired
+	case ocppmodels.ConfigurationStatus_Rejected:
+		return dbmodels.KeyStatus_Rejected
+	case ocppmodels.ConfigurationStatus_NotSupported:
+		return dbmodels.KeyStatus_NotSupported
+	default:
+		return dbmodels.KeyStatus_Error
+	}
+}
+
+// Sets each of the template's charging profiles, returning the charger's status for each
+func setChargingProfiles(ctx context.Context, device *Device, template *dbmodels.DeviceTemplate) []string {
+	statuses := []string{}
+	for _, profile := range template.ChargingProfiles {
+		response, err := sendActionAndWait(ctx, device, ocppmodels.MsgType_SetChargingProfile, profile)
+		if err != nil {
+			logging.ForCharger(device.NetworkId).WithContext(ctx).Warnf("Error setting charging profile: %s", err.Error())
+			statuses = append(statuses, dbmodels.KeyStatus_Error)
+			continue
+		}
+		statusResponse := &ocppmodels.OcppStatusResponse{}
+		json.Unmarshal(response.MessageBody, statusResponse)
+		if statusResponse.Status == "" {
+			statusResponse.Status = dbmodels.KeyStatus_Error
+		}
+		statuses = append(statuses, statusResponse.Status)
+	}
+	return statuses
+}
+
+func complianceStatus(compliance *dbmodels.DeviceCompliance) string {
+	status := dbmodels.ComplianceStatus_Compliant
+	for _, key := range compliance.Keys {
+		switch key.Status {
+		case dbmodels.KeyStatus_Compliant:
+		case dbmodels.KeyStatus_Changed, dbmodels.KeyStatus_RebootRequired:
+			status = dbmodels.ComplianceStatus_Remediated
+		default:
+			return dbmodels.ComplianceStatus_NonCompliant
+		}
+	}
+	for _, profileStatus := range compliance.ChargingProfiles {
+		if profileStatus != ocppmodels.GenericStatus_Accepted {
+			return dbmodels.ComplianceStatus_NonCompliant
+		}
+	}
+	return status
+}
+
+func templateKeys(template *dbmodels.DeviceTemplate) []string {
+	keys := make([]string, 0, len(template.Configuration))
+	for key := range template.Configuration {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Compares configuration values case insensitively, as OCPP booleans may be "true" or "True", and comma separated
+// lists such as MeterValuesSampledData ignoring spaces
+func configValuesEqual(a string, b string) bool {
+	aItems, bItems := strings.Split(a, ","), strings.Split(b, ",")
+	if len(aItems) != len(bItems) {
+		return false
+	}
+	for i := range aItems {
+		if !strings.EqualFold(strings.TrimSpace(aItems[i]), strings.TrimSpace(bItems[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func templateFromRequest(r *http.Request) (*dbmodels.DeviceTemplate, error) {
+	request := &TemplateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		return nil, err
+	}
+	if request.Name == "" || len(request.Name) > templateNameMaxLen {
+		return nil, fmt.Errorf("invalid name, must be 1-%d characters", templateNameMaxLen)
+	}
+	for key, value := range request.Configuration {
+		if key == "" || len(key) > configKeyMaxLen || len(value) > configValueMaxLen {
+			return nil, fmt.Errorf("invalid configuration key: %s", key)
+		}
+		if key == ocppmodels.ConfigKey_AuthorizationKey {
+			return nil, errors.New("AuthorizationKey is set with changepassword")
+		}
+	}
+	for i, profile := range request.ChargingProfiles {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(profile, &fields); err != nil || fields["connectorId"] == nil || fields["csChargingProfiles"] == nil {
+			return nil, fmt.Errorf("invalid charging profile %d, must be a SetChargingProfile payload", i)
+		}
+	}
+
+	template := &dbmodels.DeviceTemplate{Tenant: serviceState.Config.Services.DeviceManager.Tenant, Name: request.Name,
+		Configuration: request.Configuration, ChargingProfiles: request.ChargingProfiles, UpdatedAt: time.Now().UTC()}
+	if template.Configuration == nil {
+		template.Configuration = map[string]string{}
+	}
+	if template.ChargingProfiles == nil {
+		template.ChargingProfiles = []json.RawMessage{}
+	}
+	return template, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTemplatesRouter(t *testing.T) (http.Handler, *fakeMqBus) {
+	bus, _ := setupDeviceState(t)
+
+	return apiRouter(), bus
+}
+
+func TestTemplates(t *testing.T) {
+	router, _ := setupTemplatesRouter(t)
+
+	rec := serveDevices(router, http.MethodPost, "/templates", `{"name":"ac-22kw","configuration":{"HeartbeatInterval":"300"},
+		"chargingProfiles":[{"connectorId":0,"csChargingProfiles":{"chargingProfileId":1}}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var template dbmodels.DeviceTemplate
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &template))
+	assert.Equal(t, "300", template.Configuration["HeartbeatInterval"])
+	require.Len(t, template.ChargingProfiles, 1)
+
+	assert.Equal(t, http.StatusConflict, serveDevices(router, http.MethodPost, "/templates", `{"name":"ac-22kw"}`).Code)
+	for _, body := range []string{`{}`, `{"name":"ac","configuration":{"AuthorizationKey":"secret"}}`, `{"name":"ac","configuration":{"":"1"}}`,
+		`{"name":"ac","chargingProfiles":[{"connectorId":0}]}`, `not json`} {
+		assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodPost, "/templates", body).Code, body)
+	}
+
+	rec = serveDevices(router, http.MethodPut, "/templates/1", `{"name":"ac-22kw","configuration":{"HeartbeatInterval":"600"}}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = serveDevices(router, http.MethodGet, "/templates/1", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &template))
+	assert.Equal(t, "600", template.Configuration["HeartbeatInterval"])
+	assert.Empty(t, template.ChargingProfiles, "replaced")
+	assert.Equal(t, http.StatusNotFound, serveDevices(router, http.MethodPut, "/templates/2", `{"name":"ac-11kw"}`).Code)
+
+	_, err := serviceState.Devices.InsertDevice(context.Background(), &dbmodels.Device{NetworkId: "charger-1", DeviceTemplateId: 1})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, serveDevices(router, http.MethodDelete, "/templates/1", "").Code, "in use")
+	require.NoError(t, serviceState.Devices.DeleteDevice(context.Background(), "", "charger-1"))
+	assert.Equal(t, http.StatusNoContent, serveDevices(router, http.MethodDelete, "/templates/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveDevices(router, http.MethodGet, "/templates/1", "").Code)
+}
+
+func TestEnforceTemplateOnBoot(t *testing.T) {
+	router, bus := setupTemplatesRouter(t)
+	ctx := context.Background()
+	template := &dbmodels.DeviceTemplate{Name: "ac-22kw", Configuration: map[string]string{
+		"HeartbeatInterval":        "300",
+		"MeterValuesSampledData":   "Energy.Active.Import.Register,Voltage",
+		"NumberOfConnectors":       "2",
+		"WebSocketPingInterval":    "60",
+		"MeterValueSampleInterval": "60",
+	}, ChargingProfiles: []json.RawMessage{json.RawMessage(`{"connectorId":0,"csChargingProfiles":{"chargingProfileId":1}}`)}}
+	_, err := serviceState.Templates.InsertTemplate(ctx, template)
+	require.NoError(t, err)
+	_, err = serviceState.Devices.InsertDevice(ctx, &dbmodels.Device{NetworkId: "charger-1", DeviceTemplateId: template.Id})
+	require.NoError(t, err)
+
+	ProcessRecvMessage(chargerMessage(t, 2, "boot-1", "BootNotification", map[string]string{"chargePointVendor": "v", "chargePointModel": "m",
+		"firmwareVersion": "1.2.3"}), nil)
+
+	call := bus.next(t)
+	assert.Equal(t, "GetConfiguration", call.Body.MessageType)
+	assert.JSONEq(t, `{"key":["HeartbeatInterval","MeterValueSampleInterval","MeterValuesSampledData","NumberOfConnectors","WebSocketPingInterval"]}`,
+		string(call.Body.MessageBody))
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]any{
+		"configurationKey": []map[string]any{
+			{"key": "HeartbeatInterval", "value": "60"},
+			{"key": "MeterValuesSampledData", "value": "energy.active.import.register, Voltage"},
+			{"key": "NumberOfConnectors", "value": "1", "readonly": true},
+			{"key": "MeterValueSampleInterval", "value": "30"},
+		},
+		"unknownKey": []string{"WebSocketPingInterval"},
+	}), nil)
+
+	for _, status := range []string{"Accepted", "RebootRequired"} {
+		call = bus.next(t)
+		assert.Equal(t, "ChangeConfiguration", call.Body.MessageType)
+		ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": status}), nil)
+	}
+	call = bus.next(t)
+	assert.Equal(t, "SetChargingProfile", call.Body.MessageType)
+	assert.JSONEq(t, string(template.ChargingProfiles[0]), string(call.Body.MessageBody))
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", map[string]string{"status": "Accepted"}), nil)
+
+	var compliance *dbmodels.DeviceCompliance
+	require.Eventually(t, func() bool {
+		compliance, err = serviceState.Compliance.GetCompliance(ctx, "", "charger-1")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, dbmodels.ComplianceStatus_NonCompliant, compliance.Status)
+	statuses := map[string]string{}
+	for _, key := range compliance.Keys {
+		statuses[key.Key] = key.Status
+	}
+	assert.Equal(t, map[string]string{
+		"HeartbeatInterval":        dbmodels.KeyStatus_Changed,
+		"MeterValueSampleInterval": dbmodels.KeyStatus_RebootRequired,
+		"MeterValuesSampledData":   dbmodels.KeyStatus_Compliant,
+		"NumberOfConnectors":       dbmodels.KeyStatus_Readonly,
+		"WebSocketPingInterval":    dbmodels.KeyStatus_Unknown,
+	}, statuses)
+	assert.Equal(t, []string{"Accepted"}, compliance.ChargingProfiles)
+	device, err := serviceState.Devices.GetDevice(ctx, "", "charger-1")
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3", device.FirmwareVersion)
+
+	var listed []dbmodels.DeviceCompliance
+	rec := serveDevices(router, http.MethodGet, "/compliance?status=noncompliant", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, "charger-1", listed[0].NetworkId)
+	assert.Equal(t, http.StatusBadRequest, serveDevices(router, http.MethodGet, "/compliance?status=unknown", "").Code)
+}
+
+func TestEnforceTemplateError(t *testing.T) {
+	bus, _ := setupDeviceState(t)
+	ctx := context.Background()
+	template := &dbmodels.DeviceTemplate{Name: "ac-22kw", Configuration: map[string]string{"HeartbeatInterval": "300"}}
+	_, err := serviceState.Templates.InsertTemplate(ctx, template)
+	require.NoError(t, err)
+	device := &Device{NetworkId: "charger-1", Record: &dbmodels.Device{NetworkId: "charger-1", DeviceTemplateId: template.Id}}
+
+	done := make(chan struct{})
+	var compliance *dbmodels.DeviceCompliance
+	go func() {
+		compliance, err = enforceTemplate(ctx, device)
+		close(done)
+	}()
+	call := bus.next(t)
+	ProcessRecvMessage(chargerMessage(t, 3, call.Body.MsgId, "", "not a GetConfiguration response"), nil)
+	<-done
+
+	require.NoError(t, err)
+	assert.Equal(t, dbmodels.ComplianceStatus_Error, compliance.Status)
+	assert.NotEmpty(t, compliance.Error)
+	assert.Empty(t, bus.published, "nothing changed")
+}
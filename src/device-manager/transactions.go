@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	db "sw/ocpp/csms/internal/db"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+const maxListLimit = 1000
+
+// Lists transactions for the device, filtered by query parameters: status, connectorId, from, to, limit, offset
+func transactions_List(w http.ResponseWriter, r *http.Request) {
+	device := r.Context().Value("device").(*Device)
+
+	filter, err := transactionFilterFromQuery(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	filter.ClientId = device.NetworkId
+
+	transactions, err := serviceState.Transactions.ListTransactions(r.Context(), *filter)
+	if err != nil {
+		log.Errorf("Error listing transactions: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, transactions)
+}
+
+// Gets a single transaction for the device
+func transactions_Get(w http.ResponseWriter, r *http.Request) {
+	device := r.Context().Value("device").(*Device)
+
+	transactionId, err := strconv.ParseInt(chi.URLParam(r, "transactionid"), 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("invalid transactionid")))
+		return
+	}
+
+	transaction, err := serviceState.Transactions.GetTransaction(r.Context(), device.NetworkId, transactionId)
+	if errors.Is(err, db.ErrNotFound) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error getting transaction: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, transaction)
+}
+
+func transactionFilterFromQuery(r *http.Request) (*dbmodels.TransactionFilter, error) {
+	query := r.URL.Query()
+	filter := &dbmodels.TransactionFilter{}
+
+	if status := query.Get("status"); status != "" {
+		switch status {
+		case dbmodels.TransactionStatus_Active, dbmodels.TransactionStatus_Completed, dbmodels.TransactionStatus_Orphaned:
+			filter.Status = status
+		default:
+			return nil, fmt.Errorf("invalid status: %s", status)
+		}
+	}
+	if connectorId := query.Get("connectorId"); connectorId != "" {
+		id, err := strconv.Atoi(connectorId)
+		if err != nil {
+			return nil, fmt.Errorf("invalid connectorId: %s", connectorId)
+		}
+		filter.ConnectorId = &id
+	}
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %s", from)
+		}
+		filter.From = &t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to: %s", to)
+		}
+		filter.To = &t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxListLimit {
+			return nil, fmt.Errorf("invalid limit, must be 1-%d", maxListLimit)
+		}
+		filter.Limit = l
+	}
+	if offset := query.Get("offset"); offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil || o < 0 {
+			return nil, fmt.Errorf("invalid offset: %s", offset)
+		}
+		filter.Offset = o
+	}
+	return filter, nil
+}
+
+// Parses the limit and offset query parameters, zero if they're not set
+func pageFromQuery(r *http.Request) (limit int, offset int, err error) {
+	query := r.URL.Query()
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return 0, 0, fmt.Errorf("invalid limit, must be 1-%d", maxListLimit)
+		}
+	}
+	if o := query.Get("offset"); o != "" {
+		offset, err = strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset: %s", o)
+		}
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTransactionsRouter(t *testing.T) (http.Handler, *memdb.TransactionRepository) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+
+	transactions := memdb.NewTransactionRepository()
+	serviceState = &ServiceState{Config: &conf.Configuration{}, Devices: memdb.NewDeviceRepository(), Transactions: transactions}
+	t.Cleanup(func() { serviceState = nil })
+	for _, networkId := range []string{"charger-1", "charger-2"} {
+		_, err := serviceState.Devices.InsertDevice(context.Background(), &dbmodels.Device{NetworkId: networkId})
+		require.NoError(t, err)
+	}
+
+	return apiRouter(), transactions
+}
+
+func TestTransactionsList(t *testing.T) {
+	router, transactions := setupTransactionsRouter(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	_, err := transactions.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-1", ConnectorId: 1, TimeStarted: now, TimeReceived: now})
+	require.NoError(t, err)
+	id, err := transactions.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-1", ConnectorId: 2, TimeStarted: now, TimeReceived: now})
+	require.NoError(t, err)
+	_, err = transactions.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-2", ConnectorId: 1, TimeStarted: now, TimeReceived: now})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transactions/charger-1?connectorId=2", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var result []dbmodels.Transaction
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Len(t, result, 1)
+	assert.Equal(t, id, result[0].Id)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transactions/charger-1?status=unknown", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transactions/charger-3", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "not a device")
+}
+
+func TestTransactionsGet(t *testing.T) {
+	router, transactions := setupTransactionsRouter(t)
+
+	now := time.Now()
+	id, err := transactions.InsertNextTransaction(context.Background(), &dbmodels.Transaction{ClientId: "charger-1", ConnectorId: 1, TimeStarted: now, TimeReceived: now})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transactions/charger-1/1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var result dbmodels.Transaction
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, id, result.Id)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transactions/charger-2/1", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,51 @@
+// Provides the admin listener each service can run, separate from its own API, serving operational endpoints
+package admin
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	conf "sw/ocpp/csms/internal/config"
+	httplistener "sw/ocpp/csms/internal/http"
+	log "sw/ocpp/csms/internal/logging"
+	"sw/ocpp/csms/internal/metrics"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Server struct {
+	Router chi.Router
+	config conf.AdminConfig
+
+	checksMu  sync.Mutex
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+// Creates an admin server serving /metrics, /loglevel, /healthz and /readyz. Services can add their own routes
+// to Router, and their health checks, before Start
+func NewServer(config conf.AdminConfig) *Server {
+	router := chi.NewRouter()
+	s := &Server{Router: router, config: config}
+	router.Handle("/metrics", metrics.Handler())
+	router.Get("/healthz", s.healthz)
+	router.Get("/readyz", s.readyz)
+	router.Route("/loglevel", func(r chi.Router) {
+		r.Get("/", logLevel_Get)
+		r.Put("/", logLevel_Set)
+		r.Put("/{networkid}", logLevel_SetCharger)
+		r.Delete("/{networkid}", logLevel_ClearCharger)
+	})
+	return s
+}
+
+func (s *Server) Start() (io.Closer, error) {
+	listenNetPort := fmt.Sprintf("%s:%d", s.config.ListenAddress, s.config.ListenPort)
+	log.Logger.Info("Admin listening on: ", listenNetPort)
+	listener, err := httplistener.ListenAndServeWithClose(listenNetPort, s.Router)
+	if err != nil {
+		return nil, err
+	}
+	return listener, nil
+}
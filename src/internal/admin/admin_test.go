@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/logging"
+	"sw/ocpp/csms/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	metrics.CountOcppMessage("Heartbeat", metrics.Direction_In)
+
+	server := httptest.NewServer(NewServer(conf.AdminConfig{}).Router)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `csms_ocpp_messages_total{action="Heartbeat",direction="in"}`)
+	assert.Contains(t, string(body), "go_goroutines")
+}
+
+func TestLogLevelEndpoints(t *testing.T) {
+	logging.Logger = logrus.New()
+	logging.Logger.SetOutput(io.Discard)
+	defer func() { logging.Logger = nil }()
+
+	server := httptest.NewServer(NewServer(conf.AdminConfig{}).Router)
+	defer server.Close()
+
+	send := func(method string, path string, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, "/loglevel", `{"level":"warn"}`).StatusCode)
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, "/loglevel/charger-1", `{"level":"debug"}`).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPut, "/loglevel/charger-2", `{"level":"loud"}`).StatusCode)
+
+	res, err := http.Get(server.URL + "/loglevel")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	var levels logging.Levels
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&levels))
+	assert.Equal(t, logging.Levels{Level: "warning", Chargers: map[string]string{"charger-1": "debug"}}, levels)
+	assert.True(t, logging.ForCharger("charger-1").Logger.IsLevelEnabled(logrus.DebugLevel))
+	assert.False(t, logging.ForCharger("charger-2").Logger.IsLevelEnabled(logrus.DebugLevel))
+
+	assert.Equal(t, http.StatusNoContent, send(http.MethodDelete, "/loglevel/charger-1", "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodDelete, "/loglevel/charger-1", "").StatusCode)
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	s := NewServer(conf.AdminConfig{})
+	s.AddLivenessCheck("listener", func(ctx context.Context) error { return nil })
+	s.AddReadinessCheck("mq", func(ctx context.Context) error { return errors.New("connection closed") })
+	server := httptest.NewServer(s.Router)
+	defer server.Close()
+
+	get := func(path string) (int, HealthResponse) {
+		res, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var health HealthResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&health))
+		return res.StatusCode, health
+	}
+
+	code, health := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Status_Ok, health.Status)
+	assert.Equal(t, Status_Ok, health.Checks["listener"].Status)
+	assert.NotContains(t, health.Checks, "mq")
+
+	code, health = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, Status_Fail, health.Status)
+	assert.Equal(t, Status_Ok, health.Checks["listener"].Status)
+	assert.Equal(t, CheckResult{Status: Status_Fail, Error: "connection closed", DurationMs: health.Checks["mq"].DurationMs}, health.Checks["mq"])
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+const (
+	checkTimeout = 5 * time.Second
+
+	Status_Ok   = "ok"
+	Status_Fail = "fail"
+)
+
+// Checks a dependency, returning an error if it's unavailable
+type CheckFunc func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check CheckFunc
+}
+
+type CheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Adds a check run by /healthz and /readyz, failing it should restart the service, e.g a listener which has stopped
+func (s *Server) AddLivenessCheck(name string, check CheckFunc) {
+	s.checksMu.Lock()
+	defer s.checksMu.Unlock()
+	s.liveness = append(s.liveness, namedCheck{name: name, check: check})
+}
+
+// Adds a check run by /readyz, failing it should stop traffic to the service, e.g the MQ or DB is unavailable
+func (s *Server) AddReadinessCheck(name string, check CheckFunc) {
+	s.checksMu.Lock()
+	defer s.checksMu.Unlock()
+	s.readiness = append(s.readiness, namedCheck{name: name, check: check})
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	s.checksMu.Lock()
+	checks := append([]namedCheck{}, s.liveness...)
+	s.checksMu.Unlock()
+	writeHealth(w, r, runChecks(r.Context(), checks))
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	s.checksMu.Lock()
+	checks := append(append([]namedCheck{}, s.liveness...), s.readiness...)
+	s.checksMu.Unlock()
+	writeHealth(w, r, runChecks(r.Context(), checks))
+}
+
+// Runs the checks concurrently, each with a timeout
+func runChecks(ctx context.Context, checks []namedCheck) *HealthResponse {
+	response := &HealthResponse{Status: Status_Ok, Checks: map[string]CheckResult{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check namedCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.check(checkCtx)
+			result := CheckResult{Status: Status_Ok, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = Status_Fail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			response.Checks[check.name] = result
+			if err != nil {
+				response.Status = Status_Fail
+			}
+		}(check)
+	}
+	wg.Wait()
+	return response
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, response *HealthResponse) {
+	if response.Status != Status_Ok {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, response)
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"sw/ocpp/csms/internal/logging"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/sirupsen/logrus"
+)
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+func logLevel_Get(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, logging.GetLevels())
+}
+
+// Sets the global log level, e.g {"level":"debug"}
+func logLevel_Set(w http.ResponseWriter, r *http.Request) {
+	level, ok := levelFromBody(w, r)
+	if !ok {
+		return
+	}
+	logging.SetLevel(level)
+	render.JSON(w, r, logging.GetLevels())
+}
+
+// Overrides the log level of a single charger's lines
+func logLevel_SetCharger(w http.ResponseWriter, r *http.Request) {
+	level, ok := levelFromBody(w, r)
+	if !ok {
+		return
+	}
+	logging.SetChargerLevel(chi.URLParam(r, "networkid"), level)
+	render.JSON(w, r, logging.GetLevels())
+}
+
+func logLevel_ClearCharger(w http.ResponseWriter, r *http.Request) {
+	if !logging.ClearChargerLevel(chi.URLParam(r, "networkid")) {
+		http.Error(w, "no log level set for charger", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func levelFromBody(w http.ResponseWriter, r *http.Request) (logrus.Level, bool) {
+	var request logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	level, err := logrus.ParseLevel(request.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	return level, true
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db"
+	"sw/ocpp/csms/internal/logging"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	Backend_Redis  = "redis"
+	Backend_Sql    = "sql"
+	Backend_Static = "static"
+	Backend_Http   = "http"
+)
+
+// Checks chargers' Basic auth passwords, implemented by the redis, sql, static and http backends
+type Authenticator interface {
+	// Returns false if the charger is unknown or disabled or the password is wrong, or an error if it couldn't be checked
+	Authenticate(ctx context.Context, networkId string, password string) (bool, error)
+}
+
+// Called when a charger authenticates with its pending password, so device-manager can make it the current one
+type PendingPasswordFunc func(networkId string)
+
+// Checks chargers authenticated by client certificate, which have no password to check, are known and enabled
+type DeviceChecker interface {
+	// Returns false if the charger is unknown or disabled, or an error if it couldn't be checked
+	DeviceAllowed(ctx context.Context, networkId string) (bool, error)
+}
+
+// The static backend's chargers if it's configured, otherwise the devices table if devices is set, or nil.
+// The redis and http backends only hold passwords, so can't say whether a charger without one is known
+func NewDeviceChecker(config conf.AuthConfig, devices db.DeviceRepository) (DeviceChecker, error) {
+	if config.Backend == Backend_Static {
+		static, err := NewStaticAuthenticator(config.Static.Chargers)
+		if err != nil {
+			return nil, err
+		}
+		return static, nil
+	}
+	if devices != nil {
+		return NewSqlAuthenticator(devices, config.Sql.Tenant, nil), nil
+	}
+	return nil, nil
+}
+
+// Creates the configured backend, wrapped in a CachingAuthenticator if cache_ttl_secs is set.
+// cache and devices are only needed by the redis and sql backends, which call pendingUsed if it's set
+func NewAuthenticator(config conf.AuthConfig, cache *redis.Client, devices db.DeviceRepository, pendingUsed PendingPasswordFunc) (Authenticator, error) {
+	var authenticator Authenticator
+	switch config.Backend {
+	case Backend_Redis, "":
+		if cache == nil {
+			return nil, errors.New("redis auth backend needs csms_server.cache")
+		}
+		authenticator = NewRedisAuthenticator(cache, pendingUsed)
+	case Backend_Sql:
+		if devices == nil {
+			return nil, errors.New("sql auth backend needs db_config")
+		}
+		authenticator = NewSqlAuthenticator(devices, config.Sql.Tenant, pendingUsed)
+	case Backend_Static:
+		static, err := NewStaticAuthenticator(config.Static.Chargers)
+		if err != nil {
+			return nil, err
+		}
+		authenticator = static
+	case Backend_Http:
+		httpAuth, err := NewHttpAuthenticator(config.Http.Url, config.Http.Authorization, time.Duration(config.Http.TimeoutSecs)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		authenticator = httpAuth
+	default:
+		return nil, fmt.Errorf("unknown auth backend: %s", config.Backend)
+	}
+
+	if config.CacheTtlSecs > 0 {
+		authenticator = NewCachingAuthenticator(authenticator, time.Duration(config.CacheTtlSecs)*time.Second,
+			time.Duration(config.NegativeCacheTtlSecs)*time.Second)
+	}
+	return authenticator, nil
+}
+
+// Verifies a password against a backend's hash, treating an invalid hash as a wrong password
+func verifyHash(networkId string, password string, hash string) bool {
+	valid, err := VerifyPassword(password, hash)
+	if err != nil {
+		logging.ForCharger(networkId).Errorf("Auth record invalid: %s", err.Error())
+		return false
+	}
+	return valid
+}
+
+// Verifies a password against a charger's hash, then its pending hash, calling pendingUsed if only the pending one
+// matches. Either hash may be empty
+func verifyHashes(networkId string, password string, hash string, pendingHash string, pendingUsed PendingPasswordFunc) bool {
+	if hash != "" && verifyHash(networkId, password, hash) {
+		return true
+	}
+	if pendingHash == "" || !verifyHash(networkId, password, pendingHash) {
+		return false
+	}
+	logging.ForCharger(networkId).Info("Authenticated with pending password")
+	if pendingUsed != nil {
+		pendingUsed(networkId)
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPassword = "0123456789abcdef"
+
+// Counts calls, returning allowed or err
+type countingAuthenticator struct {
+	calls   int
+	allowed bool
+	err     error
+}
+
+func (a *countingAuthenticator) Authenticate(ctx context.Context, networkId string, password string) (bool, error) {
+	a.calls++
+	return a.allowed && password == testPassword, a.err
+}
+
+func TestCachingAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	next := &countingAuthenticator{allowed: true}
+	cache := NewCachingAuthenticator(next, time.Minute, 10*time.Second)
+	cache.now = func() time.Time { return now }
+
+	allowed, err := cache.Authenticate(ctx, "charger-1", testPassword)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, _ = cache.Authenticate(ctx, "charger-1", testPassword)
+	assert.True(t, allowed)
+	assert.Equal(t, 1, next.calls, "allowed decision cached")
+
+	allowed, _ = cache.Authenticate(ctx, "charger-1", "wrong-password-123")
+	assert.False(t, allowed, "another password isn't a cache hit")
+	allowed, _ = cache.Authenticate(ctx, "charger-1", "wrong-password-123")
+	assert.False(t, allowed)
+	assert.Equal(t, 2, next.calls, "denied decision cached")
+
+	now = now.Add(11 * time.Second)
+	cache.Authenticate(ctx, "charger-1", "wrong-password-123")
+	assert.Equal(t, 3, next.calls, "denied decision expired")
+
+	cache.Authenticate(ctx, "charger-1", testPassword)
+	cache.Invalidate("charger-1")
+	cache.Authenticate(ctx, "charger-1", testPassword)
+	assert.Equal(t, 5, next.calls, "invalidated")
+
+	now = now.Add(time.Minute)
+	cache.Authenticate(ctx, "charger-1", testPassword)
+	assert.Equal(t, 6, next.calls, "allowed decision expired")
+}
+
+// Invalidates the cache while the backend is checking, as if the auth record changed mid-request
+type invalidatingAuthenticator struct {
+	countingAuthenticator
+	cache *CachingAuthenticator
+}
+
+func (a *invalidatingAuthenticator) Authenticate(ctx context.Context, networkId string, password string) (bool, error) {
+	a.cache.Invalidate(networkId)
+	return a.countingAuthenticator.Authenticate(ctx, networkId, password)
+}
+
+func TestCachingAuthenticatorInvalidatedDuringCheck(t *testing.T) {
+	next := &invalidatingAuthenticator{countingAuthenticator: countingAuthenticator{allowed: true}}
+	cache := NewCachingAuthenticator(next, time.Minute, time.Minute)
+	next.cache = cache
+
+	allowed, err := cache.Authenticate(context.Background(), "charger-1", testPassword)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	cache.Authenticate(context.Background(), "charger-1", testPassword)
+	assert.Equal(t, 2, next.calls, "decision from the old record not cached")
+}
+
+func TestCachingAuthenticatorErrors(t *testing.T) {
+	next := &countingAuthenticator{err: errors.New("backend down")}
+	cache := NewCachingAuthenticator(next, time.Minute, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.Authenticate(context.Background(), "charger-1", testPassword)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 2, next.calls, "errors aren't cached")
+}
+
+func TestCachingAuthenticatorNoNegativeTtl(t *testing.T) {
+	next := &countingAuthenticator{}
+	cache := NewCachingAuthenticator(next, time.Minute, 0)
+
+	cache.Authenticate(context.Background(), "charger-1", testPassword)
+	cache.Authenticate(context.Background(), "charger-1", testPassword)
+	assert.Equal(t, 2, next.calls)
+}
+
+func TestSqlAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	hash, err := HashPassword(testPassword)
+	require.NoError(t, err)
+	devices := memdb.NewDeviceRepository()
+	devices.InsertDevice(ctx, &dbmodels.Device{Tenant: "t1", NetworkId: "charger-1", PasswordHash: hash})
+	devices.InsertDevice(ctx, &dbmodels.Device{Tenant: "t1", NetworkId: "charger-2", PasswordHash: hash, Disabled: true})
+	devices.InsertDevice(ctx, &dbmodels.Device{Tenant: "t1", NetworkId: "charger-3"})
+	devices.InsertDevice(ctx, &dbmodels.Device{Tenant: "t2", NetworkId: "charger-4", PasswordHash: hash})
+	pendingHash, err := HashPassword("pending-password-1")
+	require.NoError(t, err)
+	devices.InsertDevice(ctx, &dbmodels.Device{Tenant: "t1", NetworkId: "charger-6", PasswordHash: hash, PendingPasswordHash: pendingHash})
+	devices.InsertDevice(ctx, &dbmodels.Device{Tenant: "t1", NetworkId: "charger-7", PendingPasswordHash: pendingHash})
+	pendingUsed := []string{}
+	authenticator := NewSqlAuthenticator(devices, "t1", func(networkId string) { pendingUsed = append(pendingUsed, networkId) })
+
+	tests := []struct {
+		networkId string
+		password  string
+		allowed   bool
+	}{
+		{"charger-1", testPassword, true},
+		{"charger-1", "wrong-password-123", false},
+		{"charger-2", testPassword, false},
+		{"charger-3", testPassword, false},
+		{"charger-4", testPassword, false},
+		{"charger-5", testPassword, false},
+		{"charger-6", testPassword, true},
+		{"charger-6", "pending-password-1", true},
+		{"charger-6", "wrong-password-123", false},
+		{"charger-7", "pending-password-1", true},
+	}
+	for _, test := range tests {
+		allowed, err := authenticator.Authenticate(ctx, test.networkId, test.password)
+		require.NoError(t, err)
+		assert.Equal(t, test.allowed, allowed, test.networkId)
+	}
+	assert.Equal(t, []string{"charger-6", "charger-7"}, pendingUsed)
+
+	for networkId, allowed := range map[string]bool{"charger-1": true, "charger-2": false, "charger-3": true, "charger-4": false, "charger-5": false} {
+		deviceAllowed, err := authenticator.DeviceAllowed(ctx, networkId)
+		require.NoError(t, err)
+		assert.Equal(t, allowed, deviceAllowed, networkId)
+	}
+}
+
+func TestStaticAuthenticator(t *testing.T) {
+	hash, err := HashPassword(testPassword)
+	require.NoError(t, err)
+	authenticator, err := NewStaticAuthenticator([]conf.StaticCharger{{NetworkId: "charger-1", PasswordHash: hash}})
+	require.NoError(t, err)
+
+	allowed, err := authenticator.Authenticate(context.Background(), "charger-1", testPassword)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, _ = authenticator.Authenticate(context.Background(), "charger-1", "wrong-password-123")
+	assert.False(t, allowed)
+	allowed, _ = authenticator.Authenticate(context.Background(), "charger-2", testPassword)
+	assert.False(t, allowed)
+	allowed, _ = authenticator.DeviceAllowed(context.Background(), "charger-1")
+	assert.True(t, allowed)
+	allowed, _ = authenticator.DeviceAllowed(context.Background(), "charger-2")
+	assert.False(t, allowed)
+
+	_, err = NewStaticAuthenticator([]conf.StaticCharger{{NetworkId: "charger-1", PasswordHash: hash}, {NetworkId: "charger-1", PasswordHash: hash}})
+	assert.Error(t, err)
+	_, err = NewStaticAuthenticator([]conf.StaticCharger{{NetworkId: "charger-1"}})
+	assert.Error(t, err)
+}
+
+func TestHttpAuthenticator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		var request httpAuthRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		switch {
+		case request.NetworkId == "charger-down":
+			w.WriteHeader(http.StatusBadGateway)
+		case request.NetworkId == "charger-1" && request.Password == testPassword:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	authenticator, err := NewHttpAuthenticator(server.URL, "Bearer token", time.Second)
+	require.NoError(t, err)
+
+	allowed, err := authenticator.Authenticate(context.Background(), "charger-1", testPassword)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = authenticator.Authenticate(context.Background(), "charger-1", "wrong-password-123")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	_, err = authenticator.Authenticate(context.Background(), "charger-down", testPassword)
+	assert.Error(t, err)
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	_, err := NewAuthenticator(conf.AuthConfig{}, nil, nil, nil)
+	assert.Error(t, err, "redis backend without a cache")
+	_, err = NewAuthenticator(conf.AuthConfig{Backend: Backend_Sql}, nil, nil, nil)
+	assert.Error(t, err, "sql backend without a DB")
+	_, err = NewAuthenticator(conf.AuthConfig{Backend: "ldap"}, nil, nil, nil)
+	assert.Error(t, err)
+
+	authenticator, err := NewAuthenticator(conf.AuthConfig{Backend: Backend_Static, CacheTtlSecs: 60}, nil, nil, nil)
+	require.NoError(t, err)
+	assert.IsType(t, &CachingAuthenticator{}, authenticator)
+}
+
+func TestNewDeviceChecker(t *testing.T) {
+	checker, err := NewDeviceChecker(conf.AuthConfig{Backend: Backend_Static}, memdb.NewDeviceRepository())
+	require.NoError(t, err)
+	assert.IsType(t, &StaticAuthenticator{}, checker)
+	checker, err = NewDeviceChecker(conf.AuthConfig{Backend: Backend_Redis}, memdb.NewDeviceRepository())
+	require.NoError(t, err)
+	assert.IsType(t, &SqlAuthenticator{}, checker, "devices table, whatever the password backend")
+	checker, err = NewDeviceChecker(conf.AuthConfig{Backend: Backend_Http}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, checker)
+}
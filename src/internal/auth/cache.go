@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"sync"
+	"time"
+)
+
+// Caches an Authenticator's decisions per charger, for ttl if allowed or negativeTtl if denied.
+// Errors aren't cached. Only a salted digest of the password is kept, to match later attempts against.
+type CachingAuthenticator struct {
+	next        Authenticator
+	ttl         time.Duration
+	negativeTtl time.Duration
+	salt        []byte
+
+	mu        sync.Mutex
+	decisions map[string]cachedDecision // by networkId
+	// Bumped by Invalidate for any charger, so it doesn't grow with the chargers invalidated. An invalidation
+	// only stops decisions made meanwhile being cached
+	generation uint64
+	lastSweep  time.Time
+	now        func() time.Time
+}
+
+type cachedDecision struct {
+	digest  [sha256.Size]byte
+	allowed bool
+	expires time.Time
+}
+
+// Creates a cache in front of next. Denials aren't cached if negativeTtl is 0
+func NewCachingAuthenticator(next Authenticator, ttl time.Duration, negativeTtl time.Duration) *CachingAuthenticator {
+	salt := make([]byte, saltLength)
+	rand.Read(salt)
+	return &CachingAuthenticator{next: next, ttl: ttl, negativeTtl: negativeTtl, salt: salt,
+		decisions: map[string]cachedDecision{}, now: time.Now}
+}
+
+func (c *CachingAuthenticator) Authenticate(ctx context.Context, networkId string, password string) (bool, error) {
+	digest := c.digest(password)
+	now := c.now()
+
+	c.mu.Lock()
+	decision, ok := c.decisions[networkId]
+	generation := c.generation
+	c.mu.Unlock()
+	if ok && now.Before(decision.expires) && subtle.ConstantTimeCompare(decision.digest[:], digest[:]) == 1 {
+		return decision.allowed, nil
+	}
+
+	allowed, err := c.next.Authenticate(ctx, networkId, password)
+	if err != nil {
+		return false, err
+	}
+	ttl := c.ttl
+	if !allowed {
+		ttl = c.negativeTtl
+	}
+	if ttl > 0 {
+		c.store(networkId, generation, cachedDecision{digest: digest, allowed: allowed, expires: now.Add(ttl)}, now)
+	}
+	return allowed, nil
+}
+
+// Removes a charger's cached decision, after its auth record changes. Decisions the backend is still making
+// may have read the old record, so aren't cached
+func (c *CachingAuthenticator) Invalidate(networkId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.decisions, networkId)
+	c.generation++
+}
+
+// Stores a decision, unless a charger was invalidated since generation was read. Removes expired ones
+// at most once per ttl
+func (c *CachingAuthenticator) store(networkId string, generation uint64, decision cachedDecision, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return
+	}
+
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for k, d := range c.decisions {
+			if !now.Before(d.expires) {
+				delete(c.decisions, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.decisions[networkId] = decision
+}
+
+func (c *CachingAuthenticator) digest(password string) [sha256.Size]byte {
+	return sha256.Sum256(append(append([]byte{}, c.salt...), password...))
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultHttpTimeout = 10 * time.Second
+
+// Checks passwords with an external identity service, POSTing {"networkId": "...", "password": "..."}.
+// 200 allows the charger, 401, 403 and 404 deny it, other responses are errors
+type HttpAuthenticator struct {
+	url           string
+	authorization string
+	client        *http.Client
+}
+
+// Creates an identity service client. authorization is sent as the Authorization header if set
+func NewHttpAuthenticator(url string, authorization string, timeout time.Duration) (*HttpAuthenticator, error) {
+	if url == "" {
+		return nil, errors.New("http auth backend needs url")
+	}
+	if timeout <= 0 {
+		timeout = defaultHttpTimeout
+	}
+	return &HttpAuthenticator{url: url, authorization: authorization, client: &http.Client{Timeout: timeout}}, nil
+}
+
+type httpAuthRequest struct {
+	NetworkId string `json:"networkId"`
+	Password  string `json:"password"`
+}
+
+func (a *HttpAuthenticator) Authenticate(ctx context.Context, networkId string, password string) (bool, error) {
+	body, err := json.Marshal(httpAuthRequest{NetworkId: networkId, Password: password})
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.authorization != "" {
+		req.Header.Set("Authorization", a.authorization)
+	}
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("identity service responded %d", res.StatusCode)
+	}
+}
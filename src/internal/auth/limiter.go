@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits failed authentication attempts per key, e.g client IP, within a fixed window
+type FailureLimiter struct {
+	maxFailures int
+	window      time.Duration
+
+	mu        sync.Mutex
+	failures  map[string]*failureWindow
+	lastSweep time.Time
+}
+
+type failureWindow struct {
+	start time.Time
+	count int
+}
+
+// Creates a limiter allowing maxFailures per window, or unlimited if maxFailures is 0
+func NewFailureLimiter(maxFailures int, window time.Duration) *FailureLimiter {
+	return &FailureLimiter{maxFailures: maxFailures, window: window, failures: map[string]*failureWindow{}}
+}
+
+// Returns false if the key has reached the failure limit in the current window
+func (l *FailureLimiter) Allowed(key string, now time.Time) bool {
+	if l.maxFailures <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	failures, ok := l.failures[key]
+	if !ok || now.Sub(failures.start) >= l.window {
+		return true
+	}
+	return failures.count < l.maxFailures
+}
+
+// Records a failed attempt, removing expired windows at most once per window
+func (l *FailureLimiter) Fail(key string, now time.Time) {
+	if l.maxFailures <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= l.window {
+		for k, failures := range l.failures {
+			if now.Sub(failures.start) >= l.window {
+				delete(l.failures, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	failures, ok := l.failures[key]
+	if !ok || now.Sub(failures.start) >= l.window {
+		failures = &failureWindow{start: now}
+		l.failures[key] = failures
+	}
+	failures.count++
+}
+
+// Clears a key's failures, after it authenticates
+func (l *FailureLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
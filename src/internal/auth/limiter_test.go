@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureLimiter(t *testing.T) {
+	limiter := NewFailureLimiter(2, time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	limiter.Fail("10.0.0.1", now)
+	assert.True(t, limiter.Allowed("10.0.0.1", now))
+	limiter.Fail("10.0.0.1", now.Add(time.Second))
+	assert.False(t, limiter.Allowed("10.0.0.1", now.Add(2*time.Second)))
+	assert.True(t, limiter.Allowed("10.0.0.2", now.Add(2*time.Second)), "limited per key")
+
+	assert.True(t, limiter.Allowed("10.0.0.1", now.Add(time.Minute)), "window expired")
+	limiter.Fail("10.0.0.1", now.Add(time.Minute))
+	assert.True(t, limiter.Allowed("10.0.0.1", now.Add(time.Minute)), "new window")
+
+	limiter.Fail("10.0.0.1", now.Add(time.Minute))
+	limiter.Reset("10.0.0.1")
+	assert.True(t, limiter.Allowed("10.0.0.1", now.Add(time.Minute)))
+}
+
+func TestFailureLimiterUnlimited(t *testing.T) {
+	limiter := NewFailureLimiter(0, time.Minute)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		limiter.Fail("10.0.0.1", now)
+	}
+	assert.True(t, limiter.Allowed("10.0.0.1", now))
+}
@@ -0,0 +1,108 @@
+// Provides charge point authentication: password hashing, failed attempt limiting and the auth backends checking passwords
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	hashScheme     = "pbkdf2-sha256"
+	hashIterations = 100000
+	saltLength     = 16
+	keyLength      = 32
+
+	// OCPP 1.6 security profile 1 password length limits
+	PasswordMinLength = 16
+	PasswordMaxLength = 40
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Hashes a password with a random salt, encoded as pbkdf2-sha256$<iterations>$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, hashIterations, keyLength)
+	if err != nil {
+		return "", err
+	}
+	encoding := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, hashIterations, encoding.EncodeToString(salt), encoding.EncodeToString(key)), nil
+}
+
+// Checks a password against a hash from HashPassword, in constant time
+func VerifyPassword(password string, encodedHash string) (bool, error) {
+	iterations, salt, key, err := decodeHash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	actual, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+// Whether value is a hash from HashPassword, rather than e.g a plaintext password
+func IsPasswordHash(value string) bool {
+	_, _, _, err := decodeHash(value)
+	return err == nil
+}
+
+// A charger's auth record: its password hash, followed by the hash of its pending password if it has one,
+// e.g as the value of its redis CP_ key. Either may be empty
+func AuthRecord(hash string, pendingHash string) string {
+	if pendingHash == "" {
+		return hash
+	}
+	return hash + " " + pendingHash
+}
+
+// The password hash and pending password hash of an auth record from AuthRecord
+func SplitAuthRecord(record string) (hash string, pendingHash string) {
+	hash, pendingHash, _ = strings.Cut(record, " ")
+	return hash, pendingHash
+}
+
+// Checks a password meets the OCPP 1.6 security profile 1 rules
+func ValidatePassword(password string) error {
+	if len(password) < PasswordMinLength || len(password) > PasswordMaxLength {
+		return fmt.Errorf("password must be %d to %d characters", PasswordMinLength, PasswordMaxLength)
+	}
+	for _, c := range password {
+		if c < 0x21 || c > 0x7e {
+			return errors.New("password must be printable ASCII, without spaces")
+		}
+	}
+	return nil
+}
+
+func decodeHash(encodedHash string) (int, []byte, []byte, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return 0, nil, nil, ErrInvalidHash
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return 0, nil, nil, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, ErrInvalidHash
+	}
+	return iterations, salt, key, nil
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("0123456789abcdef")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "pbkdf2-sha256$100000$"))
+
+	other, err := HashPassword("0123456789abcdef")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "salted")
+
+	ok, err := VerifyPassword("0123456789abcdef", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyPassword("0123456789abcdeX", hash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestVerifyPasswordInvalidHash(t *testing.T) {
+	for _, hash := range []string{"", "secret", "bcrypt$1$c2FsdA$a2V5", "pbkdf2-sha256$x$c2FsdA$a2V5", "pbkdf2-sha256$1$c2FsdA$"} {
+		_, err := VerifyPassword("0123456789abcdef", hash)
+		assert.ErrorIs(t, err, ErrInvalidHash, hash)
+	}
+}
+
+type mapAuthRecords map[string]string
+
+func (m mapAuthRecords) SetPasswordHash(networkId string, record string) error {
+	m[networkId] = record
+	return nil
+}
+
+func (m mapAuthRecords) DeletePasswordHash(networkId string) error {
+	delete(m, networkId)
+	return nil
+}
+
+func (m mapAuthRecords) ListPasswordHashes() (map[string]string, error) {
+	return maps.Clone(m), nil
+}
+
+func TestHashPlaintextRecords(t *testing.T) {
+	hash, err := HashPassword("0123456789abcdef")
+	require.NoError(t, err)
+	records := mapAuthRecords{"charger-1": "legacy password", "charger-2": hash, "charger-3": AuthRecord(hash, hash)}
+
+	hashed, err := HashPlaintextRecords(records)
+	require.NoError(t, err)
+	assert.Equal(t, 1, hashed)
+	valid, err := VerifyPassword("legacy password", records["charger-1"])
+	require.NoError(t, err)
+	assert.True(t, valid)
+	assert.Equal(t, hash, records["charger-2"], "already hashed")
+	assert.Equal(t, AuthRecord(hash, hash), records["charger-3"])
+
+	hashed, err = HashPlaintextRecords(records)
+	require.NoError(t, err)
+	assert.Zero(t, hashed, "idempotent")
+}
+
+func TestAuthRecord(t *testing.T) {
+	assert.Equal(t, "hash", AuthRecord("hash", ""))
+	hash, pendingHash := SplitAuthRecord(AuthRecord("hash", "pending"))
+	assert.Equal(t, "hash", hash)
+	assert.Equal(t, "pending", pendingHash)
+	hash, pendingHash = SplitAuthRecord(AuthRecord("", "pending"))
+	assert.Empty(t, hash)
+	assert.Equal(t, "pending", pendingHash)
+}
+
+func TestValidatePassword(t *testing.T) {
+	assert.NoError(t, ValidatePassword("0123456789abcdef"))
+	assert.NoError(t, ValidatePassword(strings.Repeat("a", 40)))
+	assert.Error(t, ValidatePassword("short"))
+	assert.Error(t, ValidatePassword(strings.Repeat("a", 41)))
+	assert.Error(t, ValidatePassword("0123456789 abcdef"))
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/go-redis/redis"
+)
+
+// Redis key prefix of a charge point's auth record, holding its password hash and any pending one, see AuthRecord
+const KeyPrefix_ChargePoint = "CP_"
+
+const scanCount = 1000
+
+var ErrUnknownChargePoint = errors.New("no auth record for charge point")
+
+func ChargePointKey(networkId string) string {
+	return KeyPrefix_ChargePoint + networkId
+}
+
+// Gets a charge point's auth record, returning ErrUnknownChargePoint if it has none
+func GetPasswordHash(client *redis.Client, networkId string) (string, error) {
+	hash, err := client.Get(ChargePointKey(networkId)).Result()
+	if err == redis.Nil {
+		return "", ErrUnknownChargePoint
+	}
+	return hash, err
+}
+
+// The charge points' auth records csms-server checks, written through to by device-manager
+type AuthRecordStore interface {
+	// Sets a charge point's auth record, from AuthRecord, creating it if needed
+	SetPasswordHash(networkId string, record string) error
+	// Removes a charge point's record, so it can't authenticate. Unknown charge points are ignored
+	DeletePasswordHash(networkId string) error
+	// Gets all charge points' auth records, by networkId
+	ListPasswordHashes() (map[string]string, error)
+}
+
+// The CP_<networkId> redis keys, as an AuthRecordStore
+type RedisAuthRecords struct {
+	client *redis.Client
+}
+
+func NewRedisAuthRecords(client *redis.Client) *RedisAuthRecords {
+	return &RedisAuthRecords{client: client}
+}
+
+func (s *RedisAuthRecords) SetPasswordHash(networkId string, record string) error {
+	return s.client.Set(ChargePointKey(networkId), record, 0).Err()
+}
+
+func (s *RedisAuthRecords) DeletePasswordHash(networkId string) error {
+	return s.client.Del(ChargePointKey(networkId)).Err()
+}
+
+// Scans the CP_ keys, so redis isn't blocked as it would be by KEYS
+func (s *RedisAuthRecords) ListPasswordHashes() (map[string]string, error) {
+	hashes := map[string]string{}
+	var cursor uint64
+	for {
+		keys, next, err := s.client.Scan(cursor, KeyPrefix_ChargePoint+"*", scanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			values, err := s.client.MGet(keys...).Result()
+			if err != nil {
+				return nil, err
+			}
+			for i, value := range values {
+				if hash, ok := value.(string); ok { // nil if deleted since the scan
+					hashes[strings.TrimPrefix(keys[i], KeyPrefix_ChargePoint)] = hash
+				}
+			}
+		}
+		if next == 0 {
+			return hashes, nil
+		}
+		cursor = next
+	}
+}
+
+// Replaces auth records holding a plaintext password, from before passwords were hashed, with its hash, returning
+// how many were replaced. Records which are already hashes are left alone
+func HashPlaintextRecords(records AuthRecordStore) (int, error) {
+	existing, err := records.ListPasswordHashes()
+	if err != nil {
+		return 0, err
+	}
+	hashed := 0
+	for networkId, record := range existing {
+		if hash, _ := SplitAuthRecord(record); record == "" || IsPasswordHash(hash) {
+			continue
+		}
+		hash, err := HashPassword(record)
+		if err != nil {
+			return hashed, err
+		}
+		if err := records.SetPasswordHash(networkId, hash); err != nil {
+			return hashed, err
+		}
+		hashed++
+	}
+	return hashed, nil
+}
+
+// Checks passwords against the hashes in the charge points' redis auth records
+type RedisAuthenticator struct {
+	client      *redis.Client
+	pendingUsed PendingPasswordFunc
+}
+
+func NewRedisAuthenticator(client *redis.Client, pendingUsed PendingPasswordFunc) *RedisAuthenticator {
+	return &RedisAuthenticator{client: client, pendingUsed: pendingUsed}
+}
+
+func (a *RedisAuthenticator) Authenticate(ctx context.Context, networkId string, password string) (bool, error) {
+	record, err := GetPasswordHash(a.client.WithContext(ctx), networkId)
+	if errors.Is(err, ErrUnknownChargePoint) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	hash, pendingHash := SplitAuthRecord(record)
+	return verifyHashes(networkId, password, hash, pendingHash, a.pendingUsed), nil
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"sw/ocpp/csms/internal/db"
+)
+
+// Checks passwords against the hashes in the devices table. Disabled devices are denied
+type SqlAuthenticator struct {
+	devices     db.DeviceRepository
+	tenant      string
+	pendingUsed PendingPasswordFunc
+}
+
+func NewSqlAuthenticator(devices db.DeviceRepository, tenant string, pendingUsed PendingPasswordFunc) *SqlAuthenticator {
+	return &SqlAuthenticator{devices: devices, tenant: tenant, pendingUsed: pendingUsed}
+}
+
+func (a *SqlAuthenticator) Authenticate(ctx context.Context, networkId string, password string) (bool, error) {
+	device, err := a.devices.GetDevice(ctx, a.tenant, networkId)
+	if errors.Is(err, db.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if device.Disabled {
+		return false, nil
+	}
+	return verifyHashes(networkId, password, device.PasswordHash, device.PendingPasswordHash, a.pendingUsed), nil
+}
+
+func (a *SqlAuthenticator) DeviceAllowed(ctx context.Context, networkId string) (bool, error) {
+	device, err := a.devices.GetDevice(ctx, a.tenant, networkId)
+	if errors.Is(err, db.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !device.Disabled, nil
+}
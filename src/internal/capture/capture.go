@@ -0,0 +1,127 @@
+// Records raw OCPP websocket frames per connection to JSONL capture files, for replay against a csms-server
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/ocpp"
+)
+
+const (
+	Direction_In  = "in"  // client to server
+	Direction_Out = "out" // server to client
+
+	maxCaptureLine = 1024 * 1024
+)
+
+// A websocket frame as sent or received by the server
+type Frame struct {
+	Time      time.Time `json:"time"`
+	NetworkId string    `json:"networkId"`
+	Direction string    `json:"direction"`
+	Data      string    `json:"data"`
+}
+
+// Opens a capture file for each connection of the configured networkIds, or all if none are configured
+type Recorder struct {
+	directory  string
+	networkIds map[string]struct{}
+}
+
+// Records the frames of one connection. A nil Session records nothing.
+type Session struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	network string
+}
+
+func NewRecorder(config conf.CaptureConfig) (*Recorder, error) {
+	if config.Directory == "" {
+		return nil, fmt.Errorf("capture directory must be set")
+	}
+	if err := os.MkdirAll(config.Directory, 0o700); err != nil {
+		return nil, err
+	}
+
+	networkIds := map[string]struct{}{}
+	for _, networkId := range config.NetworkIds {
+		networkIds[networkId] = struct{}{}
+	}
+	return &Recorder{directory: config.Directory, networkIds: networkIds}, nil
+}
+
+// Opens a capture file named <networkId>-<time>.jsonl, or returns nil if the networkId isn't captured
+func (r *Recorder) Open(networkId string) (*Session, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if _, ok := r.networkIds[networkId]; len(r.networkIds) > 0 && !ok {
+		return nil, nil
+	}
+
+	name := fmt.Sprintf("%s-%s.jsonl", networkId, time.Now().UTC().Format("20060102T150405.000Z"))
+	file, err := os.OpenFile(filepath.Join(r.directory, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{file: file, encoder: json.NewEncoder(file), network: networkId}, nil
+}
+
+// Records a frame, with the AuthorizationKey of a ChangeConfiguration redacted
+func (s *Session) Record(direction string, data []byte) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(Frame{Time: time.Now().UTC(), NetworkId: s.network, Direction: direction, Data: string(ocpp.RedactFrame(data))})
+}
+
+func (s *Session) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// Reads the frames of a capture, in the order recorded
+func ReadCapture(r io.Reader) ([]Frame, error) {
+	frames := []Frame{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxCaptureLine)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var frame Frame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if frame.Direction != Direction_In && frame.Direction != Direction_Out {
+			return nil, fmt.Errorf("line %d: invalid direction: %s", line, frame.Direction)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, scanner.Err()
+}
+
+func ReadCaptureFile(fileName string) ([]Frame, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadCapture(file)
+}
@@ -0,0 +1,64 @@
+package capture
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	conf "sw/ocpp/csms/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "captures")
+	recorder, err := NewRecorder(conf.CaptureConfig{Enabled: true, Directory: dir, NetworkIds: []string{"charger-1"}})
+	require.NoError(t, err)
+
+	session, err := recorder.Open("charger-2")
+	require.NoError(t, err)
+	assert.Nil(t, session)
+	// A nil session records nothing
+	assert.NoError(t, session.Record(Direction_In, []byte("[]")))
+	assert.NoError(t, session.Close())
+
+	session, err = recorder.Open("charger-1")
+	require.NoError(t, err)
+	require.NoError(t, session.Record(Direction_In, []byte(`[2,"1","Heartbeat",{}]`)))
+	require.NoError(t, session.Record(Direction_Out, []byte(`[3,"1",{"currentTime":"2024-09-27T08:00:00Z"}]`)))
+	require.NoError(t, session.Record(Direction_Out, []byte(`[2,"2","ChangeConfiguration",{"key":"AuthorizationKey","value":"secret"}]`)))
+	require.NoError(t, session.Close())
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.True(t, strings.HasPrefix(filepath.Base(files[0]), "charger-1-"), files[0])
+
+	info, err = os.Stat(files[0])
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	frames, err := ReadCaptureFile(files[0])
+	require.NoError(t, err)
+	require.Len(t, frames, 3)
+	assert.Equal(t, "charger-1", frames[0].NetworkId)
+	assert.Equal(t, Direction_In, frames[0].Direction)
+	assert.Equal(t, `[2,"1","Heartbeat",{}]`, frames[0].Data)
+	assert.Equal(t, Direction_Out, frames[1].Direction)
+	assert.False(t, frames[1].Time.Before(frames[0].Time))
+	assert.Equal(t, `[2,"2","ChangeConfiguration",{"key":"AuthorizationKey","value":"***"}]`, frames[2].Data)
+}
+
+func TestReadCaptureInvalid(t *testing.T) {
+	_, err := ReadCapture(strings.NewReader(`{"direction":"sideways","data":"[]"}`))
+	assert.ErrorContains(t, err, "line 1: invalid direction")
+
+	_, err = ReadCaptureFile(filepath.Join(t.TempDir(), "missing.jsonl"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,325 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/ocpp"
+
+	"github.com/gorilla/websocket"
+)
+
+const defaultResponseTimeout = 10 * time.Second
+
+type ReplayOptions struct {
+	Url             string        // base websocket url, the networkId is appended, e.g ws://localhost:5200/ocpp
+	NetworkId       string        // if set, replaces the captured networkId
+	Speed           float64       // 1 keeps the recorded timing, 10 runs ten times faster, 0 sends without delay
+	ResponseTimeout time.Duration // how long to wait for each recorded response
+	IgnoreFields    []string      // payload fields not compared, e.g currentTime
+}
+
+// A server response or call which differs from the capture
+type Diff struct {
+	MsgId    string `json:"msgId"`
+	Action   string `json:"action,omitempty"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+type ReplayResult struct {
+	Sent     int    `json:"sent"`
+	Compared int    `json:"compared"`
+	Diffs    []Diff `json:"diffs"`
+}
+
+// Plays the client frames of a capture against a server as a fake charger, comparing the server's responses with
+// the recorded ones. Recorded charger responses are sent when the server makes the same call, with its new msgId.
+func Replay(ctx context.Context, frames []Frame, options ReplayOptions) (*ReplayResult, error) {
+	networkId := options.NetworkId
+	if networkId == "" && len(frames) > 0 {
+		networkId = frames[0].NetworkId
+	}
+	if networkId == "" {
+		return nil, errors.New("no networkId to replay as")
+	}
+	if options.ResponseTimeout <= 0 {
+		options.ResponseTimeout = defaultResponseTimeout
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, strings.TrimSuffix(options.Url, "/")+"/"+networkId, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	r := newReplayer(conn, frames, options)
+	defer close(r.done)
+	go r.read()
+
+	if err = r.run(ctx, frames); err != nil {
+		return r.result, err
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return r.result, nil
+}
+
+type ocppFrame struct {
+	messageType int
+	msgId       string
+	action      string
+}
+
+// Parses the OCPP message type, msgId and for calls the action, of a frame
+func parseFrame(data string) (ocppFrame, bool) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal([]byte(data), &fields); err != nil || len(fields) < 3 {
+		return ocppFrame{}, false
+	}
+	var frame ocppFrame
+	if json.Unmarshal(fields[0], &frame.messageType) != nil || json.Unmarshal(fields[1], &frame.msgId) != nil {
+		return ocppFrame{}, false
+	}
+	if frame.messageType == ocpp.MsgType_ClientToServer && json.Unmarshal(fields[2], &frame.action) != nil {
+		return ocppFrame{}, false
+	}
+	return frame, true
+}
+
+type replayer struct {
+	conn    *websocket.Conn
+	options ReplayOptions
+	ignore  map[string]struct{}
+	result  *ReplayResult
+
+	responses   map[string]string   // recorded server responses by msgId
+	actions     map[string]string   // charger call actions by msgId
+	callReplies map[string][]string // recorded charger responses to server calls, by action
+	draining    bool
+
+	recv    chan []byte
+	readErr chan error
+	done    chan struct{}
+}
+
+func newReplayer(conn *websocket.Conn, frames []Frame, options ReplayOptions) *replayer {
+	r := &replayer{
+		conn:        conn,
+		options:     options,
+		ignore:      map[string]struct{}{},
+		result:      &ReplayResult{Diffs: []Diff{}},
+		responses:   map[string]string{},
+		actions:     map[string]string{},
+		callReplies: map[string][]string{},
+		recv:        make(chan []byte, 16),
+		readErr:     make(chan error, 1),
+		done:        make(chan struct{}),
+	}
+	for _, field := range options.IgnoreFields {
+		r.ignore[field] = struct{}{}
+	}
+
+	serverCalls := map[string]string{} // action by msgId
+	for _, frame := range frames {
+		parsed, ok := parseFrame(frame.Data)
+		if !ok {
+			continue
+		}
+		switch {
+		case frame.Direction == Direction_In && parsed.messageType == ocpp.MsgType_ClientToServer:
+			r.actions[parsed.msgId] = parsed.action
+		case frame.Direction == Direction_Out && parsed.messageType == ocpp.MsgType_ClientToServer:
+			serverCalls[parsed.msgId] = parsed.action
+		case frame.Direction == Direction_Out:
+			r.responses[parsed.msgId] = frame.Data
+		case frame.Direction == Direction_In:
+			if action, ok := serverCalls[parsed.msgId]; ok {
+				r.callReplies[action] = append(r.callReplies[action], frame.Data)
+			}
+		}
+	}
+	return r
+}
+
+func (r *replayer) read() {
+	for {
+		_, data, err := r.conn.ReadMessage()
+		if err != nil {
+			r.readErr <- err
+			return
+		}
+		select {
+		case r.recv <- data:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *replayer) run(ctx context.Context, frames []Frame) error {
+	start := time.Now()
+	var first time.Time
+	for _, frame := range frames {
+		if frame.Direction != Direction_In {
+			continue
+		}
+		parsed, ok := parseFrame(frame.Data)
+		if ok && parsed.messageType != ocpp.MsgType_ClientToServer {
+			continue // charger responses are sent as the server makes its calls
+		}
+
+		if first.IsZero() {
+			first = frame.Time
+		}
+		if r.options.Speed > 0 {
+			due := start.Add(time.Duration(float64(frame.Time.Sub(first)) / r.options.Speed))
+			if _, _, err := r.wait(ctx, due, ""); err != nil {
+				return err
+			}
+		}
+
+		if err := r.conn.WriteMessage(websocket.TextMessage, []byte(frame.Data)); err != nil {
+			return err
+		}
+		r.result.Sent++
+
+		expected, ok := r.responses[parsed.msgId]
+		if !ok {
+			continue
+		}
+		actual, received, err := r.wait(ctx, time.Now().Add(r.options.ResponseTimeout), parsed.msgId)
+		if err != nil {
+			return err
+		}
+		r.result.Compared++
+		if !received {
+			r.addDiff(parsed.msgId, "no response", expected, "")
+		} else if !r.equal(expected, string(actual)) {
+			r.addDiff(parsed.msgId, "response differs", expected, string(actual))
+		}
+	}
+	return r.drainCalls(ctx)
+}
+
+// Waits for the server calls still expected from the capture, then reports any not made
+func (r *replayer) drainCalls(ctx context.Context) error {
+	r.draining = true
+	deadline := time.Now().Add(r.options.ResponseTimeout)
+	for r.pendingCalls() > 0 && time.Now().Before(deadline) {
+		if _, _, err := r.wait(ctx, deadline, ""); err != nil {
+			return err
+		}
+	}
+	for action, replies := range r.callReplies {
+		for range replies {
+			r.result.Diffs = append(r.result.Diffs, Diff{Action: action, Reason: "no server call"})
+		}
+	}
+	return nil
+}
+
+func (r *replayer) pendingCalls() int {
+	pending := 0
+	for _, replies := range r.callReplies {
+		pending += len(replies)
+	}
+	return pending
+}
+
+// Handles server frames until the response to msgId is received, or until the deadline
+func (r *replayer) wait(ctx context.Context, until time.Time, msgId string) ([]byte, bool, error) {
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-timer.C:
+			return nil, false, nil
+		case err := <-r.readErr:
+			return nil, false, fmt.Errorf("server closed connection: %w", err)
+		case data := <-r.recv:
+			parsed, ok := parseFrame(string(data))
+			switch {
+			case !ok:
+				r.addDiff("", "unparsable server frame", "", string(data))
+			case parsed.messageType == ocpp.MsgType_ClientToServer:
+				if err := r.replyToCall(parsed, string(data)); err != nil {
+					return nil, false, err
+				}
+				if r.draining {
+					return nil, false, nil
+				}
+			case parsed.msgId == msgId && msgId != "":
+				return data, true, nil
+			default:
+				r.addDiff(parsed.msgId, "unexpected response", r.responses[parsed.msgId], string(data))
+			}
+		}
+	}
+}
+
+// Sends the next recorded charger response for the call's action, with the server's msgId
+func (r *replayer) replyToCall(call ocppFrame, data string) error {
+	replies := r.callReplies[call.action]
+	if len(replies) == 0 {
+		r.result.Diffs = append(r.result.Diffs, Diff{MsgId: call.msgId, Action: call.action, Reason: "unexpected server call", Actual: data})
+		return nil
+	}
+	r.callReplies[call.action] = replies[1:]
+
+	var fields []json.RawMessage
+	if err := json.Unmarshal([]byte(replies[0]), &fields); err != nil {
+		return err
+	}
+	fields[1], _ = json.Marshal(call.msgId)
+	reply, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return r.conn.WriteMessage(websocket.TextMessage, reply)
+}
+
+func (r *replayer) addDiff(msgId string, reason string, expected string, actual string) {
+	r.result.Diffs = append(r.result.Diffs, Diff{MsgId: msgId, Action: r.actions[msgId], Reason: reason, Expected: expected, Actual: actual})
+}
+
+// Compares two frames ignoring the msgId and any ignored payload fields
+func (r *replayer) equal(expected string, actual string) bool {
+	var expectedFields, actualFields []any
+	if json.Unmarshal([]byte(expected), &expectedFields) != nil || json.Unmarshal([]byte(actual), &actualFields) != nil {
+		return expected == actual
+	}
+	if len(expectedFields) != len(actualFields) || len(expectedFields) < 2 {
+		return false
+	}
+	for i := range expectedFields {
+		if i == 1 {
+			continue
+		}
+		if !reflect.DeepEqual(r.withoutIgnored(expectedFields[i]), r.withoutIgnored(actualFields[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *replayer) withoutIgnored(value any) any {
+	fields, ok := value.(map[string]any)
+	if !ok || len(r.ignore) == 0 {
+		return value
+	}
+	kept := map[string]any{}
+	for key, fieldValue := range fields {
+		if _, ignored := r.ignore[key]; !ignored {
+			kept[key] = fieldValue
+		}
+	}
+	return kept
+}
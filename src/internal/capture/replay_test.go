@@ -0,0 +1,128 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A server which answers Heartbeat with the current time, BootNotification with a fixed interval, and after
+// StatusNotification makes a Reset call. It records the frames the charger sent, and the networkId connected as.
+type fakeServer struct {
+	mu        sync.Mutex
+	path      string
+	received  []string
+	bootReply string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	f.mu.Lock()
+	f.path = r.URL.Path
+	f.mu.Unlock()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.received = append(f.received, string(data))
+		f.mu.Unlock()
+
+		var fields []json.RawMessage
+		if json.Unmarshal(data, &fields) != nil {
+			continue
+		}
+		var action string
+		json.Unmarshal(fields[2], &action)
+		msgId := string(fields[1])
+		switch action {
+		case "Heartbeat":
+			conn.WriteMessage(websocket.TextMessage, []byte(`[3,`+msgId+`,{"currentTime":"`+time.Now().UTC().Format(time.RFC3339)+`"}]`))
+		case "BootNotification":
+			conn.WriteMessage(websocket.TextMessage, []byte(`[3,`+msgId+`,`+f.bootReply+`]`))
+		case "StatusNotification":
+			conn.WriteMessage(websocket.TextMessage, []byte(`[3,`+msgId+`,{}]`))
+			conn.WriteMessage(websocket.TextMessage, []byte(`[2,"server-call-1","Reset",{"type":"Soft"}]`))
+		}
+	}
+}
+
+func (f *fakeServer) receivedFrames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.received...)
+}
+
+func testCapture() []Frame {
+	start := time.Date(2024, 9, 27, 8, 0, 0, 0, time.UTC)
+	frame := func(offset time.Duration, direction string, data string) Frame {
+		return Frame{Time: start.Add(offset), NetworkId: "charger-1", Direction: direction, Data: data}
+	}
+	return []Frame{
+		frame(0, Direction_In, `[2,"1","BootNotification",{"chargePointModel":"m","chargePointVendor":"v"}]`),
+		frame(10*time.Millisecond, Direction_Out, `[3,"1",{"status":"Accepted","currentTime":"2024-09-27T08:00:00Z","interval":60}]`),
+		frame(time.Second, Direction_In, `[2,"2","Heartbeat",{}]`),
+		frame(time.Second+10*time.Millisecond, Direction_Out, `[3,"2",{"currentTime":"2024-09-27T08:00:01Z"}]`),
+		frame(2*time.Second, Direction_In, `[2,"3","StatusNotification",{"connectorId":1,"errorCode":"NoError","status":"Available"}]`),
+		frame(2*time.Second+10*time.Millisecond, Direction_Out, `[3,"3",{}]`),
+		frame(2*time.Second+20*time.Millisecond, Direction_Out, `[2,"orig-call","Reset",{"type":"Soft"}]`),
+		frame(2*time.Second+30*time.Millisecond, Direction_In, `[3,"orig-call",{"status":"Accepted"}]`),
+	}
+}
+
+func newTestServer(t *testing.T, bootReply string) (*fakeServer, string) {
+	fake := &fakeServer{bootReply: bootReply}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, "ws" + strings.TrimPrefix(server.URL, "http") + "/ocpp"
+}
+
+func TestReplay(t *testing.T) {
+	fake, url := newTestServer(t, `{"status":"Accepted","currentTime":"2030-01-01T00:00:00Z","interval":60}`)
+
+	result, err := Replay(context.Background(), testCapture(), ReplayOptions{Url: url, Speed: 100, IgnoreFields: []string{"currentTime"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Sent)
+	assert.Equal(t, 3, result.Compared)
+	assert.Empty(t, result.Diffs)
+
+	assert.Eventually(t, func() bool { return len(fake.receivedFrames()) == 4 }, time.Second, 10*time.Millisecond)
+	// The recorded response to the server's call is sent with the server's msgId
+	assert.Equal(t, `[3,"server-call-1",{"status":"Accepted"}]`, fake.receivedFrames()[3])
+	fake.mu.Lock()
+	assert.Equal(t, "/ocpp/charger-1", fake.path)
+	fake.mu.Unlock()
+}
+
+func TestReplayDiffs(t *testing.T) {
+	_, url := newTestServer(t, `{"status":"Rejected","currentTime":"2030-01-01T00:00:00Z","interval":60}`)
+
+	frames := testCapture()
+	frames[6].Data = `[2,"orig-call","ChangeAvailability",{"connectorId":0,"type":"Inoperative"}]` // the server calls Reset instead
+	result, err := Replay(context.Background(), frames, ReplayOptions{Url: url, NetworkId: "charger-2", ResponseTimeout: time.Second})
+	require.NoError(t, err)
+
+	reasons := []string{}
+	for _, diff := range result.Diffs {
+		reasons = append(reasons, diff.Action+": "+diff.Reason)
+	}
+	// Without ignoring currentTime, the Heartbeat response differs too
+	assert.Equal(t, []string{"BootNotification: response differs", "Heartbeat: response differs", "Reset: unexpected server call",
+		"ChangeAvailability: no server call"}, reasons)
+	assert.Contains(t, result.Diffs[0].Actual, "Rejected")
+}
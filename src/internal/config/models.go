@@ -10,37 +10,231 @@ type Configuration struct {
 	Schema   string `mapstructure:"schema"`
 	Services struct {
 		CsmsServer struct {
-			Debug          bool        `mapstructure:"debug"`
-			EnableAuth     bool        `mapstructure:"enable_auth"`
-			StandaloneMode bool        `mapstructure:"standalone_mode"`
-			ListenAddress  string      `mapstructure:"listen_address"`
-			ListenPort     int         `mapstructure:"listen_port"`
-			Cache          CacheConfig `mapstructure:"cache"`
+			Debug          bool          `mapstructure:"debug"`
+			EnableAuth     bool          `mapstructure:"enable_auth"`
+			Auth           AuthConfig    `mapstructure:"auth"`
+			StandaloneMode bool          `mapstructure:"standalone_mode"`
+			ListenAddress  string        `mapstructure:"listen_address"`
+			ListenPort     int           `mapstructure:"listen_port"`
+			Cache          CacheConfig   `mapstructure:"cache"`
+			Capture        CaptureConfig `mapstructure:"capture"`
+			Tls            TlsConfig     `mapstructure:"tls"`
+			Admin          AdminConfig   `mapstructure:"admin"`
 		} `mapstructure:"csms_server"`
 		MessageManager struct {
-			Debug              bool   `mapstructure:"debug"`
-			StorageAccountName string `mapstructure:"storage_account_name"`
-			StorageAccountKey  string `mapstructure:"storage_account_key"`
-			StoreMessages      bool   `mapstructure:"store_messages"`
+			Debug              bool               `mapstructure:"debug"`
+			StorageAccountName string             `mapstructure:"storage_account_name"`
+			StorageAccountKey  string             `mapstructure:"storage_account_key"`
+			StoreMessages      bool               `mapstructure:"store_messages"`
+			StoreDeadLetters   bool               `mapstructure:"store_dead_letters"`
+			MessageStore       MessageStoreConfig `mapstructure:"message_store"`
+			HttpConfig         HttpConfig         `mapstructure:"http_config"`
+			Retention          RetentionConfig    `mapstructure:"retention"`
+			Admin              AdminConfig        `mapstructure:"admin"`
 		} `mapstructure:"message_manager"`
 		Session struct {
-			Debug bool `mapstructure:"debug"`
+			Debug bool        `mapstructure:"debug"`
+			Admin AdminConfig `mapstructure:"admin"`
 		} `mapstructure:"session"`
 		DeviceManager struct {
-			Debug      bool       `mapstructure:"debug"`
-			HttpConfig HttpConfig `mapstructure:"http_config"`
+			Debug      bool           `mapstructure:"debug"`
+			HttpConfig HttpConfig     `mapstructure:"http_config"`
+			Tenant     string         `mapstructure:"tenant"` // tenant of the devices managed
+			Cache      CacheConfig    `mapstructure:"cache"`
+			AuthSync   AuthSyncConfig `mapstructure:"auth_sync"`
+			Pki        PkiConfig      `mapstructure:"pki"`
+			Admin      AdminConfig    `mapstructure:"admin"`
+			BulkJobs   BulkJobsConfig `mapstructure:"bulk_jobs"`
+			Commands   CommandsConfig `mapstructure:"commands"`
+			Actions    ActionsConfig  `mapstructure:"actions"`
 		} `mapstructure:"device_manager"`
 	} `mapstructure:"services"`
 	Logging struct {
 		AppInsightsInstrumentationKey string `mapstructure:"appinsights_instrumentation_key"`
+		Format                        string `mapstructure:"format"` // text | json
 	}
-	Mq       MqConfig `mapstructure:"mq"`
-	DbConfig DbConfig `mapstructure:"db_config"`
+	Tracing   TracingConfig   `mapstructure:"tracing"`
+	Telemetry TelemetryConfig `mapstructure:"telemetry"`
+	Mq        MqConfig        `mapstructure:"mq"`
+	DbConfig  DbConfig        `mapstructure:"db_config"`
 }
 
 type DbConfig struct {
-	DbType             string `mapstructure:"type"`
-	DbConnectionString string `mapstructure:"connection_string"`
+	DbType              string `mapstructure:"type"`
+	DbConnectionString  string `mapstructure:"connection_string"`
+	MaxOpenConns        int    `mapstructure:"max_open_conns"`
+	MaxIdleConns        int    `mapstructure:"max_idle_conns"`
+	ConnMaxLifetimeSecs int    `mapstructure:"conn_max_lifetime_secs"`
+	ConnMaxIdleTimeSecs int    `mapstructure:"conn_max_idle_time_secs"`
+}
+
+type MessageStoreConfig struct {
+	Type             string `mapstructure:"type"`
+	BatchSize        int    `mapstructure:"batch_size"`
+	FlushIntervalMs  int    `mapstructure:"flush_interval_ms"`
+	QueueSize        int    `mapstructure:"queue_size"`
+	EnqueueTimeoutMs int    `mapstructure:"enqueue_timeout_ms"`
+	WriteAttempts    int    `mapstructure:"write_attempts"`
+	RetryDelayMs     int    `mapstructure:"retry_delay_ms"`
+	AzureTable       struct {
+		TableName          string `mapstructure:"table_name"`
+		StorageAccountName string `mapstructure:"storage_account_name"`
+		StorageAccountKey  string `mapstructure:"storage_account_key"`
+		ConnectionString   string `mapstructure:"connection_string"`
+	} `mapstructure:"azure_table"`
+	Sql   DbConfig `mapstructure:"sql"`
+	Jsonl struct {
+		Directory  string `mapstructure:"directory"`
+		MaxSizeMb  int    `mapstructure:"max_size_mb"`
+		MaxBackups int    `mapstructure:"max_backups"`
+		MaxAgeDays int    `mapstructure:"max_age_days"`
+		Compress   bool   `mapstructure:"compress"`
+	} `mapstructure:"jsonl"`
+	S3 struct {
+		Endpoint        string `mapstructure:"endpoint"`
+		Region          string `mapstructure:"region"`
+		Bucket          string `mapstructure:"bucket"`
+		Prefix          string `mapstructure:"prefix"`
+		AccessKeyId     string `mapstructure:"access_key_id"`
+		SecretAccessKey string `mapstructure:"secret_access_key"`
+		UseSsl          bool   `mapstructure:"use_ssl"`
+	} `mapstructure:"s3"`
+}
+
+// Records raw websocket frames per connection, for the networkIds listed or all if none are
+type CaptureConfig struct {
+	Enabled    bool     `mapstructure:"enabled"`
+	Directory  string   `mapstructure:"directory"`
+	NetworkIds []string `mapstructure:"network_ids"`
+}
+
+// Retention in days, 0 keeps forever
+type RetentionConfig struct {
+	Enabled           bool           `mapstructure:"enabled"`
+	PurgeIntervalMins int            `mapstructure:"purge_interval_mins"`
+	DefaultDays       int            `mapstructure:"default_days"`
+	MessageTypeDays   map[string]int `mapstructure:"message_type_days"`
+	TransactionsDays  int            `mapstructure:"transactions_days"`
+}
+
+// OpenTelemetry tracing, exported over OTLP/HTTP
+type TracingConfig struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Endpoint    string  `mapstructure:"endpoint"`     // collector host:port, default localhost:4318
+	Insecure    bool    `mapstructure:"insecure"`     // use http rather than https
+	SampleRatio float64 `mapstructure:"sample_ratio"` // fraction of traces started here which are sampled, 0 samples all
+}
+
+// Sinks for OCPP request, connection and authentication telemetry: appinsights | otlp | prometheus | stdout.
+// If no sinks are set, App Insights is used when logging has an instrumentation key
+type TelemetryConfig struct {
+	Sinks []string            `mapstructure:"sinks"`
+	Otlp  TelemetryOtlpConfig `mapstructure:"otlp"`
+}
+
+// OpenTelemetry metrics, exported over OTLP/HTTP
+type TelemetryOtlpConfig struct {
+	Endpoint     string `mapstructure:"endpoint"`      // collector host:port, default localhost:4318
+	Insecure     bool   `mapstructure:"insecure"`      // use http rather than https
+	IntervalSecs int    `mapstructure:"interval_secs"` // export interval, default 60
+}
+
+// Charge point Basic auth, checked if enable_auth is set. Failed attempts are limited per client IP,
+// max_failures of 0 is unlimited. The client IP is taken from X-Forwarded-For if the connection is from one of
+// trusted_proxies, IPs or CIDRs e.g the ingress controller's.
+// Passwords are checked by a backend: redis | sql | static | http, default redis.
+// Decisions are cached for cache_ttl_secs, or negative_cache_ttl_secs if denied, not cached if 0
+type AuthConfig struct {
+	MaxFailures          int      `mapstructure:"max_failures"`
+	FailureWindowSecs    int      `mapstructure:"failure_window_secs"`
+	TrustedProxies       []string `mapstructure:"trusted_proxies"`
+	Backend              string   `mapstructure:"backend"`
+	CacheTtlSecs         int      `mapstructure:"cache_ttl_secs"`
+	NegativeCacheTtlSecs int      `mapstructure:"negative_cache_ttl_secs"`
+	Sql                  struct {
+		Tenant string `mapstructure:"tenant"` // tenant of the db_config devices
+	} `mapstructure:"sql"`
+	Static struct {
+		Chargers []StaticCharger `mapstructure:"chargers"`
+	} `mapstructure:"static"`
+	Http struct {
+		Url           string `mapstructure:"url"`
+		Authorization string `mapstructure:"authorization"`
+		TimeoutSecs   int    `mapstructure:"timeout_secs"` // default 10
+	} `mapstructure:"http"`
+}
+
+// A charger allowed by the static auth backend. A list rather than a map, as config map keys are lower cased
+type StaticCharger struct {
+	NetworkId    string `mapstructure:"network_id"`
+	PasswordHash string `mapstructure:"password_hash"`
+}
+
+// Serves TLS, for OCPP security profiles 2 and 3. Client certificates are verified against client_ca_file if set,
+// and required if require_client_cert is set
+type TlsConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ClientCaFile       string `mapstructure:"client_ca_file"`
+	RequireClientCert  bool   `mapstructure:"require_client_cert"`
+	ReloadIntervalSecs int    `mapstructure:"reload_interval_secs"` // how often cert_file and key_file are checked for changes, default 60
+}
+
+// Reconciliation of the csms-server auth records in cache with the devices table, on startup and every interval_mins.
+// If prune is set, auth records of chargers which aren't devices are removed, otherwise they're left as is
+type AuthSyncConfig struct {
+	IntervalMins int  `mapstructure:"interval_mins"` // default 60
+	Prune        bool `mapstructure:"prune"`
+}
+
+// Limits of bulk jobs, which send an action to many chargers
+type BulkJobsConfig struct {
+	MaxConcurrency int `mapstructure:"max_concurrency"` // default 50
+	MaxTargets     int `mapstructure:"max_targets"`     // default 10000
+}
+
+// Async actions, sent with ?async=true and tracked as commands. Callbacks are signed with HMAC-SHA256 if
+// callback_secret is set. Callbacks only go to public addresses, unless callback_allowed_hosts lists the hosts
+// allowed, which may then be private
+type CommandsConfig struct {
+	TimeoutSecs          int      `mapstructure:"timeout_secs"` // default 60, for actions without an entry in actions.timeouts
+	CallbackSecret       string   `mapstructure:"callback_secret"`
+	CallbackTimeoutSecs  int      `mapstructure:"callback_timeout_secs"` // default 10
+	CallbackAllowedHosts []string `mapstructure:"callback_allowed_hosts"`
+}
+
+// How long actions wait for the charger's response: timeout_secs, or the action's entry in timeouts keyed by lowercased
+// action e.g getdiagnostics. A request's ?timeoutSecs overrides them, up to max_timeout_secs
+type ActionsConfig struct {
+	TimeoutSecs    int            `mapstructure:"timeout_secs"` // default 5
+	Timeouts       map[string]int `mapstructure:"timeouts"`
+	MaxTimeoutSecs int            `mapstructure:"max_timeout_secs"` // default 300
+}
+
+// Signing of chargers' SignCertificate CSRs, by a local CA or an external one over HTTP: local | http.
+// Accepted certificates expiring within renew_before_days are renewed, checked every renew_interval_mins
+type PkiConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Ca      string `mapstructure:"ca"`
+	Local   struct {
+		CertFile     string `mapstructure:"cert_file"`
+		KeyFile      string `mapstructure:"key_file"`
+		ValidityDays int    `mapstructure:"validity_days"` // default 365
+	} `mapstructure:"local"`
+	Http struct {
+		Url           string `mapstructure:"url"`
+		Authorization string `mapstructure:"authorization"`
+		TimeoutSecs   int    `mapstructure:"timeout_secs"` // default 30
+	} `mapstructure:"http"`
+	RenewBeforeDays   int `mapstructure:"renew_before_days"`   // default 30
+	RenewIntervalMins int `mapstructure:"renew_interval_mins"` // default 60
+}
+
+// Listener for operational endpoints such as /metrics, disabled if listen_port is 0
+type AdminConfig struct {
+	ListenAddress string `mapstructure:"listen_address"`
+	ListenPort    int    `mapstructure:"listen_port"`
 }
 
 type HttpConfig struct {
@@ -0,0 +1,263 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/metrics"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+)
+
+const (
+	bulkJobColumns    = "id,tenant,owner,action,payload,selector,concurrency,ratePerSec,status,total,createdAt,completedAt"
+	bulkResultColumns = "jobId,networkid,status,response,error,completedAt"
+)
+
+type sqlBulkJobRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r *sqlBulkJobRepository) InsertJob(ctx context.Context, job *dbmodels.BulkJob, networkIds []string) (int64, error) {
+	defer metrics.ObserveDbQuery("bulk_jobs", "InsertJob", time.Now())
+	selector, err := json.Marshal(job.Selector)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := r.dialect.InsertReturningId(ctx, tx,
+		r.dialect.Rebind("INSERT INTO bulk_jobs(tenant,owner,action,payload,selector,concurrency,ratePerSec,status,total,createdAt) VALUES (?,?,?,?,?,?,?,?,?,?)"),
+		job.Tenant, job.Owner, job.Action, string(job.Payload), string(selector), job.Concurrency, job.RatePerSec, job.Status, len(networkIds), job.CreatedAt.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, r.dialect.Rebind("INSERT INTO bulk_job_results(jobId,networkid,status) VALUES (?,?,?)"))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, networkId := range networkIds {
+		if _, err = stmt.ExecContext(ctx, id, networkId, dbmodels.BulkResultStatus_Pending); err != nil {
+			return 0, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	job.Id = id
+	job.Total = len(networkIds)
+	job.Results = map[string]int{dbmodels.BulkResultStatus_Pending: len(networkIds)}
+	return id, nil
+}
+
+func (r *sqlBulkJobRepository) GetJob(ctx context.Context, tenant string, id int64) (*dbmodels.BulkJob, error) {
+	defer metrics.ObserveDbQuery("bulk_jobs", "GetJob", time.Now())
+	row := r.db.QueryRowContext(ctx, r.dialect.Rebind("SELECT "+bulkJobColumns+" FROM bulk_jobs WHERE tenant = ? AND id = ?"), tenant, id)
+
+	job, err := scanBulkJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = r.countResults(ctx, []*dbmodels.BulkJob{job}); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (r *sqlBulkJobRepository) UpdateJobStatus(ctx context.Context, tenant string, id int64, fromStatus string, status string, completedAt time.Time) error {
+	defer metrics.ObserveDbQuery("bulk_jobs", "UpdateJobStatus", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("UPDATE bulk_jobs SET status = ?, completedAt = ? WHERE tenant = ? AND id = ? AND status = ?"),
+		status, completedAt.UnixMilli(), tenant, id, fromStatus)
+	return expectUpdated(res, err)
+}
+
+func (r *sqlBulkJobRepository) InterruptJobs(ctx context.Context, tenant string, owner string, interruptedAt time.Time) (int64, error) {
+	defer metrics.ObserveDbQuery("bulk_jobs", "InterruptJobs", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("UPDATE bulk_jobs SET status = ?, completedAt = ? WHERE tenant = ? AND owner = ? AND status = ?"),
+		dbmodels.BulkJobStatus_Interrupted, interruptedAt.UnixMilli(), tenant, owner, dbmodels.BulkJobStatus_Running)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *sqlBulkJobRepository) ExpireJobs(ctx context.Context, tenant string, inactiveSince time.Time, expiredAt time.Time) (int64, error) {
+	defer metrics.ObserveDbQuery("bulk_jobs", "ExpireJobs", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("UPDATE bulk_jobs SET status = ?, completedAt = ? WHERE tenant = ? AND status = ? AND createdAt < ? "+
+		"AND NOT EXISTS (SELECT 1 FROM bulk_job_results WHERE bulk_job_results.jobId = bulk_jobs.id AND bulk_job_results.completedAt >= ?)"),
+		dbmodels.BulkJobStatus_Interrupted, expiredAt.UnixMilli(), tenant, dbmodels.BulkJobStatus_Running, inactiveSince.UnixMilli(),
+		inactiveSince.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *sqlBulkJobRepository) ListJobs(ctx context.Context, filter dbmodels.BulkJobFilter) ([]dbmodels.BulkJob, error) {
+	defer metrics.ObserveDbQuery("bulk_jobs", "ListJobs", time.Now())
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.Tenant != "" {
+		where = append(where, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	query := "SELECT " + bulkJobColumns + " FROM bulk_jobs WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY id DESC LIMIT ? OFFSET ?"
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*dbmodels.BulkJob{}
+	for rows.Next() {
+		job, err := scanBulkJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err = r.countResults(ctx, jobs); err != nil {
+		return nil, err
+	}
+	listed := make([]dbmodels.BulkJob, len(jobs))
+	for i, job := range jobs {
+		listed[i] = *job
+	}
+	return listed, nil
+}
+
+func (r *sqlBulkJobRepository) SetResult(ctx context.Context, result *dbmodels.BulkJobResult) error {
+	defer metrics.ObserveDbQuery("bulk_job_results", "SetResult", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("UPDATE bulk_job_results SET status = ?, response = ?, error = ?, completedAt = ? WHERE jobId = ? AND networkid = ?"),
+		result.Status, nullString(string(result.Response)), nullString(result.Error), nullMillis(result.CompletedAt), result.JobId, result.NetworkId)
+	return expectUpdated(res, err)
+}
+
+func (r *sqlBulkJobRepository) ListResults(ctx context.Context, filter dbmodels.BulkJobResultFilter) ([]dbmodels.BulkJobResult, error) {
+	defer metrics.ObserveDbQuery("bulk_job_results", "ListResults", time.Now())
+	where := []string{"jobId = ?"}
+	args := []any{filter.JobId}
+
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	query := "SELECT " + bulkResultColumns + " FROM bulk_job_results WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY " + r.dialect.OrderText("networkid") + " LIMIT ? OFFSET ?"
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []dbmodels.BulkJobResult{}
+	for rows.Next() {
+		var result dbmodels.BulkJobResult
+		var response, resultErr sql.NullString
+		var completedAt sql.NullInt64
+		if err := rows.Scan(&result.JobId, &result.NetworkId, &result.Status, &response, &resultErr, &completedAt); err != nil {
+			return nil, err
+		}
+		if response.Valid {
+			result.Response = json.RawMessage(response.String)
+		}
+		result.Error = resultErr.String
+		result.CompletedAt = nullTime(completedAt)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// Sets each job's number of results by status
+func (r *sqlBulkJobRepository) countResults(ctx context.Context, jobs []*dbmodels.BulkJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	byId := map[int64]*dbmodels.BulkJob{}
+	placeholders := make([]string, len(jobs))
+	args := make([]any, len(jobs))
+	for i, job := range jobs {
+		job.Results = map[string]int{}
+		byId[job.Id] = job
+		placeholders[i] = "?"
+		args[i] = job.Id
+	}
+
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind("SELECT jobId, status, COUNT(*) FROM bulk_job_results WHERE jobId IN ("+
+		strings.Join(placeholders, ",")+") GROUP BY jobId, status"), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jobId int64
+		var status string
+		var count int
+		if err := rows.Scan(&jobId, &status, &count); err != nil {
+			return err
+		}
+		byId[jobId].Results[status] = count
+	}
+	return rows.Err()
+}
+
+func scanBulkJob(row rowScanner) (*dbmodels.BulkJob, error) {
+	var job dbmodels.BulkJob
+	var payload, selector string
+	var createdAt int64
+	var completedAt sql.NullInt64
+	err := row.Scan(&job.Id, &job.Tenant, &job.Owner, &job.Action, &payload, &selector, &job.Concurrency, &job.RatePerSec, &job.Status, &job.Total,
+		&createdAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(selector), &job.Selector); err != nil {
+		return nil, err
+	}
+	job.Payload = json.RawMessage(payload)
+	job.CreatedAt = time.UnixMilli(createdAt).UTC()
+	job.CompletedAt = nullTime(completedAt)
+	return &job, nil
+}
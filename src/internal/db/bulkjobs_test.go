@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkJobs(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.BulkJobs
+		createdAt := time.Date(2024, 10, 1, 8, 0, 0, 0, time.UTC)
+
+		job := &dbmodels.BulkJob{Owner: "node-1", Action: "Reset", Payload: json.RawMessage(`{"type":"Soft"}`), Selector: dbmodels.BulkSelector{Tag: "site-1"},
+			Concurrency: 2, RatePerSec: 5, Status: dbmodels.BulkJobStatus_Running, CreatedAt: createdAt}
+		id, err := repo.InsertJob(ctx, job, []string{"charger-2", "charger-1", "charger-3"})
+		require.NoError(t, err)
+		_, err = repo.InsertJob(ctx, &dbmodels.BulkJob{Owner: "node-1", Action: "Reset", Payload: json.RawMessage(`{}`),
+			Status: dbmodels.BulkJobStatus_Running, CreatedAt: createdAt}, []string{"charger-1"})
+		require.NoError(t, err)
+
+		got, err := repo.GetJob(ctx, "", id)
+		require.NoError(t, err)
+		assert.Equal(t, job, got)
+		_, err = repo.GetJob(ctx, "t2", id)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		completedAt := createdAt.Add(time.Second)
+		require.NoError(t, repo.SetResult(ctx, &dbmodels.BulkJobResult{JobId: id, NetworkId: "charger-1", Status: dbmodels.BulkResultStatus_Accepted,
+			Response: json.RawMessage(`{"status":"Accepted"}`), CompletedAt: &completedAt}))
+		require.NoError(t, repo.SetResult(ctx, &dbmodels.BulkJobResult{JobId: id, NetworkId: "charger-2", Status: dbmodels.BulkResultStatus_Error,
+			Error: "offline", CompletedAt: &completedAt}))
+		assert.ErrorIs(t, repo.SetResult(ctx, &dbmodels.BulkJobResult{JobId: id, NetworkId: "charger-4"}), ErrNotFound)
+
+		results, err := repo.ListResults(ctx, dbmodels.BulkJobResultFilter{JobId: id})
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+		assert.Equal(t, dbmodels.BulkJobResult{JobId: id, NetworkId: "charger-1", Status: dbmodels.BulkResultStatus_Accepted,
+			Response: json.RawMessage(`{"status":"Accepted"}`), CompletedAt: &completedAt}, results[0])
+		assert.Equal(t, "offline", results[1].Error)
+		assert.Nil(t, results[2].CompletedAt)
+		results, err = repo.ListResults(ctx, dbmodels.BulkJobResultFilter{JobId: id, Status: dbmodels.BulkResultStatus_Pending})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "charger-3", results[0].NetworkId)
+
+		require.NoError(t, repo.UpdateJobStatus(ctx, "", id, dbmodels.BulkJobStatus_Running, dbmodels.BulkJobStatus_Cancelled, completedAt))
+		assert.ErrorIs(t, repo.UpdateJobStatus(ctx, "", id, dbmodels.BulkJobStatus_Running, dbmodels.BulkJobStatus_Completed, completedAt),
+			ErrNotFound, "already cancelled")
+
+		jobs, err := repo.ListJobs(ctx, dbmodels.BulkJobFilter{})
+		require.NoError(t, err)
+		require.Len(t, jobs, 2)
+		assert.Equal(t, map[string]int{dbmodels.BulkResultStatus_Pending: 1}, jobs[0].Results, "newest first")
+		assert.Equal(t, dbmodels.BulkJobStatus_Cancelled, jobs[1].Status)
+		assert.Equal(t, &completedAt, jobs[1].CompletedAt)
+		assert.Equal(t, map[string]int{dbmodels.BulkResultStatus_Accepted: 1, dbmodels.BulkResultStatus_Error: 1, dbmodels.BulkResultStatus_Pending: 1},
+			jobs[1].Results)
+
+		otherOwner, err := repo.InsertJob(ctx, &dbmodels.BulkJob{Owner: "node-2", Action: "Reset", Payload: json.RawMessage(`{}`),
+			Status: dbmodels.BulkJobStatus_Running, CreatedAt: createdAt}, []string{"charger-1"})
+		require.NoError(t, err)
+		interrupted, err := repo.InterruptJobs(ctx, "t2", "node-1", completedAt)
+		require.NoError(t, err)
+		assert.Zero(t, interrupted, "other tenant")
+		interrupted, err = repo.InterruptJobs(ctx, "", "node-1", completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), interrupted)
+		jobs, err = repo.ListJobs(ctx, dbmodels.BulkJobFilter{Status: dbmodels.BulkJobStatus_Interrupted})
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.NotEqual(t, id, jobs[0].Id)
+		assert.Equal(t, "node-1", jobs[0].Owner)
+		jobs, err = repo.ListJobs(ctx, dbmodels.BulkJobFilter{Status: dbmodels.BulkJobStatus_Running})
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, otherOwner, jobs[0].Id, "another instance's job left running")
+
+		active, err := repo.InsertJob(ctx, &dbmodels.BulkJob{Owner: "node-2", Action: "Reset", Payload: json.RawMessage(`{}`),
+			Status: dbmodels.BulkJobStatus_Running, CreatedAt: createdAt}, []string{"charger-1", "charger-2"})
+		require.NoError(t, err)
+		resultAt := createdAt.Add(time.Hour)
+		require.NoError(t, repo.SetResult(ctx, &dbmodels.BulkJobResult{JobId: active, NetworkId: "charger-1", Status: dbmodels.BulkResultStatus_Accepted,
+			CompletedAt: &resultAt}))
+		expired, err := repo.ExpireJobs(ctx, "", createdAt, resultAt)
+		require.NoError(t, err)
+		assert.Zero(t, expired, "created at the cutoff")
+		expired, err = repo.ExpireJobs(ctx, "t2", resultAt, resultAt)
+		require.NoError(t, err)
+		assert.Zero(t, expired, "other tenant")
+		expired, err = repo.ExpireJobs(ctx, "", resultAt, resultAt)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), expired)
+		got, err = repo.GetJob(ctx, "", otherOwner)
+		require.NoError(t, err)
+		assert.Equal(t, dbmodels.BulkJobStatus_Interrupted, got.Status, "another instance's job without results since the cutoff")
+		assert.Equal(t, &resultAt, got.CompletedAt)
+		got, err = repo.GetJob(ctx, "", active)
+		require.NoError(t, err)
+		assert.Equal(t, dbmodels.BulkJobStatus_Running, got.Status, "a result completed at the cutoff")
+	})
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/metrics"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+)
+
+const certificateColumns = "id,tenant,networkId,certificateType,serialNumber,commonName,status,certificateChain,notBefore,notAfter,issuedAt,renewalRequestedAt"
+
+type sqlCertificateRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r *sqlCertificateRepository) InsertCertificate(ctx context.Context, certificate *dbmodels.Certificate) (int64, error) {
+	defer metrics.ObserveDbQuery("certificates", "InsertCertificate", time.Now())
+	return r.dialect.InsertReturningId(ctx, r.db,
+		r.dialect.Rebind("INSERT INTO certificates(tenant,networkId,certificateType,serialNumber,commonName,status,certificateChain,notBefore,notAfter,issuedAt) VALUES (?,?,?,?,?,?,?,?,?,?)"),
+		certificate.Tenant, certificate.NetworkId, certificate.CertificateType, certificate.SerialNumber, certificate.CommonName, certificate.Status,
+		certificate.CertificateChain, certificate.NotBefore.UnixMilli(), certificate.NotAfter.UnixMilli(), certificate.IssuedAt.UnixMilli())
+}
+
+func (r *sqlCertificateRepository) UpdateCertificateStatus(ctx context.Context, id int64, status string) error {
+	defer metrics.ObserveDbQuery("certificates", "UpdateCertificateStatus", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("UPDATE certificates SET status = ? WHERE id = ?"), status, id)
+	return expectUpdated(res, err)
+}
+
+func (r *sqlCertificateRepository) SupersedeCertificates(ctx context.Context, tenant string, networkId string, certificateType string, keepId int64) error {
+	defer metrics.ObserveDbQuery("certificates", "SupersedeCertificates", time.Now())
+	_, err := r.db.ExecContext(ctx,
+		r.dialect.Rebind("UPDATE certificates SET status = ? WHERE tenant = ? AND networkId = ? AND certificateType = ? AND status = ? AND id <> ?"),
+		dbmodels.CertificateStatus_Superseded, tenant, networkId, certificateType, dbmodels.CertificateStatus_Accepted, keepId)
+	return err
+}
+
+func (r *sqlCertificateRepository) SetRenewalRequested(ctx context.Context, id int64, requestedAt time.Time) error {
+	defer metrics.ObserveDbQuery("certificates", "SetRenewalRequested", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("UPDATE certificates SET renewalRequestedAt = ? WHERE id = ?"), requestedAt.UnixMilli(), id)
+	return expectUpdated(res, err)
+}
+
+func (r *sqlCertificateRepository) ListCertificates(ctx context.Context, filter dbmodels.CertificateFilter) ([]dbmodels.Certificate, error) {
+	defer metrics.ObserveDbQuery("certificates", "ListCertificates", time.Now())
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.Tenant != "" {
+		where = append(where, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+	if filter.NetworkId != "" {
+		where = append(where, "networkId = ?")
+		args = append(args, filter.NetworkId)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.ExpiresBefore != nil {
+		where = append(where, "notAfter < ?")
+		args = append(args, filter.ExpiresBefore.UnixMilli())
+	}
+	if filter.RenewalNotRequested {
+		where = append(where, "renewalRequestedAt IS NULL")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	query := "SELECT " + certificateColumns + " FROM certificates WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY issuedAt DESC, id DESC LIMIT ? OFFSET ?"
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certificates := []dbmodels.Certificate{}
+	for rows.Next() {
+		certificate, err := scanCertificate(rows)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, *certificate)
+	}
+	return certificates, rows.Err()
+}
+
+func scanCertificate(row rowScanner) (*dbmodels.Certificate, error) {
+	var certificate dbmodels.Certificate
+	var notBefore, notAfter, issuedAt int64
+	var renewalRequestedAt sql.NullInt64
+	err := row.Scan(&certificate.Id, &certificate.Tenant, &certificate.NetworkId, &certificate.CertificateType, &certificate.SerialNumber, &certificate.CommonName,
+		&certificate.Status, &certificate.CertificateChain, &notBefore, &notAfter, &issuedAt, &renewalRequestedAt)
+	if err != nil {
+		return nil, err
+	}
+	certificate.NotBefore = time.UnixMilli(notBefore).UTC()
+	certificate.NotAfter = time.UnixMilli(notAfter).UTC()
+	certificate.IssuedAt = time.UnixMilli(issuedAt).UTC()
+	if renewalRequestedAt.Valid {
+		requestedAt := time.UnixMilli(renewalRequestedAt.Int64).UTC()
+		certificate.RenewalRequestedAt = &requestedAt
+	}
+	return &certificate, nil
+}
+
+// Returns ErrNotFound if an update matched no rows
+func expectUpdated(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificates(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.Certificates
+		issuedAt := time.Date(2024, 9, 27, 8, 0, 0, 0, time.UTC)
+
+		newCertificate := func(networkId string, serialNumber string, issuedAt time.Time) *dbmodels.Certificate {
+			return &dbmodels.Certificate{Tenant: "t1", NetworkId: networkId, CertificateType: "ChargePointCertificate", SerialNumber: serialNumber,
+				CommonName: networkId, Status: dbmodels.CertificateStatus_Issued, CertificateChain: "-----BEGIN CERTIFICATE-----",
+				NotBefore: issuedAt, NotAfter: issuedAt.AddDate(1, 0, 0), IssuedAt: issuedAt}
+		}
+		first, err := repo.InsertCertificate(ctx, newCertificate("charger-1", "01", issuedAt))
+		require.NoError(t, err)
+		require.NoError(t, repo.UpdateCertificateStatus(ctx, first, dbmodels.CertificateStatus_Accepted))
+		second, err := repo.InsertCertificate(ctx, newCertificate("charger-1", "02", issuedAt.AddDate(0, 6, 0)))
+		require.NoError(t, err)
+		_, err = repo.InsertCertificate(ctx, newCertificate("charger-2", "03", issuedAt))
+		require.NoError(t, err)
+		otherTenant := newCertificate("charger-1", "04", issuedAt)
+		otherTenant.Tenant = "t2"
+		otherTenant.Status = dbmodels.CertificateStatus_Accepted
+		otherTenantId, err := repo.InsertCertificate(ctx, otherTenant)
+		require.NoError(t, err)
+
+		certificates, err := repo.ListCertificates(ctx, dbmodels.CertificateFilter{Tenant: "t1", NetworkId: "charger-1"})
+		require.NoError(t, err)
+		require.Len(t, certificates, 2)
+		assert.Equal(t, "t1", certificates[0].Tenant)
+		assert.Equal(t, second, certificates[0].Id, "newest first")
+		assert.Equal(t, dbmodels.CertificateStatus_Accepted, certificates[1].Status)
+		assert.Equal(t, issuedAt.AddDate(1, 0, 0), certificates[1].NotAfter)
+		assert.Nil(t, certificates[1].RenewalRequestedAt)
+
+		expiresBefore := issuedAt.AddDate(1, 1, 0)
+		expiring, err := repo.ListCertificates(ctx, dbmodels.CertificateFilter{Tenant: "t1", Status: dbmodels.CertificateStatus_Accepted,
+			ExpiresBefore: &expiresBefore, RenewalNotRequested: true})
+		require.NoError(t, err)
+		require.Len(t, expiring, 1)
+		assert.Equal(t, first, expiring[0].Id)
+
+		require.NoError(t, repo.SetRenewalRequested(ctx, first, issuedAt.AddDate(0, 11, 0)))
+		expiring, err = repo.ListCertificates(ctx, dbmodels.CertificateFilter{Tenant: "t1", Status: dbmodels.CertificateStatus_Accepted,
+			ExpiresBefore: &expiresBefore, RenewalNotRequested: true})
+		require.NoError(t, err)
+		assert.Empty(t, expiring)
+
+		require.NoError(t, repo.UpdateCertificateStatus(ctx, second, dbmodels.CertificateStatus_Accepted))
+		require.NoError(t, repo.SupersedeCertificates(ctx, "t1", "charger-1", "ChargePointCertificate", second))
+		certificates, err = repo.ListCertificates(ctx, dbmodels.CertificateFilter{Tenant: "t1", NetworkId: "charger-1"})
+		require.NoError(t, err)
+		assert.Equal(t, dbmodels.CertificateStatus_Accepted, certificates[0].Status)
+		assert.Equal(t, dbmodels.CertificateStatus_Superseded, certificates[1].Status)
+		require.NotNil(t, certificates[1].RenewalRequestedAt)
+		assert.Equal(t, issuedAt.AddDate(0, 11, 0), *certificates[1].RenewalRequestedAt)
+		certificates, err = repo.ListCertificates(ctx, dbmodels.CertificateFilter{Tenant: "t2"})
+		require.NoError(t, err)
+		require.Len(t, certificates, 1)
+		assert.Equal(t, otherTenantId, certificates[0].Id)
+		assert.Equal(t, dbmodels.CertificateStatus_Accepted, certificates[0].Status, "other tenant's charger not superseded")
+
+		assert.ErrorIs(t, repo.UpdateCertificateStatus(ctx, 999, dbmodels.CertificateStatus_Rejected), ErrNotFound)
+		assert.ErrorIs(t, repo.SetRenewalRequested(ctx, 999, issuedAt), ErrNotFound)
+	})
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/metrics"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+)
+
+const commandColumns = "id,tenant,owner,networkid,action,msgId,payload,status,response,error,callbackUrl,createdAt,sentAt,completedAt"
+
+type sqlCommandRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r *sqlCommandRepository) InsertCommand(ctx context.Context, command *dbmodels.Command) (int64, error) {
+	defer metrics.ObserveDbQuery("commands", "InsertCommand", time.Now())
+	id, err := r.dialect.InsertReturningId(ctx, r.db,
+		r.dialect.Rebind("INSERT INTO commands(tenant,owner,networkid,action,msgId,payload,status,callbackUrl,createdAt) VALUES (?,?,?,?,?,?,?,?,?)"),
+		command.Tenant, command.Owner, command.NetworkId, command.Action, nullString(command.MsgId), string(command.Payload), command.Status,
+		nullString(command.CallbackUrl), command.CreatedAt.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	command.Id = id
+	return id, nil
+}
+
+func (r *sqlCommandRepository) GetCommand(ctx context.Context, tenant string, id int64) (*dbmodels.Command, error) {
+	defer metrics.ObserveDbQuery("commands", "GetCommand", time.Now())
+	row := r.db.QueryRowContext(ctx, r.dialect.Rebind("SELECT "+commandColumns+" FROM commands WHERE tenant = ? AND id = ?"), tenant, id)
+
+	command, err := scanCommand(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return command, err
+}
+
+func (r *sqlCommandRepository) UpdateCommand(ctx context.Context, command *dbmodels.Command) error {
+	defer metrics.ObserveDbQuery("commands", "UpdateCommand", time.Now())
+	res, err := r.db.ExecContext(ctx,
+		r.dialect.Rebind("UPDATE commands SET msgId = ?, status = ?, response = ?, error = ?, sentAt = ?, completedAt = ? WHERE tenant = ? AND id = ?"),
+		nullString(command.MsgId), command.Status, nullString(string(command.Response)), nullString(command.Error), nullMillis(command.SentAt),
+		nullMillis(command.CompletedAt), command.Tenant, command.Id)
+	return expectUpdated(res, err)
+}
+
+func (r *sqlCommandRepository) InterruptCommands(ctx context.Context, tenant string, owner string, interruptedAt time.Time) (int64, error) {
+	defer metrics.ObserveDbQuery("commands", "InterruptCommands", time.Now())
+	res, err := r.db.ExecContext(ctx,
+		r.dialect.Rebind("UPDATE commands SET status = ?, error = ?, completedAt = ? WHERE tenant = ? AND owner = ? AND status IN (?, ?)"),
+		dbmodels.CommandStatus_Error, "interrupted by restart", interruptedAt.UnixMilli(), tenant, owner, dbmodels.CommandStatus_Queued,
+		dbmodels.CommandStatus_Sent)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *sqlCommandRepository) ExpireCommands(ctx context.Context, tenant string, createdBefore time.Time, expiredAt time.Time) (int64, error) {
+	defer metrics.ObserveDbQuery("commands", "ExpireCommands", time.Now())
+	res, err := r.db.ExecContext(ctx,
+		r.dialect.Rebind("UPDATE commands SET status = ?, error = ?, completedAt = ? WHERE tenant = ? AND createdAt < ? AND status IN (?, ?)"),
+		dbmodels.CommandStatus_Error, "expired, no longer awaited", expiredAt.UnixMilli(), tenant, createdBefore.UnixMilli(),
+		dbmodels.CommandStatus_Queued, dbmodels.CommandStatus_Sent)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *sqlCommandRepository) ListCommands(ctx context.Context, filter dbmodels.CommandFilter) ([]dbmodels.Command, error) {
+	defer metrics.ObserveDbQuery("commands", "ListCommands", time.Now())
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.Tenant != "" {
+		where = append(where, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+	if filter.NetworkId != "" {
+		where = append(where, "networkid = ?")
+		args = append(args, filter.NetworkId)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	query := "SELECT " + commandColumns + " FROM commands WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY id DESC LIMIT ? OFFSET ?"
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	commands := []dbmodels.Command{}
+	for rows.Next() {
+		command, err := scanCommand(rows)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, *command)
+	}
+	return commands, rows.Err()
+}
+
+func scanCommand(row rowScanner) (*dbmodels.Command, error) {
+	var command dbmodels.Command
+	var msgId, response, commandErr, callbackUrl sql.NullString
+	var payload string
+	var createdAt int64
+	var sentAt, completedAt sql.NullInt64
+	err := row.Scan(&command.Id, &command.Tenant, &command.Owner, &command.NetworkId, &command.Action, &msgId, &payload, &command.Status, &response,
+		&commandErr, &callbackUrl, &createdAt, &sentAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+	command.MsgId = msgId.String
+	command.Payload = json.RawMessage(payload)
+	if response.Valid {
+		command.Response = json.RawMessage(response.String)
+	}
+	command.Error = commandErr.String
+	command.CallbackUrl = callbackUrl.String
+	command.CreatedAt = time.UnixMilli(createdAt).UTC()
+	command.SentAt = nullTime(sentAt)
+	command.CompletedAt = nullTime(completedAt)
+	return &command, nil
+}
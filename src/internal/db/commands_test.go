@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommands(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.Commands
+		createdAt := time.Date(2024, 10, 1, 8, 0, 0, 0, time.UTC)
+
+		command := &dbmodels.Command{Owner: "node-1", NetworkId: "charger-1", Action: "Reset", Payload: json.RawMessage(`{"type":"Soft"}`),
+			Status: dbmodels.CommandStatus_Queued, CallbackUrl: "https://example.com/hook", CreatedAt: createdAt}
+		id, err := repo.InsertCommand(ctx, command)
+		require.NoError(t, err)
+		_, err = repo.InsertCommand(ctx, &dbmodels.Command{Owner: "node-1", NetworkId: "charger-2", Action: "Reset", Payload: json.RawMessage(`{}`),
+			Status: dbmodels.CommandStatus_Sent, CreatedAt: createdAt})
+		require.NoError(t, err)
+
+		got, err := repo.GetCommand(ctx, "", id)
+		require.NoError(t, err)
+		assert.Equal(t, command, got)
+		_, err = repo.GetCommand(ctx, "t2", id)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		sentAt, completedAt := createdAt.Add(time.Second), createdAt.Add(2*time.Second)
+		command.MsgId, command.Status, command.SentAt = "msg-1", dbmodels.CommandStatus_Accepted, &sentAt
+		command.Response, command.CompletedAt = json.RawMessage(`{"status":"Accepted"}`), &completedAt
+		require.NoError(t, repo.UpdateCommand(ctx, command))
+		got, err = repo.GetCommand(ctx, "", id)
+		require.NoError(t, err)
+		assert.Equal(t, command, got)
+		assert.ErrorIs(t, repo.UpdateCommand(ctx, &dbmodels.Command{Id: 99}), ErrNotFound)
+
+		interrupted, err := repo.InterruptCommands(ctx, "", "node-2", completedAt)
+		require.NoError(t, err)
+		assert.Zero(t, interrupted, "another instance's commands are still awaited")
+		interrupted, err = repo.InterruptCommands(ctx, "t2", "node-1", completedAt)
+		require.NoError(t, err)
+		assert.Zero(t, interrupted, "other tenant")
+		interrupted, err = repo.InterruptCommands(ctx, "", "node-1", completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), interrupted)
+
+		otherOwner, err := repo.InsertCommand(ctx, &dbmodels.Command{Owner: "node-2", NetworkId: "charger-3", Action: "Reset", Payload: json.RawMessage(`{}`),
+			Status: dbmodels.CommandStatus_Sent, CreatedAt: createdAt.Add(time.Minute)})
+		require.NoError(t, err)
+		expired, err := repo.ExpireCommands(ctx, "", createdAt.Add(time.Minute), completedAt)
+		require.NoError(t, err)
+		assert.Zero(t, expired, "created at the cutoff")
+		expired, err = repo.ExpireCommands(ctx, "t2", createdAt.Add(2*time.Minute), completedAt)
+		require.NoError(t, err)
+		assert.Zero(t, expired, "other tenant")
+		expired, err = repo.ExpireCommands(ctx, "", createdAt.Add(2*time.Minute), completedAt)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), expired, "another instance's command, which is no longer awaited")
+		got, err = repo.GetCommand(ctx, "", otherOwner)
+		require.NoError(t, err)
+		assert.Equal(t, dbmodels.CommandStatus_Error, got.Status)
+		assert.Equal(t, &completedAt, got.CompletedAt)
+
+		commands, err := repo.ListCommands(ctx, dbmodels.CommandFilter{})
+		require.NoError(t, err)
+		require.Len(t, commands, 3)
+		assert.Equal(t, "charger-3", commands[0].NetworkId, "newest first")
+		assert.Equal(t, dbmodels.CommandStatus_Error, commands[1].Status)
+		assert.NotEmpty(t, commands[1].Error)
+		commands, err = repo.ListCommands(ctx, dbmodels.CommandFilter{NetworkId: "charger-1", Status: dbmodels.CommandStatus_Accepted})
+		require.NoError(t, err)
+		require.Len(t, commands, 1)
+		assert.Equal(t, id, commands[0].Id)
+	})
+}
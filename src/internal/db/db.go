@@ -1,114 +1,127 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
 
+	conf "sw/ocpp/csms/internal/config"
 	log "sw/ocpp/csms/internal/logging"
 
-	"github.com/google/uuid"
 	_ "github.com/lib/pq"
-	"github.com/mattn/go-sqlite3"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	DefaultListLimit           = 100
+	DefaultDeleteLimit         = 10000
+	DefaultMaxOpenConns        = 5
+	DefaultMaxIdleConns        = 5
+	DefaultConnMaxLifetimeSecs = 120
 )
 
 var (
-	//ctx context.Context
-	db *sql.DB
+	ErrNotFound  = errors.New("not found")
+	ErrRowExists = errors.New("row already exists")
 )
 
-func ConnectDb(dbType string, connStr string) error {
-	var err error
+// Store holds the DB connection pool and the repositories using it
+type Store struct {
+	db      *sql.DB
+	dialect dialect
+
+	Devices      DeviceRepository
+	Transactions TransactionRepository
+	Messages     MessageRepository
+	DeadLetters  DeadLetterRepository
+	Certificates CertificateRepository
+	Templates    DeviceTemplateRepository
+	Compliance   DeviceComplianceRepository
+	BulkJobs     BulkJobRepository
+	Commands     CommandRepository
+}
 
-	db, err = sql.Open(dbType, connStr)
+// Opens and pings the configured DB, applying pool settings from config or defaults
+func Open(config conf.DbConfig) (*Store, error) {
+	dialect, err := dialectFor(config.DbType)
+	if err != nil {
+		return nil, err
+	}
 
+	db, err := sql.Open(dialect.Name(), config.DbConnectionString)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err = db.Ping(); err != nil {
-		return err
+		db.Close()
+		return nil, err
+	}
+	db.SetConnMaxLifetime(time.Duration(valueOrDefault(config.ConnMaxLifetimeSecs, DefaultConnMaxLifetimeSecs)) * time.Second)
+	db.SetMaxOpenConns(valueOrDefault(config.MaxOpenConns, DefaultMaxOpenConns))
+	db.SetMaxIdleConns(valueOrDefault(config.MaxIdleConns, DefaultMaxIdleConns))
+	if config.ConnMaxIdleTimeSecs > 0 {
+		db.SetConnMaxIdleTime(time.Duration(config.ConnMaxIdleTimeSecs) * time.Second)
 	}
-	db.SetConnMaxLifetime(time.Minute * 2)
-	db.SetMaxOpenConns(5)
-	db.SetMaxIdleConns(5)
 
-	log.Logger.Info("Connected to: " + dbType)
-	return nil
+	log.Logger.Info("Connected to: " + config.DbType)
+	return newStore(db, dialect), nil
 }
 
-func Disconnect() {
-	if db != nil {
-		db.Close()
+func newStore(db *sql.DB, dialect dialect) *Store {
+	return &Store{
+		db:           db,
+		dialect:      dialect,
+		Devices:      &sqlDeviceRepository{db: db, dialect: dialect},
+		Transactions: &sqlTransactionRepository{db: db, dialect: dialect},
+		Messages:     &sqlMessageRepository{db: db, dialect: dialect},
+		DeadLetters:  &sqlDeadLetterRepository{db: db, dialect: dialect},
+		Certificates: &sqlCertificateRepository{db: db, dialect: dialect},
+		Templates:    &sqlDeviceTemplateRepository{db: db, dialect: dialect},
+		Compliance:   &sqlDeviceComplianceRepository{db: db, dialect: dialect},
+		BulkJobs:     &sqlBulkJobRepository{db: db, dialect: dialect},
+		Commands:     &sqlCommandRepository{db: db, dialect: dialect},
 	}
 }
 
-func CreateDeviceTables() error {
-	sql := `
-	CREATE TABLE IF NOT EXISTS devices(
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		tenant TEXT NOT NULL,
-		guid TEXT NOT NULL,
-		networkid TEXT NOT NULL,
-		devicetemplateid INTEGER NOT NULL
-	);
-	`
-	_, err := db.Exec(sql)
-	if err != nil {
-		return err
-	}
-
-	sql = `CREATE INDEX IF NOT EXISTS devices_tenant_networkid_IDX ON devices (tenant, networkid);`
-	_, err = db.Exec(sql)
-	if err != nil {
-		return err
-	}
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
 
-	return nil
+func (s *Store) Close() error {
+	return s.db.Close()
 }
 
-func CreateTables() error {
-	sql := `
-	CREATE TABLE IF NOT EXISTS transactions (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		guid TEXT NOT NULL,
-		clientId TEXT NOT NULL,
-		timeStarted INTEGER NOT NULL,
-		timeEnded INTEGER NULL,
-		meterStop FLOAT NULL
-	);
-	`
-	_, err := db.Exec(sql)
-	if err != nil {
-		return err
+func valueOrDefault(value int, defaultValue int) int {
+	if value > 0 {
+		return value
 	}
+	return defaultValue
+}
 
-	sql = `CREATE INDEX IF NOT EXISTS transaction_clientId_IDX ON transactions (clientId);`
-	_, err = db.Exec(sql)
-	if err != nil {
-		return err
-	}
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
-	return nil
+// NULL for an empty string, for optional text columns
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
 
-// Transaction: Id, Guid, ClientId, TimeStarted, TimeEnded, MeterStop
-func InsertNextTransaction(clientId string, timeStarted time.Time) (*int64, error) {
-	guid := uuid.New().String()
-	res, err := db.Exec("INSERT INTO transactions(guid,clientId,timeStarted) VALUES (?,?,?) ", guid, clientId, timeStarted.UnixMilli())
-	if err != nil {
-		var sqliteErr sqlite3.Error
-		if errors.As(err, &sqliteErr) {
-			if errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintUnique) {
-				return nil, errors.New("row already exists")
-			}
-		}
-		return nil, err
+// Writes an optional UnixMilli column
+func nullMillis(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
 	}
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: true}
+}
 
-	id, err := res.LastInsertId()
-	if err != nil {
-		return nil, err
+// Reads an optional UnixMilli column
+func nullTime(ms sql.NullInt64) *time.Time {
+	if !ms.Valid {
+		return nil
 	}
-	return &id, nil
+	t := time.UnixMilli(ms.Int64).UTC()
+	return &t
 }
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/metrics"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+)
+
+const deadLetterColumns = "id,networkId,serverNode,remoteAddr,reason,error,frame,frameEncoding,messageTime"
+
+type sqlDeadLetterRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r *sqlDeadLetterRepository) InsertDeadLetter(ctx context.Context, deadLetter *dbmodels.DeadLetter) (int64, error) {
+	defer metrics.ObserveDbQuery("dead_letters", "InsertDeadLetter", time.Now())
+	return r.dialect.InsertReturningId(ctx, r.db,
+		r.dialect.Rebind("INSERT INTO dead_letters(networkId,serverNode,remoteAddr,reason,error,frame,frameEncoding,messageTime) VALUES (?,?,?,?,?,?,?,?)"),
+		deadLetter.NetworkId, deadLetter.ServerNode, deadLetter.RemoteAddr, deadLetter.Reason, deadLetter.Error,
+		deadLetter.Frame, deadLetter.FrameEncoding, deadLetter.MessageTime.UnixMilli())
+}
+
+func (r *sqlDeadLetterRepository) GetDeadLetter(ctx context.Context, id int64) (*dbmodels.DeadLetter, error) {
+	defer metrics.ObserveDbQuery("dead_letters", "GetDeadLetter", time.Now())
+	row := r.db.QueryRowContext(ctx, r.dialect.Rebind("SELECT "+deadLetterColumns+" FROM dead_letters WHERE id = ?"), id)
+
+	deadLetter, err := scanDeadLetter(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return deadLetter, err
+}
+
+func (r *sqlDeadLetterRepository) ListDeadLetters(ctx context.Context, filter dbmodels.DeadLetterFilter) ([]dbmodels.DeadLetter, error) {
+	defer metrics.ObserveDbQuery("dead_letters", "ListDeadLetters", time.Now())
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.NetworkId != "" {
+		where = append(where, "networkId = ?")
+		args = append(args, filter.NetworkId)
+	}
+	if filter.Reason != "" {
+		where = append(where, "reason = ?")
+		args = append(args, filter.Reason)
+	}
+	if filter.From != nil {
+		where = append(where, "messageTime >= ?")
+		args = append(args, filter.From.UnixMilli())
+	}
+	if filter.To != nil {
+		where = append(where, "messageTime < ?")
+		args = append(args, filter.To.UnixMilli())
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	query := "SELECT " + deadLetterColumns + " FROM dead_letters WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY messageTime DESC, id DESC LIMIT ? OFFSET ?"
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deadLetters := []dbmodels.DeadLetter{}
+	for rows.Next() {
+		deadLetter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, *deadLetter)
+	}
+	return deadLetters, rows.Err()
+}
+
+func (r *sqlDeadLetterRepository) DeleteDeadLetter(ctx context.Context, id int64) error {
+	defer metrics.ObserveDbQuery("dead_letters", "DeleteDeadLetter", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("DELETE FROM dead_letters WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func scanDeadLetter(row rowScanner) (*dbmodels.DeadLetter, error) {
+	var deadLetter dbmodels.DeadLetter
+	var messageTime int64
+	err := row.Scan(&deadLetter.Id, &deadLetter.NetworkId, &deadLetter.ServerNode, &deadLetter.RemoteAddr, &deadLetter.Reason,
+		&deadLetter.Error, &deadLetter.Frame, &deadLetter.FrameEncoding, &messageTime)
+	if err != nil {
+		return nil, err
+	}
+	deadLetter.MessageTime = time.UnixMilli(messageTime).UTC()
+	return &deadLetter, nil
+}
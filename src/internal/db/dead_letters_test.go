@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetters(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.DeadLetters
+		messageTime := time.Date(2024, 9, 27, 8, 0, 0, 0, time.UTC)
+
+		id, err := repo.InsertDeadLetter(ctx, &dbmodels.DeadLetter{NetworkId: "charger-1", ServerNode: "node1", RemoteAddr: "10.0.0.1:5000",
+			Reason: "malformed", Error: "invalid character", Frame: "[2,", FrameEncoding: "utf8", MessageTime: messageTime})
+		require.NoError(t, err)
+		_, err = repo.InsertDeadLetter(ctx, &dbmodels.DeadLetter{NetworkId: "charger-1", ServerNode: "node1",
+			Reason: "schema_invalid", Error: "unknown action: Foo", Frame: `[2,"1","Foo",{}]`, FrameEncoding: "utf8", MessageTime: messageTime.Add(time.Minute)})
+		require.NoError(t, err)
+		_, err = repo.InsertDeadLetter(ctx, &dbmodels.DeadLetter{NetworkId: "charger-2", ServerNode: "node1",
+			Reason: "malformed", Error: "invalid character", Frame: "AAE=", FrameEncoding: "base64", MessageTime: messageTime.Add(2 * time.Minute)})
+		require.NoError(t, err)
+
+		deadLetter, err := repo.GetDeadLetter(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "[2,", deadLetter.Frame)
+		assert.Equal(t, "10.0.0.1:5000", deadLetter.RemoteAddr)
+		assert.Equal(t, messageTime, deadLetter.MessageTime)
+
+		deadLetters, err := repo.ListDeadLetters(ctx, dbmodels.DeadLetterFilter{NetworkId: "charger-1"})
+		require.NoError(t, err)
+		require.Len(t, deadLetters, 2)
+		assert.Equal(t, "schema_invalid", deadLetters[0].Reason, "newest first")
+
+		deadLetters, err = repo.ListDeadLetters(ctx, dbmodels.DeadLetterFilter{Reason: "malformed", Limit: 1, Offset: 1})
+		require.NoError(t, err)
+		require.Len(t, deadLetters, 1)
+		assert.Equal(t, id, deadLetters[0].Id)
+
+		require.NoError(t, repo.DeleteDeadLetter(ctx, id))
+		_, err = repo.GetDeadLetter(ctx, id)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, repo.DeleteDeadLetter(ctx, id), ErrNotFound)
+	})
+}
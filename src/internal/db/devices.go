@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/metrics"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/google/uuid"
+)
+
+const deviceColumns = "id,tenant,guid,networkid,devicetemplateid,passwordHash,pendingPasswordHash,disabled,firmwareVersion,tags"
+
+type sqlDeviceRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r *sqlDeviceRepository) InsertDevice(ctx context.Context, device *dbmodels.Device) (int64, error) {
+	defer metrics.ObserveDbQuery("devices", "InsertDevice", time.Now())
+	if device.Guid == "" {
+		device.Guid = uuid.New().String()
+	}
+
+	id, err := r.dialect.InsertReturningId(ctx, r.db, r.dialect.Rebind("INSERT INTO devices(tenant,guid,networkid,devicetemplateid,passwordHash,pendingPasswordHash,disabled,firmwareVersion,tags) VALUES (?,?,?,?,?,?,?,?,?)"),
+		device.Tenant, device.Guid, device.NetworkId, device.DeviceTemplateId, nullString(device.PasswordHash), nullString(device.PendingPasswordHash), device.Disabled,
+		nullString(device.FirmwareVersion), joinTags(device.Tags))
+	if err != nil {
+		if r.dialect.IsUniqueViolation(err) {
+			return 0, ErrRowExists
+		}
+		return 0, err
+	}
+	device.Id = id
+	return id, nil
+}
+
+func (r *sqlDeviceRepository) GetDevice(ctx context.Context, tenant string, networkId string) (*dbmodels.Device, error) {
+	defer metrics.ObserveDbQuery("devices", "GetDevice", time.Now())
+	row := r.db.QueryRowContext(ctx, r.dialect.Rebind("SELECT "+deviceColumns+" FROM devices WHERE tenant = ? AND networkid = ?"), tenant, networkId)
+
+	device, err := scanDevice(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return device, err
+}
+
+func (r *sqlDeviceRepository) ModifyDevice(ctx context.Context, tenant string, networkId string, modify func(device *dbmodels.Device)) (*dbmodels.Device, error) {
+	defer metrics.ObserveDbQuery("devices", "ModifyDevice", time.Now())
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, r.dialect.Rebind("SELECT "+deviceColumns+" FROM devices WHERE tenant = ? AND networkid = ?"+r.dialect.ForUpdate()),
+		tenant, networkId)
+	device, err := scanDevice(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	modify(device)
+	_, err = tx.ExecContext(ctx, r.dialect.Rebind("UPDATE devices SET devicetemplateid = ?, passwordHash = ?, pendingPasswordHash = ?, disabled = ?, tags = ? WHERE id = ?"),
+		device.DeviceTemplateId, nullString(device.PasswordHash), nullString(device.PendingPasswordHash), device.Disabled, joinTags(device.Tags), device.Id)
+	if err != nil {
+		return nil, err
+	}
+	return device, tx.Commit()
+}
+
+func (r *sqlDeviceRepository) SetFirmwareVersion(ctx context.Context, tenant string, networkId string, version string) error {
+	defer metrics.ObserveDbQuery("devices", "SetFirmwareVersion", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("UPDATE devices SET firmwareVersion = ? WHERE tenant = ? AND networkid = ?"),
+		nullString(version), tenant, networkId)
+	return expectUpdated(res, err)
+}
+
+func (r *sqlDeviceRepository) DeleteDevice(ctx context.Context, tenant string, networkId string) error {
+	defer metrics.ObserveDbQuery("devices", "DeleteDevice", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("DELETE FROM devices WHERE tenant = ? AND networkid = ?"), tenant, networkId)
+	return expectUpdated(res, err)
+}
+
+func (r *sqlDeviceRepository) ListDevices(ctx context.Context, filter dbmodels.DeviceFilter) ([]dbmodels.Device, error) {
+	defer metrics.ObserveDbQuery("devices", "ListDevices", time.Now())
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.Tenant != "" {
+		where = append(where, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+	if filter.NetworkIdPrefix != "" {
+		// rather than LIKE, which is case insensitive in sqlite and needs wildcards escaping
+		where = append(where, "substr(networkid, 1, ?) = ?")
+		args = append(args, len(filter.NetworkIdPrefix), filter.NetworkIdPrefix)
+	}
+	if filter.DeviceTemplateId != 0 {
+		where = append(where, "devicetemplateid = ?")
+		args = append(args, filter.DeviceTemplateId)
+	}
+	if filter.Disabled != nil {
+		where = append(where, "disabled = ?")
+		args = append(args, *filter.Disabled)
+	}
+	if filter.FirmwareVersion != "" {
+		where = append(where, "firmwareVersion = ?")
+		args = append(args, filter.FirmwareVersion)
+	}
+	if filter.Tag != "" {
+		// tags are validated to contain no LIKE wildcards
+		where = append(where, "tags LIKE ?")
+		args = append(args, "%,"+filter.Tag+",%")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	query := "SELECT " + deviceColumns + " FROM devices WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY " + r.dialect.OrderText("networkid") + " LIMIT ? OFFSET ?"
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []dbmodels.Device{}
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, *device)
+	}
+	return devices, rows.Err()
+}
+
+func scanDevice(row rowScanner) (*dbmodels.Device, error) {
+	var device dbmodels.Device
+	var passwordHash, pendingPasswordHash, firmwareVersion, tags sql.NullString
+	err := row.Scan(&device.Id, &device.Tenant, &device.Guid, &device.NetworkId, &device.DeviceTemplateId, &passwordHash, &pendingPasswordHash,
+		&device.Disabled, &firmwareVersion, &tags)
+	if err != nil {
+		return nil, err
+	}
+	device.PasswordHash = passwordHash.String
+	device.PendingPasswordHash = pendingPasswordHash.String
+	device.FirmwareVersion = firmwareVersion.String
+	device.Tags = splitTags(tags.String)
+	return &device, nil
+}
+
+// Tags are stored with leading and trailing commas so a single tag can be matched with LIKE
+func joinTags(tags []string) sql.NullString {
+	if len(tags) == 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: "," + strings.Join(tags, ",") + ",", Valid: true}
+}
+
+func splitTags(tags string) []string {
+	tags = strings.Trim(tags, ",")
+	if tags == "" {
+		return []string{}
+	}
+	return strings.Split(tags, ",")
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevices(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.Devices
+
+		id, err := repo.InsertDevice(ctx, &dbmodels.Device{Tenant: "t1", NetworkId: "charger-b", DeviceTemplateId: 1, PasswordHash: "hash", Disabled: true})
+		require.NoError(t, err)
+		_, err = repo.InsertDevice(ctx, &dbmodels.Device{Tenant: "t1", NetworkId: "charger-a"})
+		require.NoError(t, err)
+		_, err = repo.InsertDevice(ctx, &dbmodels.Device{Tenant: "t2", NetworkId: "charger-a"})
+		require.NoError(t, err)
+
+		_, err = repo.InsertDevice(ctx, &dbmodels.Device{Tenant: "t1", NetworkId: "charger-b"})
+		assert.ErrorIs(t, err, ErrRowExists)
+
+		device, err := repo.GetDevice(ctx, "t1", "charger-b")
+		require.NoError(t, err)
+		assert.Equal(t, id, device.Id)
+		assert.Equal(t, int64(1), device.DeviceTemplateId)
+		assert.NotEmpty(t, device.Guid)
+		assert.Equal(t, "hash", device.PasswordHash)
+		assert.True(t, device.Disabled)
+
+		_, err = repo.GetDevice(ctx, "t2", "charger-b")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		devices, err := repo.ListDevices(ctx, dbmodels.DeviceFilter{Tenant: "t1"})
+		require.NoError(t, err)
+		require.Len(t, devices, 2)
+		assert.Equal(t, "charger-a", devices[0].NetworkId)
+		assert.Empty(t, devices[0].PasswordHash)
+		assert.False(t, devices[0].Disabled)
+		assert.Equal(t, "charger-b", devices[1].NetworkId)
+
+		modified, err := repo.ModifyDevice(ctx, "t1", "charger-b", func(device *dbmodels.Device) {
+			assert.Equal(t, "hash", device.PasswordHash, "current record")
+			device.PasswordHash = ""
+			device.PendingPasswordHash = "pending"
+			device.Disabled = false
+			device.DeviceTemplateId = 2
+			device.Tags = []string{"site-1", "ac"}
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), modified.DeviceTemplateId)
+		require.NoError(t, repo.SetFirmwareVersion(ctx, "t1", "charger-b", "1.2.3"))
+		assert.ErrorIs(t, repo.SetFirmwareVersion(ctx, "t2", "charger-b", "1.2.3"), ErrNotFound)
+		device, err = repo.GetDevice(ctx, "t1", "charger-b")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), device.DeviceTemplateId)
+		assert.Equal(t, []string{"site-1", "ac"}, device.Tags)
+		assert.Equal(t, "1.2.3", device.FirmwareVersion)
+		assert.Empty(t, device.PasswordHash)
+		assert.Equal(t, "pending", device.PendingPasswordHash)
+		assert.False(t, device.Disabled)
+		_, err = repo.ModifyDevice(ctx, "t2", "charger-b", func(device *dbmodels.Device) {})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, repo.DeleteDevice(ctx, "t1", "charger-b"))
+		_, err = repo.GetDevice(ctx, "t1", "charger-b")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, repo.DeleteDevice(ctx, "t1", "charger-b"), ErrNotFound)
+	})
+}
+
+func TestListDevicesFilter(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.Devices
+		for _, device := range []dbmodels.Device{
+			{NetworkId: "site1-a", DeviceTemplateId: 1, FirmwareVersion: "1.0", Tags: []string{"ac", "fleet-1"}},
+			{NetworkId: "site1-b", DeviceTemplateId: 2, Disabled: true, Tags: []string{"fleet-10"}},
+			{NetworkId: "SITE1-c", DeviceTemplateId: 1, FirmwareVersion: "1.0.1"},
+			{NetworkId: "site2-a", DeviceTemplateId: 1, FirmwareVersion: "1.0", Tags: []string{"fleet-1"}},
+		} {
+			_, err := repo.InsertDevice(ctx, &device)
+			require.NoError(t, err)
+		}
+		networkIds := func(filter dbmodels.DeviceFilter) []string {
+			devices, err := repo.ListDevices(ctx, filter)
+			require.NoError(t, err)
+			ids := []string{}
+			for _, device := range devices {
+				ids = append(ids, device.NetworkId)
+			}
+			return ids
+		}
+		disabled, enabled := true, false
+
+		assert.Equal(t, []string{"site1-a", "site1-b"}, networkIds(dbmodels.DeviceFilter{NetworkIdPrefix: "site1"}), "case sensitive")
+		assert.Equal(t, []string{"SITE1-c", "site1-a", "site2-a"}, networkIds(dbmodels.DeviceFilter{DeviceTemplateId: 1}), "byte-wise, whatever the locale")
+		assert.Equal(t, []string{"site1-b"}, networkIds(dbmodels.DeviceFilter{Disabled: &disabled}))
+		assert.Equal(t, []string{"site1-a"}, networkIds(dbmodels.DeviceFilter{NetworkIdPrefix: "site1", Disabled: &enabled}))
+		assert.Equal(t, []string{"site1-a", "site1-b"}, networkIds(dbmodels.DeviceFilter{Limit: 2, Offset: 1}))
+		assert.Equal(t, []string{"site1-a", "site2-a"}, networkIds(dbmodels.DeviceFilter{FirmwareVersion: "1.0"}))
+		assert.Equal(t, []string{"site1-a", "site2-a"}, networkIds(dbmodels.DeviceFilter{Tag: "fleet-1"}), "whole tags only")
+		assert.Equal(t, []string{"site2-a"}, networkIds(dbmodels.DeviceFilter{Tag: "fleet-1", NetworkIdPrefix: "site2"}))
+	})
+}
@@ -0,0 +1,162 @@
+// Provides the SQL differences between the supported DB types
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	DbType_Sqlite   = "sqlite3"
+	DbType_Postgres = "postgres"
+)
+
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type dialect interface {
+	// Name of the database/sql driver, also the migrations directory
+	Name() string
+	// Rewrites ? placeholders to the driver's placeholder syntax
+	Rebind(query string) string
+	// Runs an INSERT, returning the id of the inserted row
+	InsertReturningId(ctx context.Context, q queryer, query string, args ...any) (int64, error)
+	// Whether the error is a unique constraint violation
+	IsUniqueViolation(err error) bool
+	// Orders by a text column byte-wise, so both DB types list in the same order whatever their locale
+	OrderText(column string) string
+	// Whether the table has the column
+	ColumnExists(ctx context.Context, q queryer, table string, column string) (bool, error)
+	// Suffix of a SELECT in a transaction, locking the rows read until it ends
+	ForUpdate() string
+}
+
+func dialectFor(dbType string) (dialect, error) {
+	switch dbType {
+	case DbType_Sqlite:
+		return sqliteDialect{}, nil
+	case DbType_Postgres:
+		return postgresDialect{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported db type: %s", dbType)
+	}
+}
+
+// --- sqlite ---
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return DbType_Sqlite
+}
+
+func (sqliteDialect) Rebind(query string) string {
+	return query
+}
+
+func (sqliteDialect) InsertReturningId(ctx context.Context, q queryer, query string, args ...any) (int64, error) {
+	res, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (sqliteDialect) IsUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintUnique) ||
+			errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintPrimaryKey)
+	}
+	return false
+}
+
+// sqlite's default BINARY collation is byte-wise
+func (sqliteDialect) OrderText(column string) string {
+	return column
+}
+
+// sqlite locks the whole database for writes, so a transaction's read then write can't interleave with another's,
+// one of them fails as busy instead
+func (sqliteDialect) ForUpdate() string {
+	return ""
+}
+
+func (sqliteDialect) ColumnExists(ctx context.Context, q queryer, table string, column string) (bool, error) {
+	var count int
+	err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ? COLLATE NOCASE", table, column).Scan(&count)
+	return count > 0, err
+}
+
+// --- postgres ---
+
+type postgresDialect struct{}
+
+const pqUniqueViolation = "23505"
+
+func (postgresDialect) Name() string {
+	return DbType_Postgres
+}
+
+// Rewrites ? to $1, $2... ignoring any ? within quoted strings
+func (postgresDialect) Rebind(query string) string {
+	var sb strings.Builder
+	sb.Grow(len(query) + 8)
+
+	n := 0
+	inQuote := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			inQuote = !inQuote
+			sb.WriteRune(c)
+		case c == '?' && !inQuote:
+			n++
+			sb.WriteByte('$')
+			sb.WriteString(strconv.Itoa(n))
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+// pq does not support LastInsertId, so the id is returned by the INSERT itself
+func (d postgresDialect) InsertReturningId(ctx context.Context, q queryer, query string, args ...any) (int64, error) {
+	var id int64
+	err := q.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqUniqueViolation
+	}
+	return false
+}
+
+func (postgresDialect) OrderText(column string) string {
+	return column + ` COLLATE "C"`
+}
+
+func (postgresDialect) ForUpdate() string {
+	return " FOR UPDATE"
+}
+
+// Unquoted identifiers are stored lowercased
+func (postgresDialect) ColumnExists(ctx context.Context, q queryer, table string, column string) (bool, error) {
+	var count int
+	err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = lower($1) AND column_name = lower($2)`, table, column).Scan(&count)
+	return count > 0, err
+}
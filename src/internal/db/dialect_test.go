@@ -0,0 +1,38 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresRebind(t *testing.T) {
+	d := postgresDialect{}
+
+	assert.Equal(t, "SELECT 1", d.Rebind("SELECT 1"))
+	assert.Equal(t, "UPDATE t SET a = $1 WHERE b = $2 AND c = '?'", d.Rebind("UPDATE t SET a = ? WHERE b = ? AND c = '?'"))
+	assert.Equal(t, "INSERT INTO t(a,b) VALUES ($1,$2)", d.Rebind("INSERT INTO t(a,b) VALUES (?,?)"))
+}
+
+func TestOrderText(t *testing.T) {
+	assert.Equal(t, "networkid", sqliteDialect{}.OrderText("networkid"))
+	assert.Equal(t, `networkid COLLATE "C"`, postgresDialect{}.OrderText("networkid"))
+}
+
+func TestForUpdate(t *testing.T) {
+	assert.Empty(t, sqliteDialect{}.ForUpdate())
+	assert.Equal(t, " FOR UPDATE", postgresDialect{}.ForUpdate())
+}
+
+func TestDialectFor(t *testing.T) {
+	d, err := dialectFor(DbType_Sqlite)
+	assert.NoError(t, err)
+	assert.Equal(t, DbType_Sqlite, d.Name())
+
+	d, err = dialectFor(DbType_Postgres)
+	assert.NoError(t, err)
+	assert.Equal(t, DbType_Postgres, d.Name())
+
+	_, err = dialectFor("mysql")
+	assert.Error(t, err)
+}
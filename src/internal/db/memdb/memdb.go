@@ -0,0 +1,827 @@
+// Provides in-memory implementations of the db repositories, for unit tests
+package memdb
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	db "sw/ocpp/csms/internal/db"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/google/uuid"
+)
+
+var (
+	_ db.TransactionRepository      = (*TransactionRepository)(nil)
+	_ db.DeviceRepository           = (*DeviceRepository)(nil)
+	_ db.DeadLetterRepository       = (*DeadLetterRepository)(nil)
+	_ db.CertificateRepository      = (*CertificateRepository)(nil)
+	_ db.DeviceTemplateRepository   = (*DeviceTemplateRepository)(nil)
+	_ db.DeviceComplianceRepository = (*DeviceComplianceRepository)(nil)
+	_ db.BulkJobRepository          = (*BulkJobRepository)(nil)
+	_ db.CommandRepository          = (*CommandRepository)(nil)
+)
+
+type TransactionRepository struct {
+	mu           sync.Mutex
+	lastId       int64
+	transactions []dbmodels.Transaction
+}
+
+func NewTransactionRepository() *TransactionRepository {
+	return &TransactionRepository{}
+}
+
+func (r *TransactionRepository) InsertNextTransaction(ctx context.Context, transaction *dbmodels.Transaction) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.transactions {
+		t := &r.transactions[i]
+		if t.ClientId == transaction.ClientId && t.ConnectorId == transaction.ConnectorId && t.Status == dbmodels.TransactionStatus_Active {
+			t.Status = dbmodels.TransactionStatus_Orphaned
+		}
+	}
+
+	r.lastId++
+	inserted := *transaction
+	inserted.Id = r.lastId
+	inserted.Guid = uuid.New().String()
+	inserted.Status = dbmodels.TransactionStatus_Active
+	inserted.MeterStop = nil
+	inserted.TimeEnded = nil
+	inserted.StopReason = ""
+	r.transactions = append(r.transactions, inserted)
+	return inserted.Id, nil
+}
+
+func (r *TransactionRepository) CompleteTransaction(ctx context.Context, clientId string, transactionId int64, timeEnded time.Time, meterStop float64, stopReason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.transactions {
+		t := &r.transactions[i]
+		if t.Id == transactionId && t.ClientId == clientId && t.Status == dbmodels.TransactionStatus_Active {
+			t.TimeEnded = &timeEnded
+			t.MeterStop = &meterStop
+			t.StopReason = stopReason
+			t.Status = dbmodels.TransactionStatus_Completed
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *TransactionRepository) GetTransaction(ctx context.Context, clientId string, transactionId int64) (*dbmodels.Transaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.transactions {
+		if t.Id == transactionId && t.ClientId == clientId {
+			return &t, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (r *TransactionRepository) ListTransactions(ctx context.Context, filter dbmodels.TransactionFilter) ([]dbmodels.Transaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := []dbmodels.Transaction{}
+	for i := len(r.transactions) - 1; i >= 0; i-- {
+		t := r.transactions[i]
+		if filter.ClientId != "" && t.ClientId != filter.ClientId ||
+			filter.Status != "" && t.Status != filter.Status ||
+			filter.ConnectorId != nil && t.ConnectorId != *filter.ConnectorId ||
+			filter.From != nil && t.TimeStarted.Before(*filter.From) ||
+			filter.To != nil && !t.TimeStarted.Before(*filter.To) {
+			continue
+		}
+		matched = append(matched, t)
+	}
+	return page(matched, filter.Limit, filter.Offset), nil
+}
+
+func (r *TransactionRepository) DeleteTransactionsBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limit <= 0 {
+		limit = db.DefaultDeleteLimit
+	}
+	kept := []dbmodels.Transaction{}
+	deleted := int64(0)
+	for _, t := range r.transactions {
+		if deleted < int64(limit) && t.TimeStarted.Before(before) && t.Status != dbmodels.TransactionStatus_Active {
+			deleted++
+			continue
+		}
+		kept = append(kept, t)
+	}
+	r.transactions = kept
+	return deleted, nil
+}
+
+type DeviceRepository struct {
+	mu      sync.Mutex
+	lastId  int64
+	devices []dbmodels.Device
+}
+
+func NewDeviceRepository() *DeviceRepository {
+	return &DeviceRepository{}
+}
+
+func (r *DeviceRepository) InsertDevice(ctx context.Context, device *dbmodels.Device) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, d := range r.devices {
+		if d.Tenant == device.Tenant && d.NetworkId == device.NetworkId {
+			return 0, db.ErrRowExists
+		}
+	}
+	if device.Guid == "" {
+		device.Guid = uuid.New().String()
+	}
+
+	r.lastId++
+	device.Id = r.lastId
+	r.devices = append(r.devices, *device)
+	return device.Id, nil
+}
+
+func (r *DeviceRepository) GetDevice(ctx context.Context, tenant string, networkId string) (*dbmodels.Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, d := range r.devices {
+		if d.Tenant == tenant && d.NetworkId == networkId {
+			return &d, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (r *DeviceRepository) ModifyDevice(ctx context.Context, tenant string, networkId string, modify func(device *dbmodels.Device)) (*dbmodels.Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, d := range r.devices {
+		if d.Tenant == tenant && d.NetworkId == networkId {
+			modify(&d)
+			r.devices[i].DeviceTemplateId = d.DeviceTemplateId
+			r.devices[i].PasswordHash = d.PasswordHash
+			r.devices[i].PendingPasswordHash = d.PendingPasswordHash
+			r.devices[i].Disabled = d.Disabled
+			r.devices[i].Tags = d.Tags
+			return &d, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (r *DeviceRepository) SetFirmwareVersion(ctx context.Context, tenant string, networkId string, version string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, d := range r.devices {
+		if d.Tenant == tenant && d.NetworkId == networkId {
+			r.devices[i].FirmwareVersion = version
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *DeviceRepository) DeleteDevice(ctx context.Context, tenant string, networkId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, d := range r.devices {
+		if d.Tenant == tenant && d.NetworkId == networkId {
+			r.devices = append(r.devices[:i], r.devices[i+1:]...)
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *DeviceRepository) ListDevices(ctx context.Context, filter dbmodels.DeviceFilter) ([]dbmodels.Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := []dbmodels.Device{}
+	for _, d := range r.devices {
+		if filter.Tenant != "" && d.Tenant != filter.Tenant {
+			continue
+		}
+		if filter.NetworkIdPrefix != "" && !strings.HasPrefix(d.NetworkId, filter.NetworkIdPrefix) {
+			continue
+		}
+		if filter.DeviceTemplateId != 0 && d.DeviceTemplateId != filter.DeviceTemplateId {
+			continue
+		}
+		if filter.Disabled != nil && d.Disabled != *filter.Disabled {
+			continue
+		}
+		if filter.FirmwareVersion != "" && d.FirmwareVersion != filter.FirmwareVersion {
+			continue
+		}
+		if filter.Tag != "" && !slices.Contains(d.Tags, filter.Tag) {
+			continue
+		}
+		matched = append(matched, d)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].NetworkId < matched[j].NetworkId })
+	return page(matched, filter.Limit, filter.Offset), nil
+}
+
+type DeadLetterRepository struct {
+	mu          sync.Mutex
+	lastId      int64
+	deadLetters []dbmodels.DeadLetter
+}
+
+func NewDeadLetterRepository() *DeadLetterRepository {
+	return &DeadLetterRepository{}
+}
+
+func (r *DeadLetterRepository) InsertDeadLetter(ctx context.Context, deadLetter *dbmodels.DeadLetter) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastId++
+	deadLetter.Id = r.lastId
+	r.deadLetters = append(r.deadLetters, *deadLetter)
+	return deadLetter.Id, nil
+}
+
+func (r *DeadLetterRepository) GetDeadLetter(ctx context.Context, id int64) (*dbmodels.DeadLetter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, d := range r.deadLetters {
+		if d.Id == id {
+			return &d, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (r *DeadLetterRepository) ListDeadLetters(ctx context.Context, filter dbmodels.DeadLetterFilter) ([]dbmodels.DeadLetter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := []dbmodels.DeadLetter{}
+	for _, d := range r.deadLetters {
+		if filter.NetworkId != "" && d.NetworkId != filter.NetworkId {
+			continue
+		}
+		if filter.Reason != "" && d.Reason != filter.Reason {
+			continue
+		}
+		if filter.From != nil && d.MessageTime.Before(*filter.From) {
+			continue
+		}
+		if filter.To != nil && !d.MessageTime.Before(*filter.To) {
+			continue
+		}
+		matched = append(matched, d)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].MessageTime.Equal(matched[j].MessageTime) {
+			return matched[i].MessageTime.After(matched[j].MessageTime)
+		}
+		return matched[i].Id > matched[j].Id
+	})
+	return page(matched, filter.Limit, filter.Offset), nil
+}
+
+func (r *DeadLetterRepository) DeleteDeadLetter(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, d := range r.deadLetters {
+		if d.Id == id {
+			r.deadLetters = append(r.deadLetters[:i], r.deadLetters[i+1:]...)
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+type CertificateRepository struct {
+	mu           sync.Mutex
+	lastId       int64
+	certificates []dbmodels.Certificate
+}
+
+func NewCertificateRepository() *CertificateRepository {
+	return &CertificateRepository{}
+}
+
+func (r *CertificateRepository) InsertCertificate(ctx context.Context, certificate *dbmodels.Certificate) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastId++
+	certificate.Id = r.lastId
+	r.certificates = append(r.certificates, *certificate)
+	return certificate.Id, nil
+}
+
+func (r *CertificateRepository) UpdateCertificateStatus(ctx context.Context, id int64, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.certificates {
+		if r.certificates[i].Id == id {
+			r.certificates[i].Status = status
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *CertificateRepository) SupersedeCertificates(ctx context.Context, tenant string, networkId string, certificateType string, keepId int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, c := range r.certificates {
+		if c.Tenant == tenant && c.NetworkId == networkId && c.CertificateType == certificateType && c.Status == dbmodels.CertificateStatus_Accepted && c.Id != keepId {
+			r.certificates[i].Status = dbmodels.CertificateStatus_Superseded
+		}
+	}
+	return nil
+}
+
+func (r *CertificateRepository) SetRenewalRequested(ctx context.Context, id int64, requestedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.certificates {
+		if r.certificates[i].Id == id {
+			r.certificates[i].RenewalRequestedAt = &requestedAt
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *CertificateRepository) ListCertificates(ctx context.Context, filter dbmodels.CertificateFilter) ([]dbmodels.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := []dbmodels.Certificate{}
+	for _, c := range r.certificates {
+		if filter.Tenant != "" && c.Tenant != filter.Tenant {
+			continue
+		}
+		if filter.NetworkId != "" && c.NetworkId != filter.NetworkId {
+			continue
+		}
+		if filter.Status != "" && c.Status != filter.Status {
+			continue
+		}
+		if filter.ExpiresBefore != nil && !c.NotAfter.Before(*filter.ExpiresBefore) {
+			continue
+		}
+		if filter.RenewalNotRequested && c.RenewalRequestedAt != nil {
+			continue
+		}
+		matched = append(matched, c)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].IssuedAt.Equal(matched[j].IssuedAt) {
+			return matched[i].IssuedAt.After(matched[j].IssuedAt)
+		}
+		return matched[i].Id > matched[j].Id
+	})
+	return page(matched, filter.Limit, filter.Offset), nil
+}
+
+// Applies limit and offset the same as the SQL repositories
+type DeviceTemplateRepository struct {
+	mu        sync.Mutex
+	lastId    int64
+	templates []dbmodels.DeviceTemplate
+}
+
+func NewDeviceTemplateRepository() *DeviceTemplateRepository {
+	return &DeviceTemplateRepository{}
+}
+
+func (r *DeviceTemplateRepository) InsertTemplate(ctx context.Context, template *dbmodels.DeviceTemplate) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.templates {
+		if t.Tenant == template.Tenant && t.Name == template.Name {
+			return 0, db.ErrRowExists
+		}
+	}
+
+	r.lastId++
+	template.Id = r.lastId
+	r.templates = append(r.templates, *template)
+	return template.Id, nil
+}
+
+func (r *DeviceTemplateRepository) GetTemplate(ctx context.Context, tenant string, id int64) (*dbmodels.DeviceTemplate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.templates {
+		if t.Tenant == tenant && t.Id == id {
+			return &t, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (r *DeviceTemplateRepository) UpdateTemplate(ctx context.Context, template *dbmodels.DeviceTemplate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.templates {
+		if t.Tenant == template.Tenant && t.Name == template.Name && t.Id != template.Id {
+			return db.ErrRowExists
+		}
+	}
+	for i, t := range r.templates {
+		if t.Tenant == template.Tenant && t.Id == template.Id {
+			r.templates[i] = *template
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *DeviceTemplateRepository) DeleteTemplate(ctx context.Context, tenant string, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, t := range r.templates {
+		if t.Tenant == tenant && t.Id == id {
+			r.templates = append(r.templates[:i], r.templates[i+1:]...)
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *DeviceTemplateRepository) ListTemplates(ctx context.Context, filter dbmodels.DeviceTemplateFilter) ([]dbmodels.DeviceTemplate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := []dbmodels.DeviceTemplate{}
+	for _, t := range r.templates {
+		if filter.Tenant != "" && t.Tenant != filter.Tenant {
+			continue
+		}
+		matched = append(matched, t)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+	return page(matched, filter.Limit, filter.Offset), nil
+}
+
+type DeviceComplianceRepository struct {
+	mu         sync.Mutex
+	compliance []dbmodels.DeviceCompliance
+}
+
+func NewDeviceComplianceRepository() *DeviceComplianceRepository {
+	return &DeviceComplianceRepository{}
+}
+
+func (r *DeviceComplianceRepository) SetCompliance(ctx context.Context, compliance *dbmodels.DeviceCompliance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, c := range r.compliance {
+		if c.Tenant == compliance.Tenant && c.NetworkId == compliance.NetworkId {
+			r.compliance[i] = *compliance
+			return nil
+		}
+	}
+	r.compliance = append(r.compliance, *compliance)
+	return nil
+}
+
+func (r *DeviceComplianceRepository) GetCompliance(ctx context.Context, tenant string, networkId string) (*dbmodels.DeviceCompliance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.compliance {
+		if c.Tenant == tenant && c.NetworkId == networkId {
+			return &c, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (r *DeviceComplianceRepository) DeleteCompliance(ctx context.Context, tenant string, networkId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, c := range r.compliance {
+		if c.Tenant == tenant && c.NetworkId == networkId {
+			r.compliance = append(r.compliance[:i], r.compliance[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *DeviceComplianceRepository) ListCompliance(ctx context.Context, filter dbmodels.DeviceComplianceFilter) ([]dbmodels.DeviceCompliance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := []dbmodels.DeviceCompliance{}
+	for _, c := range r.compliance {
+		if filter.Tenant != "" && c.Tenant != filter.Tenant {
+			continue
+		}
+		if filter.Status != "" && c.Status != filter.Status {
+			continue
+		}
+		if filter.DeviceTemplateId != 0 && c.DeviceTemplateId != filter.DeviceTemplateId {
+			continue
+		}
+		matched = append(matched, c)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].NetworkId < matched[j].NetworkId })
+	return page(matched, filter.Limit, filter.Offset), nil
+}
+
+type BulkJobRepository struct {
+	mu      sync.Mutex
+	lastId  int64
+	jobs    []dbmodels.BulkJob
+	results []dbmodels.BulkJobResult
+}
+
+func NewBulkJobRepository() *BulkJobRepository {
+	return &BulkJobRepository{}
+}
+
+func (r *BulkJobRepository) InsertJob(ctx context.Context, job *dbmodels.BulkJob, networkIds []string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastId++
+	job.Id = r.lastId
+	job.Total = len(networkIds)
+	job.Results = map[string]int{dbmodels.BulkResultStatus_Pending: len(networkIds)}
+	r.jobs = append(r.jobs, *job)
+	for _, networkId := range networkIds {
+		r.results = append(r.results, dbmodels.BulkJobResult{JobId: job.Id, NetworkId: networkId, Status: dbmodels.BulkResultStatus_Pending})
+	}
+	return job.Id, nil
+}
+
+func (r *BulkJobRepository) GetJob(ctx context.Context, tenant string, id int64) (*dbmodels.BulkJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, j := range r.jobs {
+		if j.Tenant == tenant && j.Id == id {
+			j.Results = r.countResults(j.Id)
+			return &j, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (r *BulkJobRepository) UpdateJobStatus(ctx context.Context, tenant string, id int64, fromStatus string, status string, completedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, j := range r.jobs {
+		if j.Tenant == tenant && j.Id == id && j.Status == fromStatus {
+			r.jobs[i].Status = status
+			r.jobs[i].CompletedAt = &completedAt
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *BulkJobRepository) InterruptJobs(ctx context.Context, tenant string, owner string, interruptedAt time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var interrupted int64
+	for i, j := range r.jobs {
+		if j.Tenant == tenant && j.Owner == owner && j.Status == dbmodels.BulkJobStatus_Running {
+			r.jobs[i].Status = dbmodels.BulkJobStatus_Interrupted
+			r.jobs[i].CompletedAt = &interruptedAt
+			interrupted++
+		}
+	}
+	return interrupted, nil
+}
+
+func (r *BulkJobRepository) ExpireJobs(ctx context.Context, tenant string, inactiveSince time.Time, expiredAt time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired int64
+	for i, j := range r.jobs {
+		if j.Tenant != tenant || j.Status != dbmodels.BulkJobStatus_Running || !j.CreatedAt.Before(inactiveSince) {
+			continue
+		}
+		active := slices.ContainsFunc(r.results, func(result dbmodels.BulkJobResult) bool {
+			return result.JobId == j.Id && result.CompletedAt != nil && !result.CompletedAt.Before(inactiveSince)
+		})
+		if !active {
+			r.jobs[i].Status = dbmodels.BulkJobStatus_Interrupted
+			r.jobs[i].CompletedAt = &expiredAt
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (r *BulkJobRepository) ListJobs(ctx context.Context, filter dbmodels.BulkJobFilter) ([]dbmodels.BulkJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := []dbmodels.BulkJob{}
+	for _, j := range r.jobs {
+		if filter.Tenant != "" && j.Tenant != filter.Tenant {
+			continue
+		}
+		if filter.Status != "" && j.Status != filter.Status {
+			continue
+		}
+		j.Results = r.countResults(j.Id)
+		matched = append(matched, j)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Id > matched[j].Id })
+	return page(matched, filter.Limit, filter.Offset), nil
+}
+
+func (r *BulkJobRepository) SetResult(ctx context.Context, result *dbmodels.BulkJobResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, res := range r.results {
+		if res.JobId == result.JobId && res.NetworkId == result.NetworkId {
+			r.results[i] = *result
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *BulkJobRepository) ListResults(ctx context.Context, filter dbmodels.BulkJobResultFilter) ([]dbmodels.BulkJobResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := []dbmodels.BulkJobResult{}
+	for _, res := range r.results {
+		if res.JobId != filter.JobId {
+			continue
+		}
+		if filter.Status != "" && res.Status != filter.Status {
+			continue
+		}
+		matched = append(matched, res)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].NetworkId < matched[j].NetworkId })
+	return page(matched, filter.Limit, filter.Offset), nil
+}
+
+func (r *BulkJobRepository) countResults(jobId int64) map[string]int {
+	counts := map[string]int{}
+	for _, res := range r.results {
+		if res.JobId == jobId {
+			counts[res.Status]++
+		}
+	}
+	return counts
+}
+
+type CommandRepository struct {
+	mu       sync.Mutex
+	lastId   int64
+	commands []dbmodels.Command
+}
+
+func NewCommandRepository() *CommandRepository {
+	return &CommandRepository{}
+}
+
+func (r *CommandRepository) InsertCommand(ctx context.Context, command *dbmodels.Command) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastId++
+	command.Id = r.lastId
+	r.commands = append(r.commands, *command)
+	return command.Id, nil
+}
+
+func (r *CommandRepository) GetCommand(ctx context.Context, tenant string, id int64) (*dbmodels.Command, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.commands {
+		if c.Tenant == tenant && c.Id == id {
+			return &c, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (r *CommandRepository) UpdateCommand(ctx context.Context, command *dbmodels.Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, c := range r.commands {
+		if c.Tenant == command.Tenant && c.Id == command.Id {
+			r.commands[i].MsgId = command.MsgId
+			r.commands[i].Status = command.Status
+			r.commands[i].Response = command.Response
+			r.commands[i].Error = command.Error
+			r.commands[i].SentAt = command.SentAt
+			r.commands[i].CompletedAt = command.CompletedAt
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *CommandRepository) InterruptCommands(ctx context.Context, tenant string, owner string, interruptedAt time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var interrupted int64
+	for i, c := range r.commands {
+		if c.Tenant == tenant && c.Owner == owner && (c.Status == dbmodels.CommandStatus_Queued || c.Status == dbmodels.CommandStatus_Sent) {
+			r.commands[i].Status = dbmodels.CommandStatus_Error
+			r.commands[i].Error = "interrupted by restart"
+			r.commands[i].CompletedAt = &interruptedAt
+			interrupted++
+		}
+	}
+	return interrupted, nil
+}
+
+func (r *CommandRepository) ExpireCommands(ctx context.Context, tenant string, createdBefore time.Time, expiredAt time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired int64
+	for i, c := range r.commands {
+		if c.Tenant == tenant && c.CreatedAt.Before(createdBefore) && (c.Status == dbmodels.CommandStatus_Queued || c.Status == dbmodels.CommandStatus_Sent) {
+			r.commands[i].Status = dbmodels.CommandStatus_Error
+			r.commands[i].Error = "expired, no longer awaited"
+			r.commands[i].CompletedAt = &expiredAt
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (r *CommandRepository) ListCommands(ctx context.Context, filter dbmodels.CommandFilter) ([]dbmodels.Command, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := []dbmodels.Command{}
+	for _, c := range r.commands {
+		if filter.Tenant != "" && c.Tenant != filter.Tenant {
+			continue
+		}
+		if filter.NetworkId != "" && c.NetworkId != filter.NetworkId {
+			continue
+		}
+		if filter.Status != "" && c.Status != filter.Status {
+			continue
+		}
+		matched = append(matched, c)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Id > matched[j].Id })
+	return page(matched, filter.Limit, filter.Offset), nil
+}
+
+func page[T any](items []T, limit int, offset int) []T {
+	if limit <= 0 {
+		limit = db.DefaultListLimit
+	}
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
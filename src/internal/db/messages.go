@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/metrics"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+)
+
+const messageColumns = "id,networkId,messageId,serverNode,direction,messageType,messageTime,body"
+
+type sqlMessageRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r *sqlMessageRepository) InsertMessages(ctx context.Context, messages []dbmodels.Message) error {
+	defer metrics.ObserveDbQuery("messages", "InsertMessages", time.Now())
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, r.dialect.Rebind("INSERT INTO messages(networkId,messageId,serverNode,direction,messageType,messageTime,body) VALUES (?,?,?,?,?,?,?)"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, message := range messages {
+		_, err = stmt.ExecContext(ctx, message.NetworkId, message.MessageId, message.ServerNode, message.Direction,
+			message.MessageType, message.MessageTime.UnixMilli(), message.Body)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *sqlMessageRepository) ListMessages(ctx context.Context, filter dbmodels.MessageFilter) ([]dbmodels.Message, error) {
+	defer metrics.ObserveDbQuery("messages", "ListMessages", time.Now())
+	where := []string{"networkId = ?", "messageTime >= ?", "messageTime < ?"}
+	args := []any{filter.NetworkId, filter.From.UnixMilli(), filter.To.UnixMilli()}
+
+	if filter.Direction != 0 {
+		where = append(where, "direction = ?")
+		args = append(args, filter.Direction)
+	}
+	if filter.MessageType != "" {
+		where = append(where, "messageType = ?")
+		args = append(args, filter.MessageType)
+	}
+	if filter.AfterTime != nil {
+		where = append(where, "(messageTime > ? OR (messageTime = ? AND id > ?))")
+		afterMs := filter.AfterTime.UnixMilli()
+		args = append(args, afterMs, afterMs, filter.AfterId)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, limit)
+
+	query := "SELECT " + messageColumns + " FROM messages WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY messageTime, id LIMIT ?"
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []dbmodels.Message{}
+	for rows.Next() {
+		var message dbmodels.Message
+		var messageTime int64
+		err = rows.Scan(&message.Id, &message.NetworkId, &message.MessageId, &message.ServerNode, &message.Direction,
+			&message.MessageType, &messageTime, &message.Body)
+		if err != nil {
+			return nil, err
+		}
+		message.MessageTime = time.UnixMilli(messageTime).UTC()
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (r *sqlMessageRepository) DeleteMessages(ctx context.Context, filter dbmodels.MessageDeleteFilter) (int64, error) {
+	defer metrics.ObserveDbQuery("messages", "DeleteMessages", time.Now())
+	where := []string{"messageTime < ?"}
+	args := []any{filter.Before.UnixMilli()}
+
+	if filter.MessageType != "" {
+		where = append(where, "LOWER(messageType) = ?")
+		args = append(args, strings.ToLower(filter.MessageType))
+	}
+	if len(filter.ExcludeMessageTypes) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(filter.ExcludeMessageTypes)), ",")
+		where = append(where, "LOWER(messageType) NOT IN ("+placeholders+")")
+		for _, messageType := range filter.ExcludeMessageTypes {
+			args = append(args, strings.ToLower(messageType))
+		}
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultDeleteLimit
+	}
+	args = append(args, limit)
+
+	// Deleted in limited batches so a large purge doesn't hold long locks
+	query := "DELETE FROM messages WHERE id IN (SELECT id FROM messages WHERE " + strings.Join(where, " AND ") + " LIMIT ?)"
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
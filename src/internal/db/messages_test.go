@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteMessages(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.Messages
+
+		old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		recent := old.Add(30 * 24 * time.Hour)
+		messages := []dbmodels.Message{}
+		for _, messageTime := range []time.Time{old, recent} {
+			for _, messageType := range []string{"Heartbeat", "StartTransaction", ""} {
+				messages = append(messages, dbmodels.Message{NetworkId: "charger-1", MessageId: messageType + messageTime.String(),
+					Direction: 2, MessageType: messageType, MessageTime: messageTime, Body: "{}"})
+			}
+		}
+		require.NoError(t, repo.InsertMessages(ctx, messages))
+
+		cutoff := old.Add(time.Hour)
+		deleted, err := repo.DeleteMessages(ctx, dbmodels.MessageDeleteFilter{Before: cutoff, MessageType: "heartbeat"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		// Everything else old, except transactions
+		deleted, err = repo.DeleteMessages(ctx, dbmodels.MessageDeleteFilter{Before: cutoff, ExcludeMessageTypes: []string{"StartTransaction"}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		remaining, err := repo.ListMessages(ctx, dbmodels.MessageFilter{NetworkId: "charger-1", From: old, To: recent.Add(time.Hour)})
+		require.NoError(t, err)
+		require.Len(t, remaining, 4)
+		assert.Equal(t, "StartTransaction", remaining[0].MessageType)
+		assert.Equal(t, old, remaining[0].MessageTime)
+	})
+}
@@ -0,0 +1,428 @@
+// Provides versioned schema migrations, embedded per DB type under ./migrations/<dbType>
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	helpers "sw/ocpp/csms/internal/helpers"
+	log "sw/ocpp/csms/internal/logging"
+)
+
+const (
+	MigrationLockTimeout = 60 * time.Second
+	MigrationLockStale   = 10 * time.Minute
+	migrationLockPollMs  = 500
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Marks an up script whose change may already exist, made by the schema created before migrations, e.g
+// "-- skip-if-column: transactions.status". The migration is then recorded as applied without running it
+const skipIfColumnMarker = "-- skip-if-column:"
+
+// Marks an up script which can't run while a table has duplicate rows, e.g before adding a unique index,
+// "-- fail-if-duplicates: devices.tenant,networkid". The migration fails listing them, to be merged by hand
+const failIfDuplicatesMarker = "-- fail-if-duplicates:"
+
+// A schema migration, loaded from NNNN_name.up.sql and NNNN_name.down.sql
+type Migration struct {
+	Version          int
+	Name             string
+	Up               string
+	Down             string
+	SkipIfColumn     string // table.column, from the up script's skip-if-column marker
+	FailIfDuplicates string // table.column,column, from the up script's fail-if-duplicates marker
+}
+
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// Loads the ordered migrations for a DB type
+func LoadMigrations(dbType string) ([]Migration, error) {
+	dir := path.Join("migrations", dbType)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for db type %s: %w", dbType, err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		versionStr, migrationName, found := strings.Cut(strings.TrimSuffix(name, "."+direction+".sql"), "_")
+		if !found {
+			return nil, fmt.Errorf("invalid migration file name: %s", name)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version: %s", name)
+		}
+
+		sqlBy, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = migration
+		}
+		if direction == "up" {
+			migration.Up = string(sqlBy)
+			migration.SkipIfColumn = scriptMarker(migration.Up, skipIfColumnMarker)
+			migration.FailIfDuplicates = scriptMarker(migration.Up, failIfDuplicatesMarker)
+		} else {
+			migration.Down = string(sqlBy)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Applies all pending migrations, holding the schema lock
+func (s *Store) MigrateUp(ctx context.Context) error {
+	migrations, err := LoadMigrations(s.dialect.Name())
+	if err != nil {
+		return err
+	}
+
+	return s.withMigrationLock(ctx, func() error {
+		applied, err := s.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			script := migration.Up
+			if skip, err := s.changeExists(ctx, migration); err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+			} else if skip {
+				log.Logger.Infof("DB migrate up: %04d_%s already in schema, recording as applied", migration.Version, migration.Name)
+				script = ""
+			} else if err := s.checkDuplicates(ctx, migration); err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+			} else {
+				log.Logger.Infof("DB migrate up: %04d_%s", migration.Version, migration.Name)
+			}
+			err = s.applyMigration(ctx, script, s.dialect.Rebind("INSERT INTO schema_version(version, name, appliedAt) VALUES (?,?,?)"),
+				migration.Version, migration.Name, time.Now().UnixMilli())
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Reverts the given number of most recently applied migrations, holding the schema lock
+func (s *Store) MigrateDown(ctx context.Context, steps int) error {
+	migrations, err := LoadMigrations(s.dialect.Name())
+	if err != nil {
+		return err
+	}
+
+	return s.withMigrationLock(ctx, func() error {
+		applied, err := s.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %04d_%s has no down script", migration.Version, migration.Name)
+			}
+			log.Logger.Infof("DB migrate down: %04d_%s", migration.Version, migration.Name)
+			err = s.applyMigration(ctx, migration.Down, s.dialect.Rebind("DELETE FROM schema_version WHERE version = ?"), migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// Lists all known migrations and whether they are applied
+func (s *Store) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	migrations, err := LoadMigrations(s.dialect.Name())
+	if err != nil {
+		return nil, err
+	}
+	if err = s.createSchemaTables(ctx); err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := []MigrationState{}
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Runs the migrate subcommand: migrate [up | down [steps] | status]
+func RunMigrateCommand(dbConfig conf.DbConfig, args []string, out io.Writer) error {
+	store, err := Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		if err := store.MigrateUp(ctx); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid steps: %s", args[1])
+			}
+		}
+		if err := store.MigrateDown(ctx, steps); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return fmt.Errorf("unknown migrate command: %s, expected up, down [steps] or status", command)
+	}
+
+	states, err := store.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		appliedAt := "pending"
+		if state.Applied {
+			appliedAt = "applied " + state.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(out, "%04d_%s\t%s\n", state.Version, state.Name, appliedAt)
+	}
+	return nil
+}
+
+func (s *Store) applyMigration(ctx context.Context, script string, versionSql string, versionArgs ...any) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if script != "" {
+		if _, err = tx.ExecContext(ctx, script); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.ExecContext(ctx, versionSql, versionArgs...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The value of the script's marker line, if any
+func scriptMarker(script string, marker string) string {
+	for _, line := range strings.Split(script, "\n") {
+		if value, found := strings.CutPrefix(strings.TrimSpace(line), marker); found {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// Whether the migration's skip-if-column already exists
+func (s *Store) changeExists(ctx context.Context, migration Migration) (bool, error) {
+	if migration.SkipIfColumn == "" {
+		return false, nil
+	}
+	table, column, found := strings.Cut(migration.SkipIfColumn, ".")
+	if !found {
+		return false, fmt.Errorf("invalid skip-if-column: %s", migration.SkipIfColumn)
+	}
+	return s.dialect.ColumnExists(ctx, s.db, table, column)
+}
+
+// Fails if the migration's fail-if-duplicates table has rows sharing the columns, listing up to 20 of them
+func (s *Store) checkDuplicates(ctx context.Context, migration Migration) error {
+	if migration.FailIfDuplicates == "" {
+		return nil
+	}
+	table, columns, found := strings.Cut(migration.FailIfDuplicates, ".")
+	if !found || columns == "" {
+		return fmt.Errorf("invalid fail-if-duplicates: %s", migration.FailIfDuplicates)
+	}
+	columnList := strings.Split(columns, ",")
+	for i := range columnList {
+		columnList[i] = strings.TrimSpace(columnList[i])
+	}
+	grouped := strings.Join(columnList, ", ")
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT %s, COUNT(*) FROM %s GROUP BY %s HAVING COUNT(*) > 1 ORDER BY %s LIMIT 20",
+		grouped, table, grouped, grouped))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	duplicates := []string{}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columnList))
+		var count int
+		dest := []any{}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err = rows.Scan(append(dest, &count)...); err != nil {
+			return err
+		}
+		fields := []string{}
+		for i, value := range values {
+			fields = append(fields, columnList[i]+"="+value.String)
+		}
+		duplicates = append(duplicates, fmt.Sprintf("(%s) x%d", strings.Join(fields, ", "), count))
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%s has duplicate (%s), merge them and rerun: %s", table, grouped, strings.Join(duplicates, "; "))
+	}
+	return nil
+}
+
+func (s *Store) createSchemaTables(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		appliedAt BIGINT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS schema_lock (
+		id INTEGER PRIMARY KEY,
+		owner TEXT NOT NULL,
+		lockedAt BIGINT NOT NULL
+	);
+	`)
+	return err
+}
+
+func (s *Store) appliedVersions(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT version, appliedAt FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = time.UnixMilli(appliedAt).UTC()
+	}
+	return applied, rows.Err()
+}
+
+// Runs fn while holding the schema lock, so concurrently starting services migrate once.
+// A lock older than MigrationLockStale is assumed to be from a crashed process and is taken over.
+func (s *Store) withMigrationLock(ctx context.Context, fn func() error) error {
+	if err := s.createSchemaTables(ctx); err != nil {
+		return err
+	}
+
+	owner := helpers.GetHostName() + ":" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	deadline := time.Now().Add(MigrationLockTimeout)
+	for {
+		_, err := s.db.ExecContext(ctx, s.dialect.Rebind("INSERT INTO schema_lock(id, owner, lockedAt) VALUES (1,?,?)"), owner, time.Now().UnixMilli())
+		if err == nil {
+			break
+		}
+
+		staleBefore := time.Now().Add(-MigrationLockStale).UnixMilli()
+		res, delErr := s.db.ExecContext(ctx, s.dialect.Rebind("DELETE FROM schema_lock WHERE id = 1 AND lockedAt < ?"), staleBefore)
+		if delErr == nil {
+			if rows, _ := res.RowsAffected(); rows > 0 {
+				log.Logger.Warn("DB migrate: removed stale schema lock")
+				continue
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for schema lock")
+		}
+		log.Logger.Debug("DB migrate: waiting for schema lock")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationLockPollMs * time.Millisecond):
+		}
+	}
+
+	defer func() {
+		// Released even if ctx is cancelled, so the lock isn't left to go stale
+		if _, err := s.db.ExecContext(context.Background(), s.dialect.Rebind("DELETE FROM schema_lock WHERE id = 1 AND owner = ?"), owner); err != nil {
+			log.Logger.Errorf("DB migrate: unable to release schema lock: %s", err.Error())
+		}
+	}()
+	return fn()
+}
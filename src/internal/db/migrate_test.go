@@ -0,0 +1,193 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	sqliteMigrations, err := LoadMigrations(DbType_Sqlite)
+	require.NoError(t, err)
+	require.NotEmpty(t, sqliteMigrations)
+
+	postgresMigrations, err := LoadMigrations(DbType_Postgres)
+	require.NoError(t, err)
+	require.Len(t, postgresMigrations, len(sqliteMigrations), "each DB type needs the same migrations")
+
+	for i, migration := range sqliteMigrations {
+		assert.Equal(t, i+1, migration.Version, "migrations must be contiguous")
+		assert.NotEmpty(t, migration.Up)
+		assert.NotEmpty(t, migration.Down)
+		assert.Equal(t, migration.Name, postgresMigrations[i].Name)
+	}
+
+	_, err = LoadMigrations("unknown")
+	assert.Error(t, err)
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	forEachTestDb(t, testMigrateUpDown)
+}
+
+func testMigrateUpDown(t *testing.T, store *Store) {
+	ctx := context.Background()
+	require.NoError(t, store.MigrateUp(ctx))
+	require.NoError(t, store.MigrateUp(ctx)) // idempotent
+
+	states, err := store.MigrationStatus(ctx)
+	require.NoError(t, err)
+	for _, state := range states {
+		assert.True(t, state.Applied)
+	}
+
+	require.NoError(t, store.MigrateDown(ctx, 1))
+	states, err = store.MigrationStatus(ctx)
+	require.NoError(t, err)
+	assert.False(t, states[len(states)-1].Applied)
+	assert.True(t, states[0].Applied)
+
+	require.NoError(t, store.MigrateDown(ctx, len(states)))
+	require.NoError(t, store.MigrateUp(ctx))
+
+	_, err = store.Transactions.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-1", TimeStarted: time.Now(), TimeReceived: time.Now()})
+	assert.NoError(t, err)
+}
+
+func TestMigrateUpgradesUnversionedDb(t *testing.T) {
+	store, err := Open(conf.DbConfig{DbType: DbType_Sqlite, DbConnectionString: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	// Schema as created before migrations existed
+	_, err = store.db.Exec(`
+	CREATE TABLE transactions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		guid TEXT NOT NULL,
+		clientId TEXT NOT NULL,
+		timeStarted INTEGER NOT NULL,
+		timeEnded INTEGER NULL,
+		meterStop FLOAT NULL
+	);
+	INSERT INTO transactions(guid, clientId, timeStarted, timeEnded, meterStop) VALUES ('g1', 'charger-1', 1000, 2000, 10);
+	`)
+	require.NoError(t, err)
+
+	require.NoError(t, store.MigrateUp(ctx))
+
+	transactions, err := store.Transactions.ListTransactions(ctx, dbmodels.TransactionFilter{ClientId: "charger-1"})
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	assert.Equal(t, dbmodels.TransactionStatus_Completed, transactions[0].Status)
+}
+
+func TestMigrateUpgradesTransactionDetailsDb(t *testing.T) {
+	store, err := Open(conf.DbConfig{DbType: DbType_Sqlite, DbConnectionString: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	defer store.Close()
+	ctx := context.Background()
+
+	// Schema as created by CreateTables with transaction details, before migrations existed
+	_, err = store.db.Exec(`
+	CREATE TABLE transactions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		guid TEXT NOT NULL,
+		clientId TEXT NOT NULL,
+		connectorId INTEGER NOT NULL DEFAULT 0,
+		idTag TEXT NOT NULL DEFAULT '',
+		reservationId INTEGER NULL,
+		meterStart INTEGER NOT NULL DEFAULT 0,
+		timeStarted INTEGER NOT NULL,
+		timeReceived INTEGER NOT NULL DEFAULT 0,
+		timeEnded INTEGER NULL,
+		meterStop FLOAT NULL,
+		stopReason TEXT NULL,
+		status TEXT NOT NULL DEFAULT 'active'
+	);
+	CREATE INDEX transaction_clientId_status_IDX ON transactions (clientId, status);
+	INSERT INTO transactions(guid, clientId, connectorId, idTag, timeStarted) VALUES ('g1', 'charger-1', 2, 'TAG1', 1000);
+	`)
+	require.NoError(t, err)
+
+	require.NoError(t, store.MigrateUp(ctx))
+
+	transactions, err := store.Transactions.ListTransactions(ctx, dbmodels.TransactionFilter{ClientId: "charger-1"})
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	assert.Equal(t, 2, transactions[0].ConnectorId)
+	assert.Equal(t, dbmodels.TransactionStatus_Active, transactions[0].Status)
+}
+
+func TestMigrateFailsOnDuplicateDevices(t *testing.T) {
+	forEachTestDb(t, testMigrateFailsOnDuplicateDevices)
+}
+
+func testMigrateFailsOnDuplicateDevices(t *testing.T, store *Store) {
+	ctx := context.Background()
+	require.NoError(t, store.MigrateUp(ctx))
+	states, err := store.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.MigrateDown(ctx, len(states)-2))
+
+	_, err = store.db.Exec(`INSERT INTO devices(tenant, guid, networkid, devicetemplateid) VALUES
+		('t1', 'g1', 'charger-1', 1), ('t1', 'g2', 'charger-1', 2), ('t2', 'g3', 'charger-1', 3)`)
+	require.NoError(t, err)
+	err = store.MigrateUp(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0003_devices_unique_networkid")
+	assert.Contains(t, err.Error(), "(tenant=t1, networkid=charger-1) x2")
+	assert.NotContains(t, err.Error(), "tenant=t2", "not duplicated")
+	var count int
+	require.NoError(t, store.db.QueryRow("SELECT COUNT(*) FROM devices").Scan(&count))
+	assert.Equal(t, 3, count, "nothing deleted")
+
+	_, err = store.db.Exec("DELETE FROM devices WHERE guid = 'g2'")
+	require.NoError(t, err)
+	require.NoError(t, store.MigrateUp(ctx))
+	device, err := store.Devices.GetDevice(ctx, "t1", "charger-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), device.DeviceTemplateId)
+}
+
+func TestMigrationLockTakesOverStaleLock(t *testing.T) {
+	forEachTestDb(t, testMigrationLockTakesOverStaleLock)
+}
+
+func testMigrationLockTakesOverStaleLock(t *testing.T, store *Store) {
+	ctx := context.Background()
+	require.NoError(t, store.createSchemaTables(ctx))
+
+	// Stale lock from a crashed process is taken over
+	_, err := store.db.Exec(store.dialect.Rebind("INSERT INTO schema_lock(id, owner, lockedAt) VALUES (1, 'crashed', ?)"),
+		time.Now().Add(-2*MigrationLockStale).UnixMilli())
+	require.NoError(t, err)
+
+	require.NoError(t, store.MigrateUp(ctx))
+
+	var count int
+	require.NoError(t, store.db.QueryRow("SELECT COUNT(*) FROM schema_lock").Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestRunMigrateCommand(t *testing.T) {
+	dbConfig := conf.DbConfig{DbType: DbType_Sqlite, DbConnectionString: filepath.Join(t.TempDir(), "test.db")}
+
+	var out bytes.Buffer
+	require.NoError(t, RunMigrateCommand(dbConfig, []string{"status"}, &out))
+	assert.Contains(t, out.String(), "0001_initial\tpending")
+
+	out.Reset()
+	require.NoError(t, RunMigrateCommand(dbConfig, []string{"up"}, &out))
+	assert.Contains(t, out.String(), "0001_initial\tapplied")
+
+	assert.Error(t, RunMigrateCommand(dbConfig, []string{"sideways"}, &out))
+}
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS transaction_clientId_IDX;
+DROP TABLE IF EXISTS transactions;
+
+DROP INDEX IF EXISTS devices_tenant_networkid_IDX;
+DROP TABLE IF EXISTS devices;
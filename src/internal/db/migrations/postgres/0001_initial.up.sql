@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS devices(
+	id BIGSERIAL PRIMARY KEY,
+	tenant TEXT NOT NULL,
+	guid TEXT NOT NULL,
+	networkid TEXT NOT NULL,
+	devicetemplateid INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS devices_tenant_networkid_IDX ON devices (tenant, networkid);
+
+CREATE TABLE IF NOT EXISTS transactions (
+	id BIGSERIAL PRIMARY KEY,
+	guid TEXT NOT NULL,
+	clientId TEXT NOT NULL,
+	timeStarted BIGINT NOT NULL,
+	timeEnded BIGINT NULL,
+	meterStop DOUBLE PRECISION NULL
+);
+
+CREATE INDEX IF NOT EXISTS transaction_clientId_IDX ON transactions (clientId);
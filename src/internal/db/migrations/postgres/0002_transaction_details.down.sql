@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS transaction_clientId_status_IDX;
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS status;
+ALTER TABLE transactions DROP COLUMN IF EXISTS stopReason;
+ALTER TABLE transactions DROP COLUMN IF EXISTS timeReceived;
+ALTER TABLE transactions DROP COLUMN IF EXISTS meterStart;
+ALTER TABLE transactions DROP COLUMN IF EXISTS reservationId;
+ALTER TABLE transactions DROP COLUMN IF EXISTS idTag;
+ALTER TABLE transactions DROP COLUMN IF EXISTS connectorId;
@@ -0,0 +1,12 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS connectorId INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS idTag TEXT NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reservationId INTEGER NULL;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS meterStart INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS timeReceived BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS stopReason TEXT NULL;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+
+-- Transactions recorded before status existed are either stopped or unknown
+UPDATE transactions SET status = 'completed' WHERE timeEnded IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS transaction_clientId_status_IDX ON transactions (clientId, status);
@@ -0,0 +1,4 @@
+-- Duplicate devices must be merged by hand first, as the unique index would reject them
+-- fail-if-duplicates: devices.tenant,networkid
+DROP INDEX IF EXISTS devices_tenant_networkid_IDX;
+CREATE UNIQUE INDEX IF NOT EXISTS devices_tenant_networkid_UIDX ON devices (tenant, networkid);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS messages (
+	id BIGSERIAL PRIMARY KEY,
+	networkId TEXT NOT NULL,
+	messageId TEXT NOT NULL,
+	serverNode TEXT NOT NULL,
+	direction INTEGER NOT NULL,
+	messageType TEXT NOT NULL,
+	messageTime BIGINT NOT NULL,
+	body TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS messages_networkId_messageTime_IDX ON messages (networkId, messageTime);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS dead_letters (
+	id BIGSERIAL PRIMARY KEY,
+	networkId TEXT NOT NULL,
+	serverNode TEXT NOT NULL,
+	remoteAddr TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	error TEXT NOT NULL,
+	frame TEXT NOT NULL,
+	frameEncoding TEXT NOT NULL,
+	messageTime BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS dead_letters_messageTime_IDX ON dead_letters (messageTime);
+CREATE INDEX IF NOT EXISTS dead_letters_networkId_messageTime_IDX ON dead_letters (networkId, messageTime);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS certificates (
+	id BIGSERIAL PRIMARY KEY,
+	tenant TEXT NOT NULL,
+	networkId TEXT NOT NULL,
+	certificateType TEXT NOT NULL,
+	serialNumber TEXT NOT NULL,
+	commonName TEXT NOT NULL,
+	status TEXT NOT NULL,
+	certificateChain TEXT NOT NULL,
+	notBefore BIGINT NOT NULL,
+	notAfter BIGINT NOT NULL,
+	issuedAt BIGINT NOT NULL,
+	renewalRequestedAt BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS certificates_tenant_networkId_issuedAt_IDX ON certificates (tenant, networkId, issuedAt);
+CREATE INDEX IF NOT EXISTS certificates_status_notAfter_IDX ON certificates (status, notAfter);
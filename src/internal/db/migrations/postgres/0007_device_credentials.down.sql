@@ -0,0 +1,2 @@
+ALTER TABLE devices DROP COLUMN IF EXISTS disabled;
+ALTER TABLE devices DROP COLUMN IF EXISTS passwordHash;
@@ -0,0 +1,3 @@
+-- Basic auth password hash, for csms-server's sql auth backend
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS passwordHash TEXT NULL;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS device_templates (
+	id BIGSERIAL PRIMARY KEY,
+	tenant TEXT NOT NULL,
+	name TEXT NOT NULL,
+	configuration TEXT NOT NULL,
+	chargingProfiles TEXT NOT NULL,
+	updatedAt BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS device_templates_tenant_name_UIDX ON device_templates (tenant, name);
+
+CREATE TABLE IF NOT EXISTS device_compliance (
+	tenant TEXT NOT NULL,
+	networkid TEXT NOT NULL,
+	devicetemplateid BIGINT NOT NULL,
+	status TEXT NOT NULL,
+	configurationKeys TEXT NOT NULL,
+	chargingProfiles TEXT NOT NULL,
+	error TEXT NULL,
+	checkedAt BIGINT NOT NULL,
+	PRIMARY KEY (tenant, networkid)
+);
+
+CREATE INDEX IF NOT EXISTS device_compliance_status_IDX ON device_compliance (status);
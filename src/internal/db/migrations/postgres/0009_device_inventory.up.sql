@@ -0,0 +1,4 @@
+-- Reported by BootNotification, and set by the API, for selecting devices
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS firmwareVersion TEXT NULL;
+-- Comma separated, with leading and trailing commas
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS tags TEXT NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS bulk_job_results;
+DROP INDEX IF EXISTS bulk_jobs_tenant_createdAt_IDX;
+DROP TABLE IF EXISTS bulk_jobs;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS devices(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	tenant TEXT NOT NULL,
+	guid TEXT NOT NULL,
+	networkid TEXT NOT NULL,
+	devicetemplateid INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS devices_tenant_networkid_IDX ON devices (tenant, networkid);
+
+CREATE TABLE IF NOT EXISTS transactions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	guid TEXT NOT NULL,
+	clientId TEXT NOT NULL,
+	timeStarted INTEGER NOT NULL,
+	timeEnded INTEGER NULL,
+	meterStop FLOAT NULL
+);
+
+CREATE INDEX IF NOT EXISTS transaction_clientId_IDX ON transactions (clientId);
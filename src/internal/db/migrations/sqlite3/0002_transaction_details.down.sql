@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS transaction_clientId_status_IDX;
+
+ALTER TABLE transactions DROP COLUMN status;
+ALTER TABLE transactions DROP COLUMN stopReason;
+ALTER TABLE transactions DROP COLUMN timeReceived;
+ALTER TABLE transactions DROP COLUMN meterStart;
+ALTER TABLE transactions DROP COLUMN reservationId;
+ALTER TABLE transactions DROP COLUMN idTag;
+ALTER TABLE transactions DROP COLUMN connectorId;
@@ -0,0 +1,14 @@
+-- Databases created by CreateTables before migrations existed already have these columns, and sqlite can't ADD COLUMN IF NOT EXISTS
+-- skip-if-column: transactions.status
+ALTER TABLE transactions ADD COLUMN connectorId INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN idTag TEXT NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN reservationId INTEGER NULL;
+ALTER TABLE transactions ADD COLUMN meterStart INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN timeReceived INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN stopReason TEXT NULL;
+ALTER TABLE transactions ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+
+-- Transactions recorded before status existed are either stopped or unknown
+UPDATE transactions SET status = 'completed' WHERE timeEnded IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS transaction_clientId_status_IDX ON transactions (clientId, status);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS devices_tenant_networkid_UIDX;
+CREATE INDEX IF NOT EXISTS devices_tenant_networkid_IDX ON devices (tenant, networkid);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS messages_networkId_messageTime_IDX;
+DROP TABLE IF EXISTS messages;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS dead_letters_networkId_messageTime_IDX;
+DROP INDEX IF EXISTS dead_letters_messageTime_IDX;
+DROP TABLE IF EXISTS dead_letters;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS certificates_status_notAfter_IDX;
+DROP INDEX IF EXISTS certificates_tenant_networkId_issuedAt_IDX;
+DROP TABLE IF EXISTS certificates;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS certificates (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	tenant TEXT NOT NULL,
+	networkId TEXT NOT NULL,
+	certificateType TEXT NOT NULL,
+	serialNumber TEXT NOT NULL,
+	commonName TEXT NOT NULL,
+	status TEXT NOT NULL,
+	certificateChain TEXT NOT NULL,
+	notBefore INTEGER NOT NULL,
+	notAfter INTEGER NOT NULL,
+	issuedAt INTEGER NOT NULL,
+	renewalRequestedAt INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS certificates_tenant_networkId_issuedAt_IDX ON certificates (tenant, networkId, issuedAt);
+CREATE INDEX IF NOT EXISTS certificates_status_notAfter_IDX ON certificates (status, notAfter);
@@ -0,0 +1,2 @@
+ALTER TABLE devices DROP COLUMN disabled;
+ALTER TABLE devices DROP COLUMN passwordHash;
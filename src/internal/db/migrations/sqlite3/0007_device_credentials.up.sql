@@ -0,0 +1,3 @@
+-- Basic auth password hash, for csms-server's sql auth backend
+ALTER TABLE devices ADD COLUMN passwordHash TEXT NULL;
+ALTER TABLE devices ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0;
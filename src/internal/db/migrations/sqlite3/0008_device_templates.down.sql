@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS device_compliance_status_IDX;
+DROP TABLE IF EXISTS device_compliance;
+DROP INDEX IF EXISTS device_templates_tenant_name_UIDX;
+DROP TABLE IF EXISTS device_templates;
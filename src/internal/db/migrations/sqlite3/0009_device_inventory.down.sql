@@ -0,0 +1,2 @@
+ALTER TABLE devices DROP COLUMN tags;
+ALTER TABLE devices DROP COLUMN firmwareVersion;
@@ -0,0 +1,4 @@
+-- Reported by BootNotification, and set by the API, for selecting devices
+ALTER TABLE devices ADD COLUMN firmwareVersion TEXT NULL;
+-- Comma separated, with leading and trailing commas
+ALTER TABLE devices ADD COLUMN tags TEXT NULL;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS bulk_jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	tenant TEXT NOT NULL,
+	owner TEXT NOT NULL,
+	action TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	selector TEXT NOT NULL,
+	concurrency INTEGER NOT NULL,
+	ratePerSec INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	total INTEGER NOT NULL,
+	createdAt INTEGER NOT NULL,
+	completedAt INTEGER NULL
+);
+
+CREATE INDEX IF NOT EXISTS bulk_jobs_tenant_createdAt_IDX ON bulk_jobs (tenant, createdAt);
+
+CREATE TABLE IF NOT EXISTS bulk_job_results (
+	jobId INTEGER NOT NULL,
+	networkid TEXT NOT NULL,
+	status TEXT NOT NULL,
+	response TEXT NULL,
+	error TEXT NULL,
+	completedAt INTEGER NULL,
+	PRIMARY KEY (jobId, networkid)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS commands_status_IDX;
+DROP INDEX IF EXISTS commands_tenant_networkid_IDX;
+DROP TABLE IF EXISTS commands;
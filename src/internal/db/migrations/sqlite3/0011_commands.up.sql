@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS commands (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	tenant TEXT NOT NULL,
+	owner TEXT NOT NULL,
+	networkid TEXT NOT NULL,
+	action TEXT NOT NULL,
+	msgId TEXT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	response TEXT NULL,
+	error TEXT NULL,
+	callbackUrl TEXT NULL,
+	createdAt INTEGER NOT NULL,
+	sentAt INTEGER NULL,
+	completedAt INTEGER NULL
+);
+
+CREATE INDEX IF NOT EXISTS commands_tenant_networkid_IDX ON commands (tenant, networkid);
+CREATE INDEX IF NOT EXISTS commands_status_IDX ON commands (status);
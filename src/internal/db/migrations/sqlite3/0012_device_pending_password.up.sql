@@ -0,0 +1,2 @@
+-- Password hash sent to the charger, accepted alongside passwordHash until the charger first authenticates with it
+ALTER TABLE devices ADD COLUMN pendingPasswordHash TEXT NULL;
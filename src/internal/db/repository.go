@@ -0,0 +1,136 @@
+// Provides the repository interfaces implemented by the SQL store and the in-memory fakes in ./memdb
+package db
+
+import (
+	"context"
+	"time"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+)
+
+type TransactionRepository interface {
+	// Inserts a new active transaction, returning its id. Any transaction still active on the
+	// same connector is marked orphaned, as the charger can only run one at a time per connector.
+	InsertNextTransaction(ctx context.Context, transaction *dbmodels.Transaction) (int64, error)
+	// Completes an active transaction for a given client, or returns ErrNotFound
+	CompleteTransaction(ctx context.Context, clientId string, transactionId int64, timeEnded time.Time, meterStop float64, stopReason string) error
+	// Gets a transaction by id for a given client, or returns ErrNotFound
+	GetTransaction(ctx context.Context, clientId string, transactionId int64) (*dbmodels.Transaction, error)
+	// Lists transactions matching the filter, newest first
+	ListTransactions(ctx context.Context, filter dbmodels.TransactionFilter) ([]dbmodels.Transaction, error)
+	// Deletes up to limit transactions which are no longer active and started before the given time,
+	// returning the number deleted
+	DeleteTransactionsBefore(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+type DeviceRepository interface {
+	// Inserts a device, returning its id. Returns ErrRowExists if the networkId is taken for the tenant
+	InsertDevice(ctx context.Context, device *dbmodels.Device) (int64, error)
+	// Gets a device by networkId, or returns ErrNotFound
+	GetDevice(ctx context.Context, tenant string, networkId string) (*dbmodels.Device, error)
+	// Applies modify to a device's current record and saves its template, password hash, disabled flag and tags,
+	// in a transaction so concurrent changes aren't lost. Returns the updated device, or ErrNotFound
+	ModifyDevice(ctx context.Context, tenant string, networkId string, modify func(device *dbmodels.Device)) (*dbmodels.Device, error)
+	// Records the firmware version reported by the charger, or returns ErrNotFound
+	SetFirmwareVersion(ctx context.Context, tenant string, networkId string, version string) error
+	// Deletes a device by networkId, or returns ErrNotFound
+	DeleteDevice(ctx context.Context, tenant string, networkId string) error
+	// Lists devices matching the filter, ordered by networkId
+	ListDevices(ctx context.Context, filter dbmodels.DeviceFilter) ([]dbmodels.Device, error)
+}
+
+type DeviceTemplateRepository interface {
+	// Inserts a template, returning its id. Returns ErrRowExists if the name is taken for the tenant
+	InsertTemplate(ctx context.Context, template *dbmodels.DeviceTemplate) (int64, error)
+	// Gets a template by id, or returns ErrNotFound
+	GetTemplate(ctx context.Context, tenant string, id int64) (*dbmodels.DeviceTemplate, error)
+	// Replaces a template's name, configuration and charging profiles, or returns ErrNotFound
+	UpdateTemplate(ctx context.Context, template *dbmodels.DeviceTemplate) error
+	// Deletes a template by id, or returns ErrNotFound
+	DeleteTemplate(ctx context.Context, tenant string, id int64) error
+	// Lists templates matching the filter, ordered by name
+	ListTemplates(ctx context.Context, filter dbmodels.DeviceTemplateFilter) ([]dbmodels.DeviceTemplate, error)
+}
+
+type DeviceComplianceRepository interface {
+	// Inserts or replaces a device's compliance
+	SetCompliance(ctx context.Context, compliance *dbmodels.DeviceCompliance) error
+	// Gets a device's compliance by networkId, or returns ErrNotFound if it hasn't been checked
+	GetCompliance(ctx context.Context, tenant string, networkId string) (*dbmodels.DeviceCompliance, error)
+	// Deletes a device's compliance, once the device is deleted
+	DeleteCompliance(ctx context.Context, tenant string, networkId string) error
+	// Lists compliance matching the filter, ordered by networkId
+	ListCompliance(ctx context.Context, filter dbmodels.DeviceComplianceFilter) ([]dbmodels.DeviceCompliance, error)
+}
+
+type BulkJobRepository interface {
+	// Inserts a job with a pending result for each networkId in a single DB transaction, returning its id
+	InsertJob(ctx context.Context, job *dbmodels.BulkJob, networkIds []string) (int64, error)
+	// Gets a job by id with its result counts, or returns ErrNotFound
+	GetJob(ctx context.Context, tenant string, id int64) (*dbmodels.BulkJob, error)
+	// Moves a job from fromStatus to status, or returns ErrNotFound if it isn't in fromStatus
+	UpdateJobStatus(ctx context.Context, tenant string, id int64, fromStatus string, status string, completedAt time.Time) error
+	// Marks the tenant's running jobs of owner as interrupted, returning the number marked. Called at startup,
+	// as jobs only run in the device-manager instance which created them.
+	InterruptJobs(ctx context.Context, tenant string, owner string, interruptedAt time.Time) (int64, error)
+	// Marks the tenant's running jobs created before inactiveSince, without a result completed since, as interrupted,
+	// whichever instance runs them, returning the number marked. Recovers the jobs of an instance which stopped and
+	// didn't restart
+	ExpireJobs(ctx context.Context, tenant string, inactiveSince time.Time, expiredAt time.Time) (int64, error)
+	// Lists jobs matching the filter with their result counts, newest first
+	ListJobs(ctx context.Context, filter dbmodels.BulkJobFilter) ([]dbmodels.BulkJob, error)
+	// Sets a charger's result for a job, or returns ErrNotFound
+	SetResult(ctx context.Context, result *dbmodels.BulkJobResult) error
+	// Lists a job's results matching the filter, ordered by networkId
+	ListResults(ctx context.Context, filter dbmodels.BulkJobResultFilter) ([]dbmodels.BulkJobResult, error)
+}
+
+type CommandRepository interface {
+	// Inserts a command, returning its id
+	InsertCommand(ctx context.Context, command *dbmodels.Command) (int64, error)
+	// Gets a command by id, or returns ErrNotFound
+	GetCommand(ctx context.Context, tenant string, id int64) (*dbmodels.Command, error)
+	// Updates a command's msgId, status, response, error and times, or returns ErrNotFound
+	UpdateCommand(ctx context.Context, command *dbmodels.Command) error
+	// Marks the tenant's queued and sent commands of owner as errors, returning the number marked. Called at startup,
+	// as commands are only awaited by the device-manager instance which sent them.
+	InterruptCommands(ctx context.Context, tenant string, owner string, interruptedAt time.Time) (int64, error)
+	// Marks the tenant's queued and sent commands created before createdBefore as errors, whichever instance sent
+	// them, returning the number marked. Recovers the commands of an instance which stopped and didn't restart
+	ExpireCommands(ctx context.Context, tenant string, createdBefore time.Time, expiredAt time.Time) (int64, error)
+	// Lists commands matching the filter, newest first
+	ListCommands(ctx context.Context, filter dbmodels.CommandFilter) ([]dbmodels.Command, error)
+}
+
+type MessageRepository interface {
+	// Inserts the messages in a single DB transaction
+	InsertMessages(ctx context.Context, messages []dbmodels.Message) error
+	// Lists messages matching the filter, oldest first
+	ListMessages(ctx context.Context, filter dbmodels.MessageFilter) ([]dbmodels.Message, error)
+	// Deletes up to filter.Limit messages, returning the number deleted
+	DeleteMessages(ctx context.Context, filter dbmodels.MessageDeleteFilter) (int64, error)
+}
+
+type DeadLetterRepository interface {
+	// Inserts a dead letter, returning its id
+	InsertDeadLetter(ctx context.Context, deadLetter *dbmodels.DeadLetter) (int64, error)
+	// Gets a dead letter by id, or returns ErrNotFound
+	GetDeadLetter(ctx context.Context, id int64) (*dbmodels.DeadLetter, error)
+	// Lists dead letters matching the filter, newest first
+	ListDeadLetters(ctx context.Context, filter dbmodels.DeadLetterFilter) ([]dbmodels.DeadLetter, error)
+	// Deletes a dead letter once triaged, or returns ErrNotFound
+	DeleteDeadLetter(ctx context.Context, id int64) error
+}
+
+type CertificateRepository interface {
+	// Inserts an issued certificate, returning its id
+	InsertCertificate(ctx context.Context, certificate *dbmodels.Certificate) (int64, error)
+	// Sets a certificate's status, or returns ErrNotFound
+	UpdateCertificateStatus(ctx context.Context, id int64, status string) error
+	// Marks a charger's other accepted certificates of the type as superseded, once keepId is accepted
+	SupersedeCertificates(ctx context.Context, tenant string, networkId string, certificateType string, keepId int64) error
+	// Records that the charger was asked to renew a certificate, or returns ErrNotFound
+	SetRenewalRequested(ctx context.Context, id int64, requestedAt time.Time) error
+	// Lists certificates matching the filter, newest first
+	ListCertificates(ctx context.Context, filter dbmodels.CertificateFilter) ([]dbmodels.Certificate, error)
+}
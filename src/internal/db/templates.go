@@ -0,0 +1,247 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/metrics"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+)
+
+const (
+	templateColumns   = "id,tenant,name,configuration,chargingProfiles,updatedAt"
+	complianceColumns = "tenant,networkid,devicetemplateid,status,configurationKeys,chargingProfiles,error,checkedAt"
+)
+
+type sqlDeviceTemplateRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r *sqlDeviceTemplateRepository) InsertTemplate(ctx context.Context, template *dbmodels.DeviceTemplate) (int64, error) {
+	defer metrics.ObserveDbQuery("device_templates", "InsertTemplate", time.Now())
+	configuration, chargingProfiles, err := marshalTemplate(template)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := r.dialect.InsertReturningId(ctx, r.db,
+		r.dialect.Rebind("INSERT INTO device_templates(tenant,name,configuration,chargingProfiles,updatedAt) VALUES (?,?,?,?,?)"),
+		template.Tenant, template.Name, configuration, chargingProfiles, template.UpdatedAt.UnixMilli())
+	if err != nil {
+		if r.dialect.IsUniqueViolation(err) {
+			return 0, ErrRowExists
+		}
+		return 0, err
+	}
+	template.Id = id
+	return id, nil
+}
+
+func (r *sqlDeviceTemplateRepository) GetTemplate(ctx context.Context, tenant string, id int64) (*dbmodels.DeviceTemplate, error) {
+	defer metrics.ObserveDbQuery("device_templates", "GetTemplate", time.Now())
+	row := r.db.QueryRowContext(ctx, r.dialect.Rebind("SELECT "+templateColumns+" FROM device_templates WHERE tenant = ? AND id = ?"), tenant, id)
+
+	template, err := scanTemplate(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return template, err
+}
+
+func (r *sqlDeviceTemplateRepository) UpdateTemplate(ctx context.Context, template *dbmodels.DeviceTemplate) error {
+	defer metrics.ObserveDbQuery("device_templates", "UpdateTemplate", time.Now())
+	configuration, chargingProfiles, err := marshalTemplate(template)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.ExecContext(ctx,
+		r.dialect.Rebind("UPDATE device_templates SET name = ?, configuration = ?, chargingProfiles = ?, updatedAt = ? WHERE tenant = ? AND id = ?"),
+		template.Name, configuration, chargingProfiles, template.UpdatedAt.UnixMilli(), template.Tenant, template.Id)
+	if err != nil && r.dialect.IsUniqueViolation(err) {
+		return ErrRowExists
+	}
+	return expectUpdated(res, err)
+}
+
+func (r *sqlDeviceTemplateRepository) DeleteTemplate(ctx context.Context, tenant string, id int64) error {
+	defer metrics.ObserveDbQuery("device_templates", "DeleteTemplate", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("DELETE FROM device_templates WHERE tenant = ? AND id = ?"), tenant, id)
+	return expectUpdated(res, err)
+}
+
+func (r *sqlDeviceTemplateRepository) ListTemplates(ctx context.Context, filter dbmodels.DeviceTemplateFilter) ([]dbmodels.DeviceTemplate, error) {
+	defer metrics.ObserveDbQuery("device_templates", "ListTemplates", time.Now())
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.Tenant != "" {
+		where = append(where, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	query := "SELECT " + templateColumns + " FROM device_templates WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY " + r.dialect.OrderText("name") + " LIMIT ? OFFSET ?"
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []dbmodels.DeviceTemplate{}
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *template)
+	}
+	return templates, rows.Err()
+}
+
+func marshalTemplate(template *dbmodels.DeviceTemplate) (string, string, error) {
+	configuration, err := json.Marshal(template.Configuration)
+	if err != nil {
+		return "", "", err
+	}
+	chargingProfiles, err := json.Marshal(template.ChargingProfiles)
+	if err != nil {
+		return "", "", err
+	}
+	return string(configuration), string(chargingProfiles), nil
+}
+
+func scanTemplate(row rowScanner) (*dbmodels.DeviceTemplate, error) {
+	var template dbmodels.DeviceTemplate
+	var configuration, chargingProfiles string
+	var updatedAt int64
+	err := row.Scan(&template.Id, &template.Tenant, &template.Name, &configuration, &chargingProfiles, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(configuration), &template.Configuration); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(chargingProfiles), &template.ChargingProfiles); err != nil {
+		return nil, err
+	}
+	template.UpdatedAt = time.UnixMilli(updatedAt).UTC()
+	return &template, nil
+}
+
+type sqlDeviceComplianceRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r *sqlDeviceComplianceRepository) SetCompliance(ctx context.Context, compliance *dbmodels.DeviceCompliance) error {
+	defer metrics.ObserveDbQuery("device_compliance", "SetCompliance", time.Now())
+	keys, err := json.Marshal(compliance.Keys)
+	if err != nil {
+		return err
+	}
+	chargingProfiles, err := json.Marshal(compliance.ChargingProfiles)
+	if err != nil {
+		return err
+	}
+
+	// ON CONFLICT is supported by both sqlite and postgres
+	_, err = r.db.ExecContext(ctx, r.dialect.Rebind("INSERT INTO device_compliance("+complianceColumns+") VALUES (?,?,?,?,?,?,?,?) "+
+		"ON CONFLICT (tenant, networkid) DO UPDATE SET devicetemplateid = excluded.devicetemplateid, status = excluded.status, "+
+		"configurationKeys = excluded.configurationKeys, chargingProfiles = excluded.chargingProfiles, error = excluded.error, checkedAt = excluded.checkedAt"),
+		compliance.Tenant, compliance.NetworkId, compliance.DeviceTemplateId, compliance.Status, string(keys), string(chargingProfiles),
+		nullString(compliance.Error), compliance.CheckedAt.UnixMilli())
+	return err
+}
+
+func (r *sqlDeviceComplianceRepository) GetCompliance(ctx context.Context, tenant string, networkId string) (*dbmodels.DeviceCompliance, error) {
+	defer metrics.ObserveDbQuery("device_compliance", "GetCompliance", time.Now())
+	row := r.db.QueryRowContext(ctx, r.dialect.Rebind("SELECT "+complianceColumns+" FROM device_compliance WHERE tenant = ? AND networkid = ?"), tenant, networkId)
+
+	compliance, err := scanCompliance(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return compliance, err
+}
+
+func (r *sqlDeviceComplianceRepository) DeleteCompliance(ctx context.Context, tenant string, networkId string) error {
+	defer metrics.ObserveDbQuery("device_compliance", "DeleteCompliance", time.Now())
+	_, err := r.db.ExecContext(ctx, r.dialect.Rebind("DELETE FROM device_compliance WHERE tenant = ? AND networkid = ?"), tenant, networkId)
+	return err
+}
+
+func (r *sqlDeviceComplianceRepository) ListCompliance(ctx context.Context, filter dbmodels.DeviceComplianceFilter) ([]dbmodels.DeviceCompliance, error) {
+	defer metrics.ObserveDbQuery("device_compliance", "ListCompliance", time.Now())
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.Tenant != "" {
+		where = append(where, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.DeviceTemplateId != 0 {
+		where = append(where, "devicetemplateid = ?")
+		args = append(args, filter.DeviceTemplateId)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	query := "SELECT " + complianceColumns + " FROM device_compliance WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY " + r.dialect.OrderText("networkid") + " LIMIT ? OFFSET ?"
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	compliance := []dbmodels.DeviceCompliance{}
+	for rows.Next() {
+		c, err := scanCompliance(rows)
+		if err != nil {
+			return nil, err
+		}
+		compliance = append(compliance, *c)
+	}
+	return compliance, rows.Err()
+}
+
+func scanCompliance(row rowScanner) (*dbmodels.DeviceCompliance, error) {
+	var compliance dbmodels.DeviceCompliance
+	var keys, chargingProfiles string
+	var complianceError sql.NullString
+	var checkedAt int64
+	err := row.Scan(&compliance.Tenant, &compliance.NetworkId, &compliance.DeviceTemplateId, &compliance.Status, &keys, &chargingProfiles,
+		&complianceError, &checkedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(keys), &compliance.Keys); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(chargingProfiles), &compliance.ChargingProfiles); err != nil {
+		return nil, err
+	}
+	compliance.Error = complianceError.String
+	compliance.CheckedAt = time.UnixMilli(checkedAt).UTC()
+	return &compliance, nil
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceTemplates(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.Templates
+		updatedAt := time.Date(2024, 9, 27, 8, 0, 0, 0, time.UTC)
+
+		template := &dbmodels.DeviceTemplate{Name: "ac-22kw", Configuration: map[string]string{"HeartbeatInterval": "300"},
+			ChargingProfiles: []json.RawMessage{json.RawMessage(`{"connectorId":0}`)}, UpdatedAt: updatedAt}
+		id, err := repo.InsertTemplate(ctx, template)
+		require.NoError(t, err)
+		_, err = repo.InsertTemplate(ctx, &dbmodels.DeviceTemplate{Name: "ac-22kw", UpdatedAt: updatedAt})
+		assert.ErrorIs(t, err, ErrRowExists)
+		_, err = repo.InsertTemplate(ctx, &dbmodels.DeviceTemplate{Name: "ac-11kw", UpdatedAt: updatedAt})
+		require.NoError(t, err)
+
+		got, err := repo.GetTemplate(ctx, "", id)
+		require.NoError(t, err)
+		assert.Equal(t, template, got)
+
+		template.Configuration["MeterValueSampleInterval"] = "60"
+		require.NoError(t, repo.UpdateTemplate(ctx, template))
+		got, err = repo.GetTemplate(ctx, "", id)
+		require.NoError(t, err)
+		assert.Equal(t, "60", got.Configuration["MeterValueSampleInterval"])
+		template.Name = "ac-11kw"
+		assert.ErrorIs(t, repo.UpdateTemplate(ctx, template), ErrRowExists)
+
+		templates, err := repo.ListTemplates(ctx, dbmodels.DeviceTemplateFilter{})
+		require.NoError(t, err)
+		require.Len(t, templates, 2)
+		assert.Equal(t, "ac-11kw", templates[0].Name, "by name")
+
+		require.NoError(t, repo.DeleteTemplate(ctx, "", id))
+		_, err = repo.GetTemplate(ctx, "", id)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, repo.DeleteTemplate(ctx, "", id), ErrNotFound)
+	})
+}
+
+func TestDeviceCompliance(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.Compliance
+		checkedAt := time.Date(2024, 9, 27, 8, 0, 0, 0, time.UTC)
+
+		_, err := repo.GetCompliance(ctx, "", "charger-1")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		compliance := &dbmodels.DeviceCompliance{NetworkId: "charger-1", DeviceTemplateId: 1, Status: dbmodels.ComplianceStatus_Remediated,
+			Keys:             []dbmodels.KeyCompliance{{Key: "HeartbeatInterval", Desired: "300", Actual: "60", Status: dbmodels.KeyStatus_Changed}},
+			ChargingProfiles: []string{"Accepted"}, CheckedAt: checkedAt}
+		require.NoError(t, repo.SetCompliance(ctx, compliance))
+		got, err := repo.GetCompliance(ctx, "", "charger-1")
+		require.NoError(t, err)
+		assert.Equal(t, compliance, got)
+
+		compliance.Status, compliance.Error = dbmodels.ComplianceStatus_Error, "timed out"
+		require.NoError(t, repo.SetCompliance(ctx, compliance), "replaced")
+		require.NoError(t, repo.SetCompliance(ctx, &dbmodels.DeviceCompliance{NetworkId: "charger-2", DeviceTemplateId: 2,
+			Status: dbmodels.ComplianceStatus_Compliant, Keys: []dbmodels.KeyCompliance{}, ChargingProfiles: []string{}, CheckedAt: checkedAt}))
+
+		list, err := repo.ListCompliance(ctx, dbmodels.DeviceComplianceFilter{Status: dbmodels.ComplianceStatus_Error})
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		assert.Equal(t, "timed out", list[0].Error)
+		list, err = repo.ListCompliance(ctx, dbmodels.DeviceComplianceFilter{DeviceTemplateId: 2})
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		assert.Equal(t, "charger-2", list[0].NetworkId)
+
+		require.NoError(t, repo.DeleteCompliance(ctx, "", "charger-1"))
+		_, err = repo.GetCompliance(ctx, "", "charger-1")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"sw/ocpp/csms/internal/metrics"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/google/uuid"
+)
+
+const transactionColumns = "id,guid,clientId,connectorId,idTag,reservationId,meterStart,timeStarted,timeReceived,timeEnded,meterStop,stopReason,status"
+
+type sqlTransactionRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r *sqlTransactionRepository) InsertNextTransaction(ctx context.Context, transaction *dbmodels.Transaction) (int64, error) {
+	defer metrics.ObserveDbQuery("transactions", "InsertNextTransaction", time.Now())
+	guid := uuid.New().String()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, r.dialect.Rebind("UPDATE transactions SET status = ? WHERE clientId = ? AND connectorId = ? AND status = ?"),
+		dbmodels.TransactionStatus_Orphaned, transaction.ClientId, transaction.ConnectorId, dbmodels.TransactionStatus_Active)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := r.dialect.InsertReturningId(ctx, tx, r.dialect.Rebind("INSERT INTO transactions(guid,clientId,connectorId,idTag,reservationId,meterStart,timeStarted,timeReceived,status) VALUES (?,?,?,?,?,?,?,?,?)"),
+		guid, transaction.ClientId, transaction.ConnectorId, transaction.IdTag, transaction.ReservationId, transaction.MeterStart,
+		transaction.TimeStarted.UnixMilli(), transaction.TimeReceived.UnixMilli(), dbmodels.TransactionStatus_Active)
+	if err != nil {
+		if r.dialect.IsUniqueViolation(err) {
+			return 0, ErrRowExists
+		}
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *sqlTransactionRepository) CompleteTransaction(ctx context.Context, clientId string, transactionId int64, timeEnded time.Time, meterStop float64, stopReason string) error {
+	defer metrics.ObserveDbQuery("transactions", "CompleteTransaction", time.Now())
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("UPDATE transactions SET timeEnded = ?, meterStop = ?, stopReason = ?, status = ? WHERE id = ? AND clientId = ? AND status = ?"),
+		timeEnded.UnixMilli(), meterStop, stopReason, dbmodels.TransactionStatus_Completed,
+		transactionId, clientId, dbmodels.TransactionStatus_Active)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *sqlTransactionRepository) GetTransaction(ctx context.Context, clientId string, transactionId int64) (*dbmodels.Transaction, error) {
+	defer metrics.ObserveDbQuery("transactions", "GetTransaction", time.Now())
+	row := r.db.QueryRowContext(ctx, r.dialect.Rebind("SELECT "+transactionColumns+" FROM transactions WHERE id = ? AND clientId = ?"), transactionId, clientId)
+
+	transaction, err := scanTransaction(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return transaction, err
+}
+
+func (r *sqlTransactionRepository) ListTransactions(ctx context.Context, filter dbmodels.TransactionFilter) ([]dbmodels.Transaction, error) {
+	defer metrics.ObserveDbQuery("transactions", "ListTransactions", time.Now())
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.ClientId != "" {
+		where = append(where, "clientId = ?")
+		args = append(args, filter.ClientId)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.ConnectorId != nil {
+		where = append(where, "connectorId = ?")
+		args = append(args, *filter.ConnectorId)
+	}
+	if filter.From != nil {
+		where = append(where, "timeStarted >= ?")
+		args = append(args, filter.From.UnixMilli())
+	}
+	if filter.To != nil {
+		where = append(where, "timeStarted < ?")
+		args = append(args, filter.To.UnixMilli())
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	args = append(args, limit, filter.Offset)
+
+	query := "SELECT " + transactionColumns + " FROM transactions WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY id DESC LIMIT ? OFFSET ?"
+	rows, err := r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []dbmodels.Transaction{}
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, *transaction)
+	}
+	return transactions, rows.Err()
+}
+
+func scanTransaction(row rowScanner) (*dbmodels.Transaction, error) {
+	var (
+		transaction   dbmodels.Transaction
+		reservationId sql.NullInt64
+		timeStarted   int64
+		timeReceived  int64
+		timeEnded     sql.NullInt64
+		meterStop     sql.NullFloat64
+		stopReason    sql.NullString
+	)
+
+	err := row.Scan(&transaction.Id, &transaction.Guid, &transaction.ClientId, &transaction.ConnectorId, &transaction.IdTag,
+		&reservationId, &transaction.MeterStart, &timeStarted, &timeReceived, &timeEnded, &meterStop, &stopReason, &transaction.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction.TimeStarted = time.UnixMilli(timeStarted).UTC()
+	transaction.TimeReceived = time.UnixMilli(timeReceived).UTC()
+	if reservationId.Valid {
+		id := int(reservationId.Int64)
+		transaction.ReservationId = &id
+	}
+	if timeEnded.Valid {
+		t := time.UnixMilli(timeEnded.Int64).UTC()
+		transaction.TimeEnded = &t
+	}
+	if meterStop.Valid {
+		transaction.MeterStop = &meterStop.Float64
+	}
+	transaction.StopReason = stopReason.String
+
+	return &transaction, nil
+}
+
+func (r *sqlTransactionRepository) DeleteTransactionsBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	defer metrics.ObserveDbQuery("transactions", "DeleteTransactionsBefore", time.Now())
+	if limit <= 0 {
+		limit = DefaultDeleteLimit
+	}
+	res, err := r.db.ExecContext(ctx, r.dialect.Rebind("DELETE FROM transactions WHERE id IN (SELECT id FROM transactions WHERE timeStarted < ? AND status <> ? LIMIT ?)"),
+		before.UnixMilli(), dbmodels.TransactionStatus_Active, limit)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
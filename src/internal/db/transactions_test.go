@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbmodels "sw/ocpp/csms/internal/models/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionLifecycle(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.Transactions
+
+		reservationId := 7
+		started := time.Date(2024, 9, 27, 8, 59, 59, 0, time.UTC)
+		transaction := &dbmodels.Transaction{
+			ClientId:      "charger-1",
+			ConnectorId:   1,
+			IdTag:         "TAG1",
+			ReservationId: &reservationId,
+			MeterStart:    1200,
+			TimeStarted:   started,
+			TimeReceived:  started.Add(time.Second),
+		}
+
+		id, err := repo.InsertNextTransaction(ctx, transaction)
+		require.NoError(t, err)
+
+		result, err := repo.GetTransaction(ctx, "charger-1", id)
+		require.NoError(t, err)
+		assert.Equal(t, dbmodels.TransactionStatus_Active, result.Status)
+		assert.Equal(t, "TAG1", result.IdTag)
+		assert.Equal(t, 1200, result.MeterStart)
+		assert.Equal(t, &reservationId, result.ReservationId)
+		assert.Equal(t, started, result.TimeStarted)
+		assert.Equal(t, started.Add(time.Second), result.TimeReceived)
+
+		err = repo.CompleteTransaction(ctx, "charger-1", id, started.Add(time.Hour), 5400, "Local")
+		require.NoError(t, err)
+
+		result, err = repo.GetTransaction(ctx, "charger-1", id)
+		require.NoError(t, err)
+		assert.Equal(t, dbmodels.TransactionStatus_Completed, result.Status)
+		assert.Equal(t, 5400.0, *result.MeterStop)
+		assert.Equal(t, "Local", result.StopReason)
+
+		// Already completed
+		err = repo.CompleteTransaction(ctx, "charger-1", id, started.Add(time.Hour), 5400, "Local")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		// Other client
+		_, err = repo.GetTransaction(ctx, "charger-2", id)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestInsertNextTransactionOrphansActiveOnConnector(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.Transactions
+
+		now := time.Now()
+		first, err := repo.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-1", ConnectorId: 1, TimeStarted: now, TimeReceived: now})
+		require.NoError(t, err)
+		otherConnector, err := repo.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-1", ConnectorId: 2, TimeStarted: now, TimeReceived: now})
+		require.NoError(t, err)
+		second, err := repo.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-1", ConnectorId: 1, TimeStarted: now, TimeReceived: now})
+		require.NoError(t, err)
+
+		result, err := repo.GetTransaction(ctx, "charger-1", first)
+		require.NoError(t, err)
+		assert.Equal(t, dbmodels.TransactionStatus_Orphaned, result.Status)
+
+		active, err := repo.ListTransactions(ctx, dbmodels.TransactionFilter{ClientId: "charger-1", Status: dbmodels.TransactionStatus_Active})
+		require.NoError(t, err)
+		require.Len(t, active, 2)
+		assert.Equal(t, second, active[0].Id)
+		assert.Equal(t, otherConnector, active[1].Id)
+	})
+}
+
+func TestDeleteTransactionsBefore(t *testing.T) {
+	setupTestDb(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		repo := store.Transactions
+
+		old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		completed, err := repo.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-1", ConnectorId: 1, TimeStarted: old, TimeReceived: old})
+		require.NoError(t, err)
+		require.NoError(t, repo.CompleteTransaction(ctx, "charger-1", completed, old.Add(time.Hour), 100, "Local"))
+		active, err := repo.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-1", ConnectorId: 2, TimeStarted: old, TimeReceived: old})
+		require.NoError(t, err)
+		now := time.Now()
+		recent, err := repo.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-1", ConnectorId: 1, TimeStarted: now, TimeReceived: now})
+		require.NoError(t, err)
+		require.NoError(t, repo.CompleteTransaction(ctx, "charger-1", recent, now, 100, "Local"))
+
+		deleted, err := repo.DeleteTransactionsBefore(ctx, now.Add(-time.Hour), 0)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		_, err = repo.GetTransaction(ctx, "charger-1", completed)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = repo.GetTransaction(ctx, "charger-1", active)
+		assert.NoError(t, err)
+		_, err = repo.GetTransaction(ctx, "charger-1", recent)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,13 @@
+package helpers
+
+import "regexp"
+
+// The longest networkId csms-server accepts, longer ones in the connection URL are truncated
+const NetworkIdMaxLen = 32
+
+var networkIdPattern = regexp.MustCompile(`^[A-Za-z0-9\-]+$`)
+
+// Returns true if the networkId is only letters, digits and '-'
+func ValidNetworkIdString(networkId string) bool {
+	return networkIdPattern.MatchString(networkId)
+}
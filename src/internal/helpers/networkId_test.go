@@ -0,0 +1,16 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidNetworkIdString(t *testing.T) {
+	for _, networkId := range []string{"charger-1", "CP001", "a"} {
+		assert.True(t, ValidNetworkIdString(networkId), networkId)
+	}
+	for _, networkId := range []string{"", "charger_1", "charger 1", "charger/1", "chärger"} {
+		assert.False(t, ValidNetworkIdString(networkId), networkId)
+	}
+}
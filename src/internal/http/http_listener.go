@@ -1,9 +1,13 @@
 package http
 
 import (
-	"io"
+	"context"
+	"crypto/tls"
+	"errors"
 	"net"
 	nethttp "net/http"
+	"sync"
+
 	log "sw/ocpp/csms/internal/logging"
 )
 
@@ -11,31 +15,75 @@ type TcpKeepAliveListener struct {
 	*net.TCPListener
 }
 
-func ListenAndServeWithClose(addr string, handler nethttp.Handler) (io.Closer, error) {
-	var (
-		listener  net.Listener
-		srvCloser io.Closer
-		err       error
-	)
+// A listener serving HTTP until closed
+type Listener struct {
+	listener net.Listener
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+func ListenAndServeWithClose(addr string, handler nethttp.Handler) (*Listener, error) {
+	return listenAndServe(addr, handler, nil)
+}
+
+// Serves HTTPS, with the certificate from tlsConfig's GetCertificate or Certificates
+func ListenAndServeTLSWithClose(addr string, handler nethttp.Handler, tlsConfig *tls.Config) (*Listener, error) {
+	return listenAndServe(addr, handler, tlsConfig)
+}
 
-	srv := &nethttp.Server{Addr: addr, Handler: handler}
+func listenAndServe(addr string, handler nethttp.Handler, tlsConfig *tls.Config) (*Listener, error) {
+	srv := &nethttp.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
 
 	if addr == "" {
 		addr = ":http"
 	}
 
-	listener, err = net.Listen("tcp", addr)
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
 
+	l := &Listener{listener: listener}
 	go func() {
-		err := srv.Serve(TcpKeepAliveListener{listener.(*net.TCPListener)})
-		if err != nil {
+		var err error
+		keepAliveListener := TcpKeepAliveListener{listener.(*net.TCPListener)}
+		if tlsConfig != nil {
+			err = srv.ServeTLS(keepAliveListener, "", "")
+		} else {
+			err = srv.Serve(keepAliveListener)
+		}
+		l.mu.Lock()
+		l.err = err
+		closed := l.closed
+		l.mu.Unlock()
+		if err != nil && !closed {
 			log.Logger.Errorf("HTTP Server Error - %s", err)
 		}
 	}()
 
-	srvCloser = listener
-	return srvCloser, nil
+	return l, nil
+}
+
+// The address listened on, e.g to find the port when listening on port 0
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	return l.listener.Close()
+}
+
+// Returns an error if the listener has stopped serving
+func (l *Listener) Check(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return errors.New("listener closed")
+	}
+	return l.err
 }
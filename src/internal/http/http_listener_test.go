@@ -0,0 +1,19 @@
+package http
+
+import (
+	"context"
+	nethttp "net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenerCheck(t *testing.T) {
+	listener, err := ListenAndServeWithClose("127.0.0.1:0", nethttp.NotFoundHandler())
+	require.NoError(t, err)
+	assert.NoError(t, listener.Check(context.Background()))
+
+	require.NoError(t, listener.Close())
+	assert.EqualError(t, listener.Check(context.Background()), "listener closed")
+}
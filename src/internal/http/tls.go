@@ -0,0 +1,126 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	log "sw/ocpp/csms/internal/logging"
+)
+
+const defaultReloadInterval = time.Minute
+
+// Serves a certificate and key, reloading them when their files change, e.g once renewed
+type CertReloader struct {
+	certFile       string
+	keyFile        string
+	reloadInterval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTimes  [2]time.Time
+	lastCheck time.Time
+}
+
+func NewCertReloader(certFile string, keyFile string, reloadInterval time.Duration) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, reloadInterval: reloadInterval}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Loads the certificate and key if their files have changed since the last load
+func (r *CertReloader) Reload() error {
+	modTimes, err := r.readModTimes()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastCheck = time.Now()
+	if r.cert != nil && modTimes == r.modTimes {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTimes = modTimes
+	return nil
+}
+
+// Gets the current certificate, checking for changes at most once per reload interval.
+// A certificate which fails to load is logged and the previous one kept
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	check := time.Since(r.lastCheck) >= r.reloadInterval
+	r.mu.Unlock()
+
+	if check {
+		if err := r.Reload(); err != nil {
+			log.Logger.Errorf("Error reloading certificate %s: %s", r.certFile, err.Error())
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+func (r *CertReloader) readModTimes() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Creates the TLS config of a listener, verifying client certificates against client_ca_file if set
+func NewTlsConfig(config conf.TlsConfig) (*tls.Config, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("tls needs cert_file and key_file")
+	}
+	reloadInterval := defaultReloadInterval
+	if config.ReloadIntervalSecs > 0 {
+		reloadInterval = time.Duration(config.ReloadIntervalSecs) * time.Second
+	}
+	reloader, err := NewCertReloader(config.CertFile, config.KeyFile, reloadInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if config.ClientCaFile != "" {
+		caPem, err := os.ReadFile(config.ClientCaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("no certificates in client_ca_file: %s", config.ClientCaFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if config.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if config.RequireClientCert {
+		return nil, errors.New("tls require_client_cert needs client_ca_file")
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	nethttp "net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	log "sw/ocpp/csms/internal/logging"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// Generates a certificate signed by parent, or self-signed CA if parent is nil
+func generateCert(t *testing.T, commonName string, parent *testCert, extKeyUsage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{extKeyUsage},
+		DNSNames:     []string{"localhost"},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		template.ExtKeyUsage = nil
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) writeFiles(t *testing.T, certFile string, keyFile string) {
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestCertReloader(t *testing.T) {
+	log.Logger = logrus.New()
+	log.Logger.SetOutput(io.Discard)
+	defer func() { log.Logger = nil }()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	ca := generateCert(t, "ca", nil, x509.ExtKeyUsageServerAuth)
+	generateCert(t, "first", ca, x509.ExtKeyUsageServerAuth).writeFiles(t, certFile, keyFile)
+
+	reloader, err := NewCertReloader(certFile, keyFile, 0)
+	require.NoError(t, err)
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "first", cert.Leaf.Subject.CommonName)
+
+	generateCert(t, "renewed", ca, x509.ExtKeyUsageServerAuth).writeFiles(t, certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	cert, err = reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "renewed", cert.Leaf.Subject.CommonName)
+
+	require.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0600))
+	require.NoError(t, os.Chtimes(certFile, later.Add(time.Minute), later.Add(time.Minute)))
+	cert, err = reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "renewed", cert.Leaf.Subject.CommonName, "keeps the previous certificate")
+}
+
+func TestListenAndServeTLSClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")
+	ca := generateCert(t, "ca", nil, x509.ExtKeyUsageServerAuth)
+	generateCert(t, "localhost", ca, x509.ExtKeyUsageServerAuth).writeFiles(t, certFile, keyFile)
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.der}), 0600))
+
+	tlsConfig, err := NewTlsConfig(conf.TlsConfig{CertFile: certFile, KeyFile: keyFile, ClientCaFile: caFile, RequireClientCert: true})
+	require.NoError(t, err)
+	listener, err := ListenAndServeTLSWithClose("127.0.0.1:0", nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+	}), tlsConfig)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *nethttp.Client {
+		return &nethttp.Client{Transport: &nethttp.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs, ServerName: "localhost"}}}
+	}
+	url := "https://" + listener.Addr().String() + "/ocpp/charger-1"
+
+	res, err := client(generateCert(t, "charger-1", ca, x509.ExtKeyUsageClientAuth).tlsCertificate()).Get(url)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "charger-1", string(body))
+
+	_, err = client().Get(url)
+	assert.Error(t, err, "client certificate required")
+
+	otherCa := generateCert(t, "other-ca", nil, x509.ExtKeyUsageServerAuth)
+	_, err = client(generateCert(t, "charger-1", otherCa, x509.ExtKeyUsageClientAuth).tlsCertificate()).Get(url)
+	assert.Error(t, err, "client certificate from another CA")
+}
+
+func TestNewTlsConfigInvalid(t *testing.T) {
+	_, err := NewTlsConfig(conf.TlsConfig{})
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	generateCert(t, "localhost", nil, x509.ExtKeyUsageServerAuth).writeFiles(t, certFile, keyFile)
+	_, err = NewTlsConfig(conf.TlsConfig{CertFile: certFile, KeyFile: keyFile, RequireClientCert: true})
+	assert.EqualError(t, err, "tls require_client_cert needs client_ca_file")
+}
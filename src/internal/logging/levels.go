@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	chargerLevelsMu sync.Mutex
+	chargerLevels   = map[string]log.Level{}
+	chargerLoggers  sync.Map // networkId -> *log.Logger, sharing Logger's output, formatter and hooks
+)
+
+// The global log level and the per-charger overrides
+type Levels struct {
+	Level    string            `json:"level"`
+	Chargers map[string]string `json:"chargers"`
+}
+
+func GetLevels() Levels {
+	chargerLevelsMu.Lock()
+	defer chargerLevelsMu.Unlock()
+
+	levels := Levels{Level: base().GetLevel().String(), Chargers: map[string]string{}}
+	for networkId, level := range chargerLevels {
+		levels.Chargers[networkId] = level.String()
+	}
+	return levels
+}
+
+// Sets the log level of lines with no charger override
+func SetLevel(level log.Level) {
+	base().SetLevel(level)
+	base().Warnf("Log level set to: %s", level)
+}
+
+// Overrides the log level of a charger's lines, e.g to debug one charger without debug logging the others
+func SetChargerLevel(networkId string, level log.Level) {
+	chargerLevelsMu.Lock()
+	defer chargerLevelsMu.Unlock()
+
+	chargerLevels[networkId] = level
+	chargerLoggers.Store(networkId, newChargerLogger(level))
+	base().WithField(Field_NetworkId, networkId).Warnf("Charger log level set to: %s", level)
+}
+
+// Removes a charger's override, returning false if it had none
+func ClearChargerLevel(networkId string) bool {
+	chargerLevelsMu.Lock()
+	defer chargerLevelsMu.Unlock()
+
+	if _, ok := chargerLevels[networkId]; !ok {
+		return false
+	}
+	delete(chargerLevels, networkId)
+	chargerLoggers.Delete(networkId)
+	return true
+}
+
+// Gets an entry for a charger's log lines, at the charger's level if overridden
+func ForCharger(networkId string) *log.Entry {
+	logger := base()
+	if chargerLogger, ok := chargerLoggers.Load(networkId); ok {
+		logger = chargerLogger.(*log.Logger)
+	}
+	return logger.WithField(Field_NetworkId, networkId)
+}
+
+// Gets an entry for the log lines of an OCPP message, with the trace id of the span in ctx
+func ForMessage(ctx context.Context, networkId string, msgId string, action string) *log.Entry {
+	entry := ForCharger(networkId).WithContext(ctx).WithField(Field_MsgId, msgId)
+	if action != "" {
+		entry = entry.WithField(Field_Action, action)
+	}
+	return entry
+}
+
+// Recreates the charger loggers from the current Logger, once it's been set up again
+func rebuildChargerLoggers() {
+	chargerLevelsMu.Lock()
+	defer chargerLevelsMu.Unlock()
+
+	for networkId, level := range chargerLevels {
+		chargerLoggers.Store(networkId, newChargerLogger(level))
+	}
+}
+
+func newChargerLogger(level log.Level) *log.Logger {
+	logger := base()
+	return &log.Logger{
+		Out:          logger.Out,
+		Hooks:        logger.Hooks,
+		Formatter:    logger.Formatter,
+		ReportCaller: logger.ReportCaller,
+		Level:        level,
+		ExitFunc:     logger.ExitFunc,
+	}
+}
+
+// Logger, or the standard logger before it's set up, e.g in tests
+func base() *log.Logger {
+	if Logger == nil {
+		return log.StandardLogger()
+	}
+	return Logger
+}
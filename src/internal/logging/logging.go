@@ -1,3 +1,4 @@
+// Provides the services' structured logging, as text or JSON, with log levels changeable at runtime
 package logging
 
 import (
@@ -6,12 +7,33 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"sw/ocpp/csms/internal/helpers"
+
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+const (
+	Format_Text = "text"
+	Format_Json = "json"
+
+	timestampFormat = "2006-01-02 15:04:05.000"
+)
+
+// Log fields
+const (
+	Field_NetworkId  = "networkId"
+	Field_ServerNode = "serverNode"
+	Field_RemoteAddr = "remoteAddr"
+	Field_MsgId      = "msgId"
+	Field_Action     = "action"
+	Field_TraceId    = "traceId"
+)
+
 var (
 	Logger           *log.Logger        = nil
 	lumberjackLogger *lumberjack.Logger = nil
@@ -26,7 +48,8 @@ func ToJson(obj any) string {
 	return string(ret)
 }
 
-func LoggingSetup(isDebug bool, fileName string) *log.Logger {
+// Sets up Logger, writing to stderr and a rolling file. format is Format_Text, or Format_Json for log shippers
+func LoggingSetup(isDebug bool, fileName string, format string) *log.Logger {
 	if Logger != nil {
 		Logger.Writer().Close()
 	}
@@ -59,20 +82,47 @@ func LoggingSetup(isDebug bool, fileName string) *log.Logger {
 			DisableLevelTruncation: true,
 		}},
 	}*/
+	var formatter log.Formatter = &myFormatter{log.TextFormatter{
+		FullTimestamp:          true,
+		TimestampFormat:        timestampFormat,
+		ForceColors:            true,
+		DisableLevelTruncation: true,
+	}}
+	if format == Format_Json {
+		formatter = &log.JSONFormatter{TimestampFormat: timestampFormat}
+	}
+
 	Logger = &log.Logger{
-		Out:   io.MultiWriter(os.Stderr, lumberjackLogger),
-		Level: logLevel,
-		Hooks: make(log.LevelHooks),
-		Formatter: &myFormatter{log.TextFormatter{
-			FullTimestamp:          true,
-			TimestampFormat:        "2006-01-02 15:04:05.000",
-			ForceColors:            true,
-			DisableLevelTruncation: true,
-		}},
+		Out:       io.MultiWriter(os.Stderr, lumberjackLogger),
+		Level:     logLevel,
+		Hooks:     make(log.LevelHooks),
+		Formatter: formatter,
+		ExitFunc:  os.Exit,
 	}
+	Logger.AddHook(&fieldsHook{serverNode: helpers.GetHostName()})
+	rebuildChargerLoggers()
 	return Logger
 }
 
+// Adds the server node, and the trace id of a span in the entry's context, to every entry
+type fieldsHook struct {
+	serverNode string
+}
+
+func (h *fieldsHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *fieldsHook) Fire(entry *log.Entry) error {
+	entry.Data[Field_ServerNode] = h.serverNode
+	if entry.Context != nil {
+		if spanContext := trace.SpanContextFromContext(entry.Context); spanContext.HasTraceID() {
+			entry.Data[Field_TraceId] = spanContext.TraceID().String()
+		}
+	}
+	return nil
+}
+
 func (f *myFormatter) Format(entry *log.Entry) ([]byte, error) {
 	var logLevelStr string
 
@@ -93,5 +143,23 @@ func (f *myFormatter) Format(entry *log.Entry) ([]byte, error) {
 		logLevelStr = strings.ToUpper(entry.Level.String())
 	}
 
-	return []byte(fmt.Sprintf("%s : %s : %s\n", entry.Time.Format(f.TimestampFormat), logLevelStr, entry.Message)), nil
+	return []byte(fmt.Sprintf("%s : %s : %s%s\n", entry.Time.Format(f.TimestampFormat), logLevelStr, entry.Message, formatFields(entry.Data))), nil
+}
+
+// Formats fields as " key=value", sorted by key
+func formatFields(fields log.Fields) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%v", key, fields[key])
+	}
+	return b.String()
 }
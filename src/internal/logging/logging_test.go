@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTestLogger(t *testing.T, formatter log.Formatter) *bytes.Buffer {
+	var out bytes.Buffer
+	previous := Logger
+	Logger = &log.Logger{Out: &out, Formatter: formatter, Hooks: make(log.LevelHooks), Level: log.InfoLevel}
+	Logger.AddHook(&fieldsHook{serverNode: "node-1"})
+	t.Cleanup(func() {
+		Logger = previous
+		for networkId := range GetLevels().Chargers {
+			ClearChargerLevel(networkId)
+		}
+	})
+	return &out
+}
+
+func TestForMessageJson(t *testing.T) {
+	out := setupTestLogger(t, &log.JSONFormatter{})
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceId, SpanID: spanId}))
+
+	ForMessage(ctx, "charger-1", "m1", "Heartbeat").Info("Received")
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "Received", line["msg"])
+	assert.Equal(t, "charger-1", line[Field_NetworkId])
+	assert.Equal(t, "m1", line[Field_MsgId])
+	assert.Equal(t, "Heartbeat", line[Field_Action])
+	assert.Equal(t, "node-1", line[Field_ServerNode])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", line[Field_TraceId])
+}
+
+func TestTextFormatFields(t *testing.T) {
+	out := setupTestLogger(t, &myFormatter{log.TextFormatter{TimestampFormat: timestampFormat}})
+
+	ForCharger("charger-1").Warn("Client disconnected")
+
+	assert.True(t, strings.HasSuffix(out.String(), " : WARN  : Client disconnected networkId=charger-1 serverNode=node-1\n"), out.String())
+}
+
+func TestChargerLevel(t *testing.T) {
+	out := setupTestLogger(t, &log.JSONFormatter{})
+
+	SetChargerLevel("charger-1", log.DebugLevel)
+	out.Reset()
+	ForCharger("charger-1").Debug("debug for charger-1")
+	ForCharger("charger-2").Debug("debug for charger-2")
+	assert.Contains(t, out.String(), "debug for charger-1")
+	assert.NotContains(t, out.String(), "debug for charger-2")
+
+	assert.Equal(t, Levels{Level: "info", Chargers: map[string]string{"charger-1": "debug"}}, GetLevels())
+
+	assert.True(t, ClearChargerLevel("charger-1"))
+	assert.False(t, ClearChargerLevel("charger-1"))
+	out.Reset()
+	ForCharger("charger-1").Debug("debug for charger-1")
+	assert.Empty(t, out.String())
+
+	SetLevel(log.DebugLevel)
+	ForCharger("charger-2").Debug("debug for charger-2")
+	assert.Contains(t, out.String(), "debug for charger-2")
+}
@@ -0,0 +1,135 @@
+// Provides the Prometheus metrics shared by the services, served on /metrics by the admin listener
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "csms"
+
+	Direction_In  = "in"  // from the charger
+	Direction_Out = "out" // to the charger
+
+	Result_Ok      = "ok"
+	Result_Error   = "error"
+	Result_Timeout = "timeout"
+
+	unknownAction = "unknown"
+)
+
+// Registry holds the metrics of this process, with Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	OcppMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ocpp_messages_total",
+		Help:      "OCPP messages by action and direction, responses are counted against the action of their call",
+	}, []string{"action", "direction"})
+
+	MqPublishDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mq_publish_duration_seconds",
+		Help:      "Time to publish an MQ message, including retries",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"channel", "result"})
+
+	MqPublishRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mq_publish_retries_total",
+		Help:      "MQ publish retries after transient errors",
+	}, []string{"channel"})
+
+	DeviceActionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "device_action_duration_seconds",
+		Help:      "Time from sending a device-manager action to the charger's response",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"action", "result"})
+
+	DeviceActionTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "device_action_timeouts_total",
+		Help:      "Device-manager actions with no charger response before the timeout",
+	}, []string{"action"})
+
+	DbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "DB repository call latency",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"repository", "operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		OcppMessages,
+		MqPublishDuration,
+		MqPublishRetries,
+		DeviceActionDuration,
+		DeviceActionTimeouts,
+		DbQueryDuration,
+	)
+}
+
+// Serves the registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Counts an OCPP message. Responses with no known call are counted as action "unknown"
+func CountOcppMessage(action string, direction string) {
+	if action == "" {
+		action = unknownAction
+	}
+	OcppMessages.WithLabelValues(action, direction).Inc()
+}
+
+func ObserveMqPublish(channel string, start time.Time, err error) {
+	MqPublishDuration.WithLabelValues(channel, resultOf(err)).Observe(time.Since(start).Seconds())
+}
+
+func ObserveDbQuery(repository string, operation string, start time.Time) {
+	DbQueryDuration.WithLabelValues(repository, operation).Observe(time.Since(start).Seconds())
+}
+
+func ObserveDeviceAction(action string, result string, start time.Time) {
+	DeviceActionDuration.WithLabelValues(action, result).Observe(time.Since(start).Seconds())
+	if result == Result_Timeout {
+		DeviceActionTimeouts.WithLabelValues(action).Inc()
+	}
+}
+
+// Reports the number of connected chargers on this node when scraped
+func WatchConnectedChargers(node string, count func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "connected_chargers",
+		Help:        "Chargers with an open websocket connection to this node",
+		ConstLabels: prometheus.Labels{"node": node},
+	}, func() float64 { return float64(count()) }))
+}
+
+// Reports the number of calls waiting for a charger response when scraped
+func WatchMessagesWaiting(count func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "messages_waiting",
+		Help:      "Calls sent to chargers still waiting for a response",
+	}, func() float64 { return float64(count()) }))
+}
+
+func resultOf(err error) string {
+	if err != nil {
+		return Result_Error
+	}
+	return Result_Ok
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountOcppMessage(t *testing.T) {
+	CountOcppMessage("BootNotification", Direction_In)
+	CountOcppMessage("", Direction_In)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(OcppMessages.WithLabelValues("BootNotification", Direction_In)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(OcppMessages.WithLabelValues(unknownAction, Direction_In)))
+}
+
+func TestObserveDeviceAction(t *testing.T) {
+	start := time.Now()
+	ObserveDeviceAction("Reset", Result_Ok, start)
+	ObserveDeviceAction("Reset", Result_Timeout, start)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(DeviceActionTimeouts.WithLabelValues("Reset")))
+	assert.Equal(t, 2, testutil.CollectAndCount(DeviceActionDuration, "csms_device_action_duration_seconds"))
+}
+
+func TestObserveMqPublish(t *testing.T) {
+	ObserveMqPublish("MessagesIn", time.Now(), nil)
+	ObserveMqPublish("MessagesIn", time.Now(), errors.New("closed"))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(MqPublishDuration, "csms_mq_publish_duration_seconds"))
+}
@@ -0,0 +1,333 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Transaction status lifecycle
+const (
+	TransactionStatus_Active    = "active"
+	TransactionStatus_Completed = "completed"
+	TransactionStatus_Orphaned  = "orphaned"
+)
+
+// Transaction row, as started by a StartTransaction and ended by a StopTransaction
+type Transaction struct {
+	Id            int64      `json:"transactionId"`
+	Guid          string     `json:"guid"`
+	ClientId      string     `json:"networkId"`
+	ConnectorId   int        `json:"connectorId"`
+	IdTag         string     `json:"idTag"`
+	ReservationId *int       `json:"reservationId,omitempty"`
+	MeterStart    int        `json:"meterStart"`
+	MeterStop     *float64   `json:"meterStop,omitempty"`
+	TimeStarted   time.Time  `json:"timeStarted"`  // timestamp reported by the charger
+	TimeReceived  time.Time  `json:"timeReceived"` // time the CSMS received the StartTransaction
+	TimeEnded     *time.Time `json:"timeEnded,omitempty"`
+	StopReason    string     `json:"stopReason,omitempty"`
+	Status        string     `json:"status"`
+}
+
+// Filter for listing transactions. Zero values are ignored.
+type TransactionFilter struct {
+	ClientId    string
+	Status      string
+	ConnectorId *int
+	From        *time.Time
+	To          *time.Time
+	Limit       int
+	Offset      int
+}
+
+// Device row, identified by networkId within a tenant
+type Device struct {
+	Id               int64  `json:"id"`
+	Tenant           string `json:"tenant"`
+	Guid             string `json:"guid"`
+	NetworkId        string `json:"networkId"`
+	DeviceTemplateId int64  `json:"deviceTemplateId"`
+	PasswordHash     string `json:"-"` // Basic auth password hash, empty if the charger has none
+	// Hash of a password sent to the charger, also accepted until the charger first authenticates with it
+	PendingPasswordHash string `json:"-"`
+	Disabled            bool   `json:"disabled"`
+	// As reported in the charger's last BootNotification
+	FirmwareVersion string   `json:"firmwareVersion"`
+	Tags            []string `json:"tags"`
+}
+
+// Filter for listing devices. Zero values are ignored.
+type DeviceFilter struct {
+	Tenant           string
+	NetworkIdPrefix  string
+	DeviceTemplateId int64
+	Disabled         *bool
+	FirmwareVersion  string
+	Tag              string
+	Limit            int
+	Offset           int
+}
+
+// Archived OCPP message, as written by the message-manager sql message store
+type Message struct {
+	Id          int64     `json:"id"`
+	NetworkId   string    `json:"networkId"`
+	MessageId   string    `json:"messageId"`
+	ServerNode  string    `json:"serverNode"`
+	Direction   int       `json:"direction"`
+	MessageType string    `json:"messageType,omitempty"`
+	MessageTime time.Time `json:"messageTime"`
+	Body        string    `json:"body"`
+}
+
+// Filter for listing messages of a networkId in [From, To), ordered by messageTime then id.
+// After* continue a previous listing, zero values are ignored.
+type MessageFilter struct {
+	NetworkId   string
+	From        time.Time
+	To          time.Time
+	Direction   int
+	MessageType string
+	AfterTime   *time.Time
+	AfterId     int64
+	Limit       int
+}
+
+// Filter for deleting messages with messageTime before Before. Message types are matched case insensitively.
+type MessageDeleteFilter struct {
+	Before              time.Time
+	MessageType         string   // if set, only messages of this type
+	ExcludeMessageTypes []string // messages of these types are kept
+	Limit               int      // max rows to delete
+}
+
+// Frame csms-server couldn't handle, as archived by message-manager from the DeadLetter channel
+type DeadLetter struct {
+	Id            int64     `json:"id"`
+	NetworkId     string    `json:"networkId"`
+	ServerNode    string    `json:"serverNode"`
+	RemoteAddr    string    `json:"remoteAddr,omitempty"`
+	Reason        string    `json:"reason"`
+	Error         string    `json:"error"`
+	Frame         string    `json:"frame"`
+	FrameEncoding string    `json:"frameEncoding"`
+	MessageTime   time.Time `json:"messageTime"`
+}
+
+// Filter for listing dead letters, newest first. Zero values are ignored.
+type DeadLetterFilter struct {
+	NetworkId string
+	Reason    string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
+
+const (
+	CertificateStatus_Issued     = "issued"     // signed and sent to the charger with CertificateSigned
+	CertificateStatus_Accepted   = "accepted"   // installed by the charger
+	CertificateStatus_Rejected   = "rejected"   // rejected by the charger, or not delivered
+	CertificateStatus_Superseded = "superseded" // replaced by a newer accepted certificate
+)
+
+// Certificate issued to a charger from its SignCertificate CSR
+type Certificate struct {
+	Id                 int64      `json:"id"`
+	Tenant             string     `json:"tenant"`
+	NetworkId          string     `json:"networkId"`
+	CertificateType    string     `json:"certificateType"`
+	SerialNumber       string     `json:"serialNumber"`
+	CommonName         string     `json:"commonName"`
+	Status             string     `json:"status"`
+	CertificateChain   string     `json:"certificateChain"`
+	NotBefore          time.Time  `json:"notBefore"`
+	NotAfter           time.Time  `json:"notAfter"`
+	IssuedAt           time.Time  `json:"issuedAt"`
+	RenewalRequestedAt *time.Time `json:"renewalRequestedAt,omitempty"`
+}
+
+// Filter for listing certificates, newest first. Zero values are ignored.
+type CertificateFilter struct {
+	Tenant    string
+	NetworkId string
+	Status    string
+	// Certificates expiring before this time
+	ExpiresBefore *time.Time
+	// Only certificates without a renewal requested
+	RenewalNotRequested bool
+	Limit               int
+	Offset              int
+}
+
+// Desired configuration of the devices using the template, enforced after they boot
+type DeviceTemplate struct {
+	Id     int64  `json:"id"`
+	Tenant string `json:"tenant"`
+	Name   string `json:"name"`
+	// Desired OCPP configuration key values, e.g HeartbeatInterval
+	Configuration map[string]string `json:"configuration"`
+	// SetChargingProfile payloads, set after each boot
+	ChargingProfiles []json.RawMessage `json:"chargingProfiles"`
+	UpdatedAt        time.Time         `json:"updatedAt"`
+}
+
+// Filter for listing device templates by name. Zero values are ignored.
+type DeviceTemplateFilter struct {
+	Tenant string
+	Limit  int
+	Offset int
+}
+
+const (
+	ComplianceStatus_Compliant    = "compliant"    // configuration matched the template
+	ComplianceStatus_Remediated   = "remediated"   // drifted keys were changed, possibly needing a reboot
+	ComplianceStatus_NonCompliant = "noncompliant" // some keys or charging profiles couldn't be set
+	ComplianceStatus_Error        = "error"        // the configuration couldn't be read
+)
+
+// Result of a device configuration key's check against its template
+const (
+	KeyStatus_Compliant      = "compliant"
+	KeyStatus_Changed        = "changed"
+	KeyStatus_RebootRequired = "rebootRequired"
+	KeyStatus_Rejected       = "rejected"
+	KeyStatus_NotSupported   = "notSupported"
+	KeyStatus_Readonly       = "readonly"
+	KeyStatus_Unknown        = "unknown" // not a key of the charger
+	KeyStatus_Error          = "error"   // the charger didn't answer ChangeConfiguration
+)
+
+// The last check of a device's configuration against its template
+type DeviceCompliance struct {
+	Tenant           string          `json:"tenant"`
+	NetworkId        string          `json:"networkId"`
+	DeviceTemplateId int64           `json:"deviceTemplateId"`
+	Status           string          `json:"status"`
+	Keys             []KeyCompliance `json:"keys"`
+	// Charger's SetChargingProfile status, by template charging profile
+	ChargingProfiles []string  `json:"chargingProfiles"`
+	Error            string    `json:"error,omitempty"`
+	CheckedAt        time.Time `json:"checkedAt"`
+}
+
+type KeyCompliance struct {
+	Key     string `json:"key"`
+	Desired string `json:"desired"`
+	Actual  string `json:"actual"`
+	Status  string `json:"status"`
+}
+
+// Filter for listing device compliance by networkId. Zero values are ignored.
+type DeviceComplianceFilter struct {
+	Tenant           string
+	Status           string
+	DeviceTemplateId int64
+	Limit            int
+	Offset           int
+}
+
+// Bulk job lifecycle
+const (
+	BulkJobStatus_Running     = "running"
+	BulkJobStatus_Completed   = "completed"
+	BulkJobStatus_Cancelled   = "cancelled"
+	BulkJobStatus_Interrupted = "interrupted" // device-manager stopped while the job was running
+)
+
+// Result of a bulk job's action on a charger
+const (
+	BulkResultStatus_Pending  = "pending"
+	BulkResultStatus_Accepted = "accepted"
+	BulkResultStatus_Rejected = "rejected" // the charger answered with a status other than accepted
+	BulkResultStatus_Error    = "error"
+	BulkResultStatus_TimedOut = "timedOut"
+)
+
+// Selects the devices a bulk job runs on, either by networkIds or by the devices matching all the other fields
+type BulkSelector struct {
+	NetworkIds       []string `json:"networkIds,omitempty"`
+	DeviceTemplateId int64    `json:"deviceTemplateId,omitempty"`
+	FirmwareVersion  string   `json:"firmwareVersion,omitempty"`
+	Tag              string   `json:"tag,omitempty"`
+}
+
+// An action sent to many chargers
+type BulkJob struct {
+	Id          int64           `json:"id"`
+	Tenant      string          `json:"tenant"`
+	Owner       string          `json:"owner"` // instance of device-manager running the job
+	Action      string          `json:"action"`
+	Payload     json.RawMessage `json:"payload"`
+	Selector    BulkSelector    `json:"selector"`
+	Concurrency int             `json:"concurrency"`
+	RatePerSec  int             `json:"ratePerSec"` // 0 is unlimited
+	Status      string          `json:"status"`
+	Total       int             `json:"total"`
+	// Number of chargers by result status
+	Results     map[string]int `json:"results"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	CompletedAt *time.Time     `json:"completedAt,omitempty"`
+}
+
+type BulkJobResult struct {
+	JobId       int64           `json:"jobId"`
+	NetworkId   string          `json:"networkId"`
+	Status      string          `json:"status"`
+	Response    json.RawMessage `json:"response,omitempty"` // the charger's response payload
+	Error       string          `json:"error,omitempty"`
+	CompletedAt *time.Time      `json:"completedAt,omitempty"`
+}
+
+// Filter for listing bulk jobs, newest first. Zero values are ignored.
+type BulkJobFilter struct {
+	Tenant string
+	Status string
+	Limit  int
+	Offset int
+}
+
+// Filter for listing a bulk job's results by networkId. Zero values are ignored.
+type BulkJobResultFilter struct {
+	JobId  int64
+	Status string
+	Limit  int
+	Offset int
+}
+
+// Lifecycle of an async action sent to a charger
+const (
+	CommandStatus_Queued   = "queued"
+	CommandStatus_Sent     = "sent"
+	CommandStatus_Accepted = "accepted"
+	CommandStatus_Rejected = "rejected" // the charger answered with a status other than accepted
+	CommandStatus_Error    = "error"
+	CommandStatus_TimedOut = "timedOut"
+)
+
+// An action sent to a charger in the background, tracked by id
+type Command struct {
+	Id          int64           `json:"id"`
+	Tenant      string          `json:"tenant"`
+	Owner       string          `json:"owner"` // instance of device-manager awaiting the response
+	NetworkId   string          `json:"networkId"`
+	Action      string          `json:"action"`
+	MsgId       string          `json:"msgId,omitempty"` // set once sent
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Response    json.RawMessage `json:"response,omitempty"` // the charger's response payload
+	Error       string          `json:"error,omitempty"`
+	CallbackUrl string          `json:"callbackUrl,omitempty"` // POSTed the command once it completes
+	CreatedAt   time.Time       `json:"createdAt"`
+	SentAt      *time.Time      `json:"sentAt,omitempty"`
+	CompletedAt *time.Time      `json:"completedAt,omitempty"`
+}
+
+// Filter for listing commands, newest first. Zero values are ignored.
+type CommandFilter struct {
+	Tenant    string
+	NetworkId string
+	Status    string
+	Limit     int
+	Offset    int
+}
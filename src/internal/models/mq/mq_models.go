@@ -1,10 +1,12 @@
 package mq
 
 type MqMessageEnvelope struct {
-	ServerNode  string `json:"serverNode"`
-	Client      string `json:"client"`
-	MessageTime string `json:"messageTime"`
-	Body        any    `json:"body"`
+	ServerNode   string            `json:"serverNode"`
+	Client       string            `json:"client"`
+	MessageTime  string            `json:"messageTime"`
+	Body         any               `json:"body"`
+	Command      string            `json:"command,omitempty"`      // for csms-server rather than the charger, e.g disconnect
+	TraceContext map[string]string `json:"traceContext,omitempty"` // W3C traceparent of the sender's span
 }
 
 type MqNotifyConnectionChange struct {
@@ -14,3 +16,26 @@ type MqNotifyConnectionChange struct {
 	RemoteAddr string `json:"remoteAddr,omitempty"`
 	NetworkId  string `json:"networkId,omitempty"`
 }
+
+// Sent when a charger's auth record changes, so csms-server drops its cached auth decision, or by csms-server when a
+// charger confirms its pending password
+type MqNotifyAuthChange struct {
+	QueuedTime string `json:"queuedTime"`
+	ServerNode string `json:"serverNode"`
+	NotifyType string `json:"notifyType"`
+	NetworkId  string `json:"networkId"`
+}
+
+// A frame csms-server couldn't handle, published to the DeadLetter channel.
+// Frame is the raw frame, base64 encoded if FrameEncoding is "base64" as it wasn't valid UTF-8.
+type MqDeadLetter struct {
+	ServerNode    string            `json:"serverNode"`
+	NetworkId     string            `json:"networkId"`
+	RemoteAddr    string            `json:"remoteAddr,omitempty"`
+	MessageTime   string            `json:"messageTime"`
+	Reason        string            `json:"reason"`
+	Error         string            `json:"error"`
+	Frame         string            `json:"frame"`
+	FrameEncoding string            `json:"frameEncoding"`
+	TraceContext  map[string]string `json:"traceContext,omitempty"`
+}
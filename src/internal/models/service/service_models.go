@@ -2,6 +2,7 @@ package service
 
 import (
 	"net/http"
+	"sw/ocpp/csms/internal/capture"
 	mqModels "sw/ocpp/csms/internal/models/mq"
 	ocppModels "sw/ocpp/csms/internal/ocpp"
 	"sync"
@@ -19,6 +20,7 @@ type ConnectionState struct {
 	HttpRequest    *http.Request
 	WebSocket      *websocket.Conn
 	WebSocketMutex sync.Mutex
+	Capture        *capture.Session // nil unless the connection's frames are being captured
 }
 
 type ConnectionInfo struct {
@@ -27,6 +29,8 @@ type ConnectionInfo struct {
 }
 
 type WaitingMessage struct {
+	Action           string            // the call's action, e.g Reset
+	TraceContext     map[string]string // trace context of the span which sent the call
 	Notify           chan int
 	Response         *ocppModels.OcppMessage
 	CreatedTimestamp time.Time
@@ -7,6 +7,7 @@ type TableMessageEntity struct {
 	aztables.Entity
 	ServerNode  string `json:"serverNode"`
 	Direction   string `json:"direction"`
+	MessageType string `json:"messageType,omitempty"`
 	MessageTime string `json:"messageTime"`
 	Body        string `json:"body"`
 }
@@ -1,6 +1,8 @@
 package mq
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"os"
 	conf "sw/ocpp/csms/internal/config"
@@ -8,6 +10,8 @@ import (
 	log "sw/ocpp/csms/internal/logging"
 	mqmodels "sw/ocpp/csms/internal/models/mq"
 	svc "sw/ocpp/csms/internal/models/service"
+	"sw/ocpp/csms/internal/tracing"
+	"unicode/utf8"
 )
 
 type MqBus interface {
@@ -15,10 +19,12 @@ type MqBus interface {
 	Close() error
 	MqQueueDeclare(queueName string) error
 	MqMessagePublish(queueName string, json string) error
-	MqSendClientMessageRetry(hostName string, connState *svc.ConnectionInfo, body any) error
+	MqSendClientMessageRetry(ctx context.Context, hostName string, connState *svc.ConnectionInfo, body any) error
 	MqMessagePublishRetry(channel string, json string) error
 	RunMqTopicReceiver(ProcessRecvMqMessage func(messageBy []byte, state any), topicName string, state any) error
 	SetupMqTopicReceiver(channelName string, routingKey string) error
+	// Returns an error if the MQ connection is down
+	MqHealthCheck(ctx context.Context) error
 }
 
 func SetupMqReceiver(mqConnection MqBus, mqType, hostname string, channelName string) {
@@ -82,6 +88,41 @@ func MqNotifyClientDisconnected(m MqBus, hostName string, connInfo *svc.Connecti
 	return m.MqMessagePublishRetry(MqChannelName_Notify, jsonString)
 }
 
+func MqNotifyAuthChanged(m MqBus, hostName string, networkId string) error {
+	return mqNotifyAuth(m, hostName, networkId, NotifyMsg_AuthChanged)
+}
+
+// Tells device-manager a charger authenticated with its pending password, so it replaces the current one
+func MqNotifyPasswordConfirmed(m MqBus, hostName string, networkId string) error {
+	return mqNotifyAuth(m, hostName, networkId, NotifyMsg_PasswordConfirmed)
+}
+
+func mqNotifyAuth(m MqBus, hostName string, networkId string, notifyType string) error {
+	notify := mqmodels.MqNotifyAuthChange{
+		QueuedTime: helpers.GenerateDateNowMs(),
+		ServerNode: hostName,
+		NotifyType: notifyType,
+		NetworkId:  networkId,
+	}
+	jsonString, _ := JsonMarshallString(notify)
+
+	return m.MqMessagePublishRetry(MqChannelName_Notify, jsonString)
+}
+
+// Asks csms-server to close a charger's connection, e.g once it's disabled. Any node holding the connection closes it
+func MqDisconnectClient(m MqBus, networkId string) error {
+	mqMsgEnvelope := mqmodels.MqMessageEnvelope{
+		MessageTime: helpers.GenerateDateNowMs(),
+		Client:      networkId,
+		Command:     Command_Disconnect,
+	}
+	jsonString, err := JsonMarshallString(mqMsgEnvelope)
+	if err != nil {
+		return err
+	}
+	return m.MqMessagePublishRetry(MqChannelName_MessagesOut, jsonString)
+}
+
 func GetMqNotifyNodeConnectionChange_Message(hostName string, notifyType string) mqmodels.MqNotifyConnectionChange {
 	return mqmodels.MqNotifyConnectionChange{
 		QueuedTime: helpers.GenerateDateNowMs(),
@@ -110,14 +151,35 @@ func JsonMarshallString(body any) (string, error) {
 	return string(jsonBy), nil
 }
 
-func MqCreateMessageEnvelope(hostName string, networkId string, body any) (string, error) {
+// Creates a message envelope, carrying the trace context of ctx
+func MqCreateMessageEnvelope(ctx context.Context, hostName string, networkId string, body any) (string, error) {
 	mqMsgEnvelope := mqmodels.MqMessageEnvelope{
-		MessageTime: helpers.GenerateDateNowMs(),
-		ServerNode:  hostName,
-		Client:      networkId,
-		Body:        body,
+		MessageTime:  helpers.GenerateDateNowMs(),
+		ServerNode:   hostName,
+		Client:       networkId,
+		Body:         body,
+		TraceContext: tracing.Inject(ctx),
 	}
 	jsonString, err := JsonMarshallString(mqMsgEnvelope)
 
 	return jsonString, err
 }
+
+func MqCreateDeadLetter(ctx context.Context, hostName string, connInfo *svc.ConnectionInfo, frame []byte, reason string, cause error) (string, error) {
+	deadLetter := mqmodels.MqDeadLetter{
+		ServerNode:    hostName,
+		NetworkId:     connInfo.NetworkId,
+		RemoteAddr:    connInfo.RemoteAddr,
+		MessageTime:   helpers.GenerateDateNowMs(),
+		Reason:        reason,
+		Error:         cause.Error(),
+		Frame:         string(frame),
+		FrameEncoding: FrameEncoding_Utf8,
+		TraceContext:  tracing.Inject(ctx),
+	}
+	if !utf8.Valid(frame) {
+		deadLetter.Frame = base64.StdEncoding.EncodeToString(frame)
+		deadLetter.FrameEncoding = FrameEncoding_Base64
+	}
+	return JsonMarshallString(deadLetter)
+}
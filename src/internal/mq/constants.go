@@ -4,8 +4,28 @@ const (
 	MqChannelName_Notify         = "Notify"
 	MqChannelName_MessagesIn     = "MessagesIn"
 	MqChannelName_MessagesOut    = "MessagesOut"
+	MqChannelName_DeadLetter     = "DeadLetter"
 	NotifyMsg_NodeConnected      = "NodeConnected"
 	NotifyMsg_NodeDisconnected   = "NodeDisconnected"
 	NotifyMsg_ClientConnected    = "ClientConnected"
 	NotifyMsg_ClientDisconnected = "ClientDisconnected"
+	NotifyMsg_AuthChanged        = "AuthChanged"
+	NotifyMsg_PasswordConfirmed  = "PasswordConfirmed"
+)
+
+// Commands to csms-server on MessagesOut, about a charger's connection
+const (
+	Command_Disconnect = "disconnect"
+)
+
+// Why a frame was dead lettered
+const (
+	DeadLetterReason_Malformed     = "malformed"      // not a parsable OCPP frame
+	DeadLetterReason_Unroutable    = "unroutable"     // e.g a response to no waiting call, or an unknown message type
+	DeadLetterReason_SchemaInvalid = "schema_invalid" // unknown action or a payload missing required fields
+)
+
+const (
+	FrameEncoding_Utf8   = "utf8"
+	FrameEncoding_Base64 = "base64"
 )
@@ -1,10 +1,15 @@
 package mq
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	log "sw/ocpp/csms/internal/logging"
+	"sw/ocpp/csms/internal/metrics"
 	svc "sw/ocpp/csms/internal/models/service"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.nanomsg.org/mangos/v3"
@@ -27,10 +32,22 @@ type MangosMqConnection struct {
 	SockSubClient       mangos.Socket
 	SockRequestListener mangos.Socket
 	SockRequestClient   mangos.Socket
+
+	handlersMu sync.RWMutex
+	handlers   map[string]mangosHandler // by channel name
+	unrouted   map[string]bool          // channels a dropped message has been warned for
+	closed     atomic.Bool
+	receiving  sync.Once
+}
+
+type mangosHandler struct {
+	process func(messageBy []byte, state any)
+	state   any
 }
 
 func (r *MangosMqConnection) Close() error {
 	log.Logger.Info("Close mangos_mq")
+	r.closed.Store(true)
 
 	if r.SockPubListener != nil {
 		r.SockPubListener.Close()
@@ -47,6 +64,28 @@ func (r *MangosMqConnection) Close() error {
 	return nil
 }
 
+// Mangos is brokerless, so this only checks the sockets for the configured URLs are open
+func (r *MangosMqConnection) MqHealthCheck(ctx context.Context) error {
+	if r.closed.Load() {
+		return errors.New("mangos_mq closed")
+	}
+	sockets := []struct {
+		url    string
+		socket mangos.Socket
+	}{
+		{r.PublisherListenUrl, r.SockPubListener},
+		{r.SubscriberClientUrl, r.SockSubClient},
+		{r.RequestListenUrl, r.SockRequestListener},
+		{r.RequestClientUrl, r.SockRequestClient},
+	}
+	for _, s := range sockets {
+		if s.url != "" && s.socket == nil {
+			return fmt.Errorf("mangos_mq socket not open: %s", s.url)
+		}
+	}
+	return nil
+}
+
 func (r *MangosMqConnection) MqConnect() error {
 	var err error
 
@@ -136,7 +175,7 @@ func (r *MangosMqConnection) MqConnect() error {
 }
 
 func (r *MangosMqConnection) MqMessagePublish(channelName string, json string) error {
-	log.Logger.Debugf(fmt.Sprintf("MQ[%s] send: %s", channelName, json))
+	log.Logger.Debugf("MQ[%s] send: %s", channelName, json)
 	if r.SockPubListener != nil {
 		if err := r.SockPubListener.Send([]byte(channelName + "|" + json)); err != nil {
 			log.Logger.Errorf("Failed publishing to pub socket: %s", err.Error())
@@ -174,35 +213,75 @@ func (r *MangosMqConnection) SetupMqTopicReceiver(channelName string, routingKey
 	return nil
 }
 
+// Registers the handler for a channel, and starts receiving on first use. Messages share the sockets, so are
+// dispatched to handlers by their channel name prefix.
 func (r *MangosMqConnection) RunMqTopicReceiver(ProcessRecvMqMessage func(messageBy []byte, state any), topicName string, state any) error {
-	if r.SockSubClient != nil {
-		go ReceiveMqTopicMessages(r.SockSubClient, ProcessRecvMqMessage, topicName, state)
-	}
-	if r.SockRequestListener != nil {
-		go ReceiveMqTopicMessages(r.SockRequestListener, ProcessRecvMqMessage, topicName, state)
+	r.handlersMu.Lock()
+	if r.handlers == nil {
+		r.handlers = map[string]mangosHandler{}
 	}
+	r.handlers[topicName] = mangosHandler{process: ProcessRecvMqMessage, state: state}
+	r.handlersMu.Unlock()
+
+	r.receiving.Do(func() {
+		if r.SockSubClient != nil {
+			go r.receiveMqTopicMessages(r.SockSubClient)
+		}
+		if r.SockRequestListener != nil {
+			go r.receiveMqTopicMessages(r.SockRequestListener)
+		}
+	})
 	return nil
 }
 
-func ReceiveMqTopicMessages(socket mangos.Socket, ProcessRecvMqMessage func(messageBy []byte, state any), topicName string, state any) error {
+func (r *MangosMqConnection) receiveMqTopicMessages(socket mangos.Socket) error {
 	for {
-		var msgBy []byte
-		var err error
-		if msgBy, err = socket.Recv(); err != nil {
+		msgBy, err := socket.Recv()
+		if err != nil {
 			log.Logger.Errorf("cannot receive: %s", err.Error())
 			return err
 		}
+		r.dispatch(msgBy)
+		_ = socket.Send([]byte{}) // ACK for REQ/REP
+	}
+}
+
+// Removes the MQ pre-amble e.g: channelName|{"message"}, and passes the message to the channel's handler
+func (r *MangosMqConnection) dispatch(msgBy []byte) {
+	channelName, message, found := strings.Cut(string(msgBy), "|")
+	if !found {
+		return
+	}
+
+	r.handlersMu.RLock()
+	handler, ok := r.handlers[channelName]
+	r.handlersMu.RUnlock()
+	if !ok {
+		r.logUnrouted(channelName)
+		return
+	}
+	log.Logger.Debugf("MQ[%s] recv: %s", channelName, message)
+	handler.process([]byte(message), handler.state)
+}
 
-		// Remove MQ pre-amble e.g: channelName|{"message"}
-		str := string(msgBy)
-		idx := strings.Index(str, "|")
-		if idx > -1 {
-			newStr := str[idx+1:]
-			log.Logger.Debugf("MQ[%s] recv: %s", topicName, newStr)
-			ProcessRecvMqMessage([]byte(newStr), state)
+// Subscriptions match by prefix and the request socket is shared by all queues, so messages for channels without a
+// receiver are expected. Warns once per channel, then logs at debug
+func (r *MangosMqConnection) logUnrouted(channelName string) {
+	r.handlersMu.Lock()
+	warned := r.unrouted[channelName]
+	if !warned {
+		if r.unrouted == nil {
+			r.unrouted = map[string]bool{}
 		}
-		_ = socket.Send([]byte{}) // ACK for REQ/REP
+		r.unrouted[channelName] = true
+	}
+	r.handlersMu.Unlock()
+
+	if warned {
+		log.Logger.Debugf("MQ[%s] no receiver, message dropped", channelName)
+		return
 	}
+	log.Logger.Warnf("MQ[%s] no receiver, messages dropped", channelName)
 }
 
 func (r *MangosMqConnection) RunMqQueueReceiver(ProcessRecvMqMessage func(messageBy []byte, state any), topicName string, state any) error {
@@ -210,9 +289,9 @@ func (r *MangosMqConnection) RunMqQueueReceiver(ProcessRecvMqMessage func(messag
 	return nil
 }
 
-func (r *MangosMqConnection) MqSendClientMessageRetry(hostName string, connInfo *svc.ConnectionInfo, body any) error {
+func (r *MangosMqConnection) MqSendClientMessageRetry(ctx context.Context, hostName string, connInfo *svc.ConnectionInfo, body any) error {
 
-	json, err := MqCreateMessageEnvelope(hostName, connInfo.NetworkId, body)
+	json, err := MqCreateMessageEnvelope(ctx, hostName, connInfo.NetworkId, body)
 	if err != nil {
 		return err
 	}
@@ -223,6 +302,7 @@ func (r *MangosMqConnection) MqSendClientMessageRetry(hostName string, connInfo
 func (r *MangosMqConnection) MqMessagePublishRetry(channel string, json string) error {
 	var mqErr error
 	retry := 0
+	start := time.Now()
 
 	// Try recover from transient errors
 	for {
@@ -232,17 +312,20 @@ func (r *MangosMqConnection) MqMessagePublishRetry(channel string, json string)
 		} else {
 			if retry == MqChannel_SendMaxRetries {
 				log.Logger.Errorf("MQ[%s] error, failed to send message after %d retries. Error: %s, message: %s", channel, MqChannel_SendMaxRetries, mqErr.Error(), json)
+				metrics.ObserveMqPublish(channel, start, mqErr)
 				return mqErr
 			}
 			retry++
+			metrics.MqPublishRetries.WithLabelValues(channel).Inc()
 			log.Logger.Warnf("MQ[%s] problem, wait: %dms, retry %d/%d, error: %s", channel, MqChannel_SendRetryWaitMs, retry, MqChannel_SendMaxRetries, mqErr.Error())
 			time.Sleep(MqChannel_SendRetryWaitMs * time.Millisecond)
 		}
 	}
+	metrics.ObserveMqPublish(channel, start, nil)
 	return nil
 }
 
-func (r MangosMqConnection) MqQueueDeclare(queueName string) error {
+func (r *MangosMqConnection) MqQueueDeclare(queueName string) error {
 	// NOOP
 	return nil
 }
@@ -0,0 +1,56 @@
+package mq
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	log "sw/ocpp/csms/internal/logging"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = logrus.New()
+	log.Logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestMangosDispatchByChannel(t *testing.T) {
+	connection := &MangosMqConnection{}
+	received := map[string][]string{}
+	record := func(channel string) func([]byte, any) {
+		return func(messageBy []byte, state any) {
+			received[channel] = append(received[channel], string(messageBy)+"/"+state.(string))
+		}
+	}
+	// No sockets, so nothing is received, only the handlers registered
+	connection.RunMqTopicReceiver(record(MqChannelName_MessagesIn), MqChannelName_MessagesIn, "in")
+	connection.RunMqTopicReceiver(record(MqChannelName_DeadLetter), MqChannelName_DeadLetter, "dead")
+
+	connection.dispatch([]byte(`MessagesIn|{"a":"b|c"}`))
+	connection.dispatch([]byte(`DeadLetter|{}`))
+	connection.dispatch([]byte(`MessagesOut|{}`))
+	connection.dispatch([]byte(`MessagesOut|{}`))
+	connection.dispatch([]byte(`no preamble`))
+
+	assert.Equal(t, map[string][]string{
+		MqChannelName_MessagesIn: {`{"a":"b|c"}/in`},
+		MqChannelName_DeadLetter: {`{}/dead`},
+	}, received)
+	assert.Equal(t, map[string]bool{"MessagesOut": true}, connection.unrouted)
+}
+
+func TestMangosHealthCheck(t *testing.T) {
+	connection := &MangosMqConnection{PublisherListenUrl: "tcp://127.0.0.1:0"}
+	assert.Error(t, connection.MqHealthCheck(context.Background()), "not connected")
+
+	require.NoError(t, connection.MqConnect())
+	assert.NoError(t, connection.MqHealthCheck(context.Background()))
+
+	connection.Close()
+	assert.Error(t, connection.MqHealthCheck(context.Background()))
+}
@@ -1,8 +1,10 @@
 package mq
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	log "sw/ocpp/csms/internal/logging"
+	"sw/ocpp/csms/internal/metrics"
 	svc "sw/ocpp/csms/internal/models/service"
 	"time"
 
@@ -12,6 +14,8 @@ import (
 type RabbitMqConnection struct {
 	AmqpServerURL   string
 	ChannelRabbitMQ *amqp.Channel
+
+	connection *amqp.Connection
 }
 
 const (
@@ -46,10 +50,18 @@ func (r *RabbitMqConnection) MqConnect() error {
 	//defer channelRabbitMQ.Close()
 
 	log.Logger.Debug("Connected to RabbitMQ")
+	r.connection = connectRabbitMQ
 	r.ChannelRabbitMQ = channelRabbitMQ
 	return nil
 }
 
+func (r *RabbitMqConnection) MqHealthCheck(ctx context.Context) error {
+	if r.connection == nil || r.connection.IsClosed() {
+		return errors.New("not connected to RabbitMQ")
+	}
+	return nil
+}
+
 func (r *RabbitMqConnection) MqQueueDeclare(queueName string) error {
 	log.Logger.Debugf("Declare queue: %s", queueName)
 
@@ -135,7 +147,7 @@ func (m RabbitMqConnection) MqMessagePublish(queueName string, json string) erro
 		Body:        []byte(json),
 	}
 
-	log.Logger.Debugf(fmt.Sprintf("MQ[%s] send: %s", queueName, json))
+	log.Logger.Debugf("MQ[%s] send: %s", queueName, json)
 	if err := m.ChannelRabbitMQ.Publish(
 		"",        // exchange
 		queueName, // queue name
@@ -149,9 +161,9 @@ func (m RabbitMqConnection) MqMessagePublish(queueName string, json string) erro
 	return nil
 }
 
-func (m *RabbitMqConnection) MqSendClientMessageRetry(hostName string, connInfo *svc.ConnectionInfo, body any) error {
+func (m *RabbitMqConnection) MqSendClientMessageRetry(ctx context.Context, hostName string, connInfo *svc.ConnectionInfo, body any) error {
 
-	json, err := MqCreateMessageEnvelope(hostName, connInfo.NetworkId, body)
+	json, err := MqCreateMessageEnvelope(ctx, hostName, connInfo.NetworkId, body)
 	if err != nil {
 		return err
 	}
@@ -162,6 +174,7 @@ func (m *RabbitMqConnection) MqSendClientMessageRetry(hostName string, connInfo
 func (m *RabbitMqConnection) MqMessagePublishRetry(queueName string, json string) error {
 	var mqErr error
 	retry := 0
+	start := time.Now()
 
 	// Try recover from transient errors
 	for {
@@ -171,9 +184,11 @@ func (m *RabbitMqConnection) MqMessagePublishRetry(queueName string, json string
 		} else {
 			if retry == MqChannel_SendMaxRetries {
 				log.Logger.Errorf("MQ[%s] error, failed to send message after %d retries. Error: %s, message: %s", queueName, MqChannel_SendMaxRetries, mqErr.Error(), json)
+				metrics.ObserveMqPublish(queueName, start, mqErr)
 				return mqErr
 			}
 			retry++
+			metrics.MqPublishRetries.WithLabelValues(queueName).Inc()
 
 			log.Logger.Warnf("MQ[%s] problem, wait: %dms, retry %d/%d, error: %s", queueName, MqChannel_SendRetryWaitMs, retry, MqChannel_SendMaxRetries, mqErr.Error())
 			time.Sleep(MqChannel_SendRetryWaitMs * time.Millisecond)
@@ -182,6 +197,7 @@ func (m *RabbitMqConnection) MqMessagePublishRetry(queueName string, json string
 			m.MqConnect()
 		}
 	}
+	metrics.ObserveMqPublish(queueName, start, nil)
 	return nil
 }
 
@@ -194,7 +210,7 @@ func (m *RabbitMqConnection) RunMqTopicReceiver(ProcessRecvMqMessage func(messag
 		}
 
 		for message := range messages {
-			log.Logger.Debugf(fmt.Sprintf("MQ[%s] recv: %s", topicName, message.Body))
+			log.Logger.Debugf("MQ[%s] recv: %s", topicName, message.Body)
 			ProcessRecvMqMessage(message.Body, state)
 		}
 		time.Sleep(MqChannel_PollWaitMs * time.Millisecond) // TODO improve this
@@ -1,10 +1,13 @@
 package mq
 
 import (
+	"context"
 	"fmt"
 	"os"
 	log "sw/ocpp/csms/internal/logging"
+	"sw/ocpp/csms/internal/metrics"
 	svc "sw/ocpp/csms/internal/models/service"
+	"sync"
 	"time"
 
 	"errors"
@@ -19,6 +22,15 @@ type RedisMqConnection struct {
 
 	clientRedis   *redis.Client
 	topicReceiver *redis.PubSub
+
+	handlersMu sync.RWMutex
+	handlers   map[string]redisHandler // by channel name
+	receiving  sync.Once
+}
+
+type redisHandler struct {
+	process func(messageBy []byte, state any)
+	state   any
 }
 
 func (r *RedisMqConnection) Close() error {
@@ -48,6 +60,13 @@ func (r *RedisMqConnection) MqConnect() error {
 	return nil
 }
 
+func (r *RedisMqConnection) MqHealthCheck(ctx context.Context) error {
+	if r.clientRedis == nil {
+		return errors.New("not connected to redis")
+	}
+	return r.clientRedis.WithContext(ctx).Ping().Err()
+}
+
 func (r *RedisMqConnection) MqMessagePublish(channelName string, json string) error {
 	return r.clientRedis.Publish(channelName, json).Err()
 }
@@ -57,20 +76,45 @@ func (r *RedisMqConnection) MqQueueMessagePublish(channelName string, json strin
 }
 
 func (r *RedisMqConnection) SetupMqTopicReceiver(channelName string, routingKey string) error {
+	if r.topicReceiver != nil {
+		return r.topicReceiver.Subscribe(channelName)
+	}
 	r.topicReceiver = r.clientRedis.Subscribe(channelName)
 	return nil
 }
 
+// Registers the handler for a channel. The first call receives messages for all channels until closed,
+// dispatching them by channel, later calls return once registered.
 func (r *RedisMqConnection) RunMqTopicReceiver(ProcessRecvMqMessage func(messageBy []byte, state any), topicName string, state any) error {
+	r.handlersMu.Lock()
+	if r.handlers == nil {
+		r.handlers = map[string]redisHandler{}
+	}
+	r.handlers[topicName] = redisHandler{process: ProcessRecvMqMessage, state: state}
+	r.handlersMu.Unlock()
+
+	r.receiving.Do(r.receiveMqTopicMessages)
+	return nil
+}
+
+func (r *RedisMqConnection) receiveMqTopicMessages() {
 	for {
 		// TODO use a channel instead of ReceiveMessage
 		msg, err := r.topicReceiver.ReceiveMessage()
 		if err != nil {
 			log.Logger.Errorf("Error receiving message: %s\n", err.Error())
+			time.Sleep(MqChannel_SendRetryWaitMs * time.Millisecond)
+			continue
 		}
-		//log.Logger.Debugf("msg: %s\n", msg.Payload)
 
-		ProcessRecvMqMessage([]byte(msg.Payload), state)
+		r.handlersMu.RLock()
+		handler, ok := r.handlers[msg.Channel]
+		r.handlersMu.RUnlock()
+		if !ok {
+			log.Logger.Warnf("MQ[%s] no receiver, message dropped", msg.Channel)
+			continue
+		}
+		handler.process([]byte(msg.Payload), handler.state)
 	}
 }
 
@@ -96,9 +140,9 @@ func (r *RedisMqConnection) RunMqQueueReceiver(ProcessRecvMqMessage func(message
 	}
 }
 
-func (r *RedisMqConnection) MqSendClientMessageRetry(hostName string, connInfo *svc.ConnectionInfo, body any) error {
+func (r *RedisMqConnection) MqSendClientMessageRetry(ctx context.Context, hostName string, connInfo *svc.ConnectionInfo, body any) error {
 
-	json, err := MqCreateMessageEnvelope(hostName, connInfo.NetworkId, body)
+	json, err := MqCreateMessageEnvelope(ctx, hostName, connInfo.NetworkId, body)
 	if err != nil {
 		return err
 	}
@@ -109,6 +153,7 @@ func (r *RedisMqConnection) MqSendClientMessageRetry(hostName string, connInfo *
 func (r *RedisMqConnection) MqMessagePublishRetry(channel string, json string) error {
 	var mqErr error
 	retry := 0
+	start := time.Now()
 
 	// Try recover from transient errors
 	for {
@@ -118,17 +163,20 @@ func (r *RedisMqConnection) MqMessagePublishRetry(channel string, json string) e
 		} else {
 			if retry == MqChannel_SendMaxRetries {
 				log.Logger.Errorf("MQ[%s] error, failed to send message after %d retries. Error: %s, message: %s", channel, MqChannel_SendMaxRetries, mqErr.Error(), json)
+				metrics.ObserveMqPublish(channel, start, mqErr)
 				return mqErr
 			}
 			retry++
+			metrics.MqPublishRetries.WithLabelValues(channel).Inc()
 			log.Logger.Warnf("MQ[%s] problem, wait: %dms, retry %d/%d, error: %s", channel, MqChannel_SendRetryWaitMs, retry, MqChannel_SendMaxRetries, mqErr.Error())
 			time.Sleep(MqChannel_SendRetryWaitMs * time.Millisecond)
 		}
 	}
+	metrics.ObserveMqPublish(channel, start, nil)
 	return nil
 }
 
-func (r RedisMqConnection) MqQueueDeclare(queueName string) error {
+func (r *RedisMqConnection) MqQueueDeclare(queueName string) error {
 	// NOOP
 	return nil
 }
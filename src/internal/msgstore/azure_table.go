@@ -0,0 +1,247 @@
+package msgstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	log "sw/ocpp/csms/internal/logging"
+	tablemodels "sw/ocpp/csms/internal/models/table"
+	table "sw/ocpp/csms/internal/table"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
+)
+
+const (
+	DefaultTableName = "Messages"
+	// Max entities in an Azure table transaction
+	maxTableTransaction = 100
+	purgePageSize       = 1000
+	// Partition key no charger uses, queried by Ping
+	pingPartition = "~ping"
+)
+
+type AzureTableStore struct {
+	client *aztables.Client
+}
+
+// Connects with the connection string if set (e.g Azurite), otherwise the storage account name and key
+func NewAzureTableStore(config conf.MessageStoreConfig) (*AzureTableStore, error) {
+	tableConfig := config.AzureTable
+	tableName := tableConfig.TableName
+	if tableName == "" {
+		tableName = DefaultTableName
+	}
+
+	var client *aztables.Client
+	var err error
+	if tableConfig.ConnectionString != "" {
+		client, err = table.GetTableClientFromConnectionString(tableName, tableConfig.ConnectionString)
+	} else {
+		client, err = table.GetTableClient(tableName, tableConfig.StorageAccountName, tableConfig.StorageAccountKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = table.CreateTable(client, tableName)
+	var respErr *azcore.ResponseError
+	if err != nil && !(errors.As(err, &respErr) && respErr.ErrorCode == string(aztables.TableAlreadyExists)) {
+		return nil, err
+	}
+	return &AzureTableStore{client: client}, nil
+}
+
+// Submits a table transaction per partition. Transactions which fail are retried an entity at a time,
+// so one bad entity doesn't lose the rest. Entities are upserted, so a batch retried after partly succeeding
+// doesn't conflict with its own rows.
+func (s *AzureTableStore) WriteBatch(ctx context.Context, messages []Message) error {
+	byPartition := map[string][]aztables.TransactionAction{}
+	partitions := []string{}
+	for _, message := range messages {
+		marshalled, err := json.Marshal(toTableEntity(message))
+		if err != nil {
+			return err
+		}
+		if _, ok := byPartition[message.NetworkId]; !ok {
+			partitions = append(partitions, message.NetworkId)
+		}
+		byPartition[message.NetworkId] = append(byPartition[message.NetworkId],
+			aztables.TransactionAction{ActionType: aztables.TransactionTypeInsertReplace, Entity: marshalled})
+	}
+
+	var errs []error
+	for _, partition := range partitions {
+		actions := byPartition[partition]
+		for start := 0; start < len(actions); start += maxTableTransaction {
+			chunk := actions[start:min(start+maxTableTransaction, len(actions))]
+			if _, err := s.client.SubmitTransaction(ctx, chunk, nil); err != nil {
+				log.Logger.Warnf("Table transaction failed for %s, adding individually: %s", partition, err.Error())
+				for _, action := range chunk {
+					if _, err := s.client.UpsertEntity(ctx, action.Entity, &aztables.UpsertEntityOptions{UpdateMode: aztables.UpdateModeReplace}); err != nil {
+						errs = append(errs, err)
+					}
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Queries the networkId partition, filtering on the stored messageTime text which sorts as time.
+// Azure may return fewer than the limit, or none, with a continuation token.
+func (s *AzureTableStore) QueryMessages(ctx context.Context, query MessageQuery) (*MessagePage, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+	token, err := decodeContinuationToken(query.ContinuationToken)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := fmt.Sprintf("PartitionKey eq %s and messageTime ge %s and messageTime lt %s", odataString(query.NetworkId),
+		odataString(query.From.UTC().Format(MessageTimeFormat)), odataString(query.To.UTC().Format(MessageTimeFormat)))
+	if query.Direction != 0 {
+		filter += fmt.Sprintf(" and direction eq %s", odataString(strconv.Itoa(query.Direction)))
+	}
+	if query.MessageType != "" {
+		filter += fmt.Sprintf(" and messageType eq %s", odataString(query.MessageType))
+	}
+
+	var nextPartitionKey, nextRowKey *string
+	if token != nil {
+		nextPartitionKey, nextRowKey = &token.PartitionKey, &token.RowKey
+	}
+	entityPage, err := table.QueryEntities[tablemodels.TableMessageEntity](ctx, s.client, filter, "", int32(query.Limit), nextPartitionKey, nextRowKey)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MessagePage{Messages: []Message{}}
+	for _, entity := range entityPage.Entities {
+		page.Messages = append(page.Messages, fromTableEntity(entity))
+	}
+	if entityPage.NextPartitionKey != nil && entityPage.NextRowKey != nil {
+		page.ContinuationToken = encodeContinuationToken(continuationToken{PartitionKey: *entityPage.NextPartitionKey, RowKey: *entityPage.NextRowKey})
+	}
+	return page, nil
+}
+
+// Table storage can't filter case insensitively on messageType, so all messages older than the shortest retention
+// are scanned, checking each against the policy. Expired messages are deleted in per-partition transactions.
+func (s *AzureTableStore) PurgeMessages(ctx context.Context, policy RetentionPolicy, now time.Time) (*PurgeResult, error) {
+	result := newPurgeResult()
+	shortest := policy.shortest()
+	if shortest == 0 {
+		return result, nil
+	}
+
+	filter := fmt.Sprintf("messageTime lt %s", odataString(now.Add(-shortest).UTC().Format(MessageTimeFormat)))
+	var nextPartitionKey, nextRowKey *string
+	for {
+		page, err := table.QueryEntities[tablemodels.TableMessageEntity](ctx, s.client, filter, "PartitionKey,RowKey,messageType,messageTime",
+			purgePageSize, nextPartitionKey, nextRowKey)
+		if err != nil {
+			return result, err
+		}
+
+		expired := map[string][]aztables.TransactionAction{}
+		for _, entity := range page.Entities {
+			message := fromTableEntity(entity)
+			if !policy.expired(&message, now) {
+				continue
+			}
+			keys, err := json.Marshal(aztables.Entity{PartitionKey: entity.PartitionKey, RowKey: entity.RowKey})
+			if err != nil {
+				return result, err
+			}
+			expired[entity.PartitionKey] = append(expired[entity.PartitionKey],
+				aztables.TransactionAction{ActionType: aztables.TransactionTypeDelete, Entity: keys})
+			rule, _ := policy.ruleFor(entity.MessageType)
+			result.add(rule, 1)
+		}
+		for partition, actions := range expired {
+			if err = s.deleteEntities(ctx, partition, actions); err != nil {
+				return result, err
+			}
+		}
+
+		if page.NextPartitionKey == nil || page.NextRowKey == nil {
+			return result, nil
+		}
+		nextPartitionKey, nextRowKey = page.NextPartitionKey, page.NextRowKey
+	}
+}
+
+// Deletes in transactions of up to maxTableTransaction, falling back to one at a time if a transaction fails
+func (s *AzureTableStore) deleteEntities(ctx context.Context, partition string, actions []aztables.TransactionAction) error {
+	for start := 0; start < len(actions); start += maxTableTransaction {
+		chunk := actions[start:min(start+maxTableTransaction, len(actions))]
+		if _, err := s.client.SubmitTransaction(ctx, chunk, nil); err == nil {
+			continue
+		}
+		for _, action := range chunk {
+			var keys aztables.Entity
+			if err := json.Unmarshal(action.Entity, &keys); err != nil {
+				return err
+			}
+			_, err := table.DeleteEntity(s.client, keys.PartitionKey, keys.RowKey)
+			var respErr *azcore.ResponseError
+			if err != nil && !(errors.As(err, &respErr) && respErr.ErrorCode == string(aztables.ResourceNotFound)) {
+				return fmt.Errorf("deleting from %s: %w", partition, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Queries a single entity, to check the table is reachable
+// Queries a partition no charger uses, so the check is a cheap point lookup rather than a table scan
+func (s *AzureTableStore) Ping(ctx context.Context) error {
+	_, err := table.QueryEntities[tablemodels.TableMessageEntity](ctx, s.client, "PartitionKey eq "+odataString(pingPartition),
+		"PartitionKey", 1, nil, nil)
+	return err
+}
+
+func (s *AzureTableStore) Close() error {
+	return nil
+}
+
+func toTableEntity(message Message) tablemodels.TableMessageEntity {
+	return tablemodels.TableMessageEntity{
+		Entity: aztables.Entity{
+			PartitionKey: message.NetworkId,
+			RowKey:       message.MessageId,
+		},
+		ServerNode:  message.ServerNode,
+		Direction:   strconv.Itoa(message.Direction),
+		MessageType: message.MessageType,
+		MessageTime: message.MessageTime.UTC().Format(MessageTimeFormat),
+		Body:        string(message.Body),
+	}
+}
+
+func fromTableEntity(entity tablemodels.TableMessageEntity) Message {
+	direction, _ := strconv.Atoi(entity.Direction)
+	messageTime, _ := time.Parse(MessageTimeFormat, entity.MessageTime)
+	return Message{
+		NetworkId:   entity.PartitionKey,
+		MessageId:   entity.RowKey,
+		ServerNode:  entity.ServerNode,
+		Direction:   direction,
+		MessageType: entity.MessageType,
+		MessageTime: messageTime,
+		Body:        bodyJson(entity.Body),
+	}
+}
+
+// Quotes an OData string literal
+func odataString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
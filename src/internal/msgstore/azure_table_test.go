@@ -0,0 +1,79 @@
+package msgstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Set to a storage connection string, e.g "UseDevelopmentStorage=true" for a local Azurite,
+// to run the Azure table tests. Each test uses its own table, deleted afterwards.
+const testAzuriteConnectionStringEnv = "CSMS_TEST_AZURITE_CONNECTION_STRING"
+
+func TestAzureTableStore(t *testing.T) {
+	connectionString := os.Getenv(testAzuriteConnectionStringEnv)
+	if connectionString == "" {
+		t.Skipf("%s not set", testAzuriteConnectionStringEnv)
+	}
+
+	config := conf.MessageStoreConfig{}
+	config.AzureTable.ConnectionString = connectionString
+	config.AzureTable.TableName = fmt.Sprintf("MessagesTest%d", time.Now().UnixNano())
+	store, err := NewAzureTableStore(config)
+	require.NoError(t, err)
+	defer store.client.Delete(context.Background(), nil)
+
+	// More than one table transaction for charger-1
+	messages := append(testMessages("charger-1", maxTableTransaction+5), testMessages("charger-2", 2)...)
+	require.NoError(t, store.WriteBatch(context.Background(), messages))
+
+	filter := "PartitionKey eq 'charger-1'"
+	pager := store.client.NewListEntitiesPager(&aztables.ListEntitiesOptions{Filter: &filter})
+	count := 0
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		require.NoError(t, err)
+		count += len(page.Entities)
+	}
+	assert.Equal(t, maxTableTransaction+5, count)
+}
+
+func TestAzureTableStoreQueryMessages(t *testing.T) {
+	connectionString := os.Getenv(testAzuriteConnectionStringEnv)
+	if connectionString == "" {
+		t.Skipf("%s not set", testAzuriteConnectionStringEnv)
+	}
+
+	config := conf.MessageStoreConfig{}
+	config.AzureTable.ConnectionString = connectionString
+	config.AzureTable.TableName = fmt.Sprintf("MessagesTest%d", time.Now().UnixNano())
+	store, err := NewAzureTableStore(config)
+	require.NoError(t, err)
+	defer store.client.Delete(context.Background(), nil)
+
+	testQueryMessages(t, store)
+}
+
+func TestAzureTableStorePurgeMessages(t *testing.T) {
+	connectionString := os.Getenv(testAzuriteConnectionStringEnv)
+	if connectionString == "" {
+		t.Skipf("%s not set", testAzuriteConnectionStringEnv)
+	}
+
+	config := conf.MessageStoreConfig{}
+	config.AzureTable.ConnectionString = connectionString
+	config.AzureTable.TableName = fmt.Sprintf("MessagesTest%d", time.Now().UnixNano())
+	store, err := NewAzureTableStore(config)
+	require.NoError(t, err)
+	defer store.client.Delete(context.Background(), nil)
+
+	testPurgeMessages(t, store)
+}
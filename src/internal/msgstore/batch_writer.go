@@ -0,0 +1,220 @@
+package msgstore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "sw/ocpp/csms/internal/logging"
+)
+
+const (
+	DefaultBatchSize        = 100
+	DefaultFlushIntervalMs  = 1000
+	DefaultQueueSize        = 10000
+	DefaultEnqueueTimeoutMs = 5000
+	DefaultWriteAttempts    = 3
+	DefaultRetryDelayMs     = 1000
+	writeTimeout            = 30 * time.Second
+)
+
+type BatchWriterConfig struct {
+	BatchSize      int           // max messages per partition batch
+	FlushInterval  time.Duration // max time a message waits to be written
+	QueueSize      int           // messages queued before Add blocks
+	EnqueueTimeout time.Duration // max time Add blocks on a full queue before dropping the message
+	WriteAttempts  int           // writes of a batch before its messages are lost
+	RetryDelay     time.Duration // delay before retrying a failed write, doubled for each retry
+}
+
+// Counters since the writer started, and the current queue length
+type BatchWriterStats struct {
+	Enqueued       uint64 // messages accepted by Add
+	Dropped        uint64 // messages dropped as the queue stayed full for EnqueueTimeout
+	QueueFullWaits uint64 // times Add blocked on a full queue
+	Written        uint64 // messages written to the store
+	WriteErrors    uint64 // messages lost due to store errors
+	WriteRetries   uint64 // failed writes retried
+	Batches        uint64 // batches written to the store
+	QueueLength    int
+	QueueCapacity  int
+}
+
+// Queues messages, writing them to the store from a single goroutine in per-partition (networkId) batches.
+// A partition is written when it reaches BatchSize, and all pending partitions every FlushInterval.
+// When the queue is full Add blocks, applying backpressure to the MQ receiver, up to EnqueueTimeout.
+type BatchWriter struct {
+	store  MessageStore
+	config BatchWriterConfig
+	queue  chan Message
+	done   chan struct{}
+	closed sync.WaitGroup
+
+	// pending batches, only used by the run goroutine
+	pending      map[string][]Message
+	pendingCount int
+
+	enqueued       atomic.Uint64
+	dropped        atomic.Uint64
+	queueFullWaits atomic.Uint64
+	written        atomic.Uint64
+	writeErrors    atomic.Uint64
+	writeRetries   atomic.Uint64
+	batches        atomic.Uint64
+}
+
+func NewBatchWriter(store MessageStore, config BatchWriterConfig) *BatchWriter {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultFlushIntervalMs * time.Millisecond
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	if config.EnqueueTimeout <= 0 {
+		config.EnqueueTimeout = DefaultEnqueueTimeoutMs * time.Millisecond
+	}
+	if config.WriteAttempts <= 0 {
+		config.WriteAttempts = DefaultWriteAttempts
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = DefaultRetryDelayMs * time.Millisecond
+	}
+
+	w := &BatchWriter{
+		store:   store,
+		config:  config,
+		queue:   make(chan Message, config.QueueSize),
+		done:    make(chan struct{}),
+		pending: map[string][]Message{},
+	}
+	w.closed.Add(1)
+	go w.run()
+	return w
+}
+
+// Queues a message to be written, returning false if it was dropped as the queue stayed full
+func (w *BatchWriter) Add(message Message) bool {
+	select {
+	case w.queue <- message:
+		w.enqueued.Add(1)
+		return true
+	default:
+	}
+
+	w.queueFullWaits.Add(1)
+	timer := time.NewTimer(w.config.EnqueueTimeout)
+	defer timer.Stop()
+	select {
+	case w.queue <- message:
+		w.enqueued.Add(1)
+		return true
+	case <-timer.C:
+		w.dropped.Add(1)
+		log.Logger.Errorf("Message queue full, message lost: %s %s", message.NetworkId, message.MessageId)
+		return false
+	}
+}
+
+func (w *BatchWriter) Stats() BatchWriterStats {
+	return BatchWriterStats{
+		Enqueued:       w.enqueued.Load(),
+		Dropped:        w.dropped.Load(),
+		QueueFullWaits: w.queueFullWaits.Load(),
+		Written:        w.written.Load(),
+		WriteErrors:    w.writeErrors.Load(),
+		WriteRetries:   w.writeRetries.Load(),
+		Batches:        w.batches.Load(),
+		QueueLength:    len(w.queue),
+		QueueCapacity:  cap(w.queue),
+	}
+}
+
+// Writes everything queued and pending, then stops. Add must not be called after Close.
+func (w *BatchWriter) Close() {
+	close(w.done)
+	w.closed.Wait()
+}
+
+func (w *BatchWriter) run() {
+	defer w.closed.Done()
+
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case message := <-w.queue:
+			w.addPending(message)
+		case <-ticker.C:
+			w.flushAll()
+		case <-w.done:
+			for {
+				select {
+				case message := <-w.queue:
+					w.addPending(message)
+				default:
+					w.flushAll()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *BatchWriter) addPending(message Message) {
+	batch := append(w.pending[message.NetworkId], message)
+	w.pendingCount++
+	if len(batch) >= w.config.BatchSize {
+		delete(w.pending, message.NetworkId)
+		w.pendingCount -= len(batch)
+		w.write(batch)
+		return
+	}
+	w.pending[message.NetworkId] = batch
+}
+
+// Writes all pending partitions in one call, which the store splits per partition as needed
+func (w *BatchWriter) flushAll() {
+	if w.pendingCount == 0 {
+		return
+	}
+	batch := make([]Message, 0, w.pendingCount)
+	for _, messages := range w.pending {
+		batch = append(batch, messages...)
+	}
+	w.pending = map[string][]Message{}
+	w.pendingCount = 0
+	w.write(batch)
+}
+
+// Writes the batch, retrying transient store errors with backoff. Retrying blocks the queue, so Add applies
+// backpressure while the store is down
+func (w *BatchWriter) write(batch []Message) {
+	w.batches.Add(1)
+	delay := w.config.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := w.writeOnce(batch)
+		if err == nil {
+			w.written.Add(uint64(len(batch)))
+			return
+		}
+		if attempt == w.config.WriteAttempts {
+			w.writeErrors.Add(uint64(len(batch)))
+			log.Logger.Errorf("Error writing %d messages to store after %d attempts, messages lost: %s", len(batch), attempt, err.Error())
+			return
+		}
+		w.writeRetries.Add(1)
+		log.Logger.Warnf("Error writing %d messages to store, retrying in %s: %s", len(batch), delay, err.Error())
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (w *BatchWriter) writeOnce(batch []Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	return w.store.WriteBatch(ctx, batch)
+}
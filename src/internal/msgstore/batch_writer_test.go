@@ -0,0 +1,131 @@
+package msgstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchWriterFlushesPartitionOnSize(t *testing.T) {
+	store := &fakeStore{}
+	writer := NewBatchWriter(store, BatchWriterConfig{BatchSize: 3, FlushInterval: time.Hour})
+
+	for _, message := range append(testMessages("charger-1", 7), testMessages("charger-2", 2)...) {
+		require.True(t, writer.Add(message))
+	}
+	assert.Eventually(t, func() bool { return len(store.batchSizes()) == 2 }, time.Second, time.Millisecond)
+	for _, batch := range store.writtenBatches() {
+		for _, message := range batch {
+			assert.Equal(t, "charger-1", message.NetworkId)
+		}
+	}
+
+	// Remaining charger-1 message and both charger-2 messages
+	writer.Close()
+	assert.Equal(t, []int{3, 3, 3}, store.batchSizes())
+
+	stats := writer.Stats()
+	assert.Equal(t, uint64(9), stats.Enqueued)
+	assert.Equal(t, uint64(9), stats.Written)
+	assert.Equal(t, uint64(3), stats.Batches)
+}
+
+func TestBatchWriterFlushesOnInterval(t *testing.T) {
+	store := &fakeStore{}
+	writer := NewBatchWriter(store, BatchWriterConfig{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	defer writer.Close()
+
+	for _, message := range testMessages("charger-1", 2) {
+		writer.Add(message)
+	}
+	assert.Eventually(t, func() bool {
+		sizes := store.batchSizes()
+		return len(sizes) == 1 && sizes[0] == 2
+	}, time.Second, 5*time.Millisecond)
+}
+
+// Blocks writes until released
+type blockingStore struct {
+	fakeStore
+	release chan struct{}
+	err     error
+}
+
+func (s *blockingStore) WriteBatch(ctx context.Context, messages []Message) error {
+	<-s.release
+	s.fakeStore.WriteBatch(ctx, messages)
+	return s.err
+}
+
+func TestBatchWriterDropsWhenQueueStaysFull(t *testing.T) {
+	store := &blockingStore{release: make(chan struct{}), err: errors.New("store down")}
+	writer := NewBatchWriter(store, BatchWriterConfig{BatchSize: 1, FlushInterval: time.Hour, QueueSize: 2, EnqueueTimeout: 10 * time.Millisecond,
+		WriteAttempts: 2, RetryDelay: time.Millisecond})
+
+	messages := testMessages("charger-1", 5)
+	// First is taken by the writer, which then blocks on the store, the next two fill the queue
+	require.True(t, writer.Add(messages[0]))
+	assert.Eventually(t, func() bool { return writer.Stats().QueueLength == 0 }, time.Second, time.Millisecond)
+	require.True(t, writer.Add(messages[1]))
+	require.True(t, writer.Add(messages[2]))
+	assert.False(t, writer.Add(messages[3]))
+
+	stats := writer.Stats()
+	assert.Equal(t, uint64(3), stats.Enqueued)
+	assert.Equal(t, uint64(1), stats.Dropped)
+	assert.Equal(t, uint64(1), stats.QueueFullWaits)
+	assert.Equal(t, 2, stats.QueueLength)
+
+	close(store.release)
+	writer.Close()
+	stats = writer.Stats()
+	assert.Equal(t, uint64(3), stats.WriteErrors)
+	assert.Equal(t, uint64(3), stats.WriteRetries)
+	assert.Equal(t, uint64(0), stats.Written)
+}
+
+// Fails writes until failures have been returned
+type flakyStore struct {
+	fakeStore
+	failures int
+}
+
+func (s *flakyStore) WriteBatch(ctx context.Context, messages []Message) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("timeout")
+	}
+	return s.fakeStore.WriteBatch(ctx, messages)
+}
+
+func TestBatchWriterRetriesFailedWrite(t *testing.T) {
+	store := &flakyStore{failures: 2}
+	writer := NewBatchWriter(store, BatchWriterConfig{BatchSize: 2, FlushInterval: time.Hour, RetryDelay: time.Millisecond})
+
+	for _, message := range testMessages("charger-1", 2) {
+		require.True(t, writer.Add(message))
+	}
+	writer.Close()
+
+	assert.Equal(t, []int{2}, store.batchSizes())
+	stats := writer.Stats()
+	assert.Equal(t, uint64(2), stats.Written)
+	assert.Equal(t, uint64(2), stats.WriteRetries)
+	assert.Equal(t, uint64(0), stats.WriteErrors)
+}
+
+func TestNewMessageIdIsOrderedAndUnique(t *testing.T) {
+	ids := map[string]bool{}
+	last := ""
+	for range 1000 {
+		id := NewMessageId()
+		assert.False(t, ids[id])
+		assert.Greater(t, id, last)
+		ids[id] = true
+		last = id
+	}
+}
@@ -0,0 +1,265 @@
+package msgstore
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	DefaultJsonlMaxSizeMb = 100
+	jsonlFileName         = "messages.jsonl"
+	maxJsonlLine          = 4 * 1024 * 1024
+)
+
+// Appends messages, one JSON object per line, to a file rotated by size
+type JsonlStore struct {
+	mu        sync.Mutex
+	directory string
+	writer    *lumberjack.Logger
+}
+
+func NewJsonlStore(config conf.MessageStoreConfig) (*JsonlStore, error) {
+	jsonlConfig := config.Jsonl
+	if jsonlConfig.Directory == "" {
+		return nil, errors.New("jsonl message store directory not set")
+	}
+
+	maxSizeMb := jsonlConfig.MaxSizeMb
+	if maxSizeMb <= 0 {
+		maxSizeMb = DefaultJsonlMaxSizeMb
+	}
+	return &JsonlStore{
+		directory: jsonlConfig.Directory,
+		writer: &lumberjack.Logger{
+			Filename:   filepath.Join(jsonlConfig.Directory, jsonlFileName),
+			MaxSize:    maxSizeMb,
+			MaxBackups: jsonlConfig.MaxBackups,
+			MaxAge:     jsonlConfig.MaxAgeDays,
+			Compress:   jsonlConfig.Compress,
+			LocalTime:  false,
+		},
+	}, nil
+}
+
+func (s *JsonlStore) WriteBatch(ctx context.Context, messages []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Buffered so the batch is written in as few writes as possible, lumberjack only rotates between writes
+	buf := bufio.NewWriterSize(s.writer, 64*1024)
+	encoder := json.NewEncoder(buf)
+	for _, message := range messages {
+		if err := encoder.Encode(message); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// Scans the current and rotated files, so is only suited to modest archives
+func (s *JsonlStore) QueryMessages(ctx context.Context, query MessageQuery) (*MessagePage, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+
+	// Rotated files are named messages-<time>.jsonl, or .jsonl.gz if compressed
+	files, err := filepath.Glob(filepath.Join(s.directory, "messages*.jsonl*"))
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []Message{}
+	for _, file := range files {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !isJsonlFile(file) {
+			continue
+		}
+		if err = scanJsonlFile(file, &query, &matched); err != nil {
+			return nil, err
+		}
+	}
+	return pageMessages(matched, query)
+}
+
+// Excludes temporary files from a purge
+func isJsonlFile(fileName string) bool {
+	return strings.HasSuffix(fileName, ".jsonl") || strings.HasSuffix(fileName, ".jsonl.gz")
+}
+
+func scanJsonlFile(fileName string, query *MessageQuery, matched *[]Message) error {
+	file, err := os.Open(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // rotated away since the glob
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(fileName, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	return scanJsonl(reader, query, matched)
+}
+
+// Appends messages matching the query. Lines which aren't messages, e.g partially written, are skipped.
+func scanJsonl(reader io.Reader, query *MessageQuery, matched *[]Message) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxJsonlLine)
+	for scanner.Scan() {
+		var message Message
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			continue
+		}
+		if query.matches(&message) {
+			*matched = append(*matched, message)
+		}
+	}
+	return scanner.Err()
+}
+
+// Lines can't be deleted from the file being written, so it is rotated first if its oldest message may have expired.
+// Rotated files with expired messages are then rewritten without them, or removed if none are left.
+func (s *JsonlStore) PurgeMessages(ctx context.Context, policy RetentionPolicy, now time.Time) (*PurgeResult, error) {
+	result := newPurgeResult()
+	shortest := policy.shortest()
+	if shortest == 0 {
+		return result, nil
+	}
+
+	if err := s.rotateIfOlderThan(now.Add(-shortest)); err != nil {
+		return result, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.directory, "messages-*.jsonl*"))
+	if err != nil {
+		return result, err
+	}
+	for _, file := range files {
+		if err = ctx.Err(); err != nil {
+			return result, err
+		}
+		if !isJsonlFile(file) {
+			continue
+		}
+		if err = purgeJsonlFile(file, policy, now, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (s *JsonlStore) rotateIfOlderThan(cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.writer.Filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxJsonlLine)
+	var oldest Message
+	found := scanner.Scan() && json.Unmarshal(scanner.Bytes(), &oldest) == nil
+	file.Close()
+
+	if found && oldest.MessageTime.Before(cutoff) {
+		return s.writer.Rotate()
+	}
+	return nil
+}
+
+func purgeJsonlFile(fileName string, policy RetentionPolicy, now time.Time, result *PurgeResult) error {
+	in, err := os.Open(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // compressed since the glob
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpName := fileName + ".purge"
+	out, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+	defer out.Close()
+
+	var reader io.Reader = in
+	var writer io.Writer = out
+	var gzOut *gzip.Writer
+	if strings.HasSuffix(fileName, ".gz") {
+		gzIn, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer gzIn.Close()
+		reader = gzIn
+		gzOut = gzip.NewWriter(out)
+		writer = gzOut
+	}
+
+	kept, deleted, err := purgeJsonl(reader, writer, policy, now, result)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return nil
+	}
+	if kept == 0 {
+		return os.Remove(fileName)
+	}
+
+	if gzOut != nil {
+		if err = gzOut.Close(); err != nil {
+			return err
+		}
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+func (s *JsonlStore) Ping(ctx context.Context) error {
+	info, err := os.Stat(s.directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("not a directory: " + s.directory)
+	}
+	return nil
+}
+
+func (s *JsonlStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writer.Close()
+}
@@ -0,0 +1,70 @@
+package msgstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	conf "sw/ocpp/csms/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJsonlStore(t *testing.T) {
+	config := conf.MessageStoreConfig{}
+	config.Jsonl.Directory = t.TempDir()
+	store, err := NewJsonlStore(config)
+	require.NoError(t, err)
+
+	messages := testMessages("charger-1", 3)
+	require.NoError(t, store.WriteBatch(context.Background(), messages[:2]))
+	require.NoError(t, store.WriteBatch(context.Background(), messages[2:]))
+	require.NoError(t, store.Close())
+
+	file, err := os.Open(filepath.Join(config.Jsonl.Directory, jsonlFileName))
+	require.NoError(t, err)
+	defer file.Close()
+
+	read := []Message{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var message Message
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &message))
+		read = append(read, message)
+	}
+	require.Len(t, read, 3)
+	for i := range messages {
+		assert.Equal(t, messages[i].MessageId, read[i].MessageId)
+		assert.Equal(t, messages[i].MessageTime, read[i].MessageTime)
+		assert.JSONEq(t, string(messages[i].Body), string(read[i].Body))
+	}
+}
+
+func TestJsonlStoreQueryMessages(t *testing.T) {
+	config := conf.MessageStoreConfig{}
+	config.Jsonl.Directory = t.TempDir()
+	store, err := NewJsonlStore(config)
+	require.NoError(t, err)
+	defer store.Close()
+
+	testQueryMessages(t, store)
+}
+
+func TestJsonlStorePurgeMessages(t *testing.T) {
+	config := conf.MessageStoreConfig{}
+	config.Jsonl.Directory = t.TempDir()
+	store, err := NewJsonlStore(config)
+	require.NoError(t, err)
+	defer store.Close()
+
+	testPurgeMessages(t, store)
+
+	// The active file was rotated, as it held expired messages
+	info, err := os.Stat(filepath.Join(config.Jsonl.Directory, jsonlFileName))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
@@ -0,0 +1,11 @@
+package msgstore
+
+import (
+	"github.com/oklog/ulid/v2"
+)
+
+// Generates a message id (the Azure table RowKey), a ULID which sorts by creation time
+// and is unique even for messages in the same millisecond
+func NewMessageId() string {
+	return ulid.Make().String()
+}
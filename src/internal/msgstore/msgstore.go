@@ -0,0 +1,69 @@
+// Provides the archive of OCPP messages written by message-manager, with pluggable storage backends
+package msgstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+)
+
+const (
+	StoreType_AzureTable = "azure_table"
+	StoreType_Sql        = "sql"
+	StoreType_Jsonl      = "jsonl"
+	StoreType_S3         = "s3"
+)
+
+// Format of MessageTime in the MQ envelope and stored text
+const MessageTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// An archived OCPP message
+type Message struct {
+	NetworkId   string          `json:"networkId"`
+	MessageId   string          `json:"messageId"` // unique per networkId, the Azure table RowKey
+	ServerNode  string          `json:"serverNode"`
+	Direction   int             `json:"direction"`
+	MessageType string          `json:"messageType,omitempty"` // not set for replies
+	MessageTime time.Time       `json:"messageTime"`
+	Body        json.RawMessage `json:"body"`
+}
+
+type MessageStore interface {
+	// Writes a batch of messages, which may be for any number of networkIds
+	WriteBatch(ctx context.Context, messages []Message) error
+	// Queries a page of messages for a networkId. Returns ErrInvalidQuery for a bad query or continuation token
+	QueryMessages(ctx context.Context, query MessageQuery) (*MessagePage, error)
+	// Deletes messages expired by the retention policy at the given time
+	PurgeMessages(ctx context.Context, policy RetentionPolicy, now time.Time) (*PurgeResult, error)
+	// Returns an error if the store can't be reached
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Opens the message store of the configured type
+func Open(config conf.MessageStoreConfig) (MessageStore, error) {
+	switch config.Type {
+	case StoreType_AzureTable:
+		return NewAzureTableStore(config)
+	case StoreType_Sql:
+		return NewSqlStore(config.Sql)
+	case StoreType_Jsonl:
+		return NewJsonlStore(config)
+	case StoreType_S3:
+		return NewS3Store(config)
+	default:
+		return nil, fmt.Errorf("unsupported message store type: %s", config.Type)
+	}
+}
+
+// Stored bodies are JSON, but anything else is returned as a JSON string rather than failing the query
+func bodyJson(body string) json.RawMessage {
+	if json.Valid([]byte(body)) {
+		return json.RawMessage(body)
+	}
+	quoted, _ := json.Marshal(body)
+	return quoted
+}
@@ -0,0 +1,166 @@
+package msgstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	log "sw/ocpp/csms/internal/logging"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = logrus.New()
+	log.Logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// Records written batches
+type fakeStore struct {
+	mu      sync.Mutex
+	batches [][]Message
+}
+
+func (s *fakeStore) WriteBatch(ctx context.Context, messages []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]Message{}, messages...))
+	return nil
+}
+
+func (s *fakeStore) QueryMessages(ctx context.Context, query MessageQuery) (*MessagePage, error) {
+	return &MessagePage{Messages: []Message{}}, nil
+}
+
+func (s *fakeStore) PurgeMessages(ctx context.Context, policy RetentionPolicy, now time.Time) (*PurgeResult, error) {
+	return newPurgeResult(), nil
+}
+
+func (s *fakeStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *fakeStore) Close() error {
+	return nil
+}
+
+func (s *fakeStore) writtenBatches() [][]Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]Message{}, s.batches...)
+}
+
+func (s *fakeStore) batchSizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sizes := []int{}
+	for _, batch := range s.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func testMessages(networkId string, count int) []Message {
+	messageTime := time.Date(2024, 9, 27, 8, 59, 59, 123000000, time.UTC)
+	messages := []Message{}
+	for i := range count {
+		messages = append(messages, Message{
+			NetworkId:   networkId,
+			MessageId:   fmt.Sprintf("%s-%03d", networkId, i),
+			ServerNode:  "node1",
+			Direction:   2,
+			MessageType: "Heartbeat",
+			MessageTime: messageTime.Add(time.Duration(i) * time.Second),
+			Body:        json.RawMessage(fmt.Sprintf(`{"direction":2,"msgId":"m%d","messageType":"Heartbeat","messageBody":{}}`, i)),
+		})
+	}
+	return messages
+}
+
+// Writes a mix of messages, then checks filtering and paging through all results
+func testQueryMessages(t *testing.T, store MessageStore) {
+	ctx := context.Background()
+	messages := testMessages("charger-1", 7)
+	messages[1].Direction, messages[1].MessageType = 3, ""
+	messages[4].MessageType = "StatusNotification"
+	messages = append(messages, testMessages("charger-2", 2)...)
+	require.NoError(t, store.WriteBatch(ctx, messages))
+
+	from := messages[0].MessageTime
+	query := MessageQuery{NetworkId: "charger-1", From: from, To: from.Add(time.Hour), Direction: 2, Limit: 2}
+	read := []string{}
+	for pages := 0; pages < 10; pages++ {
+		page, err := store.QueryMessages(ctx, query)
+		require.NoError(t, err)
+		for _, message := range page.Messages {
+			read = append(read, message.MessageId)
+		}
+		if page.ContinuationToken == "" {
+			break
+		}
+		query.ContinuationToken = page.ContinuationToken
+	}
+	assert.Equal(t, []string{"charger-1-000", "charger-1-002", "charger-1-003", "charger-1-004", "charger-1-005", "charger-1-006"}, read)
+
+	page, err := store.QueryMessages(ctx, MessageQuery{NetworkId: "charger-1", From: from, To: from.Add(time.Hour), MessageType: "StatusNotification"})
+	require.NoError(t, err)
+	require.Len(t, page.Messages, 1)
+	assert.Equal(t, messages[4].MessageTime, page.Messages[0].MessageTime)
+	assert.JSONEq(t, string(messages[4].Body), string(page.Messages[0].Body))
+	assert.Empty(t, page.ContinuationToken)
+
+	// To is exclusive
+	page, err = store.QueryMessages(ctx, MessageQuery{NetworkId: "charger-1", From: from, To: messages[2].MessageTime})
+	require.NoError(t, err)
+	assert.Len(t, page.Messages, 2)
+
+	_, err = store.QueryMessages(ctx, MessageQuery{NetworkId: "charger-1", From: from, To: from.Add(time.Hour), ContinuationToken: "%%"})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+// Writes messages either side of their retention, one batch each as they are days apart, then purges and checks
+// only the expired messages were deleted
+func testPurgeMessages(t *testing.T, store MessageStore) {
+	ctx := context.Background()
+	messages := testMessages("charger-1", 4)
+	start := messages[0].MessageTime
+	now := start.Add(40 * 24 * time.Hour)
+	messages[1].MessageType, messages[1].MessageTime = "StatusNotification", now.Add(-10*24*time.Hour)
+	messages[2].MessageTime = now.Add(-10 * 24 * time.Hour).Add(time.Second)
+	messages[3].MessageType, messages[3].MessageTime = "StatusNotification", now.Add(-24*time.Hour)
+	for i := range messages {
+		require.NoError(t, store.WriteBatch(ctx, messages[i:i+1]))
+	}
+
+	policy := NewRetentionPolicy(conf.RetentionConfig{DefaultDays: 30, MessageTypeDays: map[string]int{"statusnotification": 7}})
+	result, err := store.PurgeMessages(ctx, policy, now)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{RetentionRule_Default: 1, "statusnotification": 1}, result.Deleted)
+
+	remaining := func(from time.Time) []string {
+		page, err := store.QueryMessages(ctx, MessageQuery{NetworkId: "charger-1", From: from, To: from.Add(time.Hour)})
+		require.NoError(t, err)
+		ids := []string{}
+		for _, message := range page.Messages {
+			ids = append(ids, message.MessageId)
+		}
+		return ids
+	}
+	assert.Empty(t, remaining(start))
+	assert.Equal(t, []string{"charger-1-002"}, remaining(messages[1].MessageTime))
+	assert.Equal(t, []string{"charger-1-003"}, remaining(messages[3].MessageTime))
+
+	// Nothing more to purge
+	result, err = store.PurgeMessages(ctx, policy, now)
+	require.NoError(t, err)
+	assert.Zero(t, result.Total())
+}
@@ -0,0 +1,119 @@
+package msgstore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Query for the messages of a networkId with MessageTime in [From, To). Zero Direction and MessageType match any.
+type MessageQuery struct {
+	NetworkId         string
+	From              time.Time
+	To                time.Time
+	Direction         int
+	MessageType       string
+	Limit             int
+	ContinuationToken string // from the previous MessagePage
+}
+
+// A page of messages, oldest first. ContinuationToken is set if there may be more.
+type MessagePage struct {
+	Messages          []Message `json:"messages"`
+	ContinuationToken string    `json:"continuationToken,omitempty"`
+}
+
+// Opaque to callers, each store uses the fields it needs
+type continuationToken struct {
+	Time         int64  `json:"t,omitempty"`
+	Id           int64  `json:"i,omitempty"`
+	MessageId    string `json:"m,omitempty"`
+	PartitionKey string `json:"pk,omitempty"`
+	RowKey       string `json:"rk,omitempty"`
+}
+
+func encodeContinuationToken(token continuationToken) string {
+	tokenBy, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(tokenBy)
+}
+
+// Returns nil if the token is empty
+func decodeContinuationToken(token string) (*continuationToken, error) {
+	if token == "" {
+		return nil, nil
+	}
+	tokenBy, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad continuation token", ErrInvalidQuery)
+	}
+	decoded := new(continuationToken)
+	if err = json.Unmarshal(tokenBy, decoded); err != nil {
+		return nil, fmt.Errorf("%w: bad continuation token", ErrInvalidQuery)
+	}
+	return decoded, nil
+}
+
+// Validates the query, applying the default limit
+func (q *MessageQuery) validate() error {
+	if q.NetworkId == "" {
+		return fmt.Errorf("%w: networkId required", ErrInvalidQuery)
+	}
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidQuery)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	if q.Limit > MaxQueryLimit {
+		return fmt.Errorf("%w: limit must be 1-%d", ErrInvalidQuery, MaxQueryLimit)
+	}
+	return nil
+}
+
+func (q *MessageQuery) matches(message *Message) bool {
+	return message.NetworkId == q.NetworkId &&
+		!message.MessageTime.Before(q.From) && message.MessageTime.Before(q.To) &&
+		(q.Direction == 0 || message.Direction == q.Direction) &&
+		(q.MessageType == "" || message.MessageType == q.MessageType)
+}
+
+// Pages matched messages for stores which scan, ordering by MessageTime then MessageId
+func pageMessages(matched []Message, query MessageQuery) (*MessagePage, error) {
+	token, err := decodeContinuationToken(query.ContinuationToken)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		msI, msJ := matched[i].MessageTime.UnixMilli(), matched[j].MessageTime.UnixMilli()
+		if msI != msJ {
+			return msI < msJ
+		}
+		return matched[i].MessageId < matched[j].MessageId
+	})
+
+	start := 0
+	if token != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			ms := matched[i].MessageTime.UnixMilli()
+			return ms > token.Time || ms == token.Time && matched[i].MessageId > token.MessageId
+		})
+	}
+
+	page := &MessagePage{Messages: matched[start:min(start+query.Limit, len(matched))]}
+	if start+query.Limit < len(matched) {
+		last := page.Messages[len(page.Messages)-1]
+		page.ContinuationToken = encodeContinuationToken(continuationToken{Time: last.MessageTime.UnixMilli(), MessageId: last.MessageId})
+	}
+	return page, nil
+}
@@ -0,0 +1,108 @@
+package msgstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+)
+
+// Name of the retention rule for message types without their own rule, including replies which have no type
+const RetentionRule_Default = "default"
+
+// How long messages are kept, per message type. A zero duration keeps messages forever.
+type RetentionPolicy struct {
+	Default      time.Duration
+	MessageTypes map[string]time.Duration // keyed by lower case message type
+}
+
+// Messages deleted by a purge, by retention rule: the lower case message type or RetentionRule_Default
+type PurgeResult struct {
+	Deleted map[string]int64
+}
+
+func NewRetentionPolicy(config conf.RetentionConfig) RetentionPolicy {
+	policy := RetentionPolicy{
+		Default:      days(config.DefaultDays),
+		MessageTypes: map[string]time.Duration{},
+	}
+	// viper lower cases map keys, so message types are matched case insensitively
+	for messageType, retentionDays := range config.MessageTypeDays {
+		policy.MessageTypes[strings.ToLower(messageType)] = days(retentionDays)
+	}
+	return policy
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// Gets the rule name and retention for a message type
+func (p RetentionPolicy) ruleFor(messageType string) (string, time.Duration) {
+	rule := strings.ToLower(messageType)
+	if retention, ok := p.MessageTypes[rule]; ok && rule != "" {
+		return rule, retention
+	}
+	return RetentionRule_Default, p.Default
+}
+
+func (p RetentionPolicy) expired(message *Message, now time.Time) bool {
+	_, retention := p.ruleFor(message.MessageType)
+	return retention > 0 && message.MessageTime.Before(now.Add(-retention))
+}
+
+// The shortest retention of any rule, no message newer than this is expired. Zero if nothing expires.
+func (p RetentionPolicy) shortest() time.Duration {
+	shortest := p.Default
+	for _, retention := range p.MessageTypes {
+		if retention > 0 && (shortest == 0 || retention < shortest) {
+			shortest = retention
+		}
+	}
+	return shortest
+}
+
+func newPurgeResult() *PurgeResult {
+	return &PurgeResult{Deleted: map[string]int64{}}
+}
+
+func (r *PurgeResult) add(rule string, deleted int64) {
+	if deleted > 0 {
+		r.Deleted[rule] += deleted
+	}
+}
+
+func (r *PurgeResult) Total() int64 {
+	total := int64(0)
+	for _, deleted := range r.Deleted {
+		total += deleted
+	}
+	return total
+}
+
+// Copies JSONL messages from r to w, leaving out expired messages. Lines which aren't messages are kept.
+func purgeJsonl(r io.Reader, w io.Writer, policy RetentionPolicy, now time.Time, result *PurgeResult) (kept int, deleted int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxJsonlLine)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var message Message
+		if json.Unmarshal(line, &message) == nil && policy.expired(&message, now) {
+			rule, _ := policy.ruleFor(message.MessageType)
+			result.add(rule, 1)
+			deleted++
+			continue
+		}
+		if _, err = w.Write(line); err != nil {
+			return kept, deleted, err
+		}
+		if _, err = w.Write([]byte{'\n'}); err != nil {
+			return kept, deleted, err
+		}
+		kept++
+	}
+	return kept, deleted, scanner.Err()
+}
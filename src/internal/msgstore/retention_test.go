@@ -0,0 +1,53 @@
+package msgstore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	policy := NewRetentionPolicy(conf.RetentionConfig{DefaultDays: 30, MessageTypeDays: map[string]int{"MeterValues": 7, "bootnotification": 0}})
+
+	rule, retention := policy.ruleFor("metervalues")
+	assert.Equal(t, "metervalues", rule)
+	assert.Equal(t, 7*24*time.Hour, retention)
+	rule, retention = policy.ruleFor("")
+	assert.Equal(t, RetentionRule_Default, rule)
+	assert.Equal(t, 30*24*time.Hour, retention)
+	assert.Equal(t, 7*24*time.Hour, policy.shortest())
+
+	now := time.Date(2024, 9, 27, 0, 0, 0, 0, time.UTC)
+	assert.True(t, policy.expired(&Message{MessageType: "MeterValues", MessageTime: now.Add(-8 * 24 * time.Hour)}, now))
+	assert.False(t, policy.expired(&Message{MessageType: "Heartbeat", MessageTime: now.Add(-8 * 24 * time.Hour)}, now))
+	// Kept forever
+	assert.False(t, policy.expired(&Message{MessageType: "BootNotification", MessageTime: now.Add(-365 * 24 * time.Hour)}, now))
+
+	assert.Zero(t, NewRetentionPolicy(conf.RetentionConfig{}).shortest())
+}
+
+func TestPurgeJsonl(t *testing.T) {
+	now := time.Date(2024, 9, 27, 0, 0, 0, 0, time.UTC)
+	policy := NewRetentionPolicy(conf.RetentionConfig{DefaultDays: 1})
+	input := strings.Join([]string{
+		`{"networkId":"charger-1","messageId":"old","messageType":"Heartbeat","messageTime":"2024-09-20T00:00:00Z"}`,
+		`not a message`,
+		`{"networkId":"charger-1","messageId":"new","messageType":"Heartbeat","messageTime":"2024-09-26T12:00:00Z"}`,
+	}, "\n")
+
+	var out bytes.Buffer
+	result := newPurgeResult()
+	kept, deleted, err := purgeJsonl(strings.NewReader(input), &out, policy, now, result)
+	require.NoError(t, err)
+	assert.Equal(t, 2, kept)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, int64(1), result.Deleted[RetentionRule_Default])
+	assert.NotContains(t, out.String(), `"old"`)
+	assert.Contains(t, out.String(), "not a message\n")
+}
@@ -0,0 +1,216 @@
+package msgstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	helpers "sw/ocpp/csms/internal/helpers"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	maxS3QueryHours     = 7 * 24
+	objectKeyTimeFormat = "20060102T150405.000Z"
+)
+
+// Writes each batch as a gzipped JSONL object to an S3 compatible bucket, e.g AWS S3 or MinIO.
+// Objects are keyed by the earliest message time in the batch: <prefix>/YYYY/MM/DD/HH/<time>-<host>-<uuid>.jsonl.gz
+type S3Store struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	hostName string
+}
+
+func NewS3Store(config conf.MessageStoreConfig) (*S3Store, error) {
+	s3Config := config.S3
+	if s3Config.Endpoint == "" || s3Config.Bucket == "" {
+		return nil, errors.New("s3 message store endpoint and bucket must be set")
+	}
+
+	client, err := minio.New(s3Config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(s3Config.AccessKeyId, s3Config.SecretAccessKey, ""),
+		Secure: s3Config.UseSsl,
+		Region: s3Config.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{
+		client:   client,
+		bucket:   s3Config.Bucket,
+		prefix:   s3Config.Prefix,
+		hostName: helpers.GetHostName(),
+	}, nil
+}
+
+func (s *S3Store) WriteBatch(ctx context.Context, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	for _, message := range messages {
+		if err := encoder.Encode(message); err != nil {
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	earliest := messages[0].MessageTime
+	for _, message := range messages {
+		if message.MessageTime.Before(earliest) {
+			earliest = message.MessageTime
+		}
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, s.objectKey(earliest.UTC()), &buf, int64(buf.Len()),
+		minio.PutObjectOptions{ContentType: "application/x-ndjson", ContentEncoding: "gzip"})
+	return err
+}
+
+// Reads the objects keyed in the hours of the query, from an hour before From as a batch can span an hour boundary. Ranges over maxS3QueryHours are rejected as too costly.
+func (s *S3Store) QueryMessages(ctx context.Context, query MessageQuery) (*MessagePage, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+	if query.To.Sub(query.From) > maxS3QueryHours*time.Hour {
+		return nil, fmt.Errorf("%w: time range over %d hours", ErrInvalidQuery, maxS3QueryHours)
+	}
+
+	matched := []Message{}
+	for hour := query.From.UTC().Add(-time.Hour).Truncate(time.Hour); hour.Before(query.To); hour = hour.Add(time.Hour) {
+		hourPrefix := path.Join(s.prefix, hour.Format("2006/01/02/15")) + "/"
+		for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: hourPrefix, Recursive: true}) {
+			if object.Err != nil {
+				return nil, object.Err
+			}
+			if err := s.scanObject(ctx, object.Key, &query, &matched); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pageMessages(matched, query)
+}
+
+func (s *S3Store) scanObject(ctx context.Context, key string, query *MessageQuery, matched *[]Message) error {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	gz, err := gzip.NewReader(object)
+	if err != nil {
+		return fmt.Errorf("object %s: %w", key, err)
+	}
+	defer gz.Close()
+	return scanJsonl(gz, query, matched)
+}
+
+// Objects can't be partly deleted, so objects with expired messages are replaced without them, or removed if none
+// are left. Objects keyed before the shortest retention are read, as no message in later objects can have expired.
+func (s *S3Store) PurgeMessages(ctx context.Context, policy RetentionPolicy, now time.Time) (*PurgeResult, error) {
+	result := newPurgeResult()
+	shortest := policy.shortest()
+	if shortest == 0 {
+		return result, nil
+	}
+	cutoff := now.Add(-shortest)
+
+	listPrefix := ""
+	if s.prefix != "" {
+		listPrefix = strings.TrimSuffix(s.prefix, "/") + "/"
+	}
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: listPrefix, Recursive: true}) {
+		if object.Err != nil {
+			return result, object.Err
+		}
+		earliest, ok := objectKeyTime(object.Key)
+		if !ok || !earliest.Before(cutoff) {
+			continue
+		}
+		if err := s.purgeObject(ctx, object.Key, policy, now, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (s *S3Store) purgeObject(ctx context.Context, key string, policy RetentionPolicy, now time.Time, result *PurgeResult) error {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	gzIn, err := gzip.NewReader(object)
+	if err != nil {
+		return fmt.Errorf("object %s: %w", key, err)
+	}
+	defer gzIn.Close()
+
+	var buf bytes.Buffer
+	gzOut := gzip.NewWriter(&buf)
+	kept, deleted, err := purgeJsonl(gzIn, gzOut, policy, now, result)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return nil
+	}
+	if kept == 0 {
+		return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+	}
+	if err = gzOut.Close(); err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, key, &buf, int64(buf.Len()),
+		minio.PutObjectOptions{ContentType: "application/x-ndjson", ContentEncoding: "gzip"})
+	return err
+}
+
+// Parses the earliest message time from an object key's name
+func objectKeyTime(key string) (time.Time, bool) {
+	name := path.Base(key)
+	if len(name) < len(objectKeyTimeFormat) {
+		return time.Time{}, false
+	}
+	keyTime, err := time.Parse(objectKeyTimeFormat, name[:len(objectKeyTimeFormat)])
+	return keyTime, err == nil
+}
+
+func (s *S3Store) Ping(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket not found: %s", s.bucket)
+	}
+	return nil
+}
+
+func (s *S3Store) Close() error {
+	return nil
+}
+
+func (s *S3Store) objectKey(earliest time.Time) string {
+	name := fmt.Sprintf("%s-%s-%s.jsonl.gz", earliest.Format(objectKeyTimeFormat), s.hostName, uuid.New().String())
+	return path.Join(s.prefix, earliest.Format("2006/01/02/15"), name)
+}
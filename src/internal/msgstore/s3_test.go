@@ -0,0 +1,190 @@
+package msgstore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	conf "sw/ocpp/csms/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Minimal S3 compatible endpoint, supporting PutObject, GetObject and ListObjectsV2 for a path style bucket
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPut:
+		f.putObject(w, r)
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.listObjects(w, r)
+	case r.Method == http.MethodGet:
+		f.getObject(w, r)
+	case r.Method == http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, r.URL.Path)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+type fakeS3ListResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	IsTruncated bool
+	Contents    []fakeS3Object
+}
+
+type fakeS3Object struct {
+	Key          string
+	Size         int
+	ETag         string
+	LastModified string
+}
+
+func (f *fakeS3) listObjects(w http.ResponseWriter, r *http.Request) {
+	bucketPath := strings.TrimSuffix(r.URL.Path, "/") + "/"
+	prefix := r.URL.Query().Get("prefix")
+
+	f.mu.Lock()
+	result := fakeS3ListResult{Name: strings.Trim(bucketPath, "/"), Prefix: prefix}
+	for path, object := range f.objects {
+		key := strings.TrimPrefix(path, bucketPath)
+		if strings.HasPrefix(path, bucketPath) && strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, fakeS3Object{Key: key, Size: len(object), ETag: `"fake"`, LastModified: "2024-09-27T08:59:59.000Z"})
+		}
+	}
+	f.mu.Unlock()
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) getObject(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	object, ok := f.objects[r.URL.Path]
+	f.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", `"fake"`)
+	w.Header().Set("Last-Modified", "Fri, 27 Sep 2024 08:59:59 GMT")
+	w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+	w.Write(object)
+}
+
+func (f *fakeS3) putObject(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil && strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body, err = decodeAwsChunked(body)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.objects[r.URL.Path] = body
+	f.mu.Unlock()
+	w.Header().Set("ETag", `"fake"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Decodes an aws-chunked body: <hex size>;chunk-signature=<sig>\r\n<data>\r\n ... ending with a 0 size chunk
+func decodeAwsChunked(body []byte) ([]byte, error) {
+	var decoded bytes.Buffer
+	reader := bufio.NewReader(bytes.NewReader(body))
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return decoded.Bytes(), nil
+		}
+		if _, err = io.CopyN(&decoded, reader, size); err != nil {
+			return nil, err
+		}
+		if _, err = reader.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func newTestS3Store(t *testing.T) (*S3Store, *fakeS3) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	config := conf.MessageStoreConfig{}
+	config.S3.Endpoint = strings.TrimPrefix(server.URL, "http://")
+	config.S3.Region = "us-east-1"
+	config.S3.Bucket = "archive"
+	config.S3.Prefix = "messages"
+	config.S3.AccessKeyId = "key"
+	config.S3.SecretAccessKey = "secret"
+	store, err := NewS3Store(config)
+	require.NoError(t, err)
+	return store, fake
+}
+
+func TestS3Store(t *testing.T) {
+	store, fake := newTestS3Store(t)
+
+	messages := testMessages("charger-1", 3)
+	require.NoError(t, store.WriteBatch(context.Background(), messages))
+
+	require.Len(t, fake.objects, 1)
+	for key, object := range fake.objects {
+		assert.True(t, strings.HasPrefix(key, "/archive/messages/"), key)
+		assert.True(t, strings.HasSuffix(key, ".jsonl.gz"), key)
+
+		gz, err := gzip.NewReader(bytes.NewReader(object))
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(gz)
+		read := []Message{}
+		for scanner.Scan() {
+			var message Message
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &message))
+			read = append(read, message)
+		}
+		require.Len(t, read, 3)
+		assert.Equal(t, messages[2].MessageId, read[2].MessageId)
+	}
+}
+
+func TestS3StoreQueryMessages(t *testing.T) {
+	store, _ := newTestS3Store(t)
+	testQueryMessages(t, store)
+}
+
+func TestS3StorePurgeMessages(t *testing.T) {
+	store, fake := newTestS3Store(t)
+	testPurgeMessages(t, store)
+	assert.Len(t, fake.objects, 2)
+}
@@ -0,0 +1,138 @@
+package msgstore
+
+import (
+	"context"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+)
+
+// Stores messages in the messages table of a sqlite or postgres DB
+type SqlStore struct {
+	store *db.Store
+}
+
+// Opens the DB and applies any pending migrations
+func NewSqlStore(config conf.DbConfig) (*SqlStore, error) {
+	store, err := db.Open(config)
+	if err != nil {
+		return nil, err
+	}
+	if err = store.MigrateUp(context.Background()); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return &SqlStore{store: store}, nil
+}
+
+func (s *SqlStore) WriteBatch(ctx context.Context, messages []Message) error {
+	rows := make([]dbmodels.Message, 0, len(messages))
+	for _, message := range messages {
+		rows = append(rows, dbmodels.Message{
+			NetworkId:   message.NetworkId,
+			MessageId:   message.MessageId,
+			ServerNode:  message.ServerNode,
+			Direction:   message.Direction,
+			MessageType: message.MessageType,
+			MessageTime: message.MessageTime,
+			Body:        string(message.Body),
+		})
+	}
+	return s.store.Messages.InsertMessages(ctx, rows)
+}
+
+func (s *SqlStore) QueryMessages(ctx context.Context, query MessageQuery) (*MessagePage, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+	token, err := decodeContinuationToken(query.ContinuationToken)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := dbmodels.MessageFilter{
+		NetworkId:   query.NetworkId,
+		From:        query.From,
+		To:          query.To,
+		Direction:   query.Direction,
+		MessageType: query.MessageType,
+		Limit:       query.Limit + 1, // one more to know if there's another page
+	}
+	if token != nil {
+		afterTime := time.UnixMilli(token.Time)
+		filter.AfterTime = &afterTime
+		filter.AfterId = token.Id
+	}
+
+	rows, err := s.store.Messages.ListMessages(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MessagePage{Messages: []Message{}}
+	if len(rows) > query.Limit {
+		rows = rows[:query.Limit]
+		last := rows[len(rows)-1]
+		page.ContinuationToken = encodeContinuationToken(continuationToken{Time: last.MessageTime.UnixMilli(), Id: last.Id})
+	}
+	for _, row := range rows {
+		page.Messages = append(page.Messages, Message{
+			NetworkId:   row.NetworkId,
+			MessageId:   row.MessageId,
+			ServerNode:  row.ServerNode,
+			Direction:   row.Direction,
+			MessageType: row.MessageType,
+			MessageTime: row.MessageTime,
+			Body:        bodyJson(row.Body),
+		})
+	}
+	return page, nil
+}
+
+// Deletes per message type rule, then the default rule for all other types, in limited batches
+func (s *SqlStore) PurgeMessages(ctx context.Context, policy RetentionPolicy, now time.Time) (*PurgeResult, error) {
+	result := newPurgeResult()
+	ruleTypes := []string{}
+	for messageType, retention := range policy.MessageTypes {
+		ruleTypes = append(ruleTypes, messageType)
+		if retention <= 0 {
+			continue
+		}
+		err := s.deleteAll(ctx, dbmodels.MessageDeleteFilter{Before: now.Add(-retention), MessageType: messageType}, messageType, result)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if policy.Default > 0 {
+		err := s.deleteAll(ctx, dbmodels.MessageDeleteFilter{Before: now.Add(-policy.Default), ExcludeMessageTypes: ruleTypes}, RetentionRule_Default, result)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (s *SqlStore) deleteAll(ctx context.Context, filter dbmodels.MessageDeleteFilter, rule string, result *PurgeResult) error {
+	filter.Limit = db.DefaultDeleteLimit
+	for {
+		deleted, err := s.store.Messages.DeleteMessages(ctx, filter)
+		if err != nil {
+			return err
+		}
+		result.add(rule, deleted)
+		if deleted < int64(filter.Limit) {
+			return nil
+		}
+	}
+}
+
+func (s *SqlStore) Ping(ctx context.Context) error {
+	return s.store.Ping(ctx)
+}
+
+func (s *SqlStore) Close() error {
+	return s.store.Close()
+}
@@ -0,0 +1,56 @@
+package msgstore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSqlStore(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "messages.db")
+	store, err := NewSqlStore(conf.DbConfig{DbType: db.DbType_Sqlite, DbConnectionString: dbFile})
+	require.NoError(t, err)
+
+	messages := append(testMessages("charger-1", 2), testMessages("charger-2", 1)...)
+	require.NoError(t, store.WriteBatch(context.Background(), messages))
+	require.NoError(t, store.Close())
+
+	sqlDb, err := sql.Open(db.DbType_Sqlite, dbFile)
+	require.NoError(t, err)
+	defer sqlDb.Close()
+
+	var count int
+	require.NoError(t, sqlDb.QueryRow("SELECT COUNT(*) FROM messages WHERE networkId = ?", "charger-1").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	var messageType, body string
+	var messageTime int64
+	require.NoError(t, sqlDb.QueryRow("SELECT messageType, messageTime, body FROM messages WHERE messageId = ?", "charger-2-000").
+		Scan(&messageType, &messageTime, &body))
+	assert.Equal(t, "Heartbeat", messageType)
+	assert.Equal(t, messages[2].MessageTime.UnixMilli(), messageTime)
+	assert.JSONEq(t, string(messages[2].Body), body)
+}
+
+func TestSqlStoreQueryMessages(t *testing.T) {
+	store, err := NewSqlStore(conf.DbConfig{DbType: db.DbType_Sqlite, DbConnectionString: filepath.Join(t.TempDir(), "messages.db")})
+	require.NoError(t, err)
+	defer store.Close()
+
+	testQueryMessages(t, store)
+}
+
+func TestSqlStorePurgeMessages(t *testing.T) {
+	store, err := NewSqlStore(conf.DbConfig{DbType: db.DbType_Sqlite, DbConnectionString: filepath.Join(t.TempDir(), "messages.db")})
+	require.NoError(t, err)
+	defer store.Close()
+
+	testPurgeMessages(t, store)
+}
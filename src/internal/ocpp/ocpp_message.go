@@ -2,6 +2,7 @@
 package ocpp
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 
@@ -22,6 +23,12 @@ func GetHeatBeatAck(eventId string) string {
 	return json
 }
 
+// Gets a CALLERROR, e.g [4, "msgId", "NotImplemented", "unknown action: Foo", {}]
+func GetCallError(eventId string, errorCode string, errorDescription string) string {
+	jsonBy, _ := json.Marshal([]any{MsgType_Error, eventId, errorCode, errorDescription, map[string]any{}})
+	return string(jsonBy)
+}
+
 func GenerateUniqueId() string {
 	return uuid.New().String()
 }
@@ -33,3 +40,40 @@ func WrapEvent(direction int, eventId string, obj any) (string, error) {
 	}
 	return fmt.Sprintf("[%d, \"%s\", %s]", direction, eventId, string(jsonBy)), nil
 }
+
+// Replaces the value of a ChangeConfiguration payload setting the AuthorizationKey, other payloads are returned as is
+func RedactChangeConfiguration(payload json.RawMessage) json.RawMessage {
+	var change OcppChangeConfiguration
+	if err := json.Unmarshal(payload, &change); err != nil || change.Key != ConfigKey_AuthorizationKey {
+		return payload
+	}
+	change.Value = RedactedValue
+	redacted, err := json.Marshal(change)
+	if err != nil {
+		return payload
+	}
+	return redacted
+}
+
+// Redacts the AuthorizationKey of a ChangeConfiguration CALL frame, e.g [2, "msgId", "ChangeConfiguration", {...}]
+func RedactFrame(frame []byte) []byte {
+	if !bytes.Contains(frame, []byte(ConfigKey_AuthorizationKey)) {
+		return frame
+	}
+	var parts []json.RawMessage
+	if err := json.Unmarshal(frame, &parts); err != nil || len(parts) != 4 {
+		return frame
+	}
+	var messageType int
+	var action string
+	if json.Unmarshal(parts[0], &messageType) != nil || messageType != MsgType_ClientToServer ||
+		json.Unmarshal(parts[2], &action) != nil || action != MsgType_ChangeConfiguration {
+		return frame
+	}
+	parts[3] = RedactChangeConfiguration(parts[3])
+	redacted, err := json.Marshal(parts)
+	if err != nil {
+		return frame
+	}
+	return redacted
+}
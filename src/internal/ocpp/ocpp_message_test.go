@@ -3,6 +3,9 @@ package ocpp
 import (
 	"encoding/json"
 	"testing"
+	"time"
+
+	helpers "sw/ocpp/csms/internal/helpers"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -15,7 +18,7 @@ func mockGenerateDateNowMs() string {
 
 func TestGetAck(t *testing.T) {
 	correlationId := "test-correlation-id"
-	expected := "[2, \"test-correlation-id\", \"\"]"
+	expected := "[3, \"test-correlation-id\", \"\"]"
 
 	result := GetAck(correlationId)
 
@@ -23,15 +26,14 @@ func TestGetAck(t *testing.T) {
 }
 
 func TestGetHeatBeatAck(t *testing.T) {
-	// Mock the GenerateDateNowMs function
-	//originalGenerateDateNowMs := helpers.GenerateDateNowMs
-	//helpers.GenerateDateNowMs = mockGenerateDateNowMs
-	//defer func() { helpers.GenerateDateNowMs = originalGenerateDateNowMs }()
+	// Mock the time used by GenerateDateNowMs
+	helpers.SetMockNow(func() time.Time { return time.Date(2024, 9, 27, 8, 59, 59, 0, time.UTC) })
+	defer helpers.ResetMockNow()
 
 	eventId := "test-event-id"
 	hbEvent := OcppHeartBeatAck{CurrentTime: mockGenerateDateNowMs()}
 	hbEventJson, _ := json.Marshal(hbEvent)
-	expected := "[2, \"test-event-id\", " + string(hbEventJson) + "]"
+	expected := "[3, \"test-event-id\", " + string(hbEventJson) + "]"
 
 	result := GetHeatBeatAck(eventId)
 
@@ -57,3 +59,18 @@ func TestWrapEvent(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expected, result)
 }
+
+func TestRedactFrame(t *testing.T) {
+	frame := []byte(`[2, "1", "ChangeConfiguration", {"key":"AuthorizationKey","value":"secret"}]`)
+	assert.Equal(t, `[2,"1","ChangeConfiguration",{"key":"AuthorizationKey","value":"***"}]`, string(RedactFrame(frame)))
+
+	// Other keys, actions and malformed frames are left as is
+	for _, frame := range []string{
+		`[2,"1","ChangeConfiguration",{"key":"HeartbeatInterval","value":"60"}]`,
+		`[2,"1","DataTransfer",{"vendorId":"AuthorizationKey","data":"x"}]`,
+		`[3,"1",{"status":"Accepted"}]`,
+		`not json AuthorizationKey`,
+	} {
+		assert.Equal(t, frame, string(RedactFrame([]byte(frame))))
+	}
+}
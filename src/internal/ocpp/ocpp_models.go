@@ -19,11 +19,11 @@ type OcppMessageResponse struct {
 	MessageBody json.RawMessage `json:"messageBody,omitempty"`
 }
 
-// TODO move to abstraction
-type ActionResponse struct {
-	StatusCode  int             `json:"statusCode,omitempty"`
-	MsgId       string          `json:"msgId,omitempty"`
-	MessageBody json.RawMessage `json:"messageBody,omitempty"`
+// The body of a CALLERROR, [4, msgId, errorCode, errorDescription, errorDetails]
+type OcppCallError struct {
+	ErrorCode        string          `json:"errorCode"`
+	ErrorDescription string          `json:"errorDescription"`
+	ErrorDetails     json.RawMessage `json:"errorDetails,omitempty"`
 }
 
 type OcppCurrentTime struct {
@@ -50,6 +50,7 @@ const (
 	OcppDirection_ServerClient = 1
 	OcppDirection_ClientServer = 2
 	OcppDirection_Reply        = 3
+	OcppDirection_CallError    = 4
 )
 
 const (
@@ -193,6 +194,13 @@ const (
 	MsgType_ChangeAvailability     = "ChangeAvailability"
 	MsgType_ChangeConfiguration    = "ChangeConfiguration"
 	MsgType_TriggerMessage         = "TriggerMessage"
+
+	// Security extension
+	MsgType_CertificateSigned          = "CertificateSigned"
+	MsgType_InstallCertificate         = "InstallCertificate"
+	MsgType_GetInstalledCertificateIds = "GetInstalledCertificateIds"
+	MsgType_DeleteCertificate          = "DeleteCertificate"
+	MsgType_ExtendedTriggerMessage     = "ExtendedTriggerMessage"
 )
 
 // Transactions
@@ -200,8 +208,9 @@ type OcppStartTransaction struct {
 	Timestamp   string `json:"timestamp,omitempty"`
 	ConnectorId int    `json:"connectorId,omitempty"`
 
-	IdTag      string `json:"idTag,omitempty"`
-	MeterStart int    `json:"meterStart,omitempty"`
+	IdTag         string `json:"idTag,omitempty"`
+	MeterStart    int    `json:"meterStart,omitempty"`
+	ReservationId *int   `json:"reservationId,omitempty"`
 }
 
 type OcppStopTransaction struct {
@@ -233,3 +242,55 @@ type OcppDataTransfer struct {
 	MessageId string `json:"messageId,omitempty"`
 	Data      string `json:"data,omitempty"`
 }
+
+// Configuration
+type OcppChangeConfiguration struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type OcppChangeConfigurationResponse struct {
+	Status string `json:"status"`
+}
+
+const (
+	ConfigurationStatus_Accepted       = "Accepted"
+	ConfigurationStatus_Rejected       = "Rejected"
+	ConfigurationStatus_RebootRequired = "RebootRequired"
+	ConfigurationStatus_NotSupported   = "NotSupported"
+)
+
+// Security profile 1 Basic auth password of the charger
+const ConfigKey_AuthorizationKey = "AuthorizationKey"
+
+// Stored in place of an AuthorizationKey in captures and archived messages
+const RedactedValue = "***"
+
+// Certificates
+type OcppSignCertificate struct {
+	Csr string `json:"csr"`
+}
+
+type OcppCertificateSigned struct {
+	CertificateChain string `json:"certificateChain"`
+}
+
+type OcppExtendedTriggerMessage struct {
+	RequestedMessage string `json:"requestedMessage"`
+	ConnectorId      int    `json:"connectorId,omitempty"`
+}
+
+// Response of SignCertificate, CertificateSigned and ExtendedTriggerMessage
+type OcppStatusResponse struct {
+	Status string `json:"status"`
+}
+
+const (
+	GenericStatus_Accepted = "Accepted"
+	GenericStatus_Rejected = "Rejected"
+
+	CertificateType_ChargePoint = "ChargePointCertificate"
+
+	// ExtendedTriggerMessage requestedMessage, for the charger to send a new SignCertificate
+	TriggerMessage_SignChargePointCertificate = "SignChargePointCertificate"
+)
@@ -0,0 +1,55 @@
+package ocpp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// OCPP CALLERROR error codes
+const (
+	CallError_NotImplemented     = "NotImplemented"
+	CallError_FormationViolation = "FormationViolation"
+)
+
+var ErrUnknownAction = errors.New("unknown action")
+
+// Required payload fields of the OCPP 1.6 charger to server actions, including the security extension
+var callRequiredFields = map[string][]string{
+	"Authorize":                        {"idTag"},
+	"BootNotification":                 {"chargePointVendor", "chargePointModel"},
+	"DataTransfer":                     {"vendorId"},
+	"DiagnosticsStatusNotification":    {"status"},
+	"FirmwareStatusNotification":       {"status"},
+	"Heartbeat":                        {},
+	"MeterValues":                      {"connectorId", "meterValue"},
+	"StartTransaction":                 {"connectorId", "idTag", "meterStart", "timestamp"},
+	"StatusNotification":               {"connectorId", "errorCode", "status"},
+	"StopTransaction":                  {"transactionId", "meterStop", "timestamp"},
+	"LogStatusNotification":            {"status"},
+	"SecurityEventNotification":        {"type", "timestamp"},
+	"SignCertificate":                  {"csr"},
+	"SignedFirmwareStatusNotification": {"status"},
+}
+
+// Checks a charger call's action is known and its payload is an object with the action's required fields.
+// Returns ErrUnknownAction for an action which isn't an OCPP 1.6 charger to server action.
+func ValidateCall(action string, payload json.RawMessage) error {
+	required, ok := callRequiredFields[action]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownAction, action)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil || fields == nil {
+		return fmt.Errorf("%s payload is not an object", action)
+	}
+	for _, field := range required {
+		value, ok := fields[field]
+		if !ok || bytes.Equal(value, []byte("null")) {
+			return fmt.Errorf("%s payload missing required field: %s", action, field)
+		}
+	}
+	return nil
+}
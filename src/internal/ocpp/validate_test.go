@@ -0,0 +1,26 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCall(t *testing.T) {
+	assert.NoError(t, ValidateCall("Heartbeat", json.RawMessage(`{}`)))
+	assert.NoError(t, ValidateCall("BootNotification", json.RawMessage(`{"chargePointVendor":"v","chargePointModel":"m"}`)))
+
+	err := ValidateCall("BootNotification", json.RawMessage(`{"chargePointVendor":"v","chargePointModel":null}`))
+	assert.EqualError(t, err, "BootNotification payload missing required field: chargePointModel")
+
+	err = ValidateCall("StatusNotification", json.RawMessage(`[1]`))
+	assert.EqualError(t, err, "StatusNotification payload is not an object")
+	assert.Error(t, ValidateCall("Heartbeat", nil))
+
+	assert.ErrorIs(t, ValidateCall("Reset", json.RawMessage(`{"type":"Soft"}`)), ErrUnknownAction)
+}
+
+func TestGetCallError(t *testing.T) {
+	assert.Equal(t, `[4,"m1","NotImplemented","unknown action: Foo",{}]`, GetCallError("m1", CallError_NotImplemented, "unknown action: Foo"))
+}
@@ -0,0 +1,73 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const defaultHttpTimeout = 30 * time.Second
+
+// Signs certificates with an external CA, POSTing {"csr": "<PEM>"} and expecting {"certificateChain": "<PEM>"}
+type HttpCa struct {
+	url           string
+	authorization string
+	client        *http.Client
+}
+
+// Creates an external CA client. authorization is sent as the Authorization header if set
+func NewHttpCa(url string, authorization string, timeout time.Duration) (*HttpCa, error) {
+	if url == "" {
+		return nil, errors.New("pki http ca needs url")
+	}
+	if timeout <= 0 {
+		timeout = defaultHttpTimeout
+	}
+	return &HttpCa{url: url, authorization: authorization, client: &http.Client{Timeout: timeout}}, nil
+}
+
+type httpSignRequest struct {
+	Csr string `json:"csr"`
+}
+
+type httpSignResponse struct {
+	CertificateChain string `json:"certificateChain"`
+}
+
+func (c *HttpCa) SignCsr(ctx context.Context, csr *x509.CertificateRequest) ([]*x509.Certificate, error) {
+	body, err := json.Marshal(httpSignRequest{Csr: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}))})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("ca responded %d: %s", res.StatusCode, string(message))
+	}
+
+	response := &httpSignResponse{}
+	if err := json.NewDecoder(res.Body).Decode(response); err != nil {
+		return nil, err
+	}
+	return ParseChain(response.CertificateChain)
+}
@@ -0,0 +1,69 @@
+package pki
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// Signs certificates with a CA certificate and key held locally
+type LocalCa struct {
+	cert     *x509.Certificate
+	key      crypto.Signer
+	chain    []*x509.Certificate
+	validity time.Duration
+}
+
+// Loads the CA from PEM files. certFile can hold the CA's own chain after its certificate, which is appended to issued chains
+func NewLocalCa(certFile string, keyFile string, validity time.Duration) (*LocalCa, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("ca key can't sign")
+	}
+	var chain []*x509.Certificate
+	for _, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if !chain[0].IsCA {
+		return nil, errors.New("ca certificate isn't a CA")
+	}
+	return &LocalCa{cert: chain[0], key: key, chain: chain, validity: validity}, nil
+}
+
+func (c *LocalCa) SignCsr(ctx context.Context, csr *x509.CertificateRequest) ([]*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      csr.Subject,
+		NotBefore:    now.Add(-5 * time.Minute), // allow for charger clock skew
+		NotAfter:     now.Add(c.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.cert, csr.PublicKey, c.key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return append([]*x509.Certificate{cert}, c.chain...), nil
+}
@@ -0,0 +1,99 @@
+// Provides certificate signing for chargers' SignCertificate CSRs, by a local CA or an external one
+package pki
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+)
+
+const (
+	Ca_Local = "local"
+	Ca_Http  = "http"
+
+	DefaultValidityDays = 365
+)
+
+// Signs charger certificates, implemented by LocalCa and HttpCa. Other CAs can be plugged in by implementing it
+type CertificateAuthority interface {
+	// Signs a CSR, returning the certificate chain, leaf first
+	SignCsr(ctx context.Context, csr *x509.CertificateRequest) ([]*x509.Certificate, error)
+}
+
+// Creates the configured CA
+func NewCertificateAuthority(config conf.PkiConfig) (CertificateAuthority, error) {
+	switch config.Ca {
+	case Ca_Local:
+		return NewLocalCa(config.Local.CertFile, config.Local.KeyFile, validity(config.Local.ValidityDays))
+	case Ca_Http:
+		return NewHttpCa(config.Http.Url, config.Http.Authorization, time.Duration(config.Http.TimeoutSecs)*time.Second)
+	default:
+		return nil, fmt.Errorf("unknown pki ca: %s", config.Ca)
+	}
+}
+
+// Parses a PEM CSR from SignCertificate, checking its signature and that its CN is the networkId
+func ParseCsr(csrPem string, networkId string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(csrPem))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("csr is not a PEM certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("csr signature invalid: %w", err)
+	}
+	if csr.Subject.CommonName != networkId {
+		return nil, fmt.Errorf("csr CN doesn't match networkId: %s", csr.Subject.CommonName)
+	}
+	return csr, nil
+}
+
+// Encodes a certificate chain as concatenated PEM, as sent in CertificateSigned
+func EncodeChain(chain []*x509.Certificate) string {
+	var sb strings.Builder
+	for _, cert := range chain {
+		sb.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+	return sb.String()
+}
+
+// Parses concatenated PEM certificates
+func ParseChain(chainPem string) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	rest := []byte(chainPem)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificates in chain")
+	}
+	return chain, nil
+}
+
+func validity(days int) time.Duration {
+	if days <= 0 {
+		days = DefaultValidityDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
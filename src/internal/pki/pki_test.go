@@ -0,0 +1,130 @@
+package pki
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Writes a self-signed CA's certificate and key, returning their files
+func writeCa(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "chargers-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func generateCsr(t *testing.T, commonName string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName, Organization: []string{"cpo"}}}, key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func TestParseCsr(t *testing.T) {
+	csr, err := ParseCsr(generateCsr(t, "charger-1"), "charger-1")
+	require.NoError(t, err)
+	assert.Equal(t, "charger-1", csr.Subject.CommonName)
+
+	_, err = ParseCsr(generateCsr(t, "charger-2"), "charger-1")
+	assert.EqualError(t, err, "csr CN doesn't match networkId: charger-2")
+
+	_, err = ParseCsr("not a csr", "charger-1")
+	assert.Error(t, err)
+}
+
+func TestLocalCaSignCsr(t *testing.T) {
+	certFile, keyFile := writeCa(t)
+	ca, err := NewLocalCa(certFile, keyFile, 30*24*time.Hour)
+	require.NoError(t, err)
+
+	csr, err := ParseCsr(generateCsr(t, "charger-1"), "charger-1")
+	require.NoError(t, err)
+	chain, err := ca.SignCsr(context.Background(), csr)
+	require.NoError(t, err)
+	require.Len(t, chain, 2)
+
+	leaf := chain[0]
+	assert.Equal(t, "charger-1", leaf.Subject.CommonName)
+	assert.Equal(t, []string{"cpo"}, leaf.Subject.Organization)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), leaf.NotAfter, time.Minute)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(chain[1])
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(t, err)
+
+	parsed, err := ParseChain(EncodeChain(chain))
+	require.NoError(t, err)
+	assert.Equal(t, chain[0].Raw, parsed[0].Raw)
+	assert.Equal(t, chain[1].Raw, parsed[1].Raw)
+}
+
+func TestHttpCaSignCsr(t *testing.T) {
+	certFile, keyFile := writeCa(t)
+	localCa, err := NewLocalCa(certFile, keyFile, time.Hour)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		request := &httpSignRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(request))
+		csr, err := ParseCsr(request.Csr, "charger-1")
+		require.NoError(t, err)
+		chain, err := localCa.SignCsr(r.Context(), csr)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(httpSignResponse{CertificateChain: EncodeChain(chain)})
+	}))
+	defer server.Close()
+
+	csr, err := ParseCsr(generateCsr(t, "charger-1"), "charger-1")
+	require.NoError(t, err)
+
+	ca, err := NewHttpCa(server.URL, "Bearer token", 0)
+	require.NoError(t, err)
+	chain, err := ca.SignCsr(context.Background(), csr)
+	require.NoError(t, err)
+	require.Len(t, chain, 2)
+	assert.Equal(t, "charger-1", chain[0].Subject.CommonName)
+
+	ca, err = NewHttpCa(server.URL, "", 0)
+	require.NoError(t, err)
+	_, err = ca.SignCsr(context.Background(), csr)
+	assert.ErrorContains(t, err, "ca responded 401")
+}
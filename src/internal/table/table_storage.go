@@ -27,6 +27,15 @@ func GetTableClient(tableName string, accountName string, accountKey string) (*a
 	return client, nil
 }
 
+// Gets a client from a storage connection string, e.g for Azurite: "UseDevelopmentStorage=true"
+func GetTableClientFromConnectionString(tableName string, connectionString string) (*aztables.Client, error) {
+	serviceClient, err := aztables.NewServiceClientFromConnectionString(connectionString, nil)
+	if err != nil {
+		return nil, err
+	}
+	return serviceClient.NewClient(tableName), nil
+}
+
 // Creates a new table as given in NewClient(). Storage Blob Data Contributor role needed
 func CreateTable(client *aztables.Client, tableName string) (*aztables.CreateTableResponse, error) {
 	//TODO: Check access policy, Storage Blob Data Contributor role needed
@@ -61,36 +70,49 @@ func listEntities(client *aztables.Client) {
 	}
 }*/
 
-// Queries an entity for a given partitionKey and rowKey, unmarshalling the JSON in to the given type
-// TODO finish - doesnt page properly yet
-func QueryEntity[K any](client *aztables.Client, partitionKey string, rowKey string) (map[string]any, error) {
-	filter := fmt.Sprintf("PartitionKey eq '%v' and RowKey eq '%v'", partitionKey, rowKey)
+// A page of entities, with the keys to continue from if there may be more
+type EntityPage[K any] struct {
+	Entities         []K
+	NextPartitionKey *string
+	NextRowKey       *string
+}
+
+// Queries one page of up to top entities matching the OData filter, continuing from the given keys if set.
+// selectColumns limits the properties returned, e.g "PartitionKey,RowKey", or all if empty.
+// Entities which can't be unmarshalled in to K are logged and skipped.
+func QueryEntities[K any](ctx context.Context, client *aztables.Client, filter string, selectColumns string, top int32, nextPartitionKey *string, nextRowKey *string) (*EntityPage[K], error) {
 	options := &aztables.ListEntitiesOptions{
-		Filter: &filter,
-		//Select: to.Ptr("RowKey,Price,Inventory,ProductName,OnSale"),
-		Top: to.Ptr(int32(15)),
+		Top:              to.Ptr(top),
+		NextPartitionKey: nextPartitionKey,
+		NextRowKey:       nextRowKey,
+	}
+	if filter != "" {
+		options.Filter = &filter
+	}
+	if selectColumns != "" {
+		options.Select = &selectColumns
 	}
 
-	results := make(map[string]any)
 	pager := client.NewListEntitiesPager(options)
-	for pager.More() {
-		resp, err := pager.NextPage(context.Background())
-		if err != nil {
-			return nil, err
-		}
-		for _, entity := range resp.Entities {
-			var myEntity K
-			err = json.Unmarshal(entity, &myEntity)
-			if err != nil {
-				panic(err)
-			}
-			fmt.Printf("Returned [%T]: %s", myEntity, entity)
-			key := fmt.Sprintf("%s_%s", partitionKey, rowKey)
-			results[key] = myEntity
-		}
+	resp, err := pager.NextPage(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return results, nil
+	page := &EntityPage[K]{
+		Entities:         make([]K, 0, len(resp.Entities)),
+		NextPartitionKey: resp.NextPartitionKey,
+		NextRowKey:       resp.NextRowKey,
+	}
+	for _, entity := range resp.Entities {
+		var myEntity K
+		if err = json.Unmarshal(entity, &myEntity); err != nil {
+			log.Logger.Warnf("Skipping entity, unable to unmarshall: %s", err.Error())
+			continue
+		}
+		page.Entities = append(page.Entities, myEntity)
+	}
+	return page, nil
 }
 
 // Deletes entity with a given partitionKey and rowKey
@@ -0,0 +1,96 @@
+package telemetry
+
+import (
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+	logrus_appinsights "github.com/steve-white/logrus-appinsights"
+)
+
+// AppInsightsHook is a logrus hook for Application Insights
+type AppInsightsHook struct {
+	Client appinsights.TelemetryClient
+
+	async        bool
+	levels       []logrus.Level
+	ignoreFields map[string]struct{}
+	filters      map[string]func(interface{}) interface{}
+}
+
+// New returns an initialised logrus hook for Application Insights
+func NewTelemetryClient(instrumentationKey string, roleName string) (*logrus_appinsights.AppInsightsHook, error) {
+	if len(instrumentationKey) == 0 {
+		return nil, nil
+	}
+
+	hook, err := logrus_appinsights.New("my_client", logrus_appinsights.Config{
+		InstrumentationKey: instrumentationKey,
+		MaxBatchSize:       10,              // optional
+		MaxBatchInterval:   time.Second * 5, // optional
+	})
+	if err != nil || hook == nil {
+		panic(err)
+	}
+
+	// set custom levels
+	hook.SetLevels([]log.Level{
+		log.PanicLevel,
+		log.ErrorLevel,
+		log.WarnLevel,
+		log.InfoLevel,
+		log.DebugLevel,
+	})
+	// ignore fields
+	//hook.AddIgnore("private")
+	return hook, nil
+}
+
+// Sends telemetry to Application Insights, sharing the logging hook's client
+type AppInsightsSink struct {
+	client appinsights.TelemetryClient
+}
+
+func NewAppInsightsSink(client appinsights.TelemetryClient) *AppInsightsSink {
+	return &AppInsightsSink{client: client}
+}
+
+func (s *AppInsightsSink) TrackOcppRequest(request OcppRequest) {
+	telemetry := appinsights.NewRequestTelemetry("OCPP", request.Action, request.Duration, request.ResponseCode())
+	telemetry.MarkTime(request.Start, request.Start.Add(request.Duration))
+	telemetry.Source = request.ClientAddress
+	telemetry.Success = request.Outcome == Outcome_Ok
+	telemetry.Properties["networkId"] = request.NetworkId
+	telemetry.Properties["ocppMsgId"] = request.MsgId
+	telemetry.Properties["outcome"] = request.Outcome
+	s.client.Track(telemetry)
+}
+
+func (s *AppInsightsSink) TrackConnectionRequest(request ConnectionRequest) {
+	telemetry := appinsights.NewRequestTelemetry("GET", request.Url, request.Duration, request.ResponseCode)
+	telemetry.MarkTime(request.Start, request.Start.Add(request.Duration))
+	telemetry.Source = request.ClientAddress
+	telemetry.Properties["networkId"] = request.NetworkId
+	s.client.Track(telemetry)
+}
+
+func (s *AppInsightsSink) TrackAuthenticationEvent(event AuthenticationEvent) {
+	telemetry := appinsights.NewEventTelemetry("AuthenticationEvent")
+	telemetry.Properties["networkId"] = event.NetworkId
+	telemetry.Properties["clientAddress"] = event.ClientAddress
+	telemetry.Properties["responseCode"] = event.ResponseCode
+	s.client.Track(telemetry)
+}
+
+func (s *AppInsightsSink) TrackPurgeDeleted(rule string, deleted int64) {
+	metric := appinsights.NewMetricTelemetry("PurgeDeleted", float64(deleted))
+	metric.Properties["rule"] = rule
+	s.client.Track(metric)
+}
+
+// Flushes without closing the channel, which the logging hook still uses
+func (s *AppInsightsSink) Close() error {
+	s.client.Channel().Flush()
+	return nil
+}
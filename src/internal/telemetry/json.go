@@ -0,0 +1,88 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Writes telemetry as JSON lines, e.g to stdout for a log collector
+type JsonSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+type jsonRecord struct {
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+	NetworkId     string    `json:"networkId,omitempty"`
+	ClientAddress string    `json:"clientAddress,omitempty"`
+	MsgId         string    `json:"msgId,omitempty"`
+	Action        string    `json:"action,omitempty"`
+	Outcome       string    `json:"outcome,omitempty"`
+	Url           string    `json:"url,omitempty"`
+	ResponseCode  string    `json:"responseCode,omitempty"`
+	DurationMs    *float64  `json:"durationMs,omitempty"`
+	Rule          string    `json:"rule,omitempty"`
+	Deleted       *int64    `json:"deleted,omitempty"`
+}
+
+func NewJsonSink(w io.Writer) *JsonSink {
+	return &JsonSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *JsonSink) TrackOcppRequest(request OcppRequest) {
+	s.write(jsonRecord{
+		Time:          request.Start.UTC(),
+		Event:         "ocppRequest",
+		NetworkId:     request.NetworkId,
+		ClientAddress: request.ClientAddress,
+		MsgId:         request.MsgId,
+		Action:        request.Action,
+		Outcome:       request.Outcome,
+		ResponseCode:  request.ResponseCode(),
+		DurationMs:    durationMs(request.Duration),
+	})
+}
+
+func (s *JsonSink) TrackConnectionRequest(request ConnectionRequest) {
+	s.write(jsonRecord{
+		Time:          request.Start.UTC(),
+		Event:         "connectionRequest",
+		NetworkId:     request.NetworkId,
+		ClientAddress: request.ClientAddress,
+		Url:           request.Url,
+		ResponseCode:  request.ResponseCode,
+		DurationMs:    durationMs(request.Duration),
+	})
+}
+
+func (s *JsonSink) TrackAuthenticationEvent(event AuthenticationEvent) {
+	s.write(jsonRecord{
+		Time:          time.Now().UTC(),
+		Event:         "authentication",
+		NetworkId:     event.NetworkId,
+		ClientAddress: event.ClientAddress,
+		ResponseCode:  event.ResponseCode,
+	})
+}
+
+func (s *JsonSink) TrackPurgeDeleted(rule string, deleted int64) {
+	s.write(jsonRecord{Time: time.Now().UTC(), Event: "purgeDeleted", Rule: rule, Deleted: &deleted})
+}
+
+func (s *JsonSink) Close() error {
+	return nil
+}
+
+func (s *JsonSink) write(record jsonRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encoder.Encode(record) // best effort, telemetry isn't worth failing a request for
+}
+
+func durationMs(duration time.Duration) *float64 {
+	ms := float64(duration.Microseconds()) / 1000
+	return &ms
+}
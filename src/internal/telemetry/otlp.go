@@ -0,0 +1,108 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+const (
+	meterName           = "sw/ocpp/csms"
+	defaultIntervalSecs = 60
+)
+
+// Exports telemetry as OpenTelemetry metrics
+type OtlpSink struct {
+	provider           *sdkmetric.MeterProvider
+	ocppRequests       metric.Float64Histogram
+	connectionRequests metric.Float64Histogram
+	authentications    metric.Int64Counter
+	purgeDeleted       metric.Int64Counter
+}
+
+// Creates a sink exporting periodically over OTLP/HTTP
+func NewOtlpSink(config conf.TelemetryOtlpConfig, serviceName string) (*OtlpSink, error) {
+	options := []otlpmetrichttp.Option{}
+	if config.Endpoint != "" {
+		options = append(options, otlpmetrichttp.WithEndpoint(config.Endpoint))
+	}
+	if config.Insecure {
+		options = append(options, otlpmetrichttp.WithInsecure())
+	}
+	exporter, err := otlpmetrichttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := config.IntervalSecs
+	if interval <= 0 {
+		interval = defaultIntervalSecs
+	}
+	reader := sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(time.Duration(interval)*time.Second))
+	return NewOtlpSinkWithReader(reader, serviceName)
+}
+
+// Creates a sink collected by the reader, e.g a sdkmetric.ManualReader in tests
+func NewOtlpSinkWithReader(reader sdkmetric.Reader, serviceName string) (*OtlpSink, error) {
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+	meter := provider.Meter(meterName)
+
+	s := &OtlpSink{provider: provider}
+	var err error
+	if s.ocppRequests, err = meter.Float64Histogram("csms.ocpp.request.duration", metric.WithUnit("s"),
+		metric.WithDescription("Time from receiving a charger's OCPP call to its response or MQ publish")); err != nil {
+		return nil, err
+	}
+	if s.connectionRequests, err = meter.Float64Histogram("csms.connection.request.duration", metric.WithUnit("s"),
+		metric.WithDescription("Time to accept or reject a charger's websocket connection request")); err != nil {
+		return nil, err
+	}
+	if s.authentications, err = meter.Int64Counter("csms.authentication.events",
+		metric.WithDescription("Charger authentications by response code")); err != nil {
+		return nil, err
+	}
+	if s.purgeDeleted, err = meter.Int64Counter("csms.purge.deleted",
+		metric.WithDescription("Messages or transactions deleted by retention rule")); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *OtlpSink) TrackOcppRequest(request OcppRequest) {
+	s.ocppRequests.Record(context.Background(), request.Duration.Seconds(), metric.WithAttributes(
+		attribute.String("ocpp.action", request.Action),
+		attribute.String("outcome", request.Outcome),
+	))
+}
+
+func (s *OtlpSink) TrackConnectionRequest(request ConnectionRequest) {
+	s.connectionRequests.Record(context.Background(), request.Duration.Seconds(), metric.WithAttributes(
+		attribute.String("response_code", request.ResponseCode),
+	))
+}
+
+func (s *OtlpSink) TrackAuthenticationEvent(event AuthenticationEvent) {
+	s.authentications.Add(context.Background(), 1, metric.WithAttributes(attribute.String("response_code", event.ResponseCode)))
+}
+
+func (s *OtlpSink) TrackPurgeDeleted(rule string, deleted int64) {
+	s.purgeDeleted.Add(context.Background(), deleted, metric.WithAttributes(attribute.String("rule", rule)))
+}
+
+// Exports any metrics not yet sent and stops the exporter
+func (s *OtlpSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.provider.Shutdown(ctx)
+}
@@ -0,0 +1,66 @@
+package telemetry
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "csms"
+
+// Exports telemetry as Prometheus metrics, served on /metrics by the admin listener
+type PrometheusSink struct {
+	ocppRequests       *prometheus.HistogramVec
+	connectionRequests *prometheus.HistogramVec
+	authentications    *prometheus.CounterVec
+}
+
+func NewPrometheusSink(registerer prometheus.Registerer) (*PrometheusSink, error) {
+	s := &PrometheusSink{
+		ocppRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "ocpp_request_duration_seconds",
+			Help:      "Time from receiving a charger's OCPP call to its response or MQ publish",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"action", "outcome"}),
+		connectionRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "connection_request_duration_seconds",
+			Help:      "Time to accept or reject a charger's websocket connection request",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"response_code"}),
+		authentications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "authentication_events_total",
+			Help:      "Charger authentications by response code",
+		}, []string{"response_code"}),
+	}
+	err := errors.Join(
+		registerer.Register(s.ocppRequests),
+		registerer.Register(s.connectionRequests),
+		registerer.Register(s.authentications),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *PrometheusSink) TrackOcppRequest(request OcppRequest) {
+	s.ocppRequests.WithLabelValues(request.Action, request.Outcome).Observe(request.Duration.Seconds())
+}
+
+func (s *PrometheusSink) TrackConnectionRequest(request ConnectionRequest) {
+	s.connectionRequests.WithLabelValues(request.ResponseCode).Observe(request.Duration.Seconds())
+}
+
+func (s *PrometheusSink) TrackAuthenticationEvent(event AuthenticationEvent) {
+	s.authentications.WithLabelValues(event.ResponseCode).Inc()
+}
+
+// Already exported by message-manager as csms_purge_deleted_total
+func (s *PrometheusSink) TrackPurgeDeleted(rule string, deleted int64) {}
+
+func (s *PrometheusSink) Close() error {
+	return nil
+}
@@ -1,139 +1,169 @@
+// Provides telemetry of OCPP requests, charger connections and authentication, sent to one or more sinks
 package telemetry
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"time"
 
-	"github.com/microsoft/ApplicationInsights-Go/appinsights"
-	"github.com/sirupsen/logrus"
-	log "github.com/sirupsen/logrus"
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/metrics"
+
 	logrus_appinsights "github.com/steve-white/logrus-appinsights"
 )
 
-// AppInsightsHook is a logrus hook for Application Insights
-type AppInsightsHook struct {
-	Client appinsights.TelemetryClient
+const (
+	Sink_AppInsights = "appinsights"
+	Sink_Otlp        = "otlp"
+	Sink_Prometheus  = "prometheus"
+	Sink_Stdout      = "stdout"
+)
 
-	async        bool
-	levels       []logrus.Level
-	ignoreFields map[string]struct{}
-	filters      map[string]func(interface{}) interface{}
-}
+// Outcome of an OCPP request from a charger
+const (
+	Outcome_Ok         = "ok"          // acknowledged, or published to the MQ for a service to respond
+	Outcome_CallError  = "call_error"  // answered with a CALLERROR, e.g schema invalid or unknown action
+	Outcome_DeadLetter = "dead_letter" // malformed or unroutable, sent to the dead letter channel
+	Outcome_MqError    = "mq_error"    // not published to the MQ, the connection is closed
+	Outcome_WriteError = "write_error" // the response couldn't be written to the charger
+)
 
-var client appinsights.TelemetryClient
+// An OCPP call from a charger, timed from frame receipt to its response or MQ publish
+type OcppRequest struct {
+	NetworkId     string
+	ClientAddress string
+	MsgId         string
+	Action        string
+	Outcome       string
+	Start         time.Time
+	Duration      time.Duration
+}
 
-// New returns an initialised logrus hook for Application Insights
-func NewTelemetryClient(instrumentationKey string, roleName string) (*logrus_appinsights.AppInsightsHook, error) {
-	if len(instrumentationKey) == 0 {
-		return nil, nil
+// HTTP style response code of the request outcome
+func (r OcppRequest) ResponseCode() string {
+	switch r.Outcome {
+	case Outcome_Ok:
+		return "200"
+	case Outcome_CallError, Outcome_DeadLetter:
+		return "400"
+	case Outcome_MqError:
+		return "503"
+	default:
+		return "500"
 	}
+}
 
-	hook, err := logrus_appinsights.New("my_client", logrus_appinsights.Config{
-		InstrumentationKey: instrumentationKey,
-		MaxBatchSize:       10,              // optional
-		MaxBatchInterval:   time.Second * 5, // optional
-	})
-	if err != nil || hook == nil {
-		panic(err)
-	}
+// A charger's websocket connection request, timed until the upgrade or rejection
+type ConnectionRequest struct {
+	Url           string
+	NetworkId     string
+	ClientAddress string
+	ResponseCode  string
+	Start         time.Time
+	Duration      time.Duration
+}
 
-	// set custom levels
-	hook.SetLevels([]log.Level{
-		log.PanicLevel,
-		log.ErrorLevel,
-		log.WarnLevel,
-		log.InfoLevel,
-		log.DebugLevel,
-	})
-	// ignore fields
-	//hook.AddIgnore("private")
-	client = hook.Client
-	return hook, nil
+type AuthenticationEvent struct {
+	NetworkId     string
+	ClientAddress string
+	ResponseCode  string
 }
 
-func TrackConnectionRequest(url string, timeMs int) {
-	if client == nil {
-		return
-	}
-	var duration time.Duration = time.Duration(timeMs) * time.Millisecond
-	client.TrackRequest("GET", url, duration, "302")
+type TelemetrySink interface {
+	TrackOcppRequest(request OcppRequest)
+	TrackConnectionRequest(request ConnectionRequest)
+	TrackAuthenticationEvent(event AuthenticationEvent)
+	// The number of messages or transactions deleted by a purge, per retention rule
+	TrackPurgeDeleted(rule string, deleted int64)
+	// Flushes buffered telemetry
+	Close() error
 }
 
-func TrackAuthenticationEvent(networkId string, clientAddress string, responseCode string) {
-	if client == nil {
-		return
-	}
+// Fans out telemetry to each sink
+type MultiSink []TelemetrySink
 
-	event := appinsights.NewEventTelemetry("AuthenticationEvent")
-	event.Properties["networkId"] = networkId
-	event.Properties["clientAddress"] = clientAddress
-	event.Properties["responseCode"] = responseCode
-	client.Track(event)
+func (m MultiSink) TrackOcppRequest(request OcppRequest) {
+	for _, sink := range m {
+		sink.TrackOcppRequest(request)
+	}
 }
 
-func TrackOcppRequest(networkId string, clientAddress string, ocppMsgId string, msgType string, responseCode string, duration time.Duration) {
-	if client == nil {
-		return
+func (m MultiSink) TrackConnectionRequest(request ConnectionRequest) {
+	for _, sink := range m {
+		sink.TrackConnectionRequest(request)
 	}
-
-	//	durationMs := requestEndTime.Sub(requestStartTime)
-	request := appinsights.NewRequestTelemetry("GET", msgType, duration, responseCode)
-
-	// Note that the timestamp will be set to time.Now() minus the
-	// specified duration.  This can be overridden by either manually
-	// setting the Timestamp and Duration fields, or with MarkTime:
-	//request.MarkTime(requestStartTime, requestEndTime)
-
-	request.Source = clientAddress
-	//request.Success = false
-
-	request.Properties["ocppMsgId"] = ocppMsgId
-	//request.Properties["user-agent"] = request.headers["User-agent"]
-	//request.Measurements["POST size"] = float64(len(data))
-
-	// Context tags become more useful here as well
-	//request.Tags.Session().SetId("<session id>")
-	//request.Tags.User().SetAccountId("<user id>")
-
-	// Finally track it
-	client.Track(request)
 }
 
-func TrackTraceVerbose(message string) {
-	if client == nil {
-		return
+func (m MultiSink) TrackAuthenticationEvent(event AuthenticationEvent) {
+	for _, sink := range m {
+		sink.TrackAuthenticationEvent(event)
 	}
-	trace := appinsights.NewTraceTelemetry(message, appinsights.Verbose)
-	client.Track(trace)
 }
 
-func TrackTraceInformation(message string) {
-	if client == nil {
-		return
+func (m MultiSink) TrackPurgeDeleted(rule string, deleted int64) {
+	for _, sink := range m {
+		sink.TrackPurgeDeleted(rule, deleted)
 	}
-	trace := appinsights.NewTraceTelemetry(message, appinsights.Information)
-	client.Track(trace)
 }
 
-func TrackTraceWarning(message string) {
-	if client == nil {
-		return
+func (m MultiSink) Close() error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.Close())
 	}
-	trace := appinsights.NewTraceTelemetry(message, appinsights.Warning)
-	client.Track(trace)
+	return errors.Join(errs...)
 }
 
-func TrackTraceError(message string) {
-	if client == nil {
-		return
+// Discards telemetry, used when no sinks are configured
+type NopSink struct{}
+
+func (NopSink) TrackOcppRequest(OcppRequest)                 {}
+func (NopSink) TrackConnectionRequest(ConnectionRequest)     {}
+func (NopSink) TrackAuthenticationEvent(AuthenticationEvent) {}
+func (NopSink) TrackPurgeDeleted(string, int64)              {}
+func (NopSink) Close() error                                 { return nil }
+
+// Creates the configured sinks. appInsightsHook is the logging hook, nil if App Insights isn't configured
+func NewSink(config conf.TelemetryConfig, appInsightsHook *logrus_appinsights.AppInsightsHook, serviceName string) (TelemetrySink, error) {
+	sinkNames := config.Sinks
+	if len(sinkNames) == 0 && appInsightsHook != nil {
+		sinkNames = []string{Sink_AppInsights}
+	}
+
+	sinks := MultiSink{}
+	for _, name := range sinkNames {
+		switch name {
+		case Sink_AppInsights:
+			if appInsightsHook == nil {
+				return nil, errors.New("appinsights telemetry sink needs logging.appinsights_instrumentation_key")
+			}
+			sinks = append(sinks, NewAppInsightsSink(appInsightsHook.Client))
+		case Sink_Otlp:
+			sink, err := NewOtlpSink(config.Otlp, serviceName)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case Sink_Prometheus:
+			sink, err := NewPrometheusSink(metrics.Registry)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case Sink_Stdout:
+			sinks = append(sinks, NewJsonSink(os.Stdout))
+		default:
+			return nil, fmt.Errorf("unknown telemetry sink: %s", name)
+		}
 	}
-	trace := appinsights.NewTraceTelemetry(message, appinsights.Error)
-	client.Track(trace)
-}
 
-func TrackTraceCritical(message string) {
-	if client == nil {
-		return
+	switch len(sinks) {
+	case 0:
+		return NopSink{}, nil
+	case 1:
+		return sinks[0], nil
+	default:
+		return sinks, nil
 	}
-	trace := appinsights.NewTraceTelemetry(message, appinsights.Critical)
-	client.Track(trace)
 }
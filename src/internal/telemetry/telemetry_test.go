@@ -0,0 +1,137 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+var request = OcppRequest{
+	NetworkId:     "charger-1",
+	ClientAddress: "10.0.0.1:5000",
+	MsgId:         "m1",
+	Action:        "Heartbeat",
+	Outcome:       Outcome_Ok,
+	Start:         time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC),
+	Duration:      1500 * time.Microsecond,
+}
+
+func TestNewSink(t *testing.T) {
+	sink, err := NewSink(conf.TelemetryConfig{}, nil, "test")
+	require.NoError(t, err)
+	assert.Equal(t, NopSink{}, sink)
+
+	sink, err = NewSink(conf.TelemetryConfig{Sinks: []string{Sink_Stdout}}, nil, "test")
+	require.NoError(t, err)
+	assert.IsType(t, &JsonSink{}, sink)
+
+	_, err = NewSink(conf.TelemetryConfig{Sinks: []string{Sink_AppInsights}}, nil, "test")
+	assert.Error(t, err)
+
+	_, err = NewSink(conf.TelemetryConfig{Sinks: []string{"statsd"}}, nil, "test")
+	assert.ErrorContains(t, err, "unknown telemetry sink: statsd")
+}
+
+func TestMultiSink(t *testing.T) {
+	var first, second bytes.Buffer
+	sink := MultiSink{NewJsonSink(&first), NewJsonSink(&second)}
+
+	sink.TrackOcppRequest(request)
+	require.NoError(t, sink.Close())
+
+	assert.NotEmpty(t, first.String())
+	assert.Equal(t, first.String(), second.String())
+}
+
+func TestJsonSink(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewJsonSink(&out)
+
+	sink.TrackOcppRequest(request)
+	sink.TrackPurgeDeleted("heartbeat", 3)
+
+	decoder := json.NewDecoder(&out)
+	var record map[string]any
+	require.NoError(t, decoder.Decode(&record))
+	assert.Equal(t, map[string]any{
+		"time":          "2024-10-01T12:00:00Z",
+		"event":         "ocppRequest",
+		"networkId":     "charger-1",
+		"clientAddress": "10.0.0.1:5000",
+		"msgId":         "m1",
+		"action":        "Heartbeat",
+		"outcome":       "ok",
+		"responseCode":  "200",
+		"durationMs":    1.5,
+	}, record)
+
+	record = map[string]any{}
+	require.NoError(t, decoder.Decode(&record))
+	assert.Equal(t, "purgeDeleted", record["event"])
+	assert.Equal(t, "heartbeat", record["rule"])
+	assert.Equal(t, float64(3), record["deleted"])
+}
+
+func TestPrometheusSink(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	sink, err := NewPrometheusSink(registry)
+	require.NoError(t, err)
+
+	sink.TrackOcppRequest(request)
+	sink.TrackOcppRequest(OcppRequest{Action: "Heartbeat", Outcome: Outcome_MqError})
+	sink.TrackAuthenticationEvent(AuthenticationEvent{NetworkId: "charger-1", ResponseCode: "401"})
+
+	assert.Equal(t, 2, testutil.CollectAndCount(sink.ocppRequests))
+	assert.Equal(t, float64(1), testutil.ToFloat64(sink.authentications.WithLabelValues("401")))
+
+	_, err = NewPrometheusSink(registry)
+	assert.Error(t, err, "metrics are registered once")
+}
+
+func TestOtlpSink(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	sink, err := NewOtlpSinkWithReader(reader, "test")
+	require.NoError(t, err)
+
+	sink.TrackOcppRequest(request)
+	sink.TrackOcppRequest(request)
+	sink.TrackPurgeDeleted("heartbeat", 3)
+
+	var collected metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &collected))
+	require.Len(t, collected.ScopeMetrics, 1)
+	metrics := map[string]metricdata.Aggregation{}
+	for _, metric := range collected.ScopeMetrics[0].Metrics {
+		metrics[metric.Name] = metric.Data
+	}
+
+	histogram := metrics["csms.ocpp.request.duration"].(metricdata.Histogram[float64])
+	require.Len(t, histogram.DataPoints, 1)
+	assert.Equal(t, uint64(2), histogram.DataPoints[0].Count)
+	action, _ := histogram.DataPoints[0].Attributes.Value("ocpp.action")
+	assert.Equal(t, "Heartbeat", action.AsString())
+
+	deleted := metrics["csms.purge.deleted"].(metricdata.Sum[int64])
+	require.Len(t, deleted.DataPoints, 1)
+	assert.Equal(t, int64(3), deleted.DataPoints[0].Value)
+
+	assert.NoError(t, sink.Close())
+}
+
+func TestResponseCode(t *testing.T) {
+	assert.Equal(t, "200", OcppRequest{Outcome: Outcome_Ok}.ResponseCode())
+	assert.Equal(t, "400", OcppRequest{Outcome: Outcome_CallError}.ResponseCode())
+	assert.Equal(t, "503", OcppRequest{Outcome: Outcome_MqError}.ResponseCode())
+	assert.Equal(t, "500", OcppRequest{Outcome: Outcome_WriteError}.ResponseCode())
+}
@@ -0,0 +1,111 @@
+// Provides OpenTelemetry tracing, with the trace context carried between services in MQ message envelopes
+package tracing
+
+import (
+	"context"
+
+	conf "sw/ocpp/csms/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "sw/ocpp/csms"
+
+// Span attributes
+const (
+	Attr_NetworkId = attribute.Key("ocpp.network_id")
+	Attr_MsgId     = attribute.Key("ocpp.msg_id")
+	Attr_Action    = attribute.Key("ocpp.action")
+	Attr_Direction = attribute.Key("ocpp.direction")
+	Attr_Channel   = attribute.Key("messaging.destination.name")
+)
+
+var propagator = propagation.TraceContext{}
+
+// Sets the global tracer provider to export over OTLP/HTTP, returning a function which flushes and stops it.
+// If tracing isn't enabled spans aren't recorded, but trace context received from other services is still passed on.
+func Setup(config conf.TracingConfig, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+	if !config.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracehttp.Option{}
+	if config.Endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+	}
+	if config.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	sampleRatio := config.SampleRatio
+	if sampleRatio <= 0 {
+		sampleRatio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(newResource(serviceName)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Sets the global tracer provider to export synchronously to the exporter, e.g a tracetest.InMemoryExporter in tests
+func SetupWithExporter(exporter sdktrace.SpanExporter, serviceName string) *sdktrace.TracerProvider {
+	otel.SetTextMapPropagator(propagator)
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter), sdktrace.WithResource(newResource(serviceName)))
+	otel.SetTracerProvider(provider)
+	return provider
+}
+
+func newResource(serviceName string) *resource.Resource {
+	return resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Gets the trace context of ctx to carry in an MQ message, nil if ctx has no span
+func Inject(ctx context.Context) map[string]string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier
+}
+
+// Returns ctx with the span of an MQ message's trace context as the parent, or ctx if there's none
+func Extract(ctx context.Context, traceContext map[string]string) context.Context {
+	if len(traceContext) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(traceContext))
+}
+
+// Starts a span for a received MQ message, continuing the trace of the service which sent it
+func StartConsumerSpan(traceContext map[string]string, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(Extract(context.Background(), traceContext), name,
+		trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attributes...))
+}
+
+// Marks the span of ctx as failed
+func SetError(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
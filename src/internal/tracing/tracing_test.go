@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTestTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := SetupWithExporter(exporter, "test")
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return exporter
+}
+
+func TestInjectExtract(t *testing.T) {
+	setupTestTracing(t)
+
+	assert.Nil(t, Inject(context.Background()))
+	assert.Equal(t, context.Background(), Extract(context.Background(), nil))
+
+	ctx, span := Tracer().Start(context.Background(), "send")
+	traceContext := Inject(ctx)
+	span.End()
+	require.Contains(t, traceContext, "traceparent")
+
+	remote := trace.SpanContextFromContext(Extract(context.Background(), traceContext))
+	assert.True(t, remote.IsRemote())
+	assert.Equal(t, span.SpanContext().TraceID(), remote.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), remote.SpanID())
+}
+
+func TestStartConsumerSpan(t *testing.T) {
+	exporter := setupTestTracing(t)
+
+	ctx, producer := Tracer().Start(context.Background(), "send")
+	traceContext := Inject(ctx)
+	producer.End()
+
+	_, consumer := StartConsumerSpan(traceContext, "receive", Attr_NetworkId.String("charger-1"))
+	consumer.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "receive", spans[1].Name)
+	assert.Equal(t, trace.SpanKindConsumer, spans[1].SpanKind)
+	assert.Equal(t, spans[0].SpanContext.TraceID(), spans[1].SpanContext.TraceID())
+	assert.Equal(t, spans[0].SpanContext.SpanID(), spans[1].Parent.SpanID())
+}
+
+func TestHttpMiddleware(t *testing.T) {
+	exporter := setupTestTracing(t)
+
+	var handlerTraceContext map[string]string
+	router := chi.NewRouter()
+	router.Use(HttpMiddleware)
+	router.Post("/actions/reset/{networkid}", func(w http.ResponseWriter, r *http.Request) {
+		handlerTraceContext = Inject(r.Context())
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/actions/reset/charger-1", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "POST /actions/reset/{networkid}", spans[0].Name)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[0].SpanContext.TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", spans[0].Parent.SpanID().String())
+	assert.Contains(t, handlerTraceContext["traceparent"], spans[0].SpanContext.SpanID().String())
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"sw/ocpp/csms/internal/admin"
 	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
 	helpers "sw/ocpp/csms/internal/helpers"
 	"sw/ocpp/csms/internal/logging"
+	"sw/ocpp/csms/internal/metrics"
 	svc "sw/ocpp/csms/internal/models/service"
 	mq "sw/ocpp/csms/internal/mq"
+	msgstore "sw/ocpp/csms/internal/msgstore"
 	service "sw/ocpp/csms/internal/service"
-	table "sw/ocpp/csms/internal/table"
 	telemetry "sw/ocpp/csms/internal/telemetry"
+	"sw/ocpp/csms/internal/tracing"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
 	"github.com/puzpuzpuz/xsync/v3"
 )
 
@@ -26,6 +31,8 @@ var (
 
 type T = struct{}
 
+const statsLogInterval = 60 * time.Second
+
 func initialise() *ServiceState {
 	config := conf.ReadConfig()
 	serviceContext := getServiceContext()
@@ -34,6 +41,10 @@ func initialise() *ServiceState {
 	if err != nil {
 		return &ServiceState{LastError: err}
 	}
+	telemetrySink, err := telemetry.NewSink(config.Telemetry, telemetryHook, "message-manager")
+	if err != nil {
+		return &ServiceState{LastError: err}
+	}
 
 	mqConnection := mq.SetupMqConnection(config.Mq, "", config.Mq.MangosMq.CsmsListenUrl, "", "")
 
@@ -47,17 +58,60 @@ func initialise() *ServiceState {
 		return &ServiceState{LastError: err}
 	}
 
-	var tableClient *aztables.Client
+	var messageStore msgstore.MessageStore
+	var messageWriter *msgstore.BatchWriter
 	if !config.Services.MessageManager.StoreMessages {
 		log.Warn("Not storing messages")
 	} else {
 		mq.SetupMqReceiver(mqConnection, config.Mq.Type, serviceContext.HostName, mq.MqChannelName_MessagesIn)
 
-		tableClient, err = table.GetTableClient("Messages", config.Services.MessageManager.StorageAccountName, config.Services.MessageManager.StorageAccountKey)
+		storeConfig := getMessageStoreConfig(config)
+		log.Infof("Message store: %s", storeConfig.Type)
+		messageStore, err = msgstore.Open(storeConfig)
+		if err != nil {
+			return &ServiceState{LastError: err}
+		}
+		messageWriter = msgstore.NewBatchWriter(messageStore, msgstore.BatchWriterConfig{
+			BatchSize:      storeConfig.BatchSize,
+			FlushInterval:  time.Duration(storeConfig.FlushIntervalMs) * time.Millisecond,
+			QueueSize:      storeConfig.QueueSize,
+			EnqueueTimeout: time.Duration(storeConfig.EnqueueTimeoutMs) * time.Millisecond,
+			WriteAttempts:  storeConfig.WriteAttempts,
+			RetryDelay:     time.Duration(storeConfig.RetryDelayMs) * time.Millisecond,
+		})
+	}
+
+	// The db_config DB is only needed to store dead letters and purge transactions
+	retention := config.Services.MessageManager.Retention
+	var store *db.Store
+	if config.Services.MessageManager.StoreDeadLetters || (retention.Enabled && retention.TransactionsDays > 0) {
+		store, err = db.Open(config.DbConfig)
+		if err != nil {
+			return &ServiceState{LastError: err}
+		}
+		if err = store.MigrateUp(context.Background()); err != nil {
+			store.Close()
+			return &ServiceState{LastError: err}
+		}
+	}
+
+	var deadLetters db.DeadLetterRepository
+	if config.Services.MessageManager.StoreDeadLetters {
+		err = mqConnection.MqQueueDeclare(mq.MqChannelName_DeadLetter)
 		if err != nil {
 			return &ServiceState{LastError: err}
 		}
-		table.CreateTable(tableClient, "Messages")
+		mq.SetupMqReceiver(mqConnection, config.Mq.Type, serviceContext.HostName, mq.MqChannelName_DeadLetter)
+		deadLetters = store.DeadLetters
+	}
+
+	var purger *Purger
+	if retention.Enabled {
+		var transactions db.TransactionRepository
+		if retention.TransactionsDays > 0 {
+			transactions = store.Transactions
+		}
+		purger = NewPurger(retention, messageStore, transactions, telemetrySink)
 	}
 
 	return &ServiceState{
@@ -66,8 +120,30 @@ func initialise() *ServiceState {
 		Connections:     xsync.NewMap(),
 		Context:         serviceContext,
 		AppInsightsHook: telemetryHook,
-		TableClient:     tableClient,
+		Telemetry:       telemetrySink,
+		MessageStore:    messageStore,
+		MessageWriter:   messageWriter,
+		Db:              store,
+		DeadLetters:     deadLetters,
+		Purger:          purger,
+	}
+}
+
+// Gets the message store config, defaulting to Azure table storage with the message_manager storage account,
+// and for sql, the db_config DB
+func getMessageStoreConfig(config *conf.Configuration) conf.MessageStoreConfig {
+	storeConfig := config.Services.MessageManager.MessageStore
+	if storeConfig.Type == "" {
+		storeConfig.Type = msgstore.StoreType_AzureTable
+	}
+	if storeConfig.AzureTable.StorageAccountName == "" {
+		storeConfig.AzureTable.StorageAccountName = config.Services.MessageManager.StorageAccountName
+		storeConfig.AzureTable.StorageAccountKey = config.Services.MessageManager.StorageAccountKey
+	}
+	if storeConfig.Sql.DbType == "" {
+		storeConfig.Sql = config.DbConfig
 	}
+	return storeConfig
 }
 
 func getServiceContext() svc.ServiceContext {
@@ -88,7 +164,7 @@ func main() {
 		<-exitNotification // send notification to unblock and exit
 	}()
 
-	log = logging.LoggingSetup(true, "messageManager") // start with debug enabled until overridden in config later
+	log = logging.LoggingSetup(true, "messageManager", logging.Format_Text) // start with debug enabled until overridden in config later
 
 	log.Infof("--- OCPP Message Manager - v%s ---", service.Version)
 
@@ -98,12 +174,61 @@ func main() {
 		os.Exit(1)
 	}
 	config := serviceState.Config
-	log = logging.LoggingSetup(config.Services.MessageManager.Debug, "messageManager")
+	log = logging.LoggingSetup(config.Services.MessageManager.Debug, "messageManager", serviceState.Config.Logging.Format)
 	if len(config.Logging.AppInsightsInstrumentationKey) > 0 {
 		log.AddHook(serviceState.AppInsightsHook)
 	}
 
+	shutdownTracing, err := tracing.Setup(config.Tracing, "message-manager")
+	if err != nil {
+		log.Errorf("Error in tracing setup: %s", err.Error())
+		os.Exit(1)
+	}
+	serviceState.ShutdownTracing = shutdownTracing
+
 	go serviceState.MqBus.RunMqTopicReceiver(ProcessRecvMessage, mq.MqChannelName_MessagesIn, serviceState)
+	if serviceState.DeadLetters != nil {
+		go serviceState.MqBus.RunMqTopicReceiver(ProcessRecvDeadLetter, mq.MqChannelName_DeadLetter, serviceState)
+	}
+
+	httpConfig := config.Services.MessageManager.HttpConfig
+	if serviceState.MessageStore != nil && httpConfig.ListenPort > 0 {
+		if err := setupRestApi(serviceState, httpConfig); err != nil {
+			log.Errorf("Error starting REST API: %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if serviceState.MessageWriter != nil {
+		go logWriterStats(serviceState.MessageWriter)
+	}
+
+	metrics.Registry.MustRegister(&statsCollector{writer: serviceState.MessageWriter, purger: serviceState.Purger})
+	if config.Services.MessageManager.Admin.ListenPort > 0 {
+		server := admin.NewServer(config.Services.MessageManager.Admin)
+		if serviceState.Listener != nil {
+			server.AddLivenessCheck("listener", serviceState.Listener.Check)
+		}
+		server.AddReadinessCheck("mq", serviceState.MqBus.MqHealthCheck)
+		if serviceState.Db != nil {
+			server.AddReadinessCheck("db", serviceState.Db.Ping)
+		}
+		if serviceState.MessageStore != nil {
+			server.AddReadinessCheck("messagestore", serviceState.MessageStore.Ping)
+		}
+		adminCloser, err := server.Start()
+		if err != nil {
+			log.Errorf("Error starting admin listener: %s", err.Error())
+			os.Exit(1)
+		}
+		serviceState.AdminCloser = &adminCloser
+	}
+
+	if serviceState.Purger != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		serviceState.StopPurger = cancel
+		go serviceState.Purger.Run(ctx)
+	}
 
 	log.Debug("block...")
 	exitNotification <- struct{}{} // block until exit notification received
@@ -113,7 +238,41 @@ func main() {
 	os.Exit(0)
 }
 
+// Logs message writer stats when they change, warning when messages have been dropped or lost
+func logWriterStats(writer *msgstore.BatchWriter) {
+	var last msgstore.BatchWriterStats
+	for range time.Tick(statsLogInterval) {
+		stats := writer.Stats()
+		if stats == last {
+			continue
+		}
+		logf := log.Infof
+		if stats.Dropped > last.Dropped || stats.WriteErrors > last.WriteErrors {
+			logf = log.Warnf
+		}
+		logf("Message writer: enqueued=%d written=%d batches=%d dropped=%d writeErrors=%d writeRetries=%d queueFullWaits=%d queue=%d/%d",
+			stats.Enqueued, stats.Written, stats.Batches, stats.Dropped, stats.WriteErrors, stats.WriteRetries, stats.QueueFullWaits,
+			stats.QueueLength, stats.QueueCapacity)
+		last = stats
+	}
+}
+
 func dispose() {
+	if serviceState.StopPurger != nil {
+		log.Debug("Stop purger")
+		serviceState.StopPurger()
+	}
+
+	if serviceState.IoCloser != nil {
+		log.Debug("Close REST listener")
+		(*serviceState.IoCloser).Close()
+	}
+
+	if serviceState.AdminCloser != nil {
+		log.Debug("Close admin listener")
+		(*serviceState.AdminCloser).Close()
+	}
+
 	if serviceState.Cache != nil {
 		log.Debug("Close cache")
 		serviceState.Cache.Close()
@@ -123,6 +282,31 @@ func dispose() {
 		log.Debug("Close MqChannel")
 		serviceState.MqBus.Close()
 	}
+
+	if serviceState.MessageWriter != nil {
+		log.Debug("Flush message writer")
+		serviceState.MessageWriter.Close()
+	}
+
+	if serviceState.Telemetry != nil {
+		log.Debug("Flush telemetry")
+		serviceState.Telemetry.Close()
+	}
+
+	if serviceState.MessageStore != nil {
+		log.Debug("Close message store")
+		serviceState.MessageStore.Close()
+	}
+
+	if serviceState.Db != nil {
+		log.Debug("Close DB")
+		serviceState.Db.Close()
+	}
+
+	if serviceState.ShutdownTracing != nil {
+		log.Debug("Flush traces")
+		serviceState.ShutdownTracing(context.Background())
+	}
 }
 
 func multiSignalHandler(signal os.Signal) {
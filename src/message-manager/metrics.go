@@ -0,0 +1,64 @@
+package main
+
+import (
+	msgstore "sw/ocpp/csms/internal/msgstore"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	writerEnqueuedDesc       = prometheus.NewDesc("csms_message_writer_enqueued_total", "Messages queued to be written to the message store", nil, nil)
+	writerWrittenDesc        = prometheus.NewDesc("csms_message_writer_written_total", "Messages written to the message store", nil, nil)
+	writerBatchesDesc        = prometheus.NewDesc("csms_message_writer_batches_total", "Batches written to the message store", nil, nil)
+	writerDroppedDesc        = prometheus.NewDesc("csms_message_writer_dropped_total", "Messages dropped as the queue stayed full", nil, nil)
+	writerWriteErrorsDesc    = prometheus.NewDesc("csms_message_writer_write_errors_total", "Messages lost due to message store errors", nil, nil)
+	writerWriteRetriesDesc   = prometheus.NewDesc("csms_message_writer_write_retries_total", "Failed message store writes retried", nil, nil)
+	writerQueueFullWaitsDesc = prometheus.NewDesc("csms_message_writer_queue_full_waits_total", "Times the MQ receiver blocked on a full queue", nil, nil)
+	writerQueueLengthDesc    = prometheus.NewDesc("csms_message_writer_queue_length", "Messages queued and not yet written", nil, nil)
+
+	purgeRunsDesc         = prometheus.NewDesc("csms_purge_runs_total", "Retention purge runs", nil, nil)
+	purgeFailuresDesc     = prometheus.NewDesc("csms_purge_failures_total", "Retention purge runs which failed", nil, nil)
+	purgeDeletedDesc      = prometheus.NewDesc("csms_purge_deleted_total", "Messages or transactions deleted by retention rule", []string{"rule"}, nil)
+	purgeLastRunDesc      = prometheus.NewDesc("csms_purge_last_run_timestamp_seconds", "Start time of the last purge", nil, nil)
+	purgeLastDurationDesc = prometheus.NewDesc("csms_purge_last_duration_seconds", "Duration of the last purge", nil, nil)
+)
+
+// Exports the message writer and purger stats when scraped. Either may be nil
+type statsCollector struct {
+	writer *msgstore.BatchWriter
+	purger *Purger
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{writerEnqueuedDesc, writerWrittenDesc, writerBatchesDesc, writerDroppedDesc,
+		writerWriteErrorsDesc, writerWriteRetriesDesc, writerQueueFullWaitsDesc, writerQueueLengthDesc,
+		purgeRunsDesc, purgeFailuresDesc, purgeDeletedDesc, purgeLastRunDesc, purgeLastDurationDesc} {
+		ch <- desc
+	}
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.writer != nil {
+		stats := c.writer.Stats()
+		ch <- prometheus.MustNewConstMetric(writerEnqueuedDesc, prometheus.CounterValue, float64(stats.Enqueued))
+		ch <- prometheus.MustNewConstMetric(writerWrittenDesc, prometheus.CounterValue, float64(stats.Written))
+		ch <- prometheus.MustNewConstMetric(writerBatchesDesc, prometheus.CounterValue, float64(stats.Batches))
+		ch <- prometheus.MustNewConstMetric(writerDroppedDesc, prometheus.CounterValue, float64(stats.Dropped))
+		ch <- prometheus.MustNewConstMetric(writerWriteErrorsDesc, prometheus.CounterValue, float64(stats.WriteErrors))
+		ch <- prometheus.MustNewConstMetric(writerWriteRetriesDesc, prometheus.CounterValue, float64(stats.WriteRetries))
+		ch <- prometheus.MustNewConstMetric(writerQueueFullWaitsDesc, prometheus.CounterValue, float64(stats.QueueFullWaits))
+		ch <- prometheus.MustNewConstMetric(writerQueueLengthDesc, prometheus.GaugeValue, float64(stats.QueueLength))
+	}
+	if c.purger != nil {
+		stats := c.purger.Stats()
+		ch <- prometheus.MustNewConstMetric(purgeRunsDesc, prometheus.CounterValue, float64(stats.Runs))
+		ch <- prometheus.MustNewConstMetric(purgeFailuresDesc, prometheus.CounterValue, float64(stats.Failures))
+		for rule, deleted := range stats.Deleted {
+			ch <- prometheus.MustNewConstMetric(purgeDeletedDesc, prometheus.CounterValue, float64(deleted), rule)
+		}
+		if !stats.LastRun.IsZero() {
+			ch <- prometheus.MustNewConstMetric(purgeLastRunDesc, prometheus.GaugeValue, float64(stats.LastRun.Unix()))
+			ch <- prometheus.MustNewConstMetric(purgeLastDurationDesc, prometheus.GaugeValue, stats.LastDuration.Seconds())
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	msgstore "sw/ocpp/csms/internal/msgstore"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsCollector(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+	ctx := context.Background()
+	now := time.Date(2024, 9, 27, 0, 0, 0, 0, time.UTC)
+
+	storeConfig := conf.MessageStoreConfig{}
+	storeConfig.Jsonl.Directory = t.TempDir()
+	store, err := msgstore.NewJsonlStore(storeConfig)
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, store.WriteBatch(ctx, []msgstore.Message{
+		{NetworkId: "charger-1", MessageId: "1", MessageType: "Heartbeat", MessageTime: now.Add(-8 * 24 * time.Hour), Body: json.RawMessage(`{}`)},
+	}))
+
+	writer := msgstore.NewBatchWriter(store, msgstore.BatchWriterConfig{})
+	writer.Add(msgstore.Message{NetworkId: "charger-1", MessageId: "2", MessageType: "Heartbeat", MessageTime: now, Body: json.RawMessage(`{}`)})
+	writer.Close()
+
+	purger := NewPurger(conf.RetentionConfig{Enabled: true, MessageTypeDays: map[string]int{"heartbeat": 7}}, store, nil, nil)
+	purger.Purge(ctx, now)
+
+	expected := `
+# HELP csms_message_writer_written_total Messages written to the message store
+# TYPE csms_message_writer_written_total counter
+csms_message_writer_written_total 1
+# HELP csms_purge_deleted_total Messages or transactions deleted by retention rule
+# TYPE csms_purge_deleted_total counter
+csms_purge_deleted_total{rule="heartbeat"} 1
+# HELP csms_purge_runs_total Retention purge runs
+# TYPE csms_purge_runs_total counter
+csms_purge_runs_total 1
+`
+	collector := &statsCollector{writer: writer, purger: purger}
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"csms_message_writer_written_total", "csms_purge_deleted_total", "csms_purge_runs_total"))
+
+	assert.Equal(t, 8, testutil.CollectAndCount(&statsCollector{writer: writer}))
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"strconv"
-
-	mqmodels "sw/ocpp/csms/internal/models/mq"
-	tablemodels "sw/ocpp/csms/internal/models/table"
-	table "sw/ocpp/csms/internal/table"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
+	"sw/ocpp/csms/internal/logging"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mqmodels "sw/ocpp/csms/internal/models/mq"
+	msgstore "sw/ocpp/csms/internal/msgstore"
+	"sw/ocpp/csms/internal/ocpp"
+	"sw/ocpp/csms/internal/tracing"
 )
 
 func ProcessRecvMessage(messageBy []byte, state any) {
@@ -28,32 +28,111 @@ func ProcessRecvMessage(messageBy []byte, state any) {
 		return
 	}
 
-	entity := aztables.Entity{
-		PartitionKey: msgEnvelope.Client,
-		RowKey:       strconv.FormatInt(time.Now().UnixMilli(), 10),
-		Timestamp:    aztables.EDMDateTime(time.Now()),
+	ctx, span := tracing.StartConsumerSpan(msgEnvelope.TraceContext, "message-manager store",
+		tracing.Attr_NetworkId.String(msgEnvelope.Client))
+	defer span.End()
+
+	message, err := toStoreMessage(msgEnvelope)
+	if err != nil {
+		log.Errorf("Unable to store message: %s", err.Error())
+		tracing.SetError(ctx, err)
+		return
 	}
-	log.Debugf("Add message partitionKey/rowKey: %s %s\n", entity.PartitionKey, entity.RowKey)
+	logging.ForCharger(message.NetworkId).WithContext(ctx).Debugf("Add message: %s", message.MessageId)
 
-	bodyJson, err := json.Marshal(msgEnvelope.Body)
+	serviceState.MessageWriter.Add(*message)
+}
+
+const dbTimeout = 10 * time.Second
+
+// Archives a frame csms-server couldn't handle, for triage through device-manager
+func ProcessRecvDeadLetter(messageBy []byte, state any) {
+	serviceState := state.(*ServiceState)
+
+	mqDeadLetter := new(mqmodels.MqDeadLetter)
+	if err := json.Unmarshal(messageBy, mqDeadLetter); err != nil {
+		log.Errorf("MQ Received dead letter, unmarshall error: %s", err.Error())
+		return
+	}
+
+	messageTime, err := time.Parse(msgstore.MessageTimeFormat, mqDeadLetter.MessageTime)
 	if err != nil {
-		log.Errorf("Error: %s", err.Error())
+		log.Warnf("Unable to parse dead letter time: {%s} - {%s}", mqDeadLetter.MessageTime, err.Error())
+		messageTime = time.Now().UTC()
 	}
 
-	ocppEnvelopeFields := msgEnvelope.Body.(map[string]interface{})
-	Direction := fmt.Sprintf("%d", int(ocppEnvelopeFields["direction"].(float64)))
+	ctx, span := tracing.StartConsumerSpan(mqDeadLetter.TraceContext, "message-manager dead letter",
+		tracing.Attr_NetworkId.String(mqDeadLetter.NetworkId))
+	defer span.End()
+
+	dbCtx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
 
-	tableEntity := tablemodels.TableMessageEntity{
-		entity,
-		msgEnvelope.ServerNode,
-		Direction,
-		msgEnvelope.MessageTime,
-		string(bodyJson),
+	_, err = serviceState.DeadLetters.InsertDeadLetter(dbCtx, &dbmodels.DeadLetter{
+		NetworkId:     mqDeadLetter.NetworkId,
+		ServerNode:    mqDeadLetter.ServerNode,
+		RemoteAddr:    mqDeadLetter.RemoteAddr,
+		Reason:        mqDeadLetter.Reason,
+		Error:         mqDeadLetter.Error,
+		Frame:         mqDeadLetter.Frame,
+		FrameEncoding: mqDeadLetter.FrameEncoding,
+		MessageTime:   messageTime,
+	})
+	if err != nil {
+		log.Errorf("Error storing dead letter, lost: %s - %s", err.Error(), string(messageBy))
+		tracing.SetError(ctx, err)
+		return
 	}
+	logging.ForCharger(mqDeadLetter.NetworkId).WithContext(ctx).Warnf("Dead letter stored, reason: %s - %s", mqDeadLetter.Reason, mqDeadLetter.Error)
+}
 
-	// Add MQ received message to table storage
-	_, err = table.AddEntity[tablemodels.TableMessageEntity](serviceState.TableClient, tableEntity)
+func toStoreMessage(msgEnvelope *mqmodels.MqMessageEnvelope) (*msgstore.Message, error) {
+	bodyJson, err := json.Marshal(msgEnvelope.Body)
 	if err != nil {
-		log.Errorf("Error: %s", err.Error())
+		return nil, err
+	}
+
+	ocppEnvelope := struct {
+		Direction   int             `json:"direction"`
+		MessageType string          `json:"messageType"`
+		MessageBody json.RawMessage `json:"messageBody"`
+	}{}
+	if err = json.Unmarshal(bodyJson, &ocppEnvelope); err != nil {
+		return nil, err
+	}
+	if ocppEnvelope.MessageType == ocpp.MsgType_ChangeConfiguration {
+		if bodyJson, err = redactMessageBody(bodyJson, ocppEnvelope.MessageBody); err != nil {
+			return nil, err
+		}
+	}
+
+	messageTime, err := time.Parse(msgstore.MessageTimeFormat, msgEnvelope.MessageTime)
+	if err != nil {
+		log.Warnf("Unable to parse message time: {%s} - {%s}", msgEnvelope.MessageTime, err.Error())
+		messageTime = time.Now().UTC()
+	}
+
+	return &msgstore.Message{
+		NetworkId:   msgEnvelope.Client,
+		MessageId:   msgstore.NewMessageId(),
+		ServerNode:  msgEnvelope.ServerNode,
+		Direction:   ocppEnvelope.Direction,
+		MessageType: ocppEnvelope.MessageType,
+		MessageTime: messageTime,
+		Body:        bodyJson,
+	}, nil
+}
+
+// Replaces the messageBody of an OCPP message with its AuthorizationKey redacted, keeping the other fields
+func redactMessageBody(bodyJson []byte, messageBody json.RawMessage) ([]byte, error) {
+	redacted := ocpp.RedactChangeConfiguration(messageBody)
+	if string(redacted) == string(messageBody) {
+		return bodyJson, nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bodyJson, &fields); err != nil {
+		return nil, err
 	}
+	fields["messageBody"] = redacted
+	return json.Marshal(fields)
 }
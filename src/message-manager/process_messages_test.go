@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mqmodels "sw/ocpp/csms/internal/models/mq"
+	mq "sw/ocpp/csms/internal/mq"
+	msgstore "sw/ocpp/csms/internal/msgstore"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessRecvDeadLetter(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+
+	deadLetters := memdb.NewDeadLetterRepository()
+	state := &ServiceState{DeadLetters: deadLetters}
+
+	messageTime := time.Date(2024, 9, 27, 10, 0, 0, 0, time.UTC)
+	messageBy, err := json.Marshal(mqmodels.MqDeadLetter{
+		ServerNode:    "node-1",
+		NetworkId:     "charger-1",
+		MessageTime:   messageTime.Format(msgstore.MessageTimeFormat),
+		Reason:        mq.DeadLetterReason_SchemaInvalid,
+		Error:         "unknown action: Foo",
+		Frame:         `[2,"1","Foo",{}]`,
+		FrameEncoding: mq.FrameEncoding_Utf8,
+	})
+	require.NoError(t, err)
+
+	ProcessRecvDeadLetter(messageBy, state)
+	ProcessRecvDeadLetter([]byte("{"), state)
+
+	stored, err := deadLetters.ListDeadLetters(context.Background(), dbmodels.DeadLetterFilter{})
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, "charger-1", stored[0].NetworkId)
+	assert.Equal(t, mq.DeadLetterReason_SchemaInvalid, stored[0].Reason)
+	assert.Equal(t, `[2,"1","Foo",{}]`, stored[0].Frame)
+	assert.True(t, messageTime.Equal(stored[0].MessageTime))
+}
+
+func TestToStoreMessageRedactsAuthorizationKey(t *testing.T) {
+	message, err := toStoreMessage(&mqmodels.MqMessageEnvelope{
+		Client:      "charger-1",
+		MessageTime: "2024-09-27T10:00:00.000Z",
+		Body: map[string]any{
+			"msgId":       "1",
+			"direction":   2,
+			"messageType": "ChangeConfiguration",
+			"messageBody": map[string]string{"key": "AuthorizationKey", "value": "secret"},
+		},
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, string(message.Body), "secret")
+	assert.JSONEq(t, `{"msgId":"1","direction":2,"messageType":"ChangeConfiguration","messageBody":{"key":"AuthorizationKey","value":"***"}}`, string(message.Body))
+
+	message, err = toStoreMessage(&mqmodels.MqMessageEnvelope{
+		Client:      "charger-1",
+		MessageTime: "2024-09-27T10:00:00.000Z",
+		Body: map[string]any{
+			"messageType": "ChangeConfiguration",
+			"messageBody": map[string]string{"key": "HeartbeatInterval", "value": "60"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, string(message.Body), `"value":"60"`)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
+	msgstore "sw/ocpp/csms/internal/msgstore"
+	telemetry "sw/ocpp/csms/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPurgeIntervalMins = 60
+	purgeTimeout             = 30 * time.Minute
+	purgeRule_Transactions   = "transactions"
+)
+
+// Cumulative purge counts since the service started
+type PurgeStats struct {
+	Runs         int64
+	Failures     int64
+	Deleted      map[string]int64 // by retention rule, and purgeRule_Transactions for completed transactions
+	LastRun      time.Time
+	LastDuration time.Duration
+}
+
+// Periodically deletes archived messages and completed transactions older than their retention
+type Purger struct {
+	store                 msgstore.MessageStore
+	transactions          db.TransactionRepository
+	policy                msgstore.RetentionPolicy
+	transactionsRetention time.Duration
+	interval              time.Duration
+	telemetry             telemetry.TelemetrySink
+
+	mu    sync.Mutex
+	stats PurgeStats
+}
+
+// store or transactions can be nil, if there is nothing to purge from them
+func NewPurger(config conf.RetentionConfig, store msgstore.MessageStore, transactions db.TransactionRepository, sink telemetry.TelemetrySink) *Purger {
+	interval := config.PurgeIntervalMins
+	if interval <= 0 {
+		interval = defaultPurgeIntervalMins
+	}
+	if sink == nil {
+		sink = telemetry.NopSink{}
+	}
+	return &Purger{
+		store:                 store,
+		transactions:          transactions,
+		policy:                msgstore.NewRetentionPolicy(config),
+		transactionsRetention: time.Duration(config.TransactionsDays) * 24 * time.Hour,
+		interval:              time.Duration(interval) * time.Minute,
+		telemetry:             sink,
+		stats:                 PurgeStats{Deleted: map[string]int64{}},
+	}
+}
+
+// Purges at startup then every interval, until ctx is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.Purge(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Runs one purge, logging the deleted counts per retention rule
+func (p *Purger) Purge(ctx context.Context, now time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, purgeTimeout)
+	defer cancel()
+
+	started := time.Now()
+	deleted := map[string]int64{}
+	var err error
+	if p.store != nil {
+		var result *msgstore.PurgeResult
+		result, err = p.store.PurgeMessages(ctx, p.policy, now)
+		if result != nil {
+			for rule, count := range result.Deleted {
+				deleted[rule] += count
+			}
+		}
+	}
+	if err == nil && p.transactions != nil && p.transactionsRetention > 0 {
+		err = p.purgeTransactions(ctx, now.Add(-p.transactionsRetention), deleted)
+	}
+	duration := time.Since(started)
+
+	p.mu.Lock()
+	p.stats.Runs++
+	if err != nil {
+		p.stats.Failures++
+	}
+	for rule, count := range deleted {
+		p.stats.Deleted[rule] += count
+	}
+	p.stats.LastRun = started
+	p.stats.LastDuration = duration
+	p.mu.Unlock()
+
+	fields := logrus.Fields{"durationMs": duration.Milliseconds()}
+	for rule, count := range deleted {
+		fields["deleted_"+rule] = count
+		p.telemetry.TrackPurgeDeleted(rule, count)
+	}
+	if err != nil {
+		log.WithFields(fields).Errorf("Purge failed: %s", err.Error())
+		return
+	}
+	log.WithFields(fields).Infof("Purge completed")
+}
+
+func (p *Purger) purgeTransactions(ctx context.Context, before time.Time, deleted map[string]int64) error {
+	for {
+		count, err := p.transactions.DeleteTransactionsBefore(ctx, before, db.DefaultDeleteLimit)
+		if count > 0 {
+			deleted[purgeRule_Transactions] += count
+		}
+		if err != nil {
+			return err
+		}
+		if count < db.DefaultDeleteLimit {
+			return nil
+		}
+	}
+}
+
+func (p *Purger) Stats() PurgeStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Deleted = map[string]int64{}
+	for rule, count := range p.stats.Deleted {
+		stats.Deleted[rule] = count
+	}
+	return stats
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	msgstore "sw/ocpp/csms/internal/msgstore"
+	"sw/ocpp/csms/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Records the deleted counts tracked by purges
+type purgeSink struct {
+	telemetry.NopSink
+	deleted map[string]int64
+}
+
+func (s *purgeSink) TrackPurgeDeleted(rule string, deleted int64) {
+	s.deleted[rule] += deleted
+}
+
+func TestPurge(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+	ctx := context.Background()
+	now := time.Date(2024, 9, 27, 0, 0, 0, 0, time.UTC)
+
+	storeConfig := conf.MessageStoreConfig{}
+	storeConfig.Jsonl.Directory = t.TempDir()
+	store, err := msgstore.NewJsonlStore(storeConfig)
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, store.WriteBatch(ctx, []msgstore.Message{
+		{NetworkId: "charger-1", MessageId: "1", MessageType: "Heartbeat", MessageTime: now.Add(-8 * 24 * time.Hour), Body: json.RawMessage(`{}`)},
+		{NetworkId: "charger-1", MessageId: "2", MessageType: "MeterValues", MessageTime: now.Add(-8 * 24 * time.Hour), Body: json.RawMessage(`{}`)},
+	}))
+
+	transactions := memdb.NewTransactionRepository()
+	for _, started := range []time.Time{now.Add(-400 * 24 * time.Hour), now.Add(-24 * time.Hour)} {
+		id, err := transactions.InsertNextTransaction(ctx, &dbmodels.Transaction{ClientId: "charger-1", TimeStarted: started, TimeReceived: started})
+		require.NoError(t, err)
+		require.NoError(t, transactions.CompleteTransaction(ctx, "charger-1", id, started.Add(time.Hour), 10, "Local"))
+	}
+
+	sink := &purgeSink{deleted: map[string]int64{}}
+	purger := NewPurger(conf.RetentionConfig{
+		Enabled:          true,
+		MessageTypeDays:  map[string]int{"heartbeat": 7},
+		TransactionsDays: 365,
+	}, store, transactions, sink)
+	purger.Purge(ctx, now)
+	purger.Purge(ctx, now)
+
+	stats := purger.Stats()
+	assert.Equal(t, int64(2), stats.Runs)
+	assert.Zero(t, stats.Failures)
+	assert.Equal(t, map[string]int64{"heartbeat": 1, purgeRule_Transactions: 1}, stats.Deleted)
+	assert.Equal(t, stats.Deleted, sink.deleted)
+
+	remaining, err := transactions.ListTransactions(ctx, dbmodels.TransactionFilter{ClientId: "charger-1"})
+	require.NoError(t, err)
+	assert.Len(t, remaining, 1)
+}
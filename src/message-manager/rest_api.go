@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	httplistener "sw/ocpp/csms/internal/http"
+	msgstore "sw/ocpp/csms/internal/msgstore"
+	ocppmodels "sw/ocpp/csms/internal/ocpp"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultQueryRange = 24 * time.Hour
+	queryTimeout      = 30 * time.Second
+)
+
+func newRouter(config conf.HttpConfig) http.Handler {
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
+	router.Use(middleware.Logger)
+	router.Use(middleware.Recoverer)
+
+	router.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	router.Route("/", func(r chi.Router) {
+		r.Use(middleware.BasicAuth("message-manager", map[string]string{
+			config.HttpUser: config.HttpPassword,
+		}))
+		r.Get("/messages/{networkid}", messages_List)
+	})
+	return router
+}
+
+func setupRestApi(serviceState *ServiceState, config conf.HttpConfig) error {
+	log.Info("Starting REST API Server")
+	listenNetPort := fmt.Sprintf("%s:%d", config.ListenAddress, config.ListenPort)
+	log.Info("REST API listening on: ", listenNetPort)
+
+	listener, err := httplistener.ListenAndServeWithClose(listenNetPort, newRouter(config))
+	if err != nil {
+		log.Error("Failed to start REST API server")
+		return err
+	}
+	var ioCloser io.Closer = listener
+	serviceState.IoCloser = &ioCloser
+	serviceState.Listener = listener
+
+	log.Info("REST server started")
+	return nil
+}
+
+// Lists archived messages for a networkId, filtered by query parameters: from, to (RFC3339, default the last 24 hours),
+// direction, messageType, limit and continuationToken from the previous page
+func messages_List(w http.ResponseWriter, r *http.Request) {
+	query, err := messageQueryFromRequest(r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+
+	page, err := serviceState.MessageStore.QueryMessages(ctx, *query)
+	if errors.Is(err, msgstore.ErrInvalidQuery) {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err != nil {
+		log.Errorf("Error querying messages: %s", err.Error())
+		render.Render(w, r, ErrInternal(err))
+		return
+	}
+	render.JSON(w, r, page)
+}
+
+func messageQueryFromRequest(r *http.Request) (*msgstore.MessageQuery, error) {
+	params := r.URL.Query()
+	query := &msgstore.MessageQuery{
+		NetworkId:         chi.URLParam(r, "networkid"),
+		MessageType:       params.Get("messageType"),
+		ContinuationToken: params.Get("continuationToken"),
+		To:                time.Now().UTC(),
+	}
+
+	if to := params.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to: %s", to)
+		}
+		query.To = t
+	}
+	query.From = query.To.Add(-defaultQueryRange)
+	if from := params.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %s", from)
+		}
+		query.From = t
+	}
+	if direction := params.Get("direction"); direction != "" {
+		d, err := strconv.Atoi(direction)
+		if err != nil || d < ocppmodels.OcppDirection_ServerClient || d > ocppmodels.OcppDirection_CallError {
+			return nil, fmt.Errorf("invalid direction: %s", direction)
+		}
+		query.Direction = d
+	}
+	if limit := params.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > msgstore.MaxQueryLimit {
+			return nil, fmt.Errorf("invalid limit, must be 1-%d", msgstore.MaxQueryLimit)
+		}
+		query.Limit = l
+	}
+	return query, nil
+}
+
+type ErrResponse struct {
+	Err            error `json:"-"` // low-level runtime error
+	HTTPStatusCode int   `json:"-"` // http response status code
+
+	StatusText string `json:"status"`          // user-level status message
+	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
+}
+
+func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	render.Status(r, e.HTTPStatusCode)
+	return nil
+}
+
+func ErrInvalidRequest(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 400,
+		StatusText:     "Invalid request.",
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrInternal(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 500,
+		StatusText:     "Internal server error.",
+		ErrorText:      err.Error(),
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	conf "sw/ocpp/csms/internal/config"
+	msgstore "sw/ocpp/csms/internal/msgstore"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestRouter(t *testing.T) (http.Handler, msgstore.MessageStore) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+
+	storeConfig := conf.MessageStoreConfig{}
+	storeConfig.Jsonl.Directory = t.TempDir()
+	store, err := msgstore.NewJsonlStore(storeConfig)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	serviceState = &ServiceState{MessageStore: store}
+	t.Cleanup(func() { serviceState = nil })
+
+	return newRouter(conf.HttpConfig{HttpUser: "admin", HttpPassword: "secret"}), store
+}
+
+func TestMessagesList(t *testing.T) {
+	router, store := setupTestRouter(t)
+
+	messageTime := time.Date(2024, 9, 27, 8, 0, 0, 0, time.UTC)
+	messages := []msgstore.Message{}
+	for i, messageType := range []string{"Heartbeat", "StatusNotification", "Heartbeat"} {
+		messages = append(messages, msgstore.Message{
+			NetworkId:   "charger-1",
+			MessageId:   string(rune('a' + i)),
+			Direction:   2,
+			MessageType: messageType,
+			MessageTime: messageTime.Add(time.Duration(i) * time.Minute),
+			Body:        json.RawMessage(`{}`),
+		})
+	}
+	require.NoError(t, store.WriteBatch(context.Background(), messages))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/messages/charger-1?from=2024-09-27T07:00:00Z&to=2024-09-27T09:00:00Z&messageType=Heartbeat&limit=1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var page msgstore.MessagePage
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	require.Len(t, page.Messages, 1)
+	assert.Equal(t, "a", page.Messages[0].MessageId)
+	require.NotEmpty(t, page.ContinuationToken)
+
+	rec = get("/messages/charger-1?from=2024-09-27T07:00:00Z&to=2024-09-27T09:00:00Z&messageType=Heartbeat&limit=1&continuationToken=" + page.ContinuationToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	page = msgstore.MessagePage{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	require.Len(t, page.Messages, 1)
+	assert.Equal(t, "c", page.Messages[0].MessageId)
+	assert.Empty(t, page.ContinuationToken)
+
+	rec = get("/messages/charger-1?from=2024-09-27T07:00:00Z&to=2024-09-27T09:00:00Z&direction=4")
+	require.Equal(t, http.StatusOK, rec.Code, "call errors can be queried")
+	assert.Equal(t, http.StatusBadRequest, get("/messages/charger-1?direction=0").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/messages/charger-1?direction=5").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/messages/charger-1?from=2024-09-27T09:00:00Z&to=2024-09-27T07:00:00Z").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/messages/charger-1?continuationToken=bad!").Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/messages/charger-1", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"io"
 
 	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
+	httplistener "sw/ocpp/csms/internal/http"
 	svc "sw/ocpp/csms/internal/models/service"
 	mq "sw/ocpp/csms/internal/mq"
+	msgstore "sw/ocpp/csms/internal/msgstore"
+	"sw/ocpp/csms/internal/telemetry"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
 	"github.com/go-redis/redis"
 	"github.com/sirupsen/logrus"
 
@@ -17,11 +21,20 @@ import (
 type ServiceState struct {
 	Config          *conf.Configuration
 	IoCloser        *io.Closer
+	AdminCloser     *io.Closer
+	Listener        *httplistener.Listener
 	Cache           *redis.Client
 	MqBus           mq.MqBus
 	Connections     *xsync.Map
 	LastError       error
 	Context         svc.ServiceContext
 	AppInsightsHook logrus.Hook
-	TableClient     *aztables.Client
+	Telemetry       telemetry.TelemetrySink
+	MessageStore    msgstore.MessageStore
+	MessageWriter   *msgstore.BatchWriter
+	Db              *db.Store
+	DeadLetters     db.DeadLetterRepository
+	Purger          *Purger
+	StopPurger      context.CancelFunc
+	ShutdownTracing func(context.Context) error
 }
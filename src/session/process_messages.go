@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	db "sw/ocpp/csms/internal/db"
+	"sw/ocpp/csms/internal/logging"
+	dbmodels "sw/ocpp/csms/internal/models/db"
 	mqmodels "sw/ocpp/csms/internal/models/mq"
 	mq "sw/ocpp/csms/internal/mq"
 	ocppmodels "sw/ocpp/csms/internal/ocpp"
+	"sw/ocpp/csms/internal/tracing"
 	"time"
 )
 
+const dbTimeout = 10 * time.Second
+
 func ProcessRecvMessage(messageBy []byte, state any) {
+	serviceState := state.(*ServiceState)
+
 	// Unmarshell to a MqMessageEnvelope message
 	msgEnvelope := new(mqmodels.MqMessageEnvelope)
 	err := json.Unmarshal(messageBy, &msgEnvelope)
@@ -32,28 +41,63 @@ func ProcessRecvMessage(messageBy []byte, state any) {
 		return
 	}
 	msgType := ocppEnvelopeFields["messageType"].(string)
-
-	// TODO StopTransaction
-	if msgType != "StartTransaction" {
+	if msgType != "StartTransaction" && msgType != "StopTransaction" {
 		return
 	}
+	msgId, _ := ocppEnvelopeFields["msgId"].(string)
+	ctx, span := tracing.StartConsumerSpan(msgEnvelope.TraceContext, "session "+msgType,
+		tracing.Attr_NetworkId.String(msgEnvelope.Client), tracing.Attr_MsgId.String(msgId), tracing.Attr_Action.String(msgType))
+	defer span.End()
+	logging.ForMessage(ctx, msgEnvelope.Client, msgId, msgType).Debugf("MQ Received MessagesOut: %s", string(messageBy))
 
-	log.Debugf("MQ Received MessagesOut: %s\n", string(messageBy))
+	switch msgType {
+	case "StartTransaction":
+		processStartTransaction(ctx, serviceState, msgEnvelope, ocppEnvelopeFields)
+	case "StopTransaction":
+		processStopTransaction(ctx, serviceState, msgEnvelope, ocppEnvelopeFields)
+	}
+}
 
+func processStartTransaction(ctx context.Context, serviceState *ServiceState, msgEnvelope *mqmodels.MqMessageEnvelope, ocppEnvelopeFields map[string]interface{}) {
 	msgId := ocppEnvelopeFields["msgId"].(string)
+	mlog := logging.ForMessage(ctx, msgEnvelope.Client, msgId, "StartTransaction")
 
-	timeStarted, err := time.Parse("2006-01-02T15:04:05.000Z", msgEnvelope.MessageTime)
+	timeReceived, err := time.Parse("2006-01-02T15:04:05.000Z", msgEnvelope.MessageTime)
 	if err != nil {
-		log.Errorf("Unable to parse message time: {%s} - {%s}", msgEnvelope.MessageTime, err.Error())
+		mlog.Errorf("Unable to parse message time: {%s} - {%s}", msgEnvelope.MessageTime, err.Error())
+		timeReceived = time.Now()
+	}
+
+	startTransaction := new(ocppmodels.OcppStartTransaction)
+	err = unmarshallMessageBody(ocppEnvelopeFields, startTransaction)
+	if err != nil {
+		mlog.Errorf("Unable to unmarshall StartTransaction: %s", err.Error())
+		return
 	}
 
+	timeStarted := parseChargerTimestamp(startTransaction.Timestamp, timeReceived)
+
+	transaction := &dbmodels.Transaction{
+		ClientId:      msgEnvelope.Client,
+		ConnectorId:   startTransaction.ConnectorId,
+		IdTag:         startTransaction.IdTag,
+		ReservationId: startTransaction.ReservationId,
+		MeterStart:    startTransaction.MeterStart,
+		TimeStarted:   timeStarted,
+		TimeReceived:  timeReceived,
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
 	transResponse := new(ocppmodels.OcppTransactionResponse)
-	transactionId, err := db.InsertNextTransaction(msgEnvelope.Client, timeStarted)
+	transactionId, err := serviceState.Transactions.InsertNextTransaction(dbCtx, transaction)
 	if err != nil {
-		log.Errorf("Error inserting transation: %s", err.Error())
+		mlog.Errorf("Error inserting transation: %s", err.Error())
+		tracing.SetError(ctx, err)
 		transResponse.IdTagInfo.Status = "error"
 	} else {
-		transResponse.TransactionId = *transactionId
+		transResponse.TransactionId = transactionId
 		transResponse.IdTagInfo.Status = "accepted"
 	}
 
@@ -63,18 +107,68 @@ func ProcessRecvMessage(messageBy []byte, state any) {
 
 	transResponseBy, err := json.Marshal(&transResponse)
 	if err != nil {
-		log.Errorf("Error marshalling response: %s", err.Error())
+		mlog.Errorf("Error marshalling response: %s", err.Error())
 		return
 	}
 	transResponseRaw := json.RawMessage(transResponseBy)
 	ocppResponse.MessageBody = transResponseRaw
 
-	json, _ := mq.MqCreateMessageEnvelope(msgEnvelope.ServerNode, msgEnvelope.Client, ocppResponse)
+	json, _ := mq.MqCreateMessageEnvelope(ctx, msgEnvelope.ServerNode, msgEnvelope.Client, ocppResponse)
 
 	mqErr := serviceState.MqBus.MqMessagePublishRetry(mq.MqChannelName_MessagesOut, json)
 	if mqErr != nil {
-		log.Errorf("Error sending reply to MQ, msg lost: %s", mqErr.Error())
+		mlog.Errorf("Error sending reply to MQ, msg lost: %s", mqErr.Error())
+		tracing.SetError(ctx, mqErr)
 		// transient MQ error unrecoverable, close connection to CP
 		// TODO log to appinsights
 	}
 }
+
+// StopTransaction is acknowledged by csms-server, so only the transaction record is updated here
+func processStopTransaction(ctx context.Context, serviceState *ServiceState, msgEnvelope *mqmodels.MqMessageEnvelope, ocppEnvelopeFields map[string]interface{}) {
+	msgId, _ := ocppEnvelopeFields["msgId"].(string)
+	mlog := logging.ForMessage(ctx, msgEnvelope.Client, msgId, "StopTransaction")
+
+	stopTransaction := new(ocppmodels.OcppStopTransaction)
+	err := unmarshallMessageBody(ocppEnvelopeFields, stopTransaction)
+	if err != nil {
+		mlog.Errorf("Unable to unmarshall StopTransaction: %s", err.Error())
+		return
+	}
+
+	timeEnded := parseChargerTimestamp(stopTransaction.Timestamp, time.Now())
+
+	dbCtx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	err = serviceState.Transactions.CompleteTransaction(dbCtx, msgEnvelope.Client, int64(stopTransaction.TransactionId), timeEnded,
+		float64(stopTransaction.MeterStop), stopTransaction.Reason)
+	if errors.Is(err, db.ErrNotFound) {
+		mlog.Warnf("No active transaction %d to stop", stopTransaction.TransactionId)
+	} else if err != nil {
+		mlog.Errorf("Error completing transaction %d: %s", stopTransaction.TransactionId, err.Error())
+		tracing.SetError(ctx, err)
+	}
+}
+
+func unmarshallMessageBody(ocppEnvelopeFields map[string]interface{}, target any) error {
+	messageBody, err := json.Marshal(ocppEnvelopeFields["messageBody"])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(messageBody, target)
+}
+
+// Parses the charger supplied timestamp, falling back to the given time if missing or invalid
+func parseChargerTimestamp(timestamp string, fallback time.Time) time.Time {
+	if timestamp == "" {
+		return fallback
+	}
+
+	parsed, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		log.Warnf("Unable to parse charger timestamp: {%s} - {%s}", timestamp, err.Error())
+		return fallback
+	}
+	return parsed
+}
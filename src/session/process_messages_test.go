@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"sw/ocpp/csms/internal/db/memdb"
+	dbmodels "sw/ocpp/csms/internal/models/db"
+	mqmodels "sw/ocpp/csms/internal/models/mq"
+	svc "sw/ocpp/csms/internal/models/service"
+	mq "sw/ocpp/csms/internal/mq"
+	"sw/ocpp/csms/internal/tracing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Records published messages
+type fakeMqBus struct {
+	mq.MqBus
+	published []string
+}
+
+func (f *fakeMqBus) MqMessagePublishRetry(channel string, json string) error {
+	f.published = append(f.published, json)
+	return nil
+}
+
+func (f *fakeMqBus) MqSendClientMessageRetry(ctx context.Context, hostName string, connState *svc.ConnectionInfo, body any) error {
+	return nil
+}
+
+func setupTestState() (*ServiceState, *fakeMqBus, *memdb.TransactionRepository) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+
+	bus := &fakeMqBus{}
+	transactions := memdb.NewTransactionRepository()
+	return &ServiceState{MqBus: bus, Transactions: transactions}, bus, transactions
+}
+
+func TestStartStopTransaction(t *testing.T) {
+	state, bus, transactions := setupTestState()
+
+	ProcessRecvMessage([]byte(`{"serverNode":"node1","client":"charger-1","messageTime":"2024-09-27T09:00:00.000Z",
+		"body":{"direction":2,"msgId":"m1","messageType":"StartTransaction",
+		"messageBody":{"connectorId":1,"idTag":"TAG1","meterStart":100,"timestamp":"2024-09-27T08:59:59Z"}}}`), state)
+
+	require.Len(t, bus.published, 1)
+	var reply struct {
+		Client string `json:"client"`
+		Body   struct {
+			MsgId       string `json:"msgId"`
+			MessageBody struct {
+				TransactionId int64 `json:"transactionId"`
+				IdTagInfo     struct {
+					Status string `json:"status"`
+				} `json:"idTagInfo"`
+			} `json:"messageBody"`
+		} `json:"body"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(bus.published[0]), &reply))
+	assert.Equal(t, "charger-1", reply.Client)
+	assert.Equal(t, "m1", reply.Body.MsgId)
+	assert.Equal(t, "accepted", reply.Body.MessageBody.IdTagInfo.Status)
+
+	transactionId := reply.Body.MessageBody.TransactionId
+	transaction, err := transactions.GetTransaction(context.Background(), "charger-1", transactionId)
+	require.NoError(t, err)
+	assert.Equal(t, dbmodels.TransactionStatus_Active, transaction.Status)
+	assert.Equal(t, "TAG1", transaction.IdTag)
+	assert.Equal(t, 100, transaction.MeterStart)
+
+	ProcessRecvMessage([]byte(fmt.Sprintf(`{"serverNode":"node1","client":"charger-1","messageTime":"2024-09-27T10:00:00.000Z",
+		"body":{"direction":2,"msgId":"m2","messageType":"StopTransaction",
+		"messageBody":{"transactionId":%d,"meterStop":900,"reason":"Local","timestamp":"2024-09-27T10:00:00Z"}}}`, transactionId)), state)
+
+	transaction, err = transactions.GetTransaction(context.Background(), "charger-1", transactionId)
+	require.NoError(t, err)
+	assert.Equal(t, dbmodels.TransactionStatus_Completed, transaction.Status)
+	assert.Equal(t, 900.0, *transaction.MeterStop)
+	assert.Equal(t, "Local", transaction.StopReason)
+	assert.Len(t, bus.published, 1) // StopTransaction is acknowledged by csms-server
+}
+
+func TestStartTransactionInvalid(t *testing.T) {
+	state, bus, transactions := setupTestState()
+
+	ProcessRecvMessage([]byte(`{"serverNode":"node1","client":"charger-1","messageTime":"2024-09-27T09:00:00.000Z",
+		"body":{"direction":2,"msgId":"m1","messageType":"StartTransaction","messageBody":{"connectorId":"one"}}}`), state)
+
+	assert.Empty(t, bus.published)
+	all, err := transactions.ListTransactions(context.Background(), dbmodels.TransactionFilter{ClientId: "charger-1"})
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestStartTransactionContinuesTrace(t *testing.T) {
+	state, bus, _ := setupTestState()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.SetupWithExporter(exporter, "session")
+	defer provider.Shutdown(context.Background())
+
+	ProcessRecvMessage([]byte(`{"serverNode":"node1","client":"charger-1","messageTime":"2024-09-27T09:00:00.000Z",
+		"traceContext":{"traceparent":"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		"body":{"direction":2,"msgId":"m1","messageType":"StartTransaction",
+		"messageBody":{"connectorId":1,"idTag":"TAG1","meterStart":100,"timestamp":"2024-09-27T08:59:59Z"}}}`), state)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "session StartTransaction", spans[0].Name)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[0].SpanContext.TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", spans[0].Parent.SpanID().String())
+
+	require.Len(t, bus.published, 1)
+	var reply mqmodels.MqMessageEnvelope
+	require.NoError(t, json.Unmarshal([]byte(bus.published[0]), &reply))
+	assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-"+spans[0].SpanContext.SpanID().String()+"-01", reply.TraceContext["traceparent"])
+}
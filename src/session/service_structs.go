@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"io"
 
 	conf "sw/ocpp/csms/internal/config"
+	db "sw/ocpp/csms/internal/db"
 	svc "sw/ocpp/csms/internal/models/service"
 	mq "sw/ocpp/csms/internal/mq"
 
@@ -16,10 +18,14 @@ import (
 type ServiceState struct {
 	Config          *conf.Configuration
 	IoCloser        *io.Closer
+	AdminCloser     *io.Closer
 	Cache           *redis.Client
 	MqBus           mq.MqBus
 	Connections     *xsync.Map
 	LastError       error
 	Context         svc.ServiceContext
 	AppInsightsHook logrus.Hook
+	Db              *db.Store
+	Transactions    db.TransactionRepository
+	ShutdownTracing func(context.Context) error
 }
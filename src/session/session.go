@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"sw/ocpp/csms/internal/admin"
 	conf "sw/ocpp/csms/internal/config"
 	db "sw/ocpp/csms/internal/db"
 	helpers "sw/ocpp/csms/internal/helpers"
@@ -13,6 +15,7 @@ import (
 	mq "sw/ocpp/csms/internal/mq"
 	service "sw/ocpp/csms/internal/service"
 	telemetry "sw/ocpp/csms/internal/telemetry"
+	"sw/ocpp/csms/internal/tracing"
 
 	"github.com/puzpuzpuz/xsync/v3"
 )
@@ -81,7 +84,11 @@ func main() {
 		<-exitNotification // send notification to unblock and exit
 	}()
 
-	log = logging.LoggingSetup(true, "session") // start with debug enabled until overridden in config later
+	log = logging.LoggingSetup(true, "session", logging.Format_Text) // start with debug enabled until overridden in config later
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+	}
 
 	log.Infof("--- OCPP Session - v%s ---", service.Version)
 
@@ -91,24 +98,45 @@ func main() {
 		os.Exit(1)
 	}
 
-	log = logging.LoggingSetup(serviceState.Config.Services.Session.Debug, "session")
+	log = logging.LoggingSetup(serviceState.Config.Services.Session.Debug, "session", serviceState.Config.Logging.Format)
 	if len(serviceState.Config.Logging.AppInsightsInstrumentationKey) > 0 {
 		log.AddHook(serviceState.AppInsightsHook)
 	}
-	dbConfig := serviceState.Config.DbConfig
-	err := db.ConnectDb(dbConfig.DbType, dbConfig.DbConnectionString)
+	shutdownTracing, err := tracing.Setup(serviceState.Config.Tracing, "session")
+	if err != nil {
+		log.Errorf("Error in tracing setup: %s", err.Error())
+		os.Exit(1)
+	}
+	serviceState.ShutdownTracing = shutdownTracing
+
+	store, err := db.Open(serviceState.Config.DbConfig)
 	if err != nil {
 		log.Errorf("Error in DB connection: %s", err.Error())
 		os.Exit(1)
 	}
-	err = db.CreateTables()
+	serviceState.Db = store
+	serviceState.Transactions = store.Transactions
+	err = store.MigrateUp(context.Background())
 	if err != nil {
-		log.Errorf("Error in DB table create: %s", err.Error())
+		log.Errorf("Error in DB migration: %s", err.Error())
 		os.Exit(1)
 	}
 
 	go serviceState.MqBus.RunMqTopicReceiver(ProcessRecvMessage, mq.MqChannelName_MessagesIn, serviceState)
 
+	adminConfig := serviceState.Config.Services.Session.Admin
+	if adminConfig.ListenPort > 0 {
+		server := admin.NewServer(adminConfig)
+		server.AddReadinessCheck("mq", serviceState.MqBus.MqHealthCheck)
+		server.AddReadinessCheck("db", serviceState.Db.Ping)
+		adminCloser, err := server.Start()
+		if err != nil {
+			log.Errorf("Error starting admin listener: %s", err.Error())
+			os.Exit(1)
+		}
+		serviceState.AdminCloser = &adminCloser
+	}
+
 	log.Debug("block...")
 	exitNotification <- struct{}{} // block until exit notification received
 	log.Debug("Service closing...")
@@ -117,7 +145,23 @@ func main() {
 	os.Exit(0)
 }
 
+// Runs DB schema migrations without starting the service, e.g: migrate up | down [steps] | status
+func runMigrateCommand(args []string) {
+	config := conf.ReadConfig()
+	err := db.RunMigrateCommand(config.DbConfig, args, os.Stdout)
+	if err != nil {
+		log.Errorf("Error in migrate: %s", err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func dispose() {
+	if serviceState.AdminCloser != nil {
+		log.Debug("Close admin listener")
+		(*serviceState.AdminCloser).Close()
+	}
+
 	if serviceState.Cache != nil {
 		log.Debug("Close cache")
 		serviceState.Cache.Close()
@@ -127,6 +171,16 @@ func dispose() {
 		log.Debug("Close MqChannel")
 		serviceState.MqBus.Close()
 	}
+
+	if serviceState.Db != nil {
+		log.Debug("Close DB")
+		serviceState.Db.Close()
+	}
+
+	if serviceState.ShutdownTracing != nil {
+		log.Debug("Flush traces")
+		serviceState.ShutdownTracing(context.Background())
+	}
 }
 
 func multiSignalHandler(signal os.Signal) {